@@ -8,6 +8,12 @@ OUTPUT_DIR="build"
 
 BINARY_NAME="velocity-cli"
 
+VERSION="${VERSION:-$(git describe --tags --always --dirty 2>/dev/null || echo dev)}"
+COMMIT="${COMMIT:-$(git rev-parse HEAD 2>/dev/null)}"
+DATE="${DATE:-$(date -u +%Y-%m-%dT%H:%M:%SZ)}"
+VERSION_PKG="github.com/bit2swaz/velocity-cache/internal/version"
+LDFLAGS="-s -w -X ${VERSION_PKG}.Version=${VERSION} -X ${VERSION_PKG}.Commit=${COMMIT} -X ${VERSION_PKG}.Date=${DATE}"
+
 
 
 echo "==> Cleaning old builds..."
@@ -18,25 +24,25 @@ mkdir -p "${OUTPUT_DIR}"
 
 
 echo "==> Building Linux AMD64..."
-GOOS=linux GOARCH=amd64 go build -ldflags="-s -w" -o "${OUTPUT_DIR}/${BINARY_NAME}-linux-amd64" "${PACKAGE_PATH}"
+GOOS=linux GOARCH=amd64 go build -ldflags="${LDFLAGS}" -o "${OUTPUT_DIR}/${BINARY_NAME}-linux-amd64" "${PACKAGE_PATH}"
 echo "==> Compressing Linux AMD64..."
 tar -czf "${OUTPUT_DIR}/${BINARY_NAME}-linux-amd64.tar.gz" -C "${OUTPUT_DIR}" "${BINARY_NAME}-linux-amd64"
 
 
 echo "==> Building macOS AMD64..."
-GOOS=darwin GOARCH=amd64 go build -ldflags="-s -w" -o "${OUTPUT_DIR}/${BINARY_NAME}-darwin-amd64" "${PACKAGE_PATH}"
+GOOS=darwin GOARCH=amd64 go build -ldflags="${LDFLAGS}" -o "${OUTPUT_DIR}/${BINARY_NAME}-darwin-amd64" "${PACKAGE_PATH}"
 echo "==> Compressing macOS AMD64..."
 tar -czf "${OUTPUT_DIR}/${BINARY_NAME}-darwin-amd64.tar.gz" -C "${OUTPUT_DIR}" "${BINARY_NAME}-darwin-amd64"
 
 
 echo "==> Building macOS ARM64..."
-GOOS=darwin GOARCH=arm64 go build -ldflags="-s -w" -o "${OUTPUT_DIR}/${BINARY_NAME}-darwin-arm64" "${PACKAGE_PATH}"
+GOOS=darwin GOARCH=arm64 go build -ldflags="${LDFLAGS}" -o "${OUTPUT_DIR}/${BINARY_NAME}-darwin-arm64" "${PACKAGE_PATH}"
 echo "==> Compressing macOS ARM64..."
 tar -czf "${OUTPUT_DIR}/${BINARY_NAME}-darwin-arm64.tar.gz" -C "${OUTPUT_DIR}" "${BINARY_NAME}-darwin-arm64"
 
 
 echo "==> Building Windows AMD64..."
-GOOS=windows GOARCH=amd64 go build -ldflags="-s -w" -o "${OUTPUT_DIR}/${BINARY_NAME}-windows-amd64.exe" "${PACKAGE_PATH}"
+GOOS=windows GOARCH=amd64 go build -ldflags="${LDFLAGS}" -o "${OUTPUT_DIR}/${BINARY_NAME}-windows-amd64.exe" "${PACKAGE_PATH}"
 echo "==> Compressing Windows AMD64..."
 zip "${OUTPUT_DIR}/${BINARY_NAME}-windows-amd64.zip" -j "${OUTPUT_DIR}/${BINARY_NAME}-windows-amd64.exe" 
 
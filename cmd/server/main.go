@@ -13,11 +13,15 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/bit2swaz/velocity-cache/internal/version"
 	"github.com/bit2swaz/velocity-cache/pkg/api"
 	"github.com/bit2swaz/velocity-cache/pkg/observability"
 	"github.com/bit2swaz/velocity-cache/pkg/storage"
 	"github.com/bit2swaz/velocity-cache/pkg/storage/local"
+	"github.com/bit2swaz/velocity-cache/pkg/storage/oci"
+	"github.com/bit2swaz/velocity-cache/pkg/storage/plugin"
 	"github.com/bit2swaz/velocity-cache/pkg/storage/s3"
+	"github.com/bit2swaz/velocity-cache/pkg/storage/webdav"
 )
 
 func main() {
@@ -39,12 +43,20 @@ func main() {
 		}
 	}
 
+	if _, err := storage.EnvURLSigner(); err != nil {
+		log.Fatalf("Failed to configure proxy URL signing: %v", err)
+	}
+
 	var store storage.Driver
 	var err error
 
 	switch driverType {
 	case "s3":
 		store, err = s3.New(context.Background())
+	case "webdav":
+		store, err = webdav.New()
+	case "oci":
+		store, err = oci.New()
 	case "local":
 		localStore, err := local.New()
 		if err == nil {
@@ -54,14 +66,31 @@ func main() {
 			log.Fatalf("Failed to initialize local driver: %v", err)
 		}
 	default:
-		log.Fatalf("Unknown driver: %s", driverType)
+		path, ok := strings.CutPrefix(driverType, "plugin:")
+		if !ok {
+			log.Fatalf("Unknown driver: %s", driverType)
+		}
+		store, err = plugin.New(path)
 	}
 
 	if err != nil {
 		log.Fatalf("Failed to initialize storage driver: %v", err)
 	}
+	if _, ok := store.(storage.Proxied); ok && os.Getenv("VC_PROXY_SIGNING_KEY") == "" {
+		log.Println("WARNING: Running without VC_PROXY_SIGNING_KEY. Proxy URLs are signed with a per-process key and stop working on restart.")
+	}
 
 	handler := api.NewHandler(store)
+	if upstreamURL := os.Getenv("VC_UPSTREAM_URL"); upstreamURL != "" {
+		var timeout time.Duration
+		if v := os.Getenv("VC_UPSTREAM_TIMEOUT"); v != "" {
+			if timeout, err = time.ParseDuration(v); err != nil || timeout <= 0 {
+				log.Fatalf("Invalid VC_UPSTREAM_TIMEOUT %q (expected a duration such as \"10m\")", v)
+			}
+		}
+		handler.SetUpstream(api.NewUpstream(upstreamURL, os.Getenv("VC_UPSTREAM_TOKEN"), timeout))
+		log.Printf("Pulling missing artifacts through from %s", upstreamURL)
+	}
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
@@ -83,15 +112,13 @@ func main() {
 			log.Println("WARNING: Running without VC_AUTH_TOKEN. API is public.")
 		}
 
-		r.Post("/v1/negotiate", handler.HandleNegotiate)
-
-		if driverType == "local" {
-			r.Put("/v1/proxy/blob/{key}", handler.HandleProxyUpload)
-			r.Get("/v1/proxy/blob/{key}", handler.HandleProxyDownload)
-		}
+		handler.Mount(r)
+		handler.MountTurbo(r)
+		handler.MountHTTPCache(r)
+		handler.MountBuildCache(r)
 	})
 
-	log.Printf("Velocity Server v3.0 starting on :%s using driver '%s'", port, driverType)
+	log.Printf("Velocity Server %s starting on :%s using driver '%s'", version.Get().Version, port, driverType)
 	if err := http.ListenAndServe(":"+port, r); err != nil {
 		log.Fatalf("Server failed to start: %v", err)
 	}
@@ -102,10 +129,24 @@ func AuthMiddleware(token string) func(http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
+				// Basic auth clients such as Gradle only send credentials
+				// once challenged.
+				w.Header().Set("WWW-Authenticate", `Basic realm="velocity"`)
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
 
+			// Clients that cannot send a bearer token may send it as the
+			// password of basic auth; the username is ignored.
+			if _, password, ok := r.BasicAuth(); ok {
+				if password != token {
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			parts := strings.Split(authHeader, " ")
 			if len(parts) != 2 || parts[0] != "Bearer" || parts[1] != token {
 				http.Error(w, "Forbidden", http.StatusForbidden)
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	handler := AuthMiddleware("secret")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	cases := []struct {
+		name      string
+		setAuth   func(*http.Request)
+		want      int
+		challenge bool
+	}{
+		{name: "no credentials", setAuth: func(*http.Request) {}, want: http.StatusUnauthorized, challenge: true},
+		{name: "bearer token", setAuth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, want: http.StatusNoContent},
+		{name: "wrong bearer token", setAuth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer other") }, want: http.StatusForbidden},
+		{name: "basic auth", setAuth: func(r *http.Request) { r.SetBasicAuth("gradle", "secret") }, want: http.StatusNoContent},
+		{name: "basic auth without username", setAuth: func(r *http.Request) { r.SetBasicAuth("", "secret") }, want: http.StatusNoContent},
+		{name: "wrong basic auth password", setAuth: func(r *http.Request) { r.SetBasicAuth("gradle", "other") }, want: http.StatusForbidden},
+		{name: "token as basic auth username", setAuth: func(r *http.Request) { r.SetBasicAuth("secret", "") }, want: http.StatusForbidden},
+		{name: "unknown scheme", setAuth: func(r *http.Request) { r.Header.Set("Authorization", "Token secret") }, want: http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/cache/abc", nil)
+			tc.setAuth(req)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.want, rec.Code)
+			if tc.challenge {
+				assert.Equal(t, `Basic realm="velocity"`, rec.Header().Get("WWW-Authenticate"))
+			} else {
+				assert.Empty(t, rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
@@ -1,15 +1,31 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/bit2swaz/velocity-cache/internal/commands"
 )
 
 func main() {
-	if err := commands.NewRootCommand().Execute(); err != nil {
-		if exitErr, ok := err.(commands.ExitError); ok {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		// Restore default handling so a second signal exits immediately.
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := commands.NewRootCommand().ExecuteContext(ctx); err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Fprintln(os.Stderr, "Interrupted.")
+			os.Exit(130)
+		}
+		var exitErr commands.ExitError
+		if errors.As(err, &exitErr) {
 			os.Exit(exitErr.ExitCode())
 		}
 		fmt.Fprintln(os.Stderr, err)
@@ -0,0 +1,416 @@
+package commands
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/spf13/cobra"
+
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+const unknownTask = "(unknown)"
+
+type cacheStats struct {
+	Artifacts  int   `json:"artifacts"`
+	TotalBytes int64 `json:"total_bytes"`
+	// Built and Downloaded count the artifacts produced on this machine
+	// and fetched from the remote; artifacts stored without metadata are
+	// in neither.
+	Built      int              `json:"built"`
+	Downloaded int              `json:"downloaded"`
+	Oldest     *time.Time       `json:"oldest,omitempty"`
+	Newest     *time.Time       `json:"newest,omitempty"`
+	Tasks      []taskCacheStats `json:"tasks"`
+}
+
+type taskCacheStats struct {
+	Task      string `json:"task"`
+	Artifacts int    `json:"artifacts"`
+	Bytes     int64  `json:"bytes"`
+	// BuildMs is the total execution time of the task's artifacts built
+	// on this machine, which is roughly what hits on them save.
+	BuildMs int64 `json:"build_ms,omitempty"`
+
+	built int
+}
+
+func newCacheCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Inspect and manage the local cache",
+	}
+
+	var asJSON bool
+	stats := &cobra.Command{
+		Use:   "stats",
+		Short: "Show the size and contents of " + cachePath,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entries, err := engine.ListLocal()
+			if err != nil {
+				return err
+			}
+			summary := computeCacheStats(entries)
+			if asJSON {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(summary)
+			}
+			return writeCacheStats(cmd.OutOrStdout(), summary, time.Now())
+		},
+	}
+	stats.Flags().BoolVar(&asJSON, "json", false, "Print statistics as JSON")
+
+	var listJSON bool
+	list := &cobra.Command{
+		Use:     "ls",
+		Aliases: []string{"list"},
+		Short:   "List local cache entries, newest first",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entries, err := engine.ListLocal()
+			if err != nil {
+				return err
+			}
+			if listJSON {
+				return writeCacheEntriesJSON(cmd.OutOrStdout(), entries)
+			}
+			return writeCacheEntries(cmd.OutOrStdout(), entries, time.Now())
+		},
+	}
+	list.Flags().BoolVar(&listJSON, "json", false, "Print entries as JSON")
+
+	remove := &cobra.Command{
+		Use:   "rm <key>...",
+		Short: "Delete local cache entries by key or unique key prefix",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entries, err := engine.ListLocal()
+			if err != nil {
+				return err
+			}
+			for _, arg := range args {
+				key, err := resolveCacheKey(arg, entries)
+				if err != nil {
+					return err
+				}
+				if _, err := engine.RemoveLocal(key); err != nil {
+					return err
+				}
+				logInfo(cmd.OutOrStdout(), fmt.Sprintf("Removed %s", key))
+			}
+			return nil
+		},
+	}
+
+	var exportTask string
+	export := &cobra.Command{
+		Use:   "export <file.tar> [key...]",
+		Short: "Bundle local cache entries into a tar file for another machine",
+		Long: "Write the selected artifacts and their manifests to a tar file. Without keys\n" +
+			"or --task every entry is exported. Files ending in .gz or .tgz are gzipped,\n" +
+			"and - writes to stdout.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entries, err := engine.ListLocal()
+			if err != nil {
+				return err
+			}
+			keys, err := selectCacheEntries(entries, args[1:], exportTask)
+			if err != nil {
+				return err
+			}
+			if err := exportCacheBundle(cmd, args[0], keys); err != nil {
+				return err
+			}
+			logInfo(cmd.ErrOrStderr(), fmt.Sprintf("Exported %d artifact(s) to %s", len(keys), args[0]))
+			return nil
+		},
+	}
+	export.Flags().StringVar(&exportTask, "task", "", "Only export artifacts whose task ID matches this glob (e.g. 'apps/*#build')")
+
+	importCmd := &cobra.Command{
+		Use:   "import <file.tar>",
+		Short: "Add the entries of an exported bundle to the local cache",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			imported, err := importCacheBundle(cmd, args[0])
+			if err != nil {
+				return err
+			}
+			logInfo(cmd.OutOrStdout(), fmt.Sprintf("Imported %d new artifact(s) from %s", len(imported), args[0]))
+			return nil
+		},
+	}
+
+	cmd.AddCommand(stats, list, remove, export, importCmd, newCachePutCommand(), newCacheGetCommand())
+	return cmd
+}
+
+// selectCacheEntries resolves key prefixes and a task glob to the keys to
+// export. With neither, every entry is selected.
+func selectCacheEntries(entries []engine.LocalEntry, prefixes []string, taskGlob string) ([]string, error) {
+	if taskGlob != "" && !doublestar.ValidatePattern(taskGlob) {
+		return nil, fmt.Errorf("invalid --task pattern %q", taskGlob)
+	}
+
+	seen := make(map[string]bool)
+	var keys []string
+	add := func(key string) {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	for _, prefix := range prefixes {
+		key, err := resolveCacheKey(prefix, entries)
+		if err != nil {
+			return nil, err
+		}
+		add(key)
+	}
+	if taskGlob != "" {
+		for _, entry := range entries {
+			if ok, _ := doublestar.Match(taskGlob, entry.Task); ok {
+				add(entry.Key)
+			}
+		}
+	}
+	if len(prefixes) == 0 && taskGlob == "" {
+		for _, entry := range entries {
+			add(entry.Key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no cache entries selected")
+	}
+	return keys, nil
+}
+
+func isGzipBundle(path string) bool {
+	return strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".tgz")
+}
+
+func exportCacheBundle(cmd *cobra.Command, path string, keys []string) (err error) {
+	var w io.Writer = cmd.OutOrStdout()
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("create %s: %w", path, err)
+		}
+		defer func() {
+			if closeErr := file.Close(); err == nil && closeErr != nil {
+				err = fmt.Errorf("close %s: %w", path, closeErr)
+			}
+			if err != nil {
+				os.Remove(path)
+			}
+		}()
+		w = file
+	}
+
+	if isGzipBundle(path) {
+		gz := gzip.NewWriter(w)
+		if err := engine.ExportLocal(gz, keys); err != nil {
+			return err
+		}
+		return gz.Close()
+	}
+	return engine.ExportLocal(w, keys)
+}
+
+func importCacheBundle(cmd *cobra.Command, path string) ([]string, error) {
+	var r io.Reader = cmd.InOrStdin()
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("open %s: %w", path, err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	if isGzipBundle(path) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return engine.ImportLocal(r)
+}
+
+type cacheEntryJSON struct {
+	Key        string     `json:"key"`
+	Bytes      int64      `json:"bytes"`
+	Modified   time.Time  `json:"modified"`
+	Task       string     `json:"task,omitempty"`
+	Command    string     `json:"command,omitempty"`
+	Source     string     `json:"source,omitempty"`
+	Created    *time.Time `json:"created,omitempty"`
+	DurationMs int64      `json:"duration_ms,omitempty"`
+}
+
+func writeCacheEntriesJSON(out io.Writer, entries []engine.LocalEntry) error {
+	items := make([]cacheEntryJSON, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		item := cacheEntryJSON{Key: entry.Key, Bytes: entry.Size, Modified: entry.ModTime, Task: entry.Task}
+		if info := entry.Info; info != nil {
+			item.Command, item.Source, item.DurationMs = info.Command, info.Source, info.DurationMs
+			item.Created = &info.CreatedAt
+		}
+		items = append(items, item)
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string]interface{}{"entries": items})
+}
+
+func writeCacheEntries(out io.Writer, entries []engine.LocalEntry, now time.Time) error {
+	if len(entries) == 0 {
+		logInfo(out, fmt.Sprintf("%s is empty.", cachePath))
+		return nil
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY	SIZE	AGE	SOURCE	TASK")
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		task := entry.Task
+		if task == "" {
+			task = subtleStyle.Sprint(unknownTask)
+		}
+		source := subtleStyle.Sprint("-")
+		if entry.Info != nil {
+			source = entry.Info.Source
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", shortHash(entry.Key), formatBytes(entry.Size), formatAge(now.Sub(entry.ModTime)), source, task)
+	}
+	return w.Flush()
+}
+
+// resolveCacheKey expands a key prefix, such as the short hashes printed by
+// `cache ls`, to the single entry it identifies.
+func resolveCacheKey(prefix string, entries []engine.LocalEntry) (string, error) {
+	var matches []string
+	for _, entry := range entries {
+		if entry.Key == prefix {
+			return entry.Key, nil
+		}
+		if strings.HasPrefix(entry.Key, prefix) {
+			matches = append(matches, entry.Key)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no cache entry matches %q", prefix)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("%q matches %d cache entries; use a longer prefix", prefix, len(matches))
+	}
+}
+
+func computeCacheStats(entries []engine.LocalEntry) cacheStats {
+	summary := cacheStats{Artifacts: len(entries), Tasks: []taskCacheStats{}}
+	byTask := make(map[string]*taskCacheStats)
+	for _, entry := range entries {
+		summary.TotalBytes += entry.Size
+		modTime := entry.ModTime
+		if summary.Oldest == nil || modTime.Before(*summary.Oldest) {
+			summary.Oldest = &modTime
+		}
+		if summary.Newest == nil || modTime.After(*summary.Newest) {
+			summary.Newest = &modTime
+		}
+
+		task := entry.Task
+		if task == "" {
+			task = unknownTask
+		}
+		stats, ok := byTask[task]
+		if !ok {
+			stats = &taskCacheStats{Task: task}
+			byTask[task] = stats
+		}
+		stats.Artifacts++
+		stats.Bytes += entry.Size
+
+		if entry.Info == nil {
+			continue
+		}
+		switch entry.Info.Source {
+		case engine.ArtifactSourceLocal:
+			summary.Built++
+			stats.built++
+			stats.BuildMs += entry.Info.DurationMs
+		case engine.ArtifactSourceRemote:
+			summary.Downloaded++
+		}
+	}
+
+	for _, stats := range byTask {
+		summary.Tasks = append(summary.Tasks, *stats)
+	}
+	sort.Slice(summary.Tasks, func(i, j int) bool {
+		if summary.Tasks[i].Bytes != summary.Tasks[j].Bytes {
+			return summary.Tasks[i].Bytes > summary.Tasks[j].Bytes
+		}
+		return summary.Tasks[i].Task < summary.Tasks[j].Task
+	})
+	return summary
+}
+
+func writeCacheStats(out io.Writer, summary cacheStats, now time.Time) error {
+	if summary.Artifacts == 0 {
+		logInfo(out, fmt.Sprintf("%s is empty.", cachePath))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Artifacts\t%d\n", summary.Artifacts)
+	fmt.Fprintf(w, "Total size\t%s\n", formatBytes(summary.TotalBytes))
+	fmt.Fprintf(w, "Built here\t%d\n", summary.Built)
+	fmt.Fprintf(w, "Downloaded\t%d\n", summary.Downloaded)
+	fmt.Fprintf(w, "Oldest\t%s (%s ago)\n", summary.Oldest.Format(time.DateTime), formatAge(now.Sub(*summary.Oldest)))
+	fmt.Fprintf(w, "Newest\t%s (%s ago)\n", summary.Newest.Format(time.DateTime), formatAge(now.Sub(*summary.Newest)))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TASK\tARTIFACTS\tSIZE\tAVG BUILD")
+	for _, task := range summary.Tasks {
+		build := subtleStyle.Sprint("-")
+		if task.built > 0 {
+			build = (time.Duration(task.BuildMs/int64(task.built)) * time.Millisecond).String()
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", task.Task, task.Artifacts, formatBytes(task.Bytes), build)
+	}
+	return w.Flush()
+}
+
+// formatAge renders a duration at the coarsest useful unit, e.g. 3d or 45m.
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
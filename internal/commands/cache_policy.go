@@ -0,0 +1,66 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+)
+
+// cachePolicy controls which cache tiers a run may read from and write to.
+type cachePolicy struct {
+	localRead   bool
+	localWrite  bool
+	remoteRead  bool
+	remoteWrite bool
+}
+
+func defaultCachePolicy() cachePolicy {
+	return cachePolicy{localRead: true, localWrite: true, remoteRead: true, remoteWrite: true}
+}
+
+// parseCachePolicy parses a spec such as "local:rw,remote:r". Tiers that are
+// not listed are disabled; an empty spec keeps every tier fully enabled.
+func parseCachePolicy(spec string) (cachePolicy, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return defaultCachePolicy(), nil
+	}
+
+	var policy cachePolicy
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		tier, mode, _ := strings.Cut(entry, ":")
+		tier = strings.ToLower(strings.TrimSpace(tier))
+		mode = strings.ToLower(strings.TrimSpace(mode))
+
+		if seen[tier] {
+			return cachePolicy{}, fmt.Errorf("invalid --cache %q: %s listed more than once", spec, tier)
+		}
+		seen[tier] = true
+
+		var read, write bool
+		for _, c := range mode {
+			switch c {
+			case 'r':
+				read = true
+			case 'w':
+				write = true
+			default:
+				return cachePolicy{}, fmt.Errorf("invalid --cache %q: unknown mode %q for %s (expected r, w or rw)", spec, mode, tier)
+			}
+		}
+
+		switch tier {
+		case "local":
+			policy.localRead, policy.localWrite = read, write
+		case "remote":
+			policy.remoteRead, policy.remoteWrite = read, write
+		default:
+			return cachePolicy{}, fmt.Errorf("invalid --cache %q: unknown tier %q (expected local or remote)", spec, tier)
+		}
+	}
+	return policy, nil
+}
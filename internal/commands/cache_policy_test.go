@@ -0,0 +1,33 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCachePolicy(t *testing.T) {
+	policy, err := parseCachePolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, defaultCachePolicy(), policy)
+
+	policy, err = parseCachePolicy("local:rw,remote:r")
+	require.NoError(t, err)
+	assert.Equal(t, cachePolicy{localRead: true, localWrite: true, remoteRead: true}, policy)
+
+	policy, err = parseCachePolicy("remote:w")
+	require.NoError(t, err)
+	assert.Equal(t, cachePolicy{remoteWrite: true}, policy, "unlisted tiers are disabled")
+
+	for _, spec := range []string{"disk:rw", "local:x", "local:r,local:w"} {
+		_, err := parseCachePolicy(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestRunOptionsCachePolicyShortcuts(t *testing.T) {
+	policy, err := runOptions{remoteOnly: true, noRemoteWrite: true}.cachePolicy()
+	require.NoError(t, err)
+	assert.Equal(t, cachePolicy{remoteRead: true}, policy)
+}
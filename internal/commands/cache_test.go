@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+func TestComputeCacheStatsGroupsByTask(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := []engine.LocalEntry{
+		{Key: "a", Size: 100, ModTime: base, Task: "app#build"},
+		{Key: "b", Size: 300, ModTime: base.Add(time.Hour), Task: "app#build"},
+		{Key: "c", Size: 50, ModTime: base.Add(2 * time.Hour), Task: "lib#build"},
+		{Key: "d", Size: 10, ModTime: base.Add(-time.Hour)},
+	}
+
+	stats := computeCacheStats(entries)
+	assert.Equal(t, 4, stats.Artifacts)
+	assert.Equal(t, int64(460), stats.TotalBytes)
+	assert.Equal(t, base.Add(-time.Hour), *stats.Oldest)
+	assert.Equal(t, base.Add(2*time.Hour), *stats.Newest)
+	assert.Equal(t, []taskCacheStats{
+		{Task: "app#build", Artifacts: 2, Bytes: 400},
+		{Task: "lib#build", Artifacts: 1, Bytes: 50},
+		{Task: unknownTask, Artifacts: 1, Bytes: 10},
+	}, stats.Tasks)
+
+	var out bytes.Buffer
+	require.NoError(t, writeCacheStats(&out, stats, base.Add(50*time.Hour)))
+	assert.Contains(t, out.String(), "Total size  460 B")
+	assert.Contains(t, out.String(), "(2d ago)")
+}
+
+func TestComputeCacheStatsUsesArtifactMetadata(t *testing.T) {
+	entries := []engine.LocalEntry{
+		{Key: "a", Size: 100, Task: "app#build", Info: &engine.ArtifactInfo{Source: engine.ArtifactSourceLocal, DurationMs: 2000}},
+		{Key: "b", Size: 100, Task: "app#build", Info: &engine.ArtifactInfo{Source: engine.ArtifactSourceLocal, DurationMs: 4000}},
+		{Key: "c", Size: 100, Task: "app#build", Info: &engine.ArtifactInfo{Source: engine.ArtifactSourceRemote}},
+	}
+
+	stats := computeCacheStats(entries)
+	assert.Equal(t, 2, stats.Built)
+	assert.Equal(t, 1, stats.Downloaded)
+	require.Len(t, stats.Tasks, 1)
+	assert.Equal(t, int64(6000), stats.Tasks[0].BuildMs)
+
+	var out bytes.Buffer
+	require.NoError(t, writeCacheStats(&out, stats, time.Now()))
+	assert.Contains(t, out.String(), "3s", "the average build time of built artifacts")
+}
+
+func TestCacheStatsEmpty(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, writeCacheStats(&out, computeCacheStats(nil), time.Now()))
+	assert.Contains(t, out.String(), "is empty")
+}
+
+func TestResolveCacheKeyPrefixes(t *testing.T) {
+	entries := []engine.LocalEntry{{Key: "abc123"}, {Key: "abd456"}, {Key: "ff00"}}
+
+	key, err := resolveCacheKey("abc", entries)
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", key)
+
+	key, err = resolveCacheKey("ff00", entries)
+	require.NoError(t, err)
+	assert.Equal(t, "ff00", key)
+
+	_, err = resolveCacheKey("ab", entries)
+	assert.ErrorContains(t, err, "matches 2 cache entries")
+
+	_, err = resolveCacheKey("zz", entries)
+	assert.ErrorContains(t, err, "no cache entry")
+}
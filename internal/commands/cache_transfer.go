@@ -0,0 +1,418 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+	"github.com/bit2swaz/velocity-cache/pkg/storage/s3"
+)
+
+func newCachePutCommand() *cobra.Command {
+	var key, tiers string
+	cmd := &cobra.Command{
+		Use:   "put --key <key> <path>...",
+		Short: "Archive files or directories and store them under a cache key",
+		Long: "Archive the given paths and store the artifact under --key in the local cache\n" +
+			"and, when remote caching is enabled, on the remote server.",
+		Example: "  velocity cache put --key deps-$(sha256sum package-lock.json | cut -c1-16) node_modules",
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cachePut(cmd, key, tiers, args)
+		},
+	}
+	cmd.Flags().StringVar(&key, "key", "", "Cache key to store the artifact under")
+	cmd.Flags().StringVar(&tiers, "cache", "", "Cache tiers to write, e.g. local:w or remote:w (default: all)")
+	cmd.MarkFlagRequired("key")
+	return cmd
+}
+
+func newCacheGetCommand() *cobra.Command {
+	var key, output, tiers string
+	cmd := &cobra.Command{
+		Use:   "get --key <key> -o <file.zip>",
+		Short: "Fetch the artifact stored under a cache key",
+		Long: "Copy the artifact stored under --key to a zip file, looking in the local cache\n" +
+			"first and then on the remote server.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cacheGet(cmd, key, output, tiers)
+		},
+	}
+	cmd.Flags().StringVar(&key, "key", "", "Cache key to fetch")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Path of the zip file to write (\"-\" for stdout)")
+	cmd.Flags().StringVar(&tiers, "cache", "", "Cache tiers to read, e.g. local:r or remote:r (default: all)")
+	cmd.MarkFlagRequired("key")
+	cmd.MarkFlagRequired("output")
+	return cmd
+}
+
+// loadCacheTiers loads the config for put and get, which also work outside
+// a workspace, and returns the remote caches when the remote tier is
+// usable.
+func loadCacheTiers(spec string) (*config.Config, cachePolicy, []*remoteCache, error) {
+	policy, err := parseCachePolicy(spec)
+	if err != nil {
+		return nil, cachePolicy{}, nil, err
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, cachePolicy{}, nil, fmt.Errorf("load config: %w", err)
+		}
+		cfg = &config.Config{}
+	}
+	remotes, err := newRemoteCaches(cfg)
+	if err != nil {
+		return nil, cachePolicy{}, nil, err
+	}
+	return cfg, policy, remotes, nil
+}
+
+// remoteCache is one of the remote caches a run reads from and writes to.
+type remoteCache struct {
+	name   string
+	client *engine.RemoteClient
+	write  bool
+	// label starts the remote's log messages; it is empty when there is
+	// only one remote.
+	label string
+}
+
+// newRemoteCaches returns clients for the configured remote caches, in
+// priority order.
+func newRemoteCaches(cfg *config.Config) ([]*remoteCache, error) {
+	configs := cfg.RemoteCaches()
+	remotes := make([]*remoteCache, 0, len(configs))
+	for _, remoteCfg := range configs {
+		client, err := newRemoteClient(cfg, remoteCfg)
+		if err != nil {
+			return nil, fmt.Errorf("remote %s: %w", remoteCfg.Name, err)
+		}
+		remote := &remoteCache{name: remoteCfg.Name, client: client, write: !remoteCfg.ReadOnly}
+		if len(configs) > 1 {
+			remote.label = remoteCfg.Name + ": "
+		}
+		remotes = append(remotes, remote)
+	}
+	return remotes, nil
+}
+
+// newRemoteClient returns a client for the remote cache described by
+// remote that encrypts and signs artifacts when the keys for that are
+// configured.
+func newRemoteClient(cfg *config.Config, remote config.RemoteConfig) (*engine.RemoteClient, error) {
+	key, err := cfg.EncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	signatureKey, err := cfg.SignatureKey()
+	if err != nil {
+		return nil, err
+	}
+	missTTL, err := remote.MissDuration()
+	if err != nil {
+		return nil, err
+	}
+	backoff, maxBackoff, err := cfg.Remote.Retry.Delays()
+	if err != nil {
+		return nil, err
+	}
+	if err := engine.SetTLSOptions(engine.TLSOptions{CAFile: cfg.Remote.CAFile, InsecureSkipVerify: cfg.Remote.InsecureSkipVerify}); err != nil {
+		return nil, err
+	}
+	engine.SetRetryPolicy(engine.RetryPolicy{Attempts: cfg.Remote.Retry.Attempts, BaseDelay: backoff, MaxDelay: maxBackoff})
+	client, err := remoteAPIClient(remote)
+	if err != nil {
+		return nil, err
+	}
+	client.SetEncryptionKey(key)
+	client.SetSignatureKey(signatureKey, cfg.Remote.RequireSignature)
+	client.SetMissTTL(missTTL)
+	return client, nil
+}
+
+// remoteAPIClient returns a client for the server at remote.url or, with
+// remote.bucket, one that stores artifacts in that bucket directly.
+func remoteAPIClient(remote config.RemoteConfig) (*engine.RemoteClient, error) {
+	bucket := remote.Bucket
+	if strings.TrimSpace(bucket.Name) == "" {
+		return engine.NewRemoteClient(remote.URL, remote.Token), nil
+	}
+	timeout, err := bucket.TimeoutDuration()
+	if err != nil {
+		return nil, err
+	}
+	prefix, err := bucket.CleanPrefix()
+	if err != nil {
+		return nil, err
+	}
+	driver, err := s3.NewWithOptions(context.Background(), s3.Options{
+		Bucket:        bucket.Name,
+		Region:        bucket.Region,
+		Endpoint:      bucket.Endpoint,
+		Prefix:        prefix,
+		VirtualHosted: bucket.VirtualHosted,
+		RetryMode:     bucket.RetryMode,
+		MaxAttempts:   bucket.MaxAttempts,
+		Timeout:       timeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("bucket %s: %w", bucket.Name, err)
+	}
+	return engine.NewStoreClient(bucket.URL(), driver), nil
+}
+
+func cachePut(cmd *cobra.Command, key, tiers string, paths []string) error {
+	if err := engine.ValidateCacheKey(key); err != nil {
+		return err
+	}
+	cfg, policy, remotes, err := loadCacheTiers(tiers)
+	if err != nil {
+		return err
+	}
+	var writable []*remoteCache
+	for _, remote := range remotes {
+		if remote.write {
+			writable = append(writable, remote)
+		}
+	}
+	if len(writable) == 0 {
+		policy.remoteWrite = false
+	}
+	if !policy.localWrite && !policy.remoteWrite {
+		return fmt.Errorf("no writable cache tier (enable remote caching in %s or allow local:w)", configFileName)
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("stat %s: %w", path, err)
+		}
+	}
+
+	maxBytes, err := cfg.LocalCacheMaxBytes()
+	if err != nil {
+		return err
+	}
+	engine.SetLocalCacheMaxBytes(maxBytes)
+
+	ctx := cmd.Context()
+	out := cmd.OutOrStdout()
+
+	var targets []*remoteCache
+	var errs []error
+	if policy.remoteWrite {
+		// Ask before archiving, so nothing is built for remotes that have
+		// the key already.
+		for _, remote := range writable {
+			resp, err := remote.client.Negotiate(ctx, key, "upload")
+			switch {
+			case err != nil:
+				errs = append(errs, fmt.Errorf("%snegotiate upload: %w", remote.label, err))
+			case resp.Status == "skipped":
+				logInfo(out, remote.label+"Artifact already exists remotely (skipped).")
+			default:
+				targets = append(targets, remote)
+			}
+		}
+		if len(targets) == 0 && !policy.localWrite {
+			return errors.Join(errs...)
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "velo-put-*.zip")
+	if err != nil {
+		return fmt.Errorf("create temp archive: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := engine.Compress(ctx, paths, tmp.Name(), ".", engine.ArchiveOptions{Format: cfg.ArchiveFormatFor(config.TaskConfig{}), PreserveMetadata: cfg.PreserveMetadata}); err != nil {
+		return fmt.Errorf("archive %v: %w", paths, err)
+	}
+
+	archive := tmp.Name()
+	if policy.localWrite {
+		localZip, err := engine.SaveLocal(key, tmp.Name())
+		if err != nil {
+			return err
+		}
+		archive = localZip
+		logInfo(out, fmt.Sprintf("Stored %s in the local cache.", key))
+	}
+
+	for _, remote := range targets {
+		if err := putRemote(ctx, remote, key, archive, out); err != nil {
+			errs = append(errs, fmt.Errorf("%s%w", remote.label, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// putRemote uploads the artifact at archive for key to remote.
+func putRemote(ctx context.Context, remote *remoteCache, key, archive string, out io.Writer) error {
+	upload, err := remote.client.SealArtifact(key, archive)
+	if err != nil {
+		return fmt.Errorf("seal %s: %w", key, err)
+	}
+	if upload != archive {
+		defer os.Remove(upload)
+	}
+	checksum, err := engine.ArtifactChecksum(upload)
+	if err != nil {
+		return err
+	}
+	resp, err := remote.client.NegotiateUpload(ctx, key, checksum)
+	if err != nil {
+		return fmt.Errorf("negotiate upload: %w", err)
+	}
+	if resp.Status != "upload_needed" {
+		logInfo(out, remote.label+"Artifact already exists remotely (skipped).")
+		return nil
+	}
+
+	stat, err := os.Stat(upload)
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", upload, err)
+	}
+	if err := remote.client.Upload(ctx, key, resp, upload, checksum, nil); err != nil {
+		return fmt.Errorf("upload %s: %w", key, err)
+	}
+	logInfo(out, fmt.Sprintf("%sUploaded %s (%s).", remote.label, key, formatBytes(stat.Size())))
+	return nil
+}
+
+func cacheGet(cmd *cobra.Command, key, output, tiers string) error {
+	if err := engine.ValidateCacheKey(key); err != nil {
+		return err
+	}
+	_, policy, remotes, err := loadCacheTiers(tiers)
+	if err != nil {
+		return err
+	}
+
+	// Status messages go to stderr so that `-o -` keeps stdout clean.
+	errOut := cmd.ErrOrStderr()
+	if policy.localRead {
+		localZip, found, err := engine.CheckLocal(key)
+		if err != nil {
+			return err
+		}
+		if found {
+			if err := engine.VerifyLocal(key); errors.Is(err, engine.ErrChecksumMismatch) || errors.Is(err, engine.ErrCorruptArtifact) {
+				logWarning(errOut, fmt.Sprintf("Removed corrupt local artifact: %v", err))
+				found = false
+			} else if err != nil {
+				return err
+			}
+		}
+		if found {
+			if err := copyArtifact(cmd, localZip, output); err != nil {
+				return err
+			}
+			_ = engine.TouchLocal(key)
+			logInfo(errOut, fmt.Sprintf("Restored %s from the local cache.", key))
+			return nil
+		}
+	}
+
+	if policy.remoteRead {
+		// Later remotes are tried when one fails; its error is reported
+		// only if none has the artifact.
+		var errs []error
+		for _, remote := range remotes {
+			found, err := getRemote(cmd, remote, key, output, policy.localWrite)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%w", remote.label, err))
+				continue
+			}
+			if found {
+				return nil
+			}
+		}
+		if len(errs) > 0 {
+			return errors.Join(errs...)
+		}
+	}
+
+	return fmt.Errorf("no artifact found for key %s", key)
+}
+
+// getRemote copies the artifact for key from remote to output, reporting
+// whether the remote had it.
+func getRemote(cmd *cobra.Command, remote *remoteCache, key, output string, localWrite bool) (bool, error) {
+	ctx, errOut := cmd.Context(), cmd.ErrOrStderr()
+	resp, err := remote.client.Negotiate(ctx, key, "download")
+	if err != nil {
+		return false, fmt.Errorf("negotiate download: %w", err)
+	}
+	if resp.Status != "found" {
+		return false, nil
+	}
+	tmp, err := os.CreateTemp("", "velo-get-*.zip")
+	if err != nil {
+		return false, fmt.Errorf("create temp archive: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	err = remote.client.Download(ctx, key, resp, tmp, nil)
+	tmp.Close()
+	if err != nil {
+		return false, fmt.Errorf("download %s: %w", key, err)
+	}
+	if err := remote.client.OpenArtifact(key, tmp.Name()); err != nil {
+		return false, fmt.Errorf("open %s: %w", key, err)
+	}
+	if localWrite {
+		if _, err := engine.SaveLocal(key, tmp.Name()); err != nil {
+			logWarning(errOut, fmt.Sprintf("Failed to store %s locally: %v", key, err))
+		}
+	}
+	if err := copyArtifact(cmd, tmp.Name(), output); err != nil {
+		return false, err
+	}
+	logInfo(errOut, fmt.Sprintf("Restored %s from the %s cache.", key, remoteCacheName(remote)))
+	return true, nil
+}
+
+// remoteCacheName names remote in messages such as "restored from the
+// remote cache".
+func remoteCacheName(remote *remoteCache) string {
+	if remote.label == "" {
+		return "remote"
+	}
+	return remote.name + " remote"
+}
+
+func copyArtifact(cmd *cobra.Command, src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", src, err)
+	}
+	defer in.Close()
+
+	if dst == "-" {
+		if _, err := io.Copy(cmd.OutOrStdout(), in); err != nil {
+			return fmt.Errorf("write artifact: %w", err)
+		}
+		return nil
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("write %s: %w", dst, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", dst, err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package commands
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachePutAndGetLocal(t *testing.T) {
+	t.Chdir(t.TempDir())
+	require.NoError(t, os.MkdirAll("deps", 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join("deps", "lib.txt"), []byte("lib"), 0o644))
+
+	put := newCacheCommand()
+	put.SetArgs([]string{"put", "--key", "deps-1", "deps"})
+	require.NoError(t, put.Execute())
+
+	get := newCacheCommand()
+	get.SetArgs([]string{"get", "--key", "deps-1", "-o", "out.zip"})
+	require.NoError(t, get.Execute())
+
+	reader, err := zip.OpenReader("out.zip")
+	require.NoError(t, err)
+	defer reader.Close()
+	var names []string
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+	assert.Contains(t, names, "deps/lib.txt")
+}
+
+func TestCacheGetMissingKey(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	get := newCacheCommand()
+	get.SetArgs([]string{"get", "--key", "missing", "-o", "out.zip"})
+	assert.ErrorContains(t, get.Execute(), "no artifact found for key missing")
+	assert.NoFileExists(t, "out.zip")
+}
+
+func TestCachePutRejectsInvalidKey(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	put := newCacheCommand()
+	put.SetArgs([]string{"put", "--key", "../escape", "."})
+	assert.Error(t, put.Execute())
+}
+
+func TestCachePutAndGetThroughBucket(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "none"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "none"))
+
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	bucket := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = body
+		case http.MethodHead, http.MethodGet:
+			body, ok := objects[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(body)
+		}
+	}))
+	defer bucket.Close()
+
+	config := fmt.Sprintf("version: 1\nremote:\n  enabled: true\n  bucket:\n    name: builds\n    region: us-east-1\n    endpoint: %s\n    prefix: velocity\n", bucket.URL)
+	require.NoError(t, os.WriteFile(configFileName, []byte(config), 0o644))
+	require.NoError(t, os.MkdirAll("deps", 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join("deps", "lib.txt"), []byte("lib"), 0o644))
+
+	put := newCacheCommand()
+	put.SetArgs([]string{"put", "--key", "deps-1", "--cache", "remote:w", "deps"})
+	require.NoError(t, put.Execute())
+	assert.Contains(t, objects, "/builds/velocity/deps-1")
+
+	get := newCacheCommand()
+	get.SetArgs([]string{"get", "--key", "deps-1", "--cache", "remote:r", "-o", "out.zip"})
+	require.NoError(t, get.Execute())
+	reader, err := zip.OpenReader("out.zip")
+	require.NoError(t, err)
+	defer reader.Close()
+	var names []string
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+	assert.Contains(t, names, "deps/lib.txt")
+}
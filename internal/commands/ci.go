@@ -0,0 +1,110 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ciProvider string
+
+const (
+	ciNone      ciProvider = ""
+	ciGeneric   ciProvider = "generic"
+	ciGitHub    ciProvider = "github"
+	ciGitLab    ciProvider = "gitlab"
+	ciBuildkite ciProvider = "buildkite"
+)
+
+// currentCI is set from --ci and the environment before a command runs.
+var currentCI = ciNone
+
+func detectCIProvider(getenv func(string) string) ciProvider {
+	switch {
+	case getenv("GITHUB_ACTIONS") == "true":
+		return ciGitHub
+	case getenv("GITLAB_CI") == "true":
+		return ciGitLab
+	case getenv("BUILDKITE") == "true":
+		return ciBuildkite
+	case envEnabled(getenv("CI")):
+		return ciGeneric
+	}
+	return ciNone
+}
+
+// ciReporter writes each task's buffered output as one collapsible group in
+// the provider's log viewer and annotates failed tasks.
+type ciReporter struct {
+	provider ciProvider
+	now      func() time.Time
+
+	mu sync.Mutex
+}
+
+func newCIReporter(provider ciProvider) *ciReporter {
+	return &ciReporter{provider: provider, now: time.Now}
+}
+
+func (r *ciReporter) flushTask(out io.Writer, taskID string, output []byte, taskErr error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.startGroup(out, taskID)
+	out.Write(output)
+	if len(output) > 0 && output[len(output)-1] != '\n' {
+		fmt.Fprintln(out)
+	}
+	r.endGroup(out, taskID)
+	if taskErr != nil {
+		r.annotateError(out, fmt.Sprintf("Task %s failed", taskID), taskErr.Error())
+	}
+}
+
+var gitlabSectionName = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+func (r *ciReporter) startGroup(out io.Writer, title string) {
+	switch r.provider {
+	case ciGitHub:
+		fmt.Fprintf(out, "::group::%s\n", title)
+	case ciGitLab:
+		name := gitlabSectionName.ReplaceAllString(title, "_")
+		fmt.Fprintf(out, "\x1b[0Ksection_start:%d:%s[collapsed=true]\r\x1b[0K%s\n", r.now().Unix(), name, title)
+	case ciBuildkite:
+		fmt.Fprintf(out, "--- %s\n", title)
+	}
+}
+
+func (r *ciReporter) endGroup(out io.Writer, title string) {
+	switch r.provider {
+	case ciGitHub:
+		fmt.Fprintln(out, "::endgroup::")
+	case ciGitLab:
+		name := gitlabSectionName.ReplaceAllString(title, "_")
+		fmt.Fprintf(out, "\x1b[0Ksection_end:%d:%s\r\x1b[0K\n", r.now().Unix(), name)
+	}
+}
+
+func (r *ciReporter) annotateError(out io.Writer, title, message string) {
+	switch r.provider {
+	case ciGitHub:
+		fmt.Fprintf(out, "::error title=%s::%s\n", escapeGitHubProperty(title), escapeGitHubData(message))
+	case ciBuildkite:
+		// Expand the group that was just closed so the failure is visible.
+		fmt.Fprintln(out, "^^^ +++")
+		fmt.Fprintf(out, "ERROR: %s\n", message)
+	default:
+		fmt.Fprintf(out, "ERROR: %s\n", message)
+	}
+}
+
+func escapeGitHubData(value string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(value)
+}
+
+func escapeGitHubProperty(value string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(value)
+}
@@ -0,0 +1,43 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectCIProvider(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(key string) string { return vars[key] }
+	}
+	assert.Equal(t, ciGitHub, detectCIProvider(env(map[string]string{"GITHUB_ACTIONS": "true", "CI": "true"})))
+	assert.Equal(t, ciGitLab, detectCIProvider(env(map[string]string{"GITLAB_CI": "true"})))
+	assert.Equal(t, ciBuildkite, detectCIProvider(env(map[string]string{"BUILDKITE": "true"})))
+	assert.Equal(t, ciGeneric, detectCIProvider(env(map[string]string{"CI": "1"})))
+	assert.Equal(t, ciNone, detectCIProvider(env(nil)))
+}
+
+func TestCIReporterGitHub(t *testing.T) {
+	var out bytes.Buffer
+	reporter := newCIReporter(ciGitHub)
+	reporter.flushTask(&out, "apps/web#build", []byte("compiling"), errors.New("exit status 1\n100% broken"))
+
+	assert.Equal(t, "::group::apps/web#build\n"+
+		"compiling\n"+
+		"::endgroup::\n"+
+		"::error title=Task apps/web#build failed::exit status 1%0A100%25 broken\n", out.String())
+}
+
+func TestCIReporterGitLabSections(t *testing.T) {
+	var out bytes.Buffer
+	reporter := newCIReporter(ciGitLab)
+	reporter.now = func() time.Time { return time.Unix(1700000000, 0) }
+	reporter.flushTask(&out, "@repo/ui#test", []byte("ok\n"), nil)
+
+	assert.Equal(t, "\x1b[0Ksection_start:1700000000:_repo_ui_test[collapsed=true]\r\x1b[0K@repo/ui#test\n"+
+		"ok\n"+
+		"\x1b[0Ksection_end:1700000000:_repo_ui_test\r\x1b[0K\n", out.String())
+}
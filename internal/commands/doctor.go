@@ -0,0 +1,323 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+
+	doctorRemoteTimeout = 5 * time.Second
+	lowDiskSpace        = 1 << 30
+	criticalDiskSpace   = 100 << 20
+)
+
+// doctorProbeKey is a cache key no task produces; asking the server for it
+// exercises authentication without touching real artifacts.
+const doctorProbeKey = "velocity-doctor-probe"
+
+type doctorCheck struct {
+	Name   string
+	Status string
+	Detail string
+	Hint   string
+}
+
+func newDoctorCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose configuration, git, remote cache and local cache problems",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			checks := runDoctorChecks(cmd.Context())
+			failed := printDoctorReport(cmd.OutOrStdout(), checks)
+			if failed > 0 {
+				err := fmt.Errorf("%d check(s) failed", failed)
+				fmt.Fprintf(cmd.ErrOrStderr(), "%s %s\n", prefix(), errorStyle.Sprint(err.Error()))
+				return newExitError(1, err)
+			}
+			logInfo(cmd.OutOrStdout(), "All checks passed.")
+			return nil
+		},
+	}
+}
+
+func runDoctorChecks(ctx context.Context) []doctorCheck {
+	var checks []doctorCheck
+
+	configCheck, cfg := checkConfig()
+	checks = append(checks, configCheck, checkGit(ctx))
+	checks = append(checks, checkRemote(ctx, cfg)...)
+	checks = append(checks,
+		checkWritable("Storage permissions", ".velocity"),
+		checkWritable("Local cache", cachePath),
+		checkDiskSpace("."),
+	)
+	return checks
+}
+
+func checkConfig() (doctorCheck, *config.Config) {
+	check := doctorCheck{Name: "Config"}
+	issues, err := config.ValidateFile(configFileName)
+	if err != nil {
+		check.Status = checkFail
+		check.Detail = err.Error()
+		if errors.Is(err, os.ErrNotExist) {
+			check.Detail = configFileName + " not found"
+		}
+		check.Hint = "run `velocity init` to create one"
+		return check, nil
+	}
+
+	errorCount, warningCount := 0, 0
+	for _, issue := range issues {
+		if issue.Severity == config.SeverityError {
+			errorCount++
+		} else {
+			warningCount++
+		}
+	}
+	switch {
+	case errorCount > 0:
+		check.Status = checkFail
+		check.Detail = fmt.Sprintf("%s has %d error(s) and %d warning(s)", configFileName, errorCount, warningCount)
+		check.Hint = "run `velocity validate` for details"
+	case warningCount > 0:
+		check.Status = checkWarn
+		check.Detail = fmt.Sprintf("%s has %d warning(s)", configFileName, warningCount)
+		check.Hint = "run `velocity validate` for details"
+	default:
+		check.Status = checkPass
+		check.Detail = configFileName + " is valid"
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return check, nil
+	}
+	return check, cfg
+}
+
+func checkGit(ctx context.Context) doctorCheck {
+	check := doctorCheck{Name: "Git"}
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		check.Status = checkFail
+		check.Detail = "git not found on PATH"
+		check.Hint = "install git; --affected and .gitignore-aware hashing need it"
+		return check
+	}
+
+	out, err := exec.CommandContext(ctx, gitPath, "rev-parse", "--is-inside-work-tree").Output()
+	if err != nil || strings.TrimSpace(string(out)) != "true" {
+		check.Status = checkWarn
+		check.Detail = "not inside a git repository"
+		check.Hint = "run `git init`; --affected needs a repository to diff against"
+		return check
+	}
+
+	check.Status = checkPass
+	check.Detail = gitPath
+	return check
+}
+
+// checkRemote reports server reachability and token validity for every
+// remote cache. Both are skipped when remote caching is disabled or the
+// config could not be read.
+func checkRemote(ctx context.Context, cfg *config.Config) []doctorCheck {
+	var remotes []config.RemoteConfig
+	if cfg != nil {
+		remotes = cfg.RemoteCaches()
+	}
+	if len(remotes) == 0 {
+		return []doctorCheck{{Name: "Remote server", Status: checkPass, Detail: "remote caching disabled"}}
+	}
+	var checks []doctorCheck
+	for _, remote := range remotes {
+		suffix := ""
+		if len(remotes) > 1 {
+			suffix = " (" + remote.Name + ")"
+		}
+		checks = append(checks, checkRemoteCache(ctx, remote, suffix)...)
+	}
+	return checks
+}
+
+func checkRemoteCache(ctx context.Context, remote config.RemoteConfig, suffix string) []doctorCheck {
+	if strings.TrimSpace(remote.Bucket.Name) != "" {
+		return []doctorCheck{checkRemoteBucket(ctx, remote, suffix)}
+	}
+	server := doctorCheck{Name: "Remote server" + suffix}
+	auth := doctorCheck{Name: "Auth token" + suffix}
+	url := strings.TrimRight(strings.TrimSpace(remote.URL), "/")
+	if url == "" {
+		server.Status = checkFail
+		server.Detail = "remote.url is empty"
+		server.Hint = "set remote.url in velocity.yml or export VC_SERVER_URL"
+		auth.Status = checkWarn
+		auth.Detail = "skipped, server unknown"
+		return []doctorCheck{server, auth}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, doctorRemoteTimeout)
+	defer cancel()
+
+	client := engine.NewRemoteClient(url, remote.Token)
+	if err := client.Health(ctx); err != nil {
+		server.Status = checkFail
+		server.Detail = fmt.Sprintf("%s: %v", url, err)
+		server.Hint = "check that the server is running and remote.url is correct"
+		auth.Status = checkWarn
+		auth.Detail = "skipped, server unreachable"
+		return []doctorCheck{server, auth}
+	}
+	server.Status = checkPass
+	server.Detail = url
+	if caps, err := client.Capabilities(ctx); err == nil && caps.Version != "" {
+		server.Detail = fmt.Sprintf("%s (server %s, flows: %s)", url, caps.Version, strings.Join(caps.Flows, ", "))
+	}
+
+	_, err := client.Negotiate(ctx, doctorProbeKey, "download")
+	switch {
+	case errors.Is(err, engine.ErrUnauthorized):
+		auth.Status = checkFail
+		auth.Detail = "token rejected by server"
+		auth.Hint = "check remote.token in velocity.yml or VC_AUTH_TOKEN"
+	case err != nil:
+		auth.Status = checkFail
+		auth.Detail = err.Error()
+		auth.Hint = "the server is up but cache requests fail; check its logs"
+	case strings.TrimSpace(remote.Token) == "":
+		auth.Status = checkWarn
+		auth.Detail = "no token configured; server accepts anonymous requests"
+	default:
+		auth.Status = checkPass
+		auth.Detail = "token accepted"
+	}
+	return []doctorCheck{server, auth}
+}
+
+// checkRemoteBucket reports whether the bucket a remote stores artifacts in
+// directly can be reached with the machine's credentials.
+func checkRemoteBucket(ctx context.Context, remote config.RemoteConfig, suffix string) doctorCheck {
+	check := doctorCheck{Name: "Remote bucket" + suffix}
+	ctx, cancel := context.WithTimeout(ctx, doctorRemoteTimeout)
+	defer cancel()
+
+	client, err := remoteAPIClient(remote)
+	if err == nil {
+		_, err = client.Negotiate(ctx, doctorProbeKey, "download")
+	}
+	if err != nil {
+		check.Status = checkFail
+		check.Detail = err.Error()
+		check.Hint = "check remote.bucket in velocity.yml and your AWS credentials"
+		return check
+	}
+	check.Status = checkPass
+	check.Detail = remote.Bucket.URL()
+	return check
+}
+
+func checkWritable(name, dir string) doctorCheck {
+	check := doctorCheck{Name: name}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		check.Status = checkFail
+		check.Detail = fmt.Sprintf("cannot create %s: %v", dir, err)
+		check.Hint = "fix the permissions of the workspace directory"
+		return check
+	}
+
+	probe, err := os.CreateTemp(dir, ".doctor-*")
+	if err == nil {
+		_, err = probe.WriteString("ok")
+		closeErr := probe.Close()
+		if err == nil {
+			err = closeErr
+		}
+		os.Remove(probe.Name())
+	}
+	if err != nil {
+		check.Status = checkFail
+		check.Detail = fmt.Sprintf("%s is not writable: %v", dir, err)
+		check.Hint = fmt.Sprintf("check the owner and mode of %s", filepath.Clean(dir))
+		return check
+	}
+
+	check.Status = checkPass
+	check.Detail = dir + " is writable"
+	return check
+}
+
+func checkDiskSpace(dir string) doctorCheck {
+	check := doctorCheck{Name: "Disk space"}
+	free, err := diskFree(dir)
+	if err != nil {
+		check.Status = checkWarn
+		check.Detail = fmt.Sprintf("unable to determine free space: %v", err)
+		return check
+	}
+
+	check.Detail = formatBytes(int64(free)) + " free"
+	switch {
+	case free < criticalDiskSpace:
+		check.Status = checkFail
+		check.Hint = "free up space or run `velocity clean`"
+	case free < lowDiskSpace:
+		check.Status = checkWarn
+		check.Hint = "cache artifacts may fail to save; consider `velocity clean`"
+	default:
+		check.Status = checkPass
+	}
+	return check
+}
+
+func printDoctorReport(out io.Writer, checks []doctorCheck) int {
+	failed := 0
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, check := range checks {
+		status := hitStyle.Sprint("PASS")
+		switch check.Status {
+		case checkWarn:
+			status = warnStyle.Sprint("WARN")
+		case checkFail:
+			status = errorStyle.Sprint("FAIL")
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", status, check.Name, check.Detail)
+		if check.Hint != "" {
+			fmt.Fprintf(w, "\t\t%s\n", subtleStyle.Sprint("hint: "+check.Hint))
+		}
+	}
+	w.Flush()
+	return failed
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+)
+
+func TestCheckRemoteReportsTokenValidity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/health":
+			w.WriteHeader(http.StatusOK)
+		case r.Header.Get("Authorization") != "Bearer secret":
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		default:
+			http.Error(w, "Not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{Remote: config.RemoteConfig{Enabled: true, URL: server.URL, Token: "secret"}}
+	checks := checkRemote(context.Background(), cfg)
+	require.Len(t, checks, 2)
+	assert.Equal(t, checkPass, checks[0].Status)
+	assert.Equal(t, checkPass, checks[1].Status)
+
+	cfg.Remote.Token = "wrong"
+	checks = checkRemote(context.Background(), cfg)
+	require.Len(t, checks, 2)
+	assert.Equal(t, checkPass, checks[0].Status)
+	assert.Equal(t, checkFail, checks[1].Status)
+	assert.Equal(t, "token rejected by server", checks[1].Detail)
+}
+
+func TestCheckRemoteUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	cfg := &config.Config{Remote: config.RemoteConfig{Enabled: true, URL: server.URL}}
+	checks := checkRemote(context.Background(), cfg)
+	require.Len(t, checks, 2)
+	assert.Equal(t, checkFail, checks[0].Status)
+	assert.Equal(t, checkWarn, checks[1].Status)
+}
+
+func TestPrintDoctorReportCountsFailures(t *testing.T) {
+	var out bytes.Buffer
+	failed := printDoctorReport(&out, []doctorCheck{
+		{Name: "Config", Status: checkPass, Detail: "velocity.yml is valid"},
+		{Name: "Git", Status: checkFail, Detail: "git not found on PATH", Hint: "install git"},
+	})
+	assert.Equal(t, 1, failed)
+	assert.Contains(t, out.String(), "hint: install git")
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512 B", formatBytes(512))
+	assert.Equal(t, "1.5 KiB", formatBytes(1536))
+	assert.Equal(t, "2.0 GiB", formatBytes(2<<30))
+}
@@ -0,0 +1,118 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+type dryRunTask struct {
+	ID           string   `json:"id"`
+	Package      string   `json:"package"`
+	Task         string   `json:"task"`
+	Hash         string   `json:"hash"`
+	Cache        string   `json:"cache"`
+	Source       string   `json:"source,omitempty"`
+	Command      string   `json:"command"`
+	Dependencies []string `json:"dependencies"`
+}
+
+// DryRun computes the cache key of every planned task and reports whether
+// it would be restored from the local or remote cache, without executing
+// anything.
+func (e *Engine) DryRun(roots []*engine.TaskNode, format string) error {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown dry-run format %q (expected text or json)", format)
+	}
+
+	nodes, err := engine.Plan(roots...)
+	if err != nil {
+		return err
+	}
+
+	tasks := make([]dryRunTask, 0, len(nodes))
+	for _, node := range nodes {
+		key, err := computeCacheKey(e.ctx, node)
+		if err != nil {
+			return fmt.Errorf("hash %s: %w", node.ID, err)
+		}
+
+		cache, source := e.lookupCacheStatus(node, key)
+		task := dryRunTask{
+			ID:           node.ID,
+			Task:         node.TaskName,
+			Hash:         key,
+			Cache:        cache,
+			Source:       source,
+			Command:      node.TaskConfig.Command,
+			Dependencies: make([]string, 0, len(node.Dependencies)),
+		}
+		if node.Package != nil {
+			task.Package = node.Package.Name
+		}
+		for _, dep := range node.Dependencies {
+			task.Dependencies = append(task.Dependencies, dep.ID)
+		}
+		tasks = append(tasks, task)
+	}
+
+	if format == "json" {
+		encoder := json.NewEncoder(e.out)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(map[string]interface{}{"tasks": tasks})
+	}
+
+	w := tabwriter.NewWriter(e.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TASK\tHASH\tCACHE\tCOMMAND")
+	for _, task := range tasks {
+		status := strings.ToUpper(task.Cache)
+		if task.Source != "" {
+			status += " (" + task.Source + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", task.ID, shortHash(task.Hash), status, task.Command)
+	}
+	return w.Flush()
+}
+
+func (e *Engine) lookupCacheStatus(node *engine.TaskNode, key string) (string, string) {
+	if !node.TaskConfig.CacheEnabled() {
+		return "miss", "disabled"
+	}
+	if e.force {
+		return "miss", "forced"
+	}
+	if e.policy.localRead {
+		if _, found, err := engine.CheckLocal(key); err == nil && found {
+			return "hit", "local"
+		}
+	}
+	unavailable := false
+	if e.policy.remoteRead {
+		for _, remote := range e.remotes {
+			resp, err := remote.client.Negotiate(e.ctx, key, "download")
+			if err != nil {
+				unavailable = true
+				continue
+			}
+			if resp.Status == "found" {
+				return "hit", remote.name
+			}
+		}
+	}
+	if unavailable {
+		return "miss", "remote unavailable"
+	}
+	return "miss", ""
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
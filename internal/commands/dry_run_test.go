@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+func TestDryRunReportsRemoteHits(t *testing.T) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, os.Chdir(wd)) })
+	require.NoError(t, os.Chdir(t.TempDir()))
+
+	var remoteHash string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Hash string `json:"hash"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if req.Hash != remoteHash {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"found","url":"http://example.invalid/blob"}`))
+	}))
+	defer server.Close()
+
+	pkg := &engine.Package{Name: "__workspace__", Path: "."}
+	lib := &engine.TaskNode{ID: "lib#build", TaskName: "build", Package: pkg, TaskConfig: config.TaskConfig{Command: "echo lib"}}
+	app := &engine.TaskNode{ID: "app#build", TaskName: "build", Package: pkg, TaskConfig: config.TaskConfig{Command: "echo app"}, Dependencies: []*engine.TaskNode{lib}}
+
+	libKey, err := engine.GenerateTaskNodeCacheKey(context.Background(), lib, nil)
+	require.NoError(t, err)
+	remoteHash = libKey
+
+	var out bytes.Buffer
+	e := &Engine{
+		ctx:     context.Background(),
+		cfg:     &config.Config{},
+		out:     &out,
+		errOut:  &bytes.Buffer{},
+		remotes: []*remoteCache{{name: "remote", client: engine.NewRemoteClient(server.URL, ""), write: true}},
+		policy:  defaultCachePolicy(),
+	}
+	require.NoError(t, e.DryRun([]*engine.TaskNode{app}, "json"))
+
+	var decoded struct {
+		Tasks []dryRunTask `json:"tasks"`
+	}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	require.Len(t, decoded.Tasks, 2)
+
+	assert.Equal(t, "lib#build", decoded.Tasks[0].ID)
+	assert.Equal(t, "hit", decoded.Tasks[0].Cache)
+	assert.Equal(t, "remote", decoded.Tasks[0].Source)
+
+	assert.Equal(t, "app#build", decoded.Tasks[1].ID)
+	assert.Equal(t, "miss", decoded.Tasks[1].Cache)
+	assert.Equal(t, []string{"lib#build"}, decoded.Tasks[1].Dependencies)
+}
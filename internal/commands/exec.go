@@ -0,0 +1,128 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+type execOptions struct {
+	run     runOptions
+	inputs  []string
+	outputs []string
+	envKeys []string
+}
+
+func newExecCommand() *cobra.Command {
+	var opts execOptions
+	cmd := &cobra.Command{
+		Use:   "exec [flags] -- <command> [args...]",
+		Short: "Run an ad-hoc command through the cache",
+		Long: "Run an arbitrary command with the same caching as pipeline tasks. Inputs and outputs\n" +
+			"are given with flags instead of a velocity.yml pipeline entry.",
+		Example: "  velocity exec --input 'src/**' --output dist -- npm run build",
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return execCommand(cmd, args, opts)
+		},
+	}
+	cmd.Flags().StringArrayVar(&opts.inputs, "input", nil, "Input glob to hash (repeatable)")
+	cmd.Flags().StringArrayVar(&opts.outputs, "output", nil, "Output directory to cache (repeatable)")
+	cmd.Flags().StringArrayVar(&opts.envKeys, "env", nil, "Environment variable to include in the hash, or KEY=VALUE to also set it (repeatable)")
+	cmd.Flags().BoolVar(&opts.run.force, "force", false, "Skip cache lookups and re-execute the command, still saving fresh artifacts")
+	cmd.Flags().StringVar(&opts.run.outputLogs, "output-logs", "", "Command output to print: full, errors-only, hash-only or none")
+	cmd.Flags().StringVar(&opts.run.profile, "profile", "", "Write a Chrome trace of the command's phases to this file")
+	cmd.Flags().StringVar(&opts.run.summaryFile, "summary-file", "", "Write a JSON run summary to this path (\"-\" for stdout)")
+	cmd.MarkFlagRequired("output")
+	return cmd
+}
+
+func execCommand(cmd *cobra.Command, args []string, opts execOptions) error {
+	if opts.run.outputLogs != "" && !config.ValidOutputLogs(opts.run.outputLogs) {
+		return fmt.Errorf("invalid --output-logs %q (expected full, errors-only, hash-only or none)", opts.run.outputLogs)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("load config: %w", err)
+		}
+		cfg = &config.Config{}
+	}
+	engine.SetCacheVersion(cfg.CacheVersion())
+	engine.SetGlobalInputs(cfg.GlobalDependencies, cfg.GlobalEnv)
+	engine.SetInputDiscovery(cfg.InputDiscovery)
+	engine.SetFileHashAlgorithm(cfg.HashAlgorithm)
+
+	var envKeys, envOverrides []string
+	for _, value := range opts.envKeys {
+		if strings.Contains(value, "=") {
+			envOverrides = append(envOverrides, value)
+		} else {
+			envKeys = append(envKeys, value)
+		}
+	}
+	env, err := parseEnvOverrides(envOverrides)
+	if err != nil {
+		return err
+	}
+
+	// Without --input only the command is hashed, rather than every file
+	// in the workspace.
+	inputs := opts.inputs
+	if inputs == nil {
+		inputs = []string{}
+	}
+
+	workspace := &engine.Package{Name: "__workspace__", Path: "."}
+	task := &engine.TaskNode{
+		ID:       "exec",
+		Package:  workspace,
+		TaskName: "exec",
+		TaskConfig: config.TaskConfig{
+			Command: engine.ExpandCommand(shellJoin(args), workspace),
+			Inputs:  inputs,
+			Outputs: opts.outputs,
+			EnvKeys: envKeys,
+		}.WithEnv(env),
+	}
+
+	exec, err := newEngine(cmd, cfg, "exec", opts.run)
+	if err != nil {
+		return err
+	}
+	runErr := exec.Run([]*engine.TaskNode{task}, 1)
+	exec.writeProfile(opts.run.profile)
+	return finishRun(cmd, exec.summary, runErr, opts.run.summaryFile)
+}
+
+// shellJoin turns the arguments after "--" back into a command line. A single
+// argument is used verbatim so that `velocity exec -- "a && b"` keeps its
+// shell syntax; otherwise arguments are quoted where needed.
+func shellJoin(args []string) string {
+	if len(args) == 1 {
+		return args[0]
+	}
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func shellQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\"'\\$`&|;<>()*?[]{}!#~") {
+		return arg
+	}
+	if runtime.GOOS == "windows" {
+		return `"` + strings.ReplaceAll(arg, `"`, `\"`) + `"`
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
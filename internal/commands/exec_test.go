@@ -0,0 +1,17 @@
+package commands
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellJoin(t *testing.T) {
+	assert.Equal(t, "npm run build && echo done", shellJoin([]string{"npm run build && echo done"}))
+	assert.Equal(t, "npm run build", shellJoin([]string{"npm", "run", "build"}))
+
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, `echo 'hello world' 'it'\''s'`, shellJoin([]string{"echo", "hello world", "it's"}))
+	}
+}
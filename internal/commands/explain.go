@@ -0,0 +1,161 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+const (
+	explainHit        = "hit"
+	explainMiss       = "miss"
+	explainNoPrevious = "no-previous"
+)
+
+type explanation struct {
+	Task        string                  `json:"task"`
+	Key         string                  `json:"key"`
+	Status      string                  `json:"status"`
+	PreviousKey string                  `json:"previous_key,omitempty"`
+	Changes     []engine.ManifestChange `json:"changes,omitempty"`
+	// Artifact describes the cached artifact for a hit, or the previous
+	// one for a miss, when it was stored with metadata.
+	Artifact *engine.ArtifactInfo `json:"artifact,omitempty"`
+}
+
+func newExplainCommand() *cobra.Command {
+	var sel taskSelection
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "explain <task-name>",
+		Short: "Explain why a task misses the local cache",
+		Long: "Compare a task's current inputs with the manifest stored alongside its most\n" +
+			"recent locally cached artifact and list the files, environment variables,\n" +
+			"command and dependencies that changed.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, roots, err := loadTaskGraph(cmd, args[0], sel)
+			if err != nil {
+				return err
+			}
+			manifests, err := rootManifests(cmd, roots)
+			if err != nil {
+				return err
+			}
+
+			explanations := make([]explanation, 0, len(manifests))
+			for _, manifest := range manifests {
+				result, err := explainManifest(manifest)
+				if err != nil {
+					return err
+				}
+				explanations = append(explanations, result)
+			}
+
+			if asJSON {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				encoder.SetEscapeHTML(false)
+				return encoder.Encode(map[string]interface{}{"tasks": explanations})
+			}
+			return writeExplanations(cmd.OutOrStdout(), explanations)
+		},
+	}
+	sel.bindFlags(cmd)
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print the explanation as JSON")
+	return cmd
+}
+
+func explainManifest(manifest *engine.KeyManifest) (explanation, error) {
+	result := explanation{Task: manifest.Task, Key: manifest.Key}
+	if _, found, err := engine.CheckLocal(manifest.Key); err != nil {
+		return result, err
+	} else if found {
+		result.Status = explainHit
+		result.Artifact, err = engine.LoadArtifactInfo(manifest.Key)
+		return result, err
+	}
+
+	previous, err := engine.LatestLocalManifest(manifest.Task)
+	if err != nil {
+		return result, err
+	}
+	if previous == nil {
+		result.Status = explainNoPrevious
+		return result, nil
+	}
+
+	result.Status = explainMiss
+	result.PreviousKey = previous.Key
+	result.Changes = engine.DiffManifests(previous, manifest)
+	result.Artifact, err = engine.LoadArtifactInfo(previous.Key)
+	return result, err
+}
+
+// describeArtifact summarizes how a cached artifact was produced, e.g.
+// "built 3h ago in 12.4s" or "downloaded 2d ago".
+func describeArtifact(info *engine.ArtifactInfo, now time.Time) string {
+	if info == nil {
+		return ""
+	}
+	if info.Source == engine.ArtifactSourceRemote {
+		return fmt.Sprintf("downloaded %s ago", formatAge(now.Sub(info.CreatedAt)))
+	}
+	return fmt.Sprintf("built %s ago in %s", formatAge(now.Sub(info.CreatedAt)), time.Duration(info.DurationMs)*time.Millisecond)
+}
+
+func writeExplanations(out io.Writer, explanations []explanation) error {
+	now := time.Now()
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for i, result := range explanations {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		switch result.Status {
+		case explainHit:
+			fmt.Fprintf(w, "%s %s\n", infoStyle.Sprint(result.Task), hitStyle.Sprint("HIT"))
+			fmt.Fprintf(w, "  %s\n", subtleStyle.Sprintf("%s is in the local cache; nothing changed", shortHash(result.Key)))
+			if description := describeArtifact(result.Artifact, now); description != "" {
+				fmt.Fprintf(w, "  %s\n", subtleStyle.Sprint(description))
+			}
+			continue
+		case explainNoPrevious:
+			fmt.Fprintf(w, "%s %s\n", infoStyle.Sprint(result.Task), missStyle.Sprint("MISS"))
+			fmt.Fprintf(w, "  %s\n", subtleStyle.Sprint("no earlier cached run with a stored manifest to compare against"))
+			continue
+		}
+
+		previous := "last cached " + shortHash(result.PreviousKey)
+		if description := describeArtifact(result.Artifact, now); description != "" {
+			previous += ", " + description
+		}
+		fmt.Fprintf(w, "%s %s %s\n", infoStyle.Sprint(result.Task), missStyle.Sprint("MISS"),
+			subtleStyle.Sprintf("(%s)", previous))
+		if len(result.Changes) == 0 {
+			fmt.Fprintf(w, "  %s\n", subtleStyle.Sprint("inputs are identical; only the task's identity changed"))
+			continue
+		}
+		for _, change := range result.Changes {
+			switch change.Kind {
+			case "cache_version":
+				fmt.Fprintf(w, "  cache_version %s\t%q -> %q\n", change.Change, change.Old, change.New)
+			case "command":
+				fmt.Fprintf(w, "  command %s\t%q -> %q\n", change.Change, change.Old, change.New)
+			case "tool":
+				fmt.Fprintf(w, "  tool %s\t%s\t%q -> %q\n", change.Change, change.Name, change.Old, change.New)
+			case "dependency":
+				fmt.Fprintf(w, "  dependency %s\t%s\t%s\n", change.Change, change.Name,
+					subtleStyle.Sprint("(run `velocity explain` on it for details)"))
+			default:
+				fmt.Fprintf(w, "  %s %s\t%s\n", change.Kind, change.Change, change.Name)
+			}
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,116 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+type graphTask struct {
+	ID           string   `json:"id"`
+	Package      string   `json:"package"`
+	Task         string   `json:"task"`
+	Command      string   `json:"command"`
+	Dependencies []string `json:"dependencies"`
+}
+
+func newGraphCommand() *cobra.Command {
+	var sel taskSelection
+	var format string
+	cmd := &cobra.Command{
+		Use:   "graph <task-name>",
+		Short: "Print the task graph as DOT, Mermaid or JSON",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, roots, err := loadTaskGraph(cmd, args[0], sel)
+			if err != nil {
+				return err
+			}
+			nodes, err := engine.Plan(roots...)
+			if err != nil {
+				return err
+			}
+			return writeGraph(cmd.OutOrStdout(), format, nodes)
+		},
+	}
+	sel.bindFlags(cmd)
+	cmd.Flags().StringVar(&format, "format", "dot", "Output format: dot, mermaid or json")
+	return cmd
+}
+
+func writeGraph(out io.Writer, format string, nodes []*engine.TaskNode) error {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "dot":
+		return writeGraphDOT(out, nodes)
+	case "mermaid":
+		return writeGraphMermaid(out, nodes)
+	case "json":
+		return writeGraphJSON(out, nodes)
+	default:
+		return fmt.Errorf("unknown graph format %q (expected dot, mermaid or json)", format)
+	}
+}
+
+func writeGraphDOT(out io.Writer, nodes []*engine.TaskNode) error {
+	var b strings.Builder
+	b.WriteString("digraph velocity {\n")
+	b.WriteString("\trankdir=LR;\n")
+	for _, node := range nodes {
+		fmt.Fprintf(&b, "\t%q;\n", node.ID)
+	}
+	for _, node := range nodes {
+		for _, dep := range node.Dependencies {
+			fmt.Fprintf(&b, "\t%q -> %q;\n", node.ID, dep.ID)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+func writeGraphMermaid(out io.Writer, nodes []*engine.TaskNode) error {
+	ids := make(map[*engine.TaskNode]string, len(nodes))
+	var b strings.Builder
+	b.WriteString("graph LR\n")
+	for i, node := range nodes {
+		ids[node] = fmt.Sprintf("t%d", i)
+		label := strings.ReplaceAll(node.ID, `"`, "#quot;")
+		fmt.Fprintf(&b, "\t%s[\"%s\"]\n", ids[node], label)
+	}
+	for _, node := range nodes {
+		for _, dep := range node.Dependencies {
+			fmt.Fprintf(&b, "\t%s --> %s\n", ids[node], ids[dep])
+		}
+	}
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+func writeGraphJSON(out io.Writer, nodes []*engine.TaskNode) error {
+	tasks := make([]graphTask, 0, len(nodes))
+	for _, node := range nodes {
+		task := graphTask{
+			ID:           node.ID,
+			Task:         node.TaskName,
+			Command:      node.TaskConfig.Command,
+			Dependencies: make([]string, 0, len(node.Dependencies)),
+		}
+		if node.Package != nil {
+			task.Package = node.Package.Name
+		}
+		for _, dep := range node.Dependencies {
+			task.Dependencies = append(task.Dependencies, dep.ID)
+		}
+		tasks = append(tasks, task)
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(map[string]interface{}{"tasks": tasks})
+}
@@ -0,0 +1,66 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+func sampleGraph(t *testing.T) []*engine.TaskNode {
+	t.Helper()
+	lib := &engine.TaskNode{
+		ID:         "packages/lib#build",
+		TaskName:   "build",
+		Package:    &engine.Package{Name: "@repo/lib", Path: "packages/lib"},
+		TaskConfig: config.TaskConfig{Command: "tsc"},
+	}
+	app := &engine.TaskNode{
+		ID:           "packages/app#build",
+		TaskName:     "build",
+		Package:      &engine.Package{Name: "@repo/app", Path: "packages/app"},
+		TaskConfig:   config.TaskConfig{Command: "vite build"},
+		Dependencies: []*engine.TaskNode{lib},
+	}
+	nodes, err := engine.Plan(app)
+	require.NoError(t, err)
+	return nodes
+}
+
+func TestWriteGraphDOT(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, writeGraph(&out, "dot", sampleGraph(t)))
+	assert.Contains(t, out.String(), "digraph velocity {")
+	assert.Contains(t, out.String(), `"packages/app#build" -> "packages/lib#build";`)
+}
+
+func TestWriteGraphMermaid(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, writeGraph(&out, "mermaid", sampleGraph(t)))
+	assert.Contains(t, out.String(), "graph LR")
+	assert.Contains(t, out.String(), `t0["packages/lib#build"]`)
+	assert.Contains(t, out.String(), "t1 --> t0")
+}
+
+func TestWriteGraphJSON(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, writeGraph(&out, "json", sampleGraph(t)))
+
+	var decoded struct {
+		Tasks []graphTask `json:"tasks"`
+	}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	require.Len(t, decoded.Tasks, 2)
+	assert.Equal(t, "@repo/app", decoded.Tasks[1].Package)
+	assert.Equal(t, []string{"packages/lib#build"}, decoded.Tasks[1].Dependencies)
+}
+
+func TestWriteGraphUnknownFormat(t *testing.T) {
+	err := writeGraph(&bytes.Buffer{}, "svg", nil)
+	require.Error(t, err)
+}
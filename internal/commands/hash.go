@@ -0,0 +1,143 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+func newHashCommand() *cobra.Command {
+	var sel taskSelection
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "hash <task-name>",
+		Short: "Print a task's cache key and the inputs it was computed from",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, roots, err := loadTaskGraph(cmd, args[0], sel)
+			if err != nil {
+				return err
+			}
+			manifests, err := rootManifests(cmd, roots)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				encoder.SetEscapeHTML(false)
+				return encoder.Encode(map[string]interface{}{"tasks": manifests})
+			}
+			return writeManifests(cmd.OutOrStdout(), manifests)
+		},
+	}
+	sel.bindFlags(cmd)
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print the breakdown as JSON")
+	return cmd
+}
+
+// rootManifests hashes the whole graph in dependency order, since a task's
+// key includes its dependencies' keys, and returns the manifests of roots.
+func rootManifests(cmd *cobra.Command, roots []*engine.TaskNode) ([]*engine.KeyManifest, error) {
+	nodes, err := hashGraph(cmd, roots)
+	if err != nil {
+		return nil, err
+	}
+	// Plan dedupes nodes by ID, so a root may not be the node that was hashed.
+	byID := make(map[string]*engine.KeyManifest, len(nodes))
+	for _, node := range nodes {
+		byID[node.ID] = node.Manifest
+	}
+	manifests := make([]*engine.KeyManifest, 0, len(roots))
+	for _, root := range roots {
+		manifests = append(manifests, byID[root.ID])
+	}
+	return manifests, nil
+}
+
+// hashGraph assigns a cache key and manifest to every node reachable from
+// roots and returns the nodes in execution order.
+func hashGraph(cmd *cobra.Command, roots []*engine.TaskNode) ([]*engine.TaskNode, error) {
+	nodes, err := engine.Plan(roots...)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		manifest, err := engine.GenerateTaskNodeManifest(cmd.Context(), node)
+		if err != nil {
+			return nil, fmt.Errorf("hash %s: %w", node.ID, err)
+		}
+		node.CacheKey = manifest.Key
+		node.Manifest = manifest
+	}
+	if err := engine.SaveHashCache(); err != nil {
+		logWarning(cmd.ErrOrStderr(), err.Error())
+	}
+	return nodes, nil
+}
+
+func writeManifests(out io.Writer, manifests []*engine.KeyManifest) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for i, m := range manifests {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s\n", infoStyle.Sprint(m.Task))
+		fmt.Fprintf(w, "  key\t%s\n", m.Key)
+		if m.CacheVersion != "" {
+			fmt.Fprintf(w, "  cache_version\t%s\n", m.CacheVersion)
+		}
+		if m.GlobalHash != "" {
+			fmt.Fprintf(w, "  global\t%s\n", shortHash(m.GlobalHash))
+			for _, file := range m.GlobalFiles {
+				fmt.Fprintf(w, "    %s\t%s\n", file.Path, shortHash(file.Hash))
+			}
+			for _, env := range m.GlobalEnv {
+				fmt.Fprintf(w, "    $%s\t%s\n", env.Name, shortHash(env.ValueHash))
+			}
+		}
+		fmt.Fprintf(w, "  command\t%s\t%s\n", shortHash(m.CommandHash), subtleStyle.Sprint(m.Command))
+		if len(m.Env) > 0 {
+			fmt.Fprintf(w, "  env\t%s\n", shortHash(m.EnvHash))
+			for _, env := range m.Env {
+				fmt.Fprintf(w, "    %s\t%s\n", env.Name, shortHash(env.ValueHash))
+			}
+		}
+		if len(m.Files) > 0 {
+			fmt.Fprintf(w, "  files\t%s\t%s\n", shortHash(m.FilesHash), subtleStyle.Sprintf("%d file(s)", len(m.Files)))
+			for _, file := range m.Files {
+				fmt.Fprintf(w, "    %s\t%s\n", file.Path, shortHash(file.Hash))
+			}
+		}
+		if len(m.DotEnv) > 0 {
+			fmt.Fprintf(w, "  dot_env\t%s\n", shortHash(m.DotEnvHash))
+			for _, file := range m.DotEnv {
+				fmt.Fprintf(w, "    %s\t%s\n", file.Path, shortHash(file.Hash))
+			}
+		}
+		if len(m.Docker) > 0 {
+			fmt.Fprintf(w, "  docker\t%s\t%s\n", shortHash(m.DockerHash), subtleStyle.Sprintf("%d file(s)", len(m.Docker)))
+			for _, file := range m.Docker {
+				fmt.Fprintf(w, "    %s\t%s\n", file.Path, shortHash(file.Hash))
+			}
+		}
+		if len(m.Tools) > 0 {
+			fmt.Fprintf(w, "  tools\t%s\n", shortHash(m.ToolsHash))
+			for _, tool := range m.Tools {
+				fmt.Fprintf(w, "    %s\t%s\n", tool.Name, subtleStyle.Sprint(tool.Version))
+			}
+		}
+		if len(m.Dependencies) > 0 {
+			fmt.Fprintln(w, "  dependencies")
+			for _, dep := range m.Dependencies {
+				fmt.Fprintf(w, "    %s\t%s\n", dep.Task, shortHash(dep.Key))
+			}
+		}
+	}
+	return w.Flush()
+}
@@ -12,6 +12,7 @@ import (
 	"gopkg.in/yaml.v3"
 
 	"github.com/bit2swaz/velocity-cache/internal/config"
+	"github.com/bit2swaz/velocity-cache/internal/engine"
 )
 
 const configFileName = "velocity.yml"
@@ -48,6 +49,14 @@ func runInit(cmd *cobra.Command) error {
 		return writeYaml(cmd, targetPath, cfg)
 	}
 
+	if info, err := os.Stat(filepath.Join(wd, "nx.json")); err == nil && !info.IsDir() {
+		cfg, err := parseNxConfig(wd)
+		if err != nil {
+			return fmt.Errorf("parse nx.json: %w", err)
+		}
+		return writeYaml(cmd, targetPath, cfg)
+	}
+
 	if cfg, ok := detectLanguageProject(wd); ok {
 		return writeYaml(cmd, targetPath, cfg)
 	}
@@ -69,6 +78,9 @@ func runInit(cmd *cobra.Command) error {
 			},
 		},
 	}
+	if workspaces, err := engine.WorkspaceGlobs(wd); err == nil {
+		defaultCfg.Packages = workspaces
+	}
 	return writeYaml(cmd, targetPath, defaultCfg)
 }
 
@@ -159,21 +171,14 @@ type turboFile struct {
 	} `json:"pipeline"`
 }
 
-type packageJSON struct {
-	Workspaces []string `json:"workspaces"`
-}
-
 func parseTurboConfig(turboPath, packageJSONPath string) (*config.Config, error) {
 	data, _ := os.ReadFile(turboPath)
 	var t turboFile
 	json.Unmarshal(data, &t)
 
-	var workspaces []string
-	if pkgData, err := os.ReadFile(packageJSONPath); err == nil {
-		var p packageJSON
-		if err := json.Unmarshal(pkgData, &p); err == nil {
-			workspaces = p.Workspaces
-		}
+	workspaces, err := engine.WorkspaceGlobs(filepath.Dir(packageJSONPath))
+	if err != nil {
+		return nil, err
 	}
 
 	pipeline := make(map[string]config.TaskConfig)
@@ -0,0 +1,271 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+)
+
+type nxTarget struct {
+	Executor  string            `json:"executor"`
+	DependsOn []json.RawMessage `json:"dependsOn"`
+	Inputs    []json.RawMessage `json:"inputs"`
+	Outputs   []string          `json:"outputs"`
+	Options   struct {
+		Command string `json:"command"`
+	} `json:"options"`
+}
+
+type nxFile struct {
+	NamedInputs     map[string][]json.RawMessage `json:"namedInputs"`
+	TargetDefaults  map[string]nxTarget          `json:"targetDefaults"`
+	WorkspaceLayout struct {
+		AppsDir string `json:"appsDir"`
+		LibsDir string `json:"libsDir"`
+	} `json:"workspaceLayout"`
+}
+
+type nxProject struct {
+	Targets map[string]nxTarget `json:"targets"`
+}
+
+// parseNxConfig converts nx.json target defaults and the targets of every
+// project.json under root into a velocity pipeline.
+func parseNxConfig(root string) (*config.Config, error) {
+	data, err := os.ReadFile(filepath.Join(root, "nx.json"))
+	if err != nil {
+		return nil, err
+	}
+	var nx nxFile
+	if err := json.Unmarshal(data, &nx); err != nil {
+		return nil, err
+	}
+
+	targets := make(map[string]nxTarget, len(nx.TargetDefaults))
+	for name, target := range nx.TargetDefaults {
+		targets[name] = target
+	}
+
+	projectDirs, err := findNxProjects(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range projectDirs {
+		projectData, err := os.ReadFile(filepath.Join(root, dir, "project.json"))
+		if err != nil {
+			return nil, err
+		}
+		var project nxProject
+		if err := json.Unmarshal(projectData, &project); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path.Join(dir, "project.json"), err)
+		}
+		for name, target := range project.Targets {
+			targets[name] = mergeNxTarget(targets[name], target)
+		}
+	}
+
+	pipeline := make(map[string]config.TaskConfig, len(targets))
+	for name, target := range targets {
+		command := "npx nx " + name
+		if target.Executor == "nx:run-commands" && strings.TrimSpace(target.Options.Command) != "" {
+			command = target.Options.Command
+		}
+
+		inputs, envKeys := nx.resolveInputs(target.Inputs, nil)
+		pipeline[name] = config.TaskConfig{
+			Command:   command,
+			DependsOn: nxDependsOn(target.DependsOn),
+			Inputs:    inputs,
+			Outputs:   nxPaths(target.Outputs),
+			EnvKeys:   envKeys,
+		}
+	}
+
+	return &config.Config{
+		Version:  1,
+		Remote:   config.RemoteConfig{Enabled: true, URL: "${VC_SERVER_URL}", Token: "${VC_AUTH_TOKEN}"},
+		Pipeline: pipeline,
+		Packages: nxPackageGlobs(nx, projectDirs),
+	}, nil
+}
+
+// mergeNxTarget overlays a project's target on the matching target default,
+// the same way Nx does.
+func mergeNxTarget(base, override nxTarget) nxTarget {
+	merged := base
+	if override.Executor != "" {
+		merged.Executor = override.Executor
+		merged.Options = override.Options
+	}
+	if override.DependsOn != nil {
+		merged.DependsOn = override.DependsOn
+	}
+	if override.Inputs != nil {
+		merged.Inputs = override.Inputs
+	}
+	if override.Outputs != nil {
+		merged.Outputs = override.Outputs
+	}
+	return merged
+}
+
+func findNxProjects(root string) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			switch d.Name() {
+			case "node_modules", ".git", ".nx", "dist":
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.Name() != "project.json" {
+			return nil
+		}
+		rel, err := filepath.Rel(root, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		if rel != "." {
+			dirs = append(dirs, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(dirs)
+	return dirs, err
+}
+
+// resolveInputs expands named inputs, turning {env: X} entries into env keys
+// and dropping inputs that only make sense to Nx (dependency inputs,
+// runtime and external dependency hashes, workspace-root files).
+func (nx nxFile) resolveInputs(raw []json.RawMessage, seen map[string]bool) ([]string, []string) {
+	var inputs, envKeys []string
+	for _, entry := range raw {
+		var value string
+		if err := json.Unmarshal(entry, &value); err == nil {
+			if strings.HasPrefix(value, "^") {
+				continue
+			}
+			if named, ok := nx.NamedInputs[value]; ok {
+				if seen[value] {
+					continue
+				}
+				nested := map[string]bool{value: true}
+				for k := range seen {
+					nested[k] = true
+				}
+				namedInputs, namedEnv := nx.resolveInputs(named, nested)
+				inputs = append(inputs, namedInputs...)
+				envKeys = append(envKeys, namedEnv...)
+				continue
+			}
+			if p, ok := nxPath(value); ok {
+				inputs = append(inputs, p)
+			}
+			continue
+		}
+
+		var object struct {
+			Env string `json:"env"`
+		}
+		if err := json.Unmarshal(entry, &object); err == nil && object.Env != "" {
+			envKeys = append(envKeys, object.Env)
+		}
+	}
+	return dedupeStrings(inputs), dedupeStrings(envKeys)
+}
+
+func nxDependsOn(raw []json.RawMessage) []string {
+	var deps []string
+	for _, entry := range raw {
+		var value string
+		if err := json.Unmarshal(entry, &value); err == nil {
+			deps = append(deps, value)
+			continue
+		}
+
+		var object struct {
+			Target       string          `json:"target"`
+			Projects     json.RawMessage `json:"projects"`
+			Dependencies bool            `json:"dependencies"`
+		}
+		if err := json.Unmarshal(entry, &object); err != nil || object.Target == "" {
+			continue
+		}
+		var projects string
+		_ = json.Unmarshal(object.Projects, &projects)
+		if object.Dependencies || projects == "dependencies" {
+			deps = append(deps, "^"+object.Target)
+		} else {
+			deps = append(deps, object.Target)
+		}
+	}
+	return dedupeStrings(deps)
+}
+
+func nxPaths(values []string) []string {
+	var paths []string
+	for _, value := range values {
+		if p, ok := nxPath(value); ok {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// nxPath rewrites a {projectRoot}-relative Nx path to a package-relative one.
+// Paths that depend on other tokens cannot be expressed and are dropped.
+func nxPath(value string) (string, bool) {
+	negated := strings.HasPrefix(value, "!")
+	value = strings.TrimPrefix(value, "!")
+	value = strings.TrimPrefix(value, "{projectRoot}")
+	value = strings.TrimPrefix(value, "/")
+	if value == "" || strings.Contains(value, "{") {
+		return "", false
+	}
+	if negated {
+		value = "!" + value
+	}
+	return value, true
+}
+
+func nxPackageGlobs(nx nxFile, projectDirs []string) []string {
+	var globs []string
+	for _, dir := range []string{nx.WorkspaceLayout.AppsDir, nx.WorkspaceLayout.LibsDir} {
+		if dir != "" {
+			globs = append(globs, path.Join(dir, "*"))
+		}
+	}
+	for _, dir := range projectDirs {
+		globs = append(globs, path.Join(path.Dir(dir), "*"))
+	}
+	globs = dedupeStrings(globs)
+	sort.Strings(globs)
+	return globs
+}
+
+func dedupeStrings(values []string) []string {
+	seen := make(map[string]struct{}, len(values))
+	out := make([]string, 0, len(values))
+	for _, value := range values {
+		if _, ok := seen[value]; ok {
+			continue
+		}
+		seen[value] = struct{}{}
+		out = append(out, value)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,94 @@
+package commands
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+)
+
+func TestInitGeneratesVelocityConfigFromNx(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	write := func(rel, contents string) {
+		t.Helper()
+		path := filepath.Join(tmpDir, rel)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+	}
+
+	write("nx.json", `{
+  "namedInputs": {
+    "default": ["{projectRoot}/**/*", {"env": "NODE_ENV"}],
+    "production": ["default", "!{projectRoot}/**/*.spec.ts"]
+  },
+  "targetDefaults": {
+    "build": {
+      "dependsOn": ["^build"],
+      "inputs": ["production", "^production"],
+      "outputs": ["{projectRoot}/dist", "{options.outputPath}"]
+    },
+    "test": {
+      "inputs": ["default", "{workspaceRoot}/jest.preset.js"]
+    }
+  }
+}`)
+
+	write("apps/web/project.json", `{
+  "targets": {
+    "build": {"executor": "@nx/vite:build"},
+    "e2e": {
+      "executor": "nx:run-commands",
+      "options": {"command": "playwright test"},
+      "dependsOn": [{"target": "build", "projects": "dependencies"}, "build"],
+      "outputs": ["{projectRoot}/test-results"]
+    }
+  }
+}`)
+	write("libs/ui/project.json", `{"targets": {"lint": {}}}`)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(wd))
+	})
+	require.NoError(t, os.Chdir(tmpDir))
+
+	cmd := newInitCommand()
+	var stdout bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetErr(io.Discard)
+	require.NoError(t, runInit(cmd))
+
+	data, err := os.ReadFile(filepath.Join(tmpDir, "velocity.yml"))
+	require.NoError(t, err)
+	var cfg config.Config
+	require.NoError(t, yaml.Unmarshal(data, &cfg))
+
+	assert.Equal(t, []string{"apps/*", "libs/*"}, cfg.Packages)
+
+	build := cfg.Pipeline["build"]
+	assert.Equal(t, "npx nx build", build.Command)
+	assert.Equal(t, []string{"^build"}, build.DependsOn)
+	assert.Equal(t, []string{"**/*", "!**/*.spec.ts"}, build.Inputs)
+	assert.Equal(t, []string{"dist"}, build.Outputs)
+	assert.Equal(t, []string{"NODE_ENV"}, build.EnvKeys)
+
+	test := cfg.Pipeline["test"]
+	assert.Equal(t, []string{"**/*"}, test.Inputs)
+
+	e2e := cfg.Pipeline["e2e"]
+	assert.Equal(t, "playwright test", e2e.Command)
+	assert.Equal(t, []string{"^build", "build"}, e2e.DependsOn)
+	assert.Equal(t, []string{"test-results"}, e2e.Outputs)
+
+	require.Contains(t, cfg.Pipeline, "lint")
+	assert.Equal(t, "Generated velocity.yml\n", stdout.String())
+}
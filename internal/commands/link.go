@@ -0,0 +1,83 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+)
+
+func newLinkCommand() *cobra.Command {
+	var projectID string
+
+	cmd := &cobra.Command{
+		Use:   "link",
+		Short: "Link this workspace to a remote cache project",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id := strings.TrimSpace(projectID)
+			if !cmd.Flags().Changed("project") {
+				fmt.Fprint(cmd.OutOrStdout(), "Project ID: ")
+				line, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+				if err != nil && line == "" {
+					return fmt.Errorf("read project id: %w", err)
+				}
+				id = strings.TrimSpace(line)
+			}
+			if id == "" {
+				return fmt.Errorf("project id is required (pass --project <id>)")
+			}
+
+			if err := writeProjectID(configFileName, id); err != nil {
+				return err
+			}
+			logInfo(cmd.OutOrStdout(), fmt.Sprintf("Linked %s to project %s", configFileName, id))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&projectID, "project", "", "Project ID to link to, skipping the prompt")
+	return cmd
+}
+
+func newUnlinkCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "unlink",
+		Short: "Remove the remote cache project from velocity.yml",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := writeProjectID(configFileName, ""); err != nil {
+				return err
+			}
+			logInfo(cmd.OutOrStdout(), fmt.Sprintf("Unlinked %s", configFileName))
+			return nil
+		},
+	}
+}
+
+func writeProjectID(path, id string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%s not found; run velocity init first", path)
+		}
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	updated, err := config.SetProjectID(data, id)
+	if err != nil {
+		return fmt.Errorf("update %s: %w", path, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, updated, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
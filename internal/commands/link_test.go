@@ -0,0 +1,49 @@
+package commands
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkAndUnlinkEditVelocityYml(t *testing.T) {
+	t.Chdir(t.TempDir())
+	original := "version: 1\n# remote cache\nremote:\n  enabled: true\n"
+	require.NoError(t, os.WriteFile(configFileName, []byte(original), 0o644))
+
+	link := newLinkCommand()
+	link.SetOut(&bytes.Buffer{})
+	link.SetArgs([]string{"--project", "acme-web"})
+	require.NoError(t, link.Execute())
+
+	data, err := os.ReadFile(configFileName)
+	require.NoError(t, err)
+	assert.Equal(t, "version: 1\nproject_id: acme-web\n# remote cache\nremote:\n  enabled: true\n", string(data))
+
+	unlink := newUnlinkCommand()
+	unlink.SetOut(&bytes.Buffer{})
+	require.NoError(t, unlink.Execute())
+
+	data, err = os.ReadFile(configFileName)
+	require.NoError(t, err)
+	assert.Equal(t, original, string(data))
+}
+
+func TestLinkPromptsWithoutProjectFlag(t *testing.T) {
+	t.Chdir(t.TempDir())
+	require.NoError(t, os.WriteFile(configFileName, []byte("version: 1\n"), 0o644))
+
+	link := newLinkCommand()
+	link.SetOut(&bytes.Buffer{})
+	link.SetIn(strings.NewReader("prompted\n"))
+	link.SetArgs([]string{})
+	require.NoError(t, link.Execute())
+
+	data, err := os.ReadFile(configFileName)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "project_id: prompted\n")
+}
@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+type logLevel int
+
+const (
+	logLevelQuiet logLevel = iota
+	logLevelInfo
+	logLevelDebug
+)
+
+// currentLogLevel controls the logging helpers in run.go. Warnings and
+// errors are printed at every level.
+var currentLogLevel = logLevelInfo
+
+type outputOptions struct {
+	verbose bool
+	quiet   bool
+	color   string
+	ci      bool
+}
+
+func (o *outputOptions) bindFlags(cmd *cobra.Command) {
+	flags := cmd.PersistentFlags()
+	flags.BoolVarP(&o.verbose, "verbose", "v", false, "Print debug output about hashing, negotiation and transfers")
+	flags.BoolVarP(&o.quiet, "quiet", "q", false, "Only print warnings, errors and task output")
+	flags.StringVar(&o.color, "color", "auto", "When to colorize output: auto, always or never")
+	flags.BoolVar(&o.ci, "ci", false, "Group task output and annotate failures for the CI provider, without colors (default: detected from the environment)")
+	cmd.MarkFlagsMutuallyExclusive("verbose", "quiet")
+}
+
+// apply configures logging for the command being run. Flags take precedence
+// over VELOCITY_LOG; colors follow --color, then CI mode, NO_COLOR and the
+// terminal.
+func (o *outputOptions) apply(cmd *cobra.Command) error {
+	level, err := parseLogLevel(os.Getenv("VELOCITY_LOG"))
+	if err != nil {
+		return err
+	}
+	if o.verbose {
+		level = logLevelDebug
+	}
+	if o.quiet {
+		level = logLevelQuiet
+	}
+	currentLogLevel = level
+
+	currentCI = detectCIProvider(os.Getenv)
+	if flag := cmd.Flag("ci"); flag != nil && flag.Changed {
+		switch {
+		case !o.ci:
+			currentCI = ciNone
+		case currentCI == ciNone:
+			currentCI = ciGeneric
+		}
+	}
+
+	switch strings.ToLower(o.color) {
+	case "auto", "":
+		if currentCI != ciNone {
+			color.NoColor = true
+		}
+	case "always":
+		color.NoColor = false
+	case "never":
+		color.NoColor = true
+	default:
+		return fmt.Errorf("invalid --color %q (expected auto, always or never)", o.color)
+	}
+
+	if level == logLevelDebug {
+		errOut := cmd.ErrOrStderr()
+		var mu sync.Mutex
+		engine.SetDebugLogger(func(message string) {
+			mu.Lock()
+			defer mu.Unlock()
+			logDebug(errOut, message)
+		})
+	} else {
+		engine.SetDebugLogger(nil)
+	}
+	return nil
+}
+
+func parseLogLevel(value string) (logLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "info":
+		return logLevelInfo, nil
+	case "debug":
+		return logLevelDebug, nil
+	case "quiet", "warn", "error":
+		return logLevelQuiet, nil
+	}
+	return logLevelInfo, fmt.Errorf("invalid VELOCITY_LOG %q (expected debug, info or quiet)", value)
+}
+
+func logDebug(errOut io.Writer, message string) {
+	if currentLogLevel < logLevelDebug {
+		return
+	}
+	fmt.Fprintf(errOut, "%s %s %s\n", prefix(), subtleStyle.Sprint("DEBUG"), subtleStyle.Sprint(message))
+}
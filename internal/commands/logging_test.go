@@ -0,0 +1,56 @@
+package commands
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	for value, want := range map[string]logLevel{
+		"":      logLevelInfo,
+		"info":  logLevelInfo,
+		"DEBUG": logLevelDebug,
+		"quiet": logLevelQuiet,
+		"warn":  logLevelQuiet,
+	} {
+		level, err := parseLogLevel(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, level, value)
+	}
+
+	_, err := parseLogLevel("loud")
+	assert.ErrorContains(t, err, `invalid VELOCITY_LOG "loud"`)
+}
+
+func TestOutputOptionsApply(t *testing.T) {
+	noColor := color.NoColor
+	t.Cleanup(func() {
+		color.NoColor = noColor
+		currentLogLevel = logLevelInfo
+		currentCI = ciNone
+		engine.SetDebugLogger(nil)
+	})
+
+	t.Setenv("VELOCITY_LOG", "debug")
+	root := NewRootCommand()
+	require.NoError(t, (&outputOptions{quiet: true, color: "never"}).apply(root))
+	assert.Equal(t, logLevelQuiet, currentLogLevel, "--quiet overrides VELOCITY_LOG")
+	assert.True(t, color.NoColor)
+
+	var out bytes.Buffer
+	logInfo(&out, "hidden")
+	logWarning(&out, "shown")
+	assert.NotContains(t, out.String(), "hidden")
+	assert.Contains(t, out.String(), "WARN shown")
+
+	require.NoError(t, (&outputOptions{color: "auto"}).apply(root))
+	assert.Equal(t, logLevelDebug, currentLogLevel)
+
+	assert.ErrorContains(t, (&outputOptions{color: "sometimes"}).apply(root), `invalid --color "sometimes"`)
+}
@@ -0,0 +1,96 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// traceEvent is an event in the Chrome trace event format, which Perfetto
+// and chrome://tracing load. Times are in microseconds.
+type traceEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat,omitempty"`
+	Ph   string                 `json:"ph"`
+	Ts   int64                  `json:"ts"`
+	Dur  int64                  `json:"dur,omitempty"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// profiler records spans for each task of a run, one trace lane per task.
+// A nil profiler records nothing.
+type profiler struct {
+	now func() time.Time
+
+	mu     sync.Mutex
+	start  time.Time
+	events []traceEvent
+	lanes  map[string]int
+}
+
+func newProfiler() *profiler {
+	p := &profiler{now: time.Now, lanes: make(map[string]int)}
+	p.start = p.now()
+	return p
+}
+
+// span starts a span on the task's lane and returns the function ending it.
+func (p *profiler) span(taskID, name string) func() {
+	if p == nil {
+		return func() {}
+	}
+	begin := p.now()
+	return func() {
+		end := p.now()
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.events = append(p.events, traceEvent{
+			Name: name,
+			Cat:  "task",
+			Ph:   "X",
+			Ts:   begin.Sub(p.start).Microseconds(),
+			Dur:  max(end.Sub(begin).Microseconds(), 1),
+			Pid:  1,
+			Tid:  p.lane(taskID),
+		})
+	}
+}
+
+// lane returns the trace thread of a task, naming it on first use. It must
+// be called with p.mu held.
+func (p *profiler) lane(taskID string) int {
+	if tid, ok := p.lanes[taskID]; ok {
+		return tid
+	}
+	tid := len(p.lanes) + 1
+	p.lanes[taskID] = tid
+	p.events = append(p.events,
+		traceEvent{Name: "thread_name", Ph: "M", Pid: 1, Tid: tid, Args: map[string]interface{}{"name": taskID}},
+		traceEvent{Name: "thread_sort_index", Ph: "M", Pid: 1, Tid: tid, Args: map[string]interface{}{"sort_index": tid}},
+	)
+	return tid
+}
+
+func (p *profiler) write(path string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	events := append([]traceEvent{
+		{Name: "process_name", Ph: "M", Pid: 1, Args: map[string]interface{}{"name": "velocity"}},
+	}, p.events...)
+	data, err := json.Marshal(map[string]interface{}{
+		"traceEvents":     events,
+		"displayTimeUnit": "ms",
+	})
+	if err != nil {
+		return fmt.Errorf("encode profile: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("write profile %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package commands
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfilerWritesChromeTrace(t *testing.T) {
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := &profiler{now: func() time.Time { return clock }, start: clock, lanes: make(map[string]int)}
+
+	endTask := p.span("app#build", "app#build")
+	endHash := p.span("app#build", "hash")
+	clock = clock.Add(3 * time.Millisecond)
+	endHash()
+	endExec := p.span("lib#build", "execute")
+	clock = clock.Add(time.Second)
+	endExec()
+	endTask()
+
+	path := filepath.Join(t.TempDir(), "trace.json")
+	require.NoError(t, p.write(path))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var trace struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	require.NoError(t, json.Unmarshal(data, &trace))
+
+	var spans []traceEvent
+	lanes := make(map[int]interface{})
+	for _, event := range trace.TraceEvents {
+		switch {
+		case event.Ph == "X":
+			spans = append(spans, event)
+		case event.Name == "thread_name":
+			lanes[event.Tid] = event.Args["name"]
+		}
+	}
+	assert.Equal(t, map[int]interface{}{1: "app#build", 2: "lib#build"}, lanes)
+	require.Len(t, spans, 3)
+	assert.Equal(t, traceEvent{Name: "hash", Cat: "task", Ph: "X", Ts: 0, Dur: 3000, Pid: 1, Tid: 1}, spans[0])
+	assert.Equal(t, traceEvent{Name: "execute", Cat: "task", Ph: "X", Ts: 3000, Dur: 1000000, Pid: 1, Tid: 2}, spans[1])
+	assert.Equal(t, int64(1003000), spans[2].Dur)
+}
+
+func TestNilProfilerIsNoop(t *testing.T) {
+	var p *profiler
+	p.span("task", "hash")()
+}
@@ -0,0 +1,126 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+func newPruneCommand() *cobra.Command {
+	var olderThan, maxSize string
+	var remote bool
+
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Trim the local (and optionally remote) cache by age or size",
+		Long: "Remove cache artifacts that have not been used within --older-than and then,\n" +
+			"if the local cache is still larger than --max-size, the least recently used\n" +
+			"ones. With --remote the server also deletes artifacts older than --older-than.",
+		Example: "  velocity prune --older-than 7d --max-size 5GB",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if olderThan == "" && maxSize == "" {
+				return fmt.Errorf("specify --older-than, --max-size or both")
+			}
+
+			var age time.Duration
+			var cutoff time.Time
+			if olderThan != "" {
+				var err error
+				if age, err = parseAge(olderThan); err != nil {
+					return err
+				}
+				cutoff = time.Now().Add(-age)
+			}
+			var maxBytes int64
+			if maxSize != "" {
+				var err error
+				if maxBytes, err = config.ParseSize(maxSize); err != nil {
+					return fmt.Errorf("--max-size: %w", err)
+				}
+			}
+			if remote && age == 0 {
+				return fmt.Errorf("--remote requires --older-than")
+			}
+
+			removed, err := engine.PruneLocal(cutoff, maxBytes)
+			var freed int64
+			for _, entry := range removed {
+				freed += entry.Size
+			}
+			logInfo(cmd.OutOrStdout(), fmt.Sprintf("Removed %d local artifact(s), freed %s.", len(removed), formatBytes(freed)))
+			if err != nil {
+				return err
+			}
+
+			if !remote {
+				return nil
+			}
+			cfg, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("load config: %w", err)
+			}
+			remotes := cfg.RemoteCaches()
+			if len(remotes) == 0 {
+				return fmt.Errorf("remote caching is disabled in %s", configFileName)
+			}
+			var errs []error
+			for _, remote := range remotes {
+				if remote.ReadOnly {
+					continue
+				}
+				label := ""
+				if len(remotes) > 1 {
+					label = remote.Name + ": "
+				}
+				client, err := remoteAPIClient(remote)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s%w", label, err))
+					continue
+				}
+				resp, err := client.Prune(cmd.Context(), age)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%sprune remote cache: %w", label, err))
+					continue
+				}
+				logInfo(cmd.OutOrStdout(), fmt.Sprintf("%sRemoved %d remote artifact(s), freed %s.", label, resp.Removed, formatBytes(resp.Bytes)))
+			}
+			if len(errs) > 0 {
+				return errors.Join(errs...)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&olderThan, "older-than", "", "Remove artifacts not used within this duration (e.g. 36h, 7d, 2w)")
+	cmd.Flags().StringVar(&maxSize, "max-size", "", "Evict least recently used local artifacts until the cache fits (e.g. 5GB)")
+	cmd.Flags().BoolVar(&remote, "remote", false, "Also prune the remote cache (supports --older-than only)")
+	return cmd
+}
+
+// parseAge accepts Go durations plus whole days (d) and weeks (w).
+func parseAge(value string) (time.Duration, error) {
+	trimmed := strings.TrimSpace(value)
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if number, ok := strings.CutSuffix(trimmed, suffix); ok {
+			n, err := strconv.Atoi(number)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid age %q (expected a value such as 36h, 7d or 2w)", value)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	age, err := time.ParseDuration(trimmed)
+	if err != nil || age <= 0 {
+		return 0, fmt.Errorf("invalid age %q (expected a value such as 36h, 7d or 2w)", value)
+	}
+	return age, nil
+}
@@ -0,0 +1,29 @@
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAge(t *testing.T) {
+	cases := map[string]time.Duration{
+		"7d":    7 * 24 * time.Hour,
+		"2w":    14 * 24 * time.Hour,
+		"36h":   36 * time.Hour,
+		"90m":   90 * time.Minute,
+		" 1d  ": 24 * time.Hour,
+	}
+	for input, want := range cases {
+		got, err := parseAge(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	for _, input := range []string{"", "0d", "-1h", "1.5d", "soon"} {
+		_, err := parseAge(input)
+		assert.Error(t, err, input)
+	}
+}
@@ -1,18 +1,42 @@
 package commands
 
-import "github.com/spf13/cobra"
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/bit2swaz/velocity-cache/internal/version"
+)
 
 func NewRootCommand() *cobra.Command {
+	var output outputOptions
 	root := &cobra.Command{
 		Use:           "velocity",
 		Short:         "Velocity Cache CLI",
+		Version:       version.Get().String(),
 		SilenceUsage:  true,
 		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return output.apply(cmd)
+		},
 	}
+	output.bindFlags(root)
+	root.SetVersionTemplate("velocity {{.Version}}\n")
 
 	root.AddCommand(newInitCommand())
 	root.AddCommand(newRunCommand())
+	root.AddCommand(newExecCommand())
 	root.AddCommand(newCleanCommand())
+	root.AddCommand(newCacheCommand())
+	root.AddCommand(newPruneCommand())
+	root.AddCommand(newWarmCommand())
+	root.AddCommand(newGraphCommand())
+	root.AddCommand(newValidateCommand())
+	root.AddCommand(newRunsCommand())
+	root.AddCommand(newLinkCommand())
+	root.AddCommand(newUnlinkCommand())
+	root.AddCommand(newDoctorCommand())
+	root.AddCommand(newHashCommand())
+	root.AddCommand(newExplainCommand())
+	root.AddCommand(newVersionCommand())
 
 	return root
 }
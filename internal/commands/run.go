@@ -1,13 +1,17 @@
 package commands
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"runtime"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fatih/color"
@@ -59,126 +63,560 @@ func newExitError(code int, err error) ExitError {
 	return &exitError{code: code, err: err}
 }
 
+type taskSelection struct {
+	filters  []string
+	tags     []string
+	all      bool
+	affected bool
+	since    string
+	env      []string
+}
+
+func (s *taskSelection) bindFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVarP(&s.filters, "filter", "F", nil, "Select packages by name or path glob; prefix with ! to exclude, ...pkg for dependents, pkg... for dependencies (repeatable)")
+	cmd.Flags().StringArrayVarP(&s.filters, "package", "p", nil, "Target package")
+	_ = cmd.Flags().MarkDeprecated("package", "use --filter instead")
+	cmd.Flags().StringArrayVar(&s.tags, "tag", nil, "Select packages carrying the tag (repeatable; combines with --filter)")
+	cmd.Flags().BoolVar(&s.all, "all", false, "Run the task in every package that defines it")
+	cmd.Flags().BoolVar(&s.affected, "affected", false, "Only run the task in packages changed since --since (and their dependents)")
+	cmd.Flags().StringVar(&s.since, "since", "main", "Git ref to compare against when computing affected packages")
+	cmd.Flags().StringArrayVar(&s.env, "env", nil, "Set KEY=VALUE for every task and include it in their cache keys (repeatable)")
+	cmd.MarkFlagsMutuallyExclusive("all", "filter")
+	cmd.MarkFlagsMutuallyExclusive("all", "package")
+	cmd.MarkFlagsMutuallyExclusive("all", "tag")
+}
+
+type runOptions struct {
+	selection     taskSelection
+	concurrency   int
+	dryRun        string
+	outputLogs    string
+	summaryFile   string
+	watch         bool
+	force         bool
+	cache         string
+	remoteOnly    bool
+	noRemoteWrite bool
+	noAsyncUpload bool
+	keepGoing     bool
+	tui           bool
+	profile       string
+}
+
 func newRunCommand() *cobra.Command {
-	var packageSelector string
+	var opts runOptions
 	cmd := &cobra.Command{
 		Use:   "run <task-name>",
 		Short: "Execute a pipeline task",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 
-			return runScript(cmd, args[0], packageSelector)
+			return runScript(cmd, args[0], opts)
 		},
 	}
-	cmd.Flags().StringVarP(&packageSelector, "package", "p", "", "Target package")
+	opts.selection.bindFlags(cmd)
+	cmd.Flags().IntVar(&opts.concurrency, "concurrency", runtime.NumCPU(), "Maximum number of tasks to execute in parallel")
+	cmd.Flags().StringVar(&opts.dryRun, "dry-run", "", "Print the planned tasks and cache status without executing (text or json)")
+	cmd.Flags().Lookup("dry-run").NoOptDefVal = "text"
+	cmd.Flags().StringVar(&opts.summaryFile, "summary-file", "", "Write a JSON run summary to this path (\"-\" for stdout)")
+	cmd.Flags().StringVar(&opts.outputLogs, "output-logs", "", "Task output to print: full, errors-only, hash-only or none (overrides output_logs)")
+	cmd.Flags().BoolVar(&opts.watch, "watch", false, "Re-run invalidated tasks whenever package files change")
+	cmd.Flags().BoolVar(&opts.force, "force", false, "Skip cache lookups and re-execute every task, still saving fresh artifacts (or set VELOCITY_FORCE=1)")
+	cmd.Flags().StringVar(&opts.cache, "cache", "", "Cache tiers to read and write, e.g. local:rw,remote:r (unlisted tiers are disabled)")
+	cmd.Flags().BoolVar(&opts.remoteOnly, "remote-only", false, "Use only the remote cache, never reading or writing the local one")
+	cmd.Flags().BoolVar(&opts.noRemoteWrite, "no-remote-write", false, "Read from the remote cache but never upload artifacts")
+	cmd.Flags().BoolVar(&opts.noAsyncUpload, "no-async-upload", false, "Upload each artifact before its task completes instead of in the background")
+	cmd.Flags().BoolVar(&opts.keepGoing, "continue", false, "Keep running independent tasks after a failure and report every failure at the end")
+	cmd.Flags().StringVar(&opts.profile, "profile", "", "Write a Chrome trace of every task's phases to this file (open it in Perfetto or chrome://tracing)")
+	cmd.Flags().BoolVar(&opts.tui, "tui", false, "Show a live table of task states instead of streaming logs (falls back to plain logs when not a terminal)")
+	cmd.MarkFlagsMutuallyExclusive("watch", "dry-run")
+	cmd.MarkFlagsMutuallyExclusive("watch", "tui")
+	cmd.MarkFlagsMutuallyExclusive("watch", "profile")
+	cmd.MarkFlagsMutuallyExclusive("cache", "remote-only")
 	return cmd
 }
 
-func runScript(cmd *cobra.Command, taskName, packageSelector string) error {
-	ctx := cmd.Context()
-	out := cmd.OutOrStdout()
+func runScript(cmd *cobra.Command, taskName string, opts runOptions) error {
+	if !cmd.Flags().Changed("force") && envEnabled(os.Getenv("VELOCITY_FORCE")) {
+		opts.force = true
+	}
+	if !cmd.Flags().Changed("no-async-upload") && envEnabled(os.Getenv("VELOCITY_NO_ASYNC_UPLOAD")) {
+		opts.noAsyncUpload = true
+	}
+	if opts.concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1, got %d", opts.concurrency)
+	}
+	if opts.outputLogs != "" && !config.ValidOutputLogs(opts.outputLogs) {
+		return fmt.Errorf("invalid --output-logs %q (expected full, errors-only, hash-only or none)", opts.outputLogs)
+	}
 
-	cfg, err := config.Load()
+	if _, err := opts.cachePolicy(); err != nil {
+		return err
+	}
+
+	if opts.watch {
+		return watchTask(cmd, taskName, opts)
+	}
+
+	cfg, roots, err := loadTaskGraph(cmd, taskName, opts.selection)
 	if err != nil {
-		return fmt.Errorf("load config: %w", err)
+		return err
+	}
+	if len(roots) == 0 {
+		return nil
 	}
 
-	packageGlobs := []string{"apps/*", "libs/*", "packages/*"}
+	exec, err := newEngine(cmd, cfg, taskName, opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.dryRun != "" {
+		return exec.DryRun(roots, opts.dryRun)
+	}
+
+	runErr := exec.Run(roots, opts.concurrency)
+	exec.writeProfile(opts.profile)
+	return finishRun(cmd, exec.summary, runErr, opts.summaryFile)
+}
+
+// finishRun finalizes the run summary, records it in the run history and
+// writes the optional summary file. It returns runErr unchanged.
+func finishRun(cmd *cobra.Command, summary *RunSummary, runErr error, summaryFile string) error {
+	summary.finish(runErr)
+
+	if err := saveRunHistory(summary); err != nil {
+		logWarning(cmd.ErrOrStderr(), err.Error())
+	}
+
+	if summaryFile != "" {
+		if err := writeSummaryFile(summaryFile, summary, cmd.OutOrStdout()); err != nil {
+			logWarning(cmd.ErrOrStderr(), err.Error())
+		}
+	}
+
+	return runErr
+}
+
+func (o runOptions) cachePolicy() (cachePolicy, error) {
+	policy, err := parseCachePolicy(o.cache)
+	if err != nil {
+		return cachePolicy{}, err
+	}
+	if o.remoteOnly {
+		policy.localRead, policy.localWrite = false, false
+	}
+	if o.noRemoteWrite {
+		policy.remoteWrite = false
+	}
+	return policy, nil
+}
+
+func newEngine(cmd *cobra.Command, cfg *config.Config, taskName string, opts runOptions) (*Engine, error) {
+	maxBytes, err := cfg.LocalCacheMaxBytes()
+	if err != nil {
+		return nil, err
+	}
+	engine.SetLocalCacheMaxBytes(maxBytes)
+	engine.SetRestoreMode(cfg.Cache.Restore)
+
+	policy, _ := opts.cachePolicy()
+	exec := &Engine{
+		ctx:        cmd.Context(),
+		cfg:        cfg,
+		out:        cmd.OutOrStdout(),
+		errOut:     cmd.ErrOrStderr(),
+		outputLogs: opts.outputLogs,
+		force:      opts.force,
+		policy:     policy,
+		keepGoing:  opts.keepGoing,
+		summary:    newRunSummary(taskName),
+
+		asyncUpload: !opts.noAsyncUpload,
+	}
+
+	if currentCI != ciNone {
+		exec.ci = newCIReporter(currentCI)
+	}
+	if opts.profile != "" {
+		exec.profile = newProfiler()
+	}
+	if opts.tui {
+		// Debug messages go straight to stderr and would tear the table.
+		if currentLogLevel < logLevelDebug {
+			exec.live = newLiveView(exec.out)
+		}
+		if exec.live == nil {
+			logDebug(exec.errOut, "--tui needs an interactive terminal; using plain logs")
+		}
+	}
+
+	if exec.remotes, err = newRemoteCaches(cfg); err != nil {
+		return nil, err
+	}
+	if len(exec.remotes) > 0 {
+		exec.transfers = engine.NewTransferPool(cfg.Remote.Transfers)
+	}
+
+	return exec, nil
+}
+
+// resolvePackageGlobs returns the package globs from velocity.yml, falling
+// back to the workspace manifests and finally to the default layout.
+func resolvePackageGlobs(cfg *config.Config) ([]string, error) {
 	if len(cfg.Packages) > 0 {
-		packageGlobs = cfg.Packages
+		return cfg.Packages, nil
+	}
+	globs, err := engine.WorkspaceGlobs(".")
+	if err != nil {
+		return nil, fmt.Errorf("read workspace globs: %w", err)
+	}
+	if len(globs) > 0 {
+		return globs, nil
+	}
+	return []string{"apps/*", "libs/*", "packages/*"}, nil
+}
+
+// loadTaskGraph loads velocity.yml, discovers packages and builds one task
+// graph per selected package. It returns no roots when an affected run has
+// nothing to do.
+func loadTaskGraph(cmd *cobra.Command, taskName string, sel taskSelection) (*config.Config, []*engine.TaskNode, error) {
+	if cmd.Flags().Changed("since") {
+		sel.affected = true
+	}
+
+	env, err := parseEnvOverrides(sel.env)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, nil, fmt.Errorf("load config: %w", err)
+	}
+	engine.SetCacheVersion(cfg.CacheVersion())
+	engine.SetGlobalInputs(cfg.GlobalDependencies, cfg.GlobalEnv)
+	engine.SetInputDiscovery(cfg.InputDiscovery)
+	engine.SetFileHashAlgorithm(cfg.HashAlgorithm)
+
+	packageGlobs, err := resolvePackageGlobs(cfg)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	packages, err := engine.DiscoverPackages(packageGlobs)
 	if err != nil {
-		return fmt.Errorf("discover packages: %w", err)
+		return nil, nil, fmt.Errorf("discover packages: %w", err)
 	}
 
 	if len(packages) > 0 {
 		if err := engine.BuildPackageGraph(packages); err != nil {
-			return fmt.Errorf("build package graph: %w", err)
+			return nil, nil, fmt.Errorf("build package graph: %w", err)
+		}
+		if err := engine.ApplyTags(packages, cfg.Tags); err != nil {
+			return nil, nil, fmt.Errorf("apply tags: %w", err)
 		}
 	}
 
-	target, err := selectTargetPackage(packageSelector, packages)
+	filtering := len(sel.filters) > 0 || len(sel.tags) > 0
+	var targets []*engine.Package
+	switch {
+	case filtering && len(packages) > 0:
+		targets, err = selectFilteredPackages(taskName, sel, packages)
+	case filtering || sel.all || sel.affected:
+		targets, err = selectTaskPackages(taskName, packages)
+	default:
+		var target *engine.Package
+		target, err = selectTargetPackage(packages)
+		targets = []*engine.Package{target}
+	}
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+
+	if _, ok := cfg.Pipeline[taskName]; !ok {
+		command, err := inferTask(cfg, taskName, targets)
+		if err != nil {
+			return nil, nil, err
+		}
+		logInfo(cmd.ErrOrStderr(), fmt.Sprintf("Task %q is not in the pipeline; running package.json scripts with %q (uncached).", taskName, command))
+	}
+
+	if sel.affected {
+		targets, err = filterAffected(cmd.Context(), sel.since, targets, packages)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(targets) == 0 {
+			logInfo(cmd.ErrOrStderr(), fmt.Sprintf("No packages affected since %s.", sel.since))
+			return cfg, nil, nil
+		}
 	}
 
-	root, err := engine.BuildTaskGraph(taskName, target, packages, cfg, nil)
+	roots := make([]*engine.TaskNode, 0, len(targets))
+	for _, target := range targets {
+		root, err := engine.BuildTaskGraph(taskName, target, packages, cfg, nil)
+		if err != nil {
+			var cycleErr *engine.CycleError
+			if errors.As(err, &cycleErr) {
+				return nil, nil, describeCycle(cycleErr)
+			}
+			return nil, nil, fmt.Errorf("build task graph: %w", err)
+		}
+		roots = append(roots, root)
+	}
+	applyEnvOverrides(roots, env)
+
+	nodes, err := engine.Plan(roots...)
 	if err != nil {
-		return fmt.Errorf("build task graph: %w", err)
+		return nil, nil, fmt.Errorf("build task graph: %w", err)
+	}
+	warnings, err := engine.TaskConflicts(nodes)
+	for _, warning := range warnings {
+		logWarning(cmd.ErrOrStderr(), warning)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("build task graph: %w", err)
 	}
 
-	exec := &Engine{
-		ctx:    ctx,
-		cfg:    cfg,
-		out:    out,
-		errOut: cmd.ErrOrStderr(),
+	return cfg, roots, nil
+}
+
+// describeCycle adds the velocity.yml lines of the depends_on entries that
+// form a dependency cycle to its error.
+func describeCycle(cycleErr *engine.CycleError) error {
+	data, _ := os.ReadFile(configFileName)
+	var lines []string
+	for _, edge := range cycleErr.Edges {
+		location := configFileName
+		if line := config.LocateDependsOn(data, edge.Task, edge.Ref); line > 0 {
+			location = fmt.Sprintf("%s:%d", configFileName, line)
+		}
+		lines = append(lines, fmt.Sprintf("  %s: pipeline.%s depends on %q", location, edge.Task, edge.Ref))
 	}
+	return fmt.Errorf("%w\n%s", cycleErr, strings.Join(lines, "\n"))
+}
 
-	if cfg.Remote.Enabled {
+// parseEnvOverrides parses repeated --env KEY=VALUE flags.
+func parseEnvOverrides(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	env := make(map[string]string, len(values))
+	for _, value := range values {
+		key, val, ok := strings.Cut(value, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid --env %q (expected KEY=VALUE)", value)
+		}
+		env[key] = val
+	}
+	return env, nil
+}
 
-		exec.remote = engine.NewRemoteClient(cfg.Remote.URL, cfg.Remote.Token)
+// applyEnvOverrides sets env on every task reachable from roots, after tag
+// overrides have been resolved so they cannot drop the extra env keys.
+func applyEnvOverrides(roots []*engine.TaskNode, env map[string]string) {
+	if len(env) == 0 {
+		return
+	}
+	seen := make(map[*engine.TaskNode]bool)
+	var visit func(node *engine.TaskNode)
+	visit = func(node *engine.TaskNode) {
+		if node == nil || seen[node] {
+			return
+		}
+		seen[node] = true
+		node.TaskConfig = node.TaskConfig.WithEnv(env)
+		for _, dep := range node.Dependencies {
+			visit(dep)
+		}
+	}
+	for _, root := range roots {
+		visit(root)
+	}
+}
+
+// inferTask adds an uncached pipeline entry for a task that is only defined
+// as a package.json script, run with each package's package manager.
+func inferTask(cfg *config.Config, taskName string, targets []*engine.Package) (string, error) {
+	defined := false
+	for _, target := range targets {
+		if _, ok := target.Scripts[taskName]; ok {
+			defined = true
+			break
+		}
+	}
+	if !defined {
+		return "", fmt.Errorf("task %q is not defined in the velocity.yml pipeline or in package.json scripts", taskName)
 	}
 
-	_, err = exec.ExecuteTask(root)
-	return err
+	cache := false
+	command := engine.PackageManagerPlaceholder + " run " + taskName
+	if cfg.Pipeline == nil {
+		cfg.Pipeline = make(map[string]config.TaskConfig)
+	}
+	cfg.Pipeline[taskName] = config.TaskConfig{Command: command, Cache: &cache}
+	return command, nil
 }
 
 type Engine struct {
-	ctx    context.Context
-	cfg    *config.Config
-	out    io.Writer
-	errOut io.Writer
-	remote *engine.RemoteClient
+	ctx        context.Context
+	cfg        *config.Config
+	out        io.Writer
+	errOut     io.Writer
+	remotes    []*remoteCache
+	outputLogs string
+	summary    *RunSummary
+
+	// lastKeys, when set, holds the cache key each task had after its last
+	// successful execution in watch mode; tasks whose key is unchanged are
+	// skipped entirely.
+	lastKeys *watchKeys
+	// force skips cache reads so every task executes; fresh artifacts are
+	// still written afterwards.
+	force     bool
+	policy    cachePolicy
+	keepGoing bool
+	// ci, when set, buffers each task's logs and writes them as one group.
+	ci *ciReporter
+	// live, when set, replaces streamed logs with a redrawn table of tasks.
+	live *liveView
+	// profile, when set, records a trace span for every phase of each task.
+	profile *profiler
+	// transfers runs the artifact transfers of all tasks, bounded by
+	// remote.transfers rather than by task concurrency.
+	transfers *engine.TransferPool
+	// asyncUpload uploads artifacts in the background once their task has
+	// finished; Run waits for them before returning.
+	asyncUpload bool
+	uploads     uploadGroup
 }
 
-func (e *Engine) ExecuteTask(task *engine.TaskNode) (string, error) {
-	if task == nil {
-		return "", nil
+func (e *Engine) Run(roots []*engine.TaskNode, concurrency int) error {
+	logOut := e.errOut
+	if e.live != nil {
+		nodes, err := engine.Plan(roots...)
+		if err != nil {
+			return err
+		}
+		e.live.start(nodes)
+		logOut = e.live
 	}
 
-	if task.State == 2 {
-		return task.CacheKey, nil
+	scheduler := engine.NewScheduler(concurrency)
+	scheduler.ContinueOnError = e.keepGoing
+	scheduler.OnRetry = func(node *engine.TaskNode, attempt int, delay time.Duration, err error) {
+		logWarning(logOut, fmt.Sprintf("Task %s failed (attempt %d of %d): %v. Retrying in %s...", node.ID, attempt, node.TaskConfig.Retries+1, err, delay))
 	}
-	if task.State == 1 {
-		return "", fmt.Errorf("cycle detected while executing %s", task.ID)
+	runErr := scheduler.Run(e.ctx, roots, e.executeTask)
+	e.waitForUploads(logOut)
+	if e.live != nil {
+		e.live.stop()
+	}
+	if err := engine.SaveHashCache(); err != nil {
+		logWarning(e.errOut, err.Error())
+	}
+	for _, remote := range e.remotes {
+		if err := remote.client.SaveMisses(); err != nil {
+			logWarning(e.errOut, err.Error())
+		}
+	}
+	if stats := e.transfers.Stats(); stats.Downloads+stats.Uploads > 0 {
+		e.summary.setTransferTime(stats.Busy)
+		logInfo(e.errOut, describeTransfers(stats))
+	}
+	if runErr == nil {
+		return nil
 	}
-	task.State = 1
-
-	logTaskHeader(e.out, task.ID)
 
-	var wg sync.WaitGroup
-	var depKeys []string
-	var depMu sync.Mutex
-	var depErr error
+	nodes, err := engine.Plan(roots...)
+	if err != nil {
+		return runErr
+	}
+	var failed, skipped []*engine.TaskNode
+	for _, node := range nodes {
+		switch node.State {
+		case engine.TaskFailed:
+			failed = append(failed, node)
+		case engine.TaskSkipped:
+			skipped = append(skipped, node)
+			record := newTaskSummary(node)
+			record.Status = taskStatusSkipped
+			record.Cache = ""
+			e.summary.record(record)
+		}
+	}
+	if e.ctx.Err() == nil {
+		logFailures(e.errOut, failed, skipped)
+	}
+	return runErr
+}
 
-	for _, dep := range task.Dependencies {
-		wg.Add(1)
-		go func(d *engine.TaskNode) {
-			defer wg.Done()
-			k, err := e.ExecuteTask(d)
-			depMu.Lock()
-			if err != nil && depErr == nil {
-				depErr = err
+func (e *Engine) executeTask(ctx context.Context, task *engine.TaskNode) (err error) {
+	record := newTaskSummary(task)
+	defer func() {
+		if err != nil {
+			record.Status = taskStatusFailed
+			var timeoutErr *engine.TimeoutError
+			if errors.As(err, &timeoutErr) {
+				record.Status = taskStatusTimeout
 			}
-			if k != "" {
-				depKeys = append(depKeys, k)
+			record.Error = err.Error()
+		}
+		e.summary.record(record)
+	}()
+
+	if e.live != nil {
+		e.live.setState(task.ID, rowRunning, "hashing")
+		defer func() {
+			switch {
+			case err != nil:
+				e.live.setState(task.ID, rowFailed, "")
+			case record.Cache == cacheSourceLocal || record.Cache == cacheSourceRemote:
+				e.live.setState(task.ID, rowHit, record.Cache)
+			case record.Cache == cacheSourceBypass:
+				e.live.setState(task.ID, rowMiss, "cache disabled")
+			default:
+				e.live.setState(task.ID, rowMiss, "")
 			}
-			depMu.Unlock()
-		}(dep)
-	}
-	wg.Wait()
-	if depErr != nil {
-		task.State = 3
-		return "", depErr
+		}()
 	}
 
-	key, err := engine.GenerateTaskNodeCacheKey(task, depKeys)
+	defer e.profile.span(task.ID, task.ID)()
+
+	endHash := e.profile.span(task.ID, "hash")
+	key, err := computeCacheKey(ctx, task)
+	endHash()
 	if err != nil {
-		return "", err
+		return err
+	}
+	record.Hash = key
+
+	if e.lastKeys.unchanged(task.ID, key) {
+		record.Cache = cacheSourceUnchanged
+		return nil
+	}
+	defer func() {
+		if err == nil {
+			e.lastKeys.store(task.ID, key)
+		}
+	}()
+
+	mode := e.outputMode(task)
+	out, errOut := e.out, e.errOut
+	if mode == config.OutputLogsNone {
+		out, errOut = io.Discard, io.Discard
+	} else if e.live != nil {
+		w := e.live.taskOutput(task.ID)
+		out, errOut = w, w
+	} else if e.ci != nil {
+		var buf bytes.Buffer
+		out, errOut = &buf, &buf
+		defer func() { e.ci.flushTask(e.out, task.ID, buf.Bytes(), err) }()
 	}
-	task.CacheKey = key
+
+	logTaskHeader(out, task.ID, key)
 
 	start := time.Now()
 	packagePath := ""
@@ -186,102 +624,544 @@ func (e *Engine) ExecuteTask(task *engine.TaskNode) (string, error) {
 		packagePath = task.Package.Path
 	}
 
-	cacheZip, found, err := engine.CheckLocal(key)
-	if err == nil && found {
-		if err := engine.Extract(cacheZip, task.TaskConfig.Outputs, packagePath); err == nil {
-			logCacheHit(e.out, "local", time.Since(start))
-			task.State = 2
-			return key, nil
+	cacheable := task.TaskConfig.CacheEnabled()
+	failureTTL, _ := task.TaskConfig.FailureTTL()
+	cacheFailures := cacheable && failureTTL > 0
+	if cacheFailures && !e.force && e.policy.localRead {
+		failure, found, err := engine.CheckFailure(key)
+		if err != nil {
+			logWarning(errOut, fmt.Sprintf("Failed to read failure record: %v", err))
+		} else if found {
+			if mode == config.OutputLogsFull || mode == config.OutputLogsErrorsOnly {
+				io.WriteString(out, failure.Log)
+			}
+			record.Cache = cacheSourceFailure
+			record.ExitCode = failure.ExitCode
+			logInfo(errOut, fmt.Sprintf("Replaying failure recorded %s ago for unchanged inputs (expires in %s). Use --force to run again.",
+				time.Since(failure.RecordedAt).Round(time.Second), time.Until(failure.ExpiresAt).Round(time.Second)))
+			return newExitError(failure.ExitCode, fmt.Errorf("task %s failed: %w (exit code %d)", task.ID, engine.ErrCachedFailure, failure.ExitCode))
+		}
+	}
+	if cacheable && !e.force {
+		if e.policy.localRead {
+			endLookup := e.profile.span(task.ID, "lookup local")
+			_, found, err := engine.CheckLocal(key)
+			if err == nil && found {
+				if err = engine.VerifyLocal(key); errors.Is(err, engine.ErrChecksumMismatch) || errors.Is(err, engine.ErrCorruptArtifact) {
+					logWarning(errOut, fmt.Sprintf("Removed corrupt local artifact: %v", err))
+				}
+			}
+			endLookup()
+			if err == nil && found {
+				endExtract := e.profile.span(task.ID, "extract")
+				err := engine.RestoreLocal(key, task.TaskConfig.Outputs, packagePath)
+				endExtract()
+				if err == nil {
+					_ = engine.TouchLocal(key)
+					record.Cache = cacheSourceLocal
+					if mode == config.OutputLogsFull {
+						replayLog(out, errOut, key)
+					}
+					logCacheHit(out, "local", time.Since(start))
+					return nil
+				}
+				logWarning(errOut, fmt.Sprintf("Failed to restore local artifact: %v", err))
+			}
+		}
+
+		if e.policy.remoteRead {
+			for _, remote := range e.remotes {
+				if e.restoreRemote(ctx, remote, task, key, packagePath, record, out, errOut, start) {
+					return nil
+				}
+			}
 		}
 	}
 
-	if e.remote != nil {
-		resp, err := e.remote.Negotiate(e.ctx, key, "download")
-		if err == nil && resp.Status == "found" {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-			tmp, _ := os.CreateTemp("", "velo-dl-*.zip")
-			defer os.Remove(tmp.Name())
+	if e.live != nil {
+		e.live.setState(task.ID, rowRunning, "executing")
+	}
+	if cacheable {
+		logCacheMissExecuting(out, task.TaskConfig.Command)
+	} else {
+		record.Cache = cacheSourceBypass
+		logCacheBypassExecuting(out, task.TaskConfig.Command)
+	}
+	var taskLog *tailBuffer
+	var capture io.Writer
+	if cacheFailures || (cacheable && e.policy.localWrite) {
+		taskLog = &tailBuffer{limit: taskLogLimit}
+		capture = taskLog
+	}
+	endExec := e.profile.span(task.ID, "execute")
+	execStart := time.Now()
+	exitCode, err := e.runCommand(ctx, task, packagePath, mode, out, errOut, capture)
+	execDuration := time.Since(execStart)
+	endExec()
+	record.ExitCode = exitCode
+	if err != nil {
+		if cacheFailures {
+			e.saveFailure(ctx, errOut, task, key, exitCode, err, failureTTL, taskLog)
+		}
+		if exitCode > 0 {
+			return newExitError(exitCode, fmt.Errorf("task %s failed: %w", task.ID, err))
+		}
+		return fmt.Errorf("task %s failed: %w", task.ID, err)
+	}
 
-			err = engine.Transfer(e.ctx, "GET", resp.URL, e.cfg.Remote.URL, nil, tmp, 0, e.cfg.Remote.Token)
-			if err == nil {
-				tmp.Close()
+	if !cacheable {
+		return nil
+	}
 
-				localZip, _ := engine.SaveLocal(key, tmp.Name())
-				engine.Extract(localZip, task.TaskConfig.Outputs, packagePath)
+	missing, err := engine.MissingOutputs(task.TaskConfig.Outputs, packagePath)
+	if err != nil {
+		logWarning(errOut, fmt.Sprintf("Failed to check outputs: %v", err))
+	} else if len(missing) > 0 {
+		if e.cfg.StrictOutputsFor(task.TaskConfig) {
+			return fmt.Errorf("task %s produced nothing matching outputs %s (strict_outputs is set)", task.ID, strings.Join(missing, ", "))
+		}
+		logWarning(errOut, fmt.Sprintf("Task %s produced nothing matching outputs %s; caching anyway.", task.ID, strings.Join(missing, ", ")))
+	}
 
-				logCacheHit(e.out, "remote", time.Since(start))
-				task.State = 2
-				return key, nil
+	var uploads []pendingUpload
+	if e.policy.remoteWrite {
+		for _, remote := range e.remotes {
+			if !remote.write {
+				continue
+			}
+			// Ask before compressing, so outputs the remotes already have
+			// are not archived for nothing.
+			endNegotiate := e.profile.span(task.ID, "negotiate upload")
+			resp, err := remote.client.Negotiate(ctx, key, "upload")
+			endNegotiate()
+			switch {
+			case err != nil:
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return ctxErr
+				}
+				uploads = append(uploads, pendingUpload{remote: remote})
+			case resp.Status == "skipped":
+				logInfo(out, remote.label+"Artifact already exists remotely (skipped).")
+			case resp.Status == "upload_needed":
+				uploads = append(uploads, pendingUpload{remote: remote, negotiated: resp})
+			default:
+				uploads = append(uploads, pendingUpload{remote: remote})
 			}
 		}
 	}
+	if len(uploads) == 0 && !e.policy.localWrite {
+		return nil
+	}
 
-	logCacheMissExecuting(e.out, task.TaskConfig.Command)
-	if _, err := engine.Execute(task.TaskConfig, packagePath); err != nil {
-		task.State = 3
-		return "", err
+	tmp, _ := os.CreateTemp("", "velo-out-*.zip")
+	tmp.Close()
+	ownTmp := true
+	defer func() {
+		if ownTmp {
+			os.Remove(tmp.Name())
+		}
+	}()
+	endCompress := e.profile.span(task.ID, "compress")
+	err = engine.Compress(ctx, task.TaskConfig.Outputs, tmp.Name(), packagePath, engine.ArchiveOptions{Format: e.cfg.ArchiveFormatFor(task.TaskConfig), PreserveMetadata: e.cfg.PreserveMetadata})
+	endCompress()
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		logWarning(errOut, fmt.Sprintf("Failed to archive outputs: %v", err))
+		return nil
 	}
 
-	if e.remote != nil {
-		resp, err := e.remote.Negotiate(e.ctx, key, "upload")
-		if err == nil && resp.Status == "upload_needed" {
-			logInfo(e.out, "Uploading artifact...")
+	archive := tmp.Name()
+	if e.policy.localWrite {
+		endStore := e.profile.span(task.ID, "store local")
+		if localZip, err := engine.SaveLocal(key, tmp.Name()); err == nil {
+			archive = localZip
+			saveMetadata(errOut, task, engine.ArtifactSourceLocal, fileSize(localZip), execDuration, taskLog)
+		}
+		endStore()
+	}
 
-			tmp, _ := os.CreateTemp("", "velo-up-*.zip")
-			defer os.Remove(tmp.Name())
-			engine.Compress(task.TaskConfig.Outputs, tmp.Name(), packagePath)
+	if len(uploads) == 0 {
+		return nil
+	}
+	if e.asyncUpload {
+		ownTmp = archive != tmp.Name()
+		background := e.errOut
+		if mode == config.OutputLogsNone {
+			background = io.Discard
+		} else if e.live != nil {
+			background = e.live
+		}
+		e.uploadInBackground(task, key, archive, archive == tmp.Name(), uploads, background)
+		return nil
+	}
+	for _, upload := range uploads {
+		uploaded, err := e.uploadArtifact(ctx, task, key, archive, upload, out, errOut, upload.remote.label, e.live != nil)
+		if err != nil {
+			return err
+		}
+		record.BytesUploaded += uploaded
+	}
+	return nil
+}
 
-			localZip, _ := engine.SaveLocal(key, tmp.Name())
+// pendingUpload is an artifact upload to remote. negotiated, if set, is the
+// remote's answer to asking before the artifact was built.
+type pendingUpload struct {
+	remote     *remoteCache
+	negotiated *engine.NegotiateResponse
+}
 
-			f, _ := os.Open(localZip)
-			stat, _ := f.Stat()
-			err = engine.Transfer(e.ctx, "PUT", resp.URL, e.cfg.Remote.URL, f, nil, stat.Size(), e.cfg.Remote.Token)
-			f.Close()
+// restoreRemote restores a task's outputs from remote if it has them,
+// reporting whether it did. Problems are logged rather than returned so
+// that the next remote can be tried.
+func (e *Engine) restoreRemote(ctx context.Context, remote *remoteCache, task *engine.TaskNode, key, packagePath string, record *TaskSummary, out, errOut io.Writer, start time.Time) bool {
+	endLookup := e.profile.span(task.ID, "lookup "+remote.name)
+	resp, err := remote.client.Negotiate(ctx, key, "download")
+	endLookup()
+	if err != nil || resp.Status != "found" {
+		return false
+	}
 
-			if err != nil {
-				logWarning(e.errOut, fmt.Sprintf("Upload failed: %v", err))
-			} else {
-				logInfo(e.out, "Upload complete.")
-			}
-		} else if resp != nil && resp.Status == "skipped" {
-			logInfo(e.out, "Artifact already exists remotely (skipped).")
+	tmp, _ := os.CreateTemp("", "velo-dl-*.zip")
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	var progress func(int)
+	if e.live != nil {
+		e.live.setState(task.ID, rowRunning, "downloading")
+		progress = e.live.trackTransfer(task.ID, "↓")
+	}
+	endDownload := e.profile.span(task.ID, "download")
+	err = e.transfers.Download(ctx, func() (int64, error) {
+		if err := remote.client.Download(ctx, key, resp, tmp, progress); err != nil {
+			return 0, err
 		}
-	} else {
+		if stat, err := tmp.Stat(); err == nil {
+			record.BytesDownloaded = stat.Size()
+		}
+		return record.BytesDownloaded, nil
+	})
+	endDownload()
+	if errors.Is(err, engine.ErrChecksumMismatch) {
+		logWarning(errOut, fmt.Sprintf("Discarded corrupt remote artifact: %v", err))
+	}
+	if err != nil {
+		return false
+	}
+	tmp.Close()
+	if err := remote.client.OpenArtifact(key, tmp.Name()); err != nil {
+		logWarning(errOut, fmt.Sprintf("Discarded remote artifact: %v", err))
+		return false
+	}
+
+	restore := func() error { return engine.Extract(tmp.Name(), task.TaskConfig.Outputs, packagePath) }
+	if e.policy.localWrite {
+		if localZip, err := engine.SaveLocal(key, tmp.Name()); err == nil {
+			restore = func() error { return engine.RestoreLocal(key, task.TaskConfig.Outputs, packagePath) }
+			saveMetadata(errOut, task, engine.ArtifactSourceRemote, fileSize(localZip), 0, nil)
+		}
+	}
+	endExtract := e.profile.span(task.ID, "extract")
+	err = restore()
+	endExtract()
+	if err != nil {
+		logWarning(errOut, fmt.Sprintf("Failed to restore remote artifact: %v", err))
+		return false
+	}
+	record.Cache = cacheSourceRemote
+	logCacheHit(out, remote.name, time.Since(start))
+	return true
+}
+
+// uploadInBackground uploads a task's artifact after the task has finished,
+// so its dependents need not wait for the network. Engine.Run waits for the
+// upload before returning. The artifact goes to every remote in uploads at
+// once and is removed afterwards when removeArchive is set.
+func (e *Engine) uploadInBackground(task *engine.TaskNode, key, archive string, removeArchive bool, uploads []pendingUpload, logOut io.Writer) {
+	e.uploads.Add(1)
+	go func() {
+		defer e.uploads.Done()
+		if removeArchive {
+			defer os.Remove(archive)
+		}
+		var wg sync.WaitGroup
+		for _, upload := range uploads {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// The run's context rather than the task's: the task no
+				// longer holds a scheduler slot to give up while the upload
+				// waits.
+				uploaded, err := e.uploadArtifact(e.ctx, task, key, archive, upload, logOut, logOut, task.ID+": "+upload.remote.label, false)
+				if err == nil && uploaded > 0 {
+					e.summary.recordUpload(task.ID, uploaded)
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+}
 
-		tmp, _ := os.CreateTemp("", "velo-local-*.zip")
-		defer os.Remove(tmp.Name())
-		engine.Compress(task.TaskConfig.Outputs, tmp.Name(), packagePath)
-		engine.SaveLocal(key, tmp.Name())
+// uploadArtifact seals and uploads a task's artifact to a remote unless it
+// already has it, returning the number of bytes sent. The answer negotiated
+// before the artifact was built is used unless the server wants the
+// artifact's checksum negotiated. Upload problems are logged, starting with
+// label, rather than returned; only cancellation is an error.
+func (e *Engine) uploadArtifact(ctx context.Context, task *engine.TaskNode, key, archive string, pending pendingUpload, out, errOut io.Writer, label string, live bool) (int64, error) {
+	remote := pending.remote.client
+	upload, err := remote.SealArtifact(key, archive)
+	if err != nil {
+		logWarning(errOut, fmt.Sprintf("%sUpload failed: %v", label, err))
+		return 0, nil
+	}
+	if upload != archive {
+		defer os.Remove(upload)
+	}
+	checksum, err := engine.ArtifactChecksum(upload)
+	if err != nil {
+		logWarning(errOut, fmt.Sprintf("%sUpload failed: %v", label, err))
+		return 0, nil
+	}
+	resp := pending.negotiated
+	if caps, capsErr := remote.Capabilities(ctx); resp == nil || capsErr != nil || caps.Checksums {
+		endNegotiate := e.profile.span(task.ID, "negotiate upload")
+		resp, err = remote.NegotiateUpload(ctx, key, checksum)
+		endNegotiate()
+	}
+	if err != nil || resp.Status != "upload_needed" {
+		if resp != nil && resp.Status == "skipped" {
+			logInfo(out, label+"Artifact already exists remotely (skipped).")
+		}
+		return 0, nil
 	}
 
-	task.State = 2
-	return key, nil
+	logInfo(out, label+"Uploading artifact...")
+
+	var wrap func(io.Reader) io.Reader
+	if live {
+		e.live.setState(task.ID, rowRunning, "uploading")
+		add := e.live.trackTransfer(task.ID, "↑")
+		wrap = func(r io.Reader) io.Reader { return progressReader{r: r, add: add} }
+	}
+	var uploaded int64
+	endUpload := e.profile.span(task.ID, "upload")
+	err = e.transfers.Upload(ctx, func() (int64, error) {
+		if err := remote.Upload(ctx, key, resp, upload, checksum, wrap); err != nil {
+			return 0, err
+		}
+		uploaded = fileSize(upload)
+		return uploaded, nil
+	})
+	endUpload()
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return 0, ctxErr
+	}
+	if err != nil {
+		logWarning(errOut, fmt.Sprintf("%sUpload failed: %v", label, err))
+		return 0, nil
+	}
+	logInfo(out, label+"Upload complete.")
+	return uploaded, nil
+}
+
+// uploadGroup tracks the uploads running in the background.
+type uploadGroup struct {
+	sync.WaitGroup
+	pending atomic.Int64
+}
+
+func (g *uploadGroup) Add(n int) {
+	g.pending.Add(int64(n))
+	g.WaitGroup.Add(n)
+}
+
+func (g *uploadGroup) Done() {
+	g.pending.Add(-1)
+	g.WaitGroup.Done()
+}
+
+// waitForUploads drains the uploads still running in the background.
+func (e *Engine) waitForUploads(logOut io.Writer) {
+	if pending := e.uploads.pending.Load(); pending > 0 {
+		logInfo(logOut, fmt.Sprintf("Waiting for %d background upload(s) to finish...", pending))
+	}
+	e.uploads.Wait()
+}
+
+// describeTransfers summarizes a run's artifact transfers and their
+// aggregate throughput.
+func describeTransfers(stats engine.TransferStats) string {
+	var parts []string
+	if stats.Downloads > 0 {
+		parts = append(parts, fmt.Sprintf("downloaded %d artifact(s) (%s)", stats.Downloads, formatBytes(stats.BytesDownloaded)))
+	}
+	if stats.Uploads > 0 {
+		parts = append(parts, fmt.Sprintf("uploaded %d artifact(s) (%s)", stats.Uploads, formatBytes(stats.BytesUploaded)))
+	}
+	return fmt.Sprintf("Remote cache: %s in %s, %s/s.", strings.Join(parts, ", "),
+		stats.Busy.Round(time.Millisecond), formatBytes(int64(stats.BytesPerSecond())))
+}
+
+func (e *Engine) writeProfile(path string) {
+	if e.profile == nil || path == "" {
+		return
+	}
+	if err := e.profile.write(path); err != nil {
+		logWarning(e.errOut, err.Error())
+		return
+	}
+	logInfo(e.errOut, fmt.Sprintf("Wrote trace profile to %s", path))
+}
+
+// taskLogLimit bounds the output kept with an artifact or failure record;
+// the end of the log is kept.
+const taskLogLimit = 256 << 10
+
+// saveFailure records a failed run so reruns with the same key replay it.
+// Only the final attempt of a task whose command exited with an error is
+// recorded; timeouts and cancellations are not.
+func (e *Engine) saveFailure(ctx context.Context, errOut io.Writer, task *engine.TaskNode, key string, exitCode int, err error, ttl time.Duration, log *tailBuffer) {
+	var timeoutErr *engine.TimeoutError
+	retriesLeft := task.Attempts > 0 && task.Attempts <= task.TaskConfig.Retries
+	if exitCode <= 0 || retriesLeft || errors.As(err, &timeoutErr) || ctx.Err() != nil || !e.policy.localWrite {
+		return
+	}
+	now := time.Now()
+	failure := engine.FailureRecord{ExitCode: exitCode, Log: log.String(), RecordedAt: now, ExpiresAt: now.Add(ttl)}
+	if err := engine.SaveFailure(key, failure); err != nil {
+		logWarning(errOut, fmt.Sprintf("Failed to record failure: %v", err))
+	}
+}
+
+// tailBuffer keeps the last limit bytes written to it.
+type tailBuffer struct {
+	mu    sync.Mutex
+	limit int
+	buf   []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.limit; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
+
+// saveMetadata stores the inputs behind a locally cached artifact, so
+// `velocity explain` can tell why a later run missed, along with how it was
+// produced and the log to replay on hits.
+func saveMetadata(errOut io.Writer, task *engine.TaskNode, source string, size int64, duration time.Duration, log *tailBuffer) {
+	info := engine.ArtifactInfo{
+		TaskID:     task.ID,
+		Command:    task.TaskConfig.Command,
+		DurationMs: duration.Milliseconds(),
+		Size:       size,
+		Source:     source,
+	}
+	var data []byte
+	if log != nil {
+		data = []byte(log.String())
+	}
+	if err := engine.SaveLocalMetadata(task.Manifest, info, data); err != nil {
+		logWarning(errOut, fmt.Sprintf("Failed to save artifact metadata: %v", err))
+	}
 }
 
-func selectTargetPackage(selector string, packages map[string]*engine.Package) (*engine.Package, error) {
+func fileSize(path string) int64 {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return stat.Size()
+}
+
+// replayLog prints the output recorded with a cached artifact.
+func replayLog(out, errOut io.Writer, key string) {
+	data, err := engine.LocalLog(key)
+	if err != nil {
+		logWarning(errOut, fmt.Sprintf("Failed to replay task log: %v", err))
+		return
+	}
+	out.Write(data)
+}
+
+func (e *Engine) outputMode(task *engine.TaskNode) string {
+	if e.outputLogs != "" {
+		return e.outputLogs
+	}
+	if config.ValidOutputLogs(task.TaskConfig.OutputLogs) {
+		return task.TaskConfig.OutputLogs
+	}
+	return config.OutputLogsFull
+}
+
+// runCommand runs the task's command, printing its output as mode asks.
+// capture, when set, receives all of the output regardless of mode.
+func (e *Engine) runCommand(ctx context.Context, task *engine.TaskNode, packagePath, mode string, out, errOut, capture io.Writer) (int, error) {
+	tee := func(w io.Writer) io.Writer {
+		if capture == nil {
+			return w
+		}
+		return io.MultiWriter(w, capture)
+	}
+	switch mode {
+	case config.OutputLogsFull:
+		return engine.ExecuteWithWriters(ctx, task.TaskConfig, packagePath, tee(out), tee(errOut))
+	case config.OutputLogsErrorsOnly:
+		var buf bytes.Buffer
+		code, err := engine.ExecuteWithWriters(ctx, task.TaskConfig, packagePath, tee(&buf), tee(&buf))
+		if err != nil {
+			errOut.Write(buf.Bytes())
+		}
+		return code, err
+	default:
+		return engine.ExecuteWithWriters(ctx, task.TaskConfig, packagePath, tee(io.Discard), tee(io.Discard))
+	}
+}
+
+// computeCacheKey derives the task's cache key from its own inputs and the
+// keys of its already-resolved dependencies, storing it on the node.
+func computeCacheKey(ctx context.Context, task *engine.TaskNode) (string, error) {
+	manifest, err := engine.GenerateTaskNodeManifest(ctx, task)
+	if err != nil {
+		return "", err
+	}
+	task.CacheKey = manifest.Key
+	task.Manifest = manifest
+	return manifest.Key, nil
+}
+
+func selectTargetPackage(packages map[string]*engine.Package) (*engine.Package, error) {
 	if len(packages) == 0 {
 		root := &engine.Package{
 			Name:            "__workspace__",
 			Path:            ".",
 			PackageJsonPath: "",
+			Scripts:         engine.ReadScripts("package.json"),
+			PackageManager:  engine.DetectPackageManager("."),
 		}
 		packages[root.Name] = root
 		return root, nil
 	}
 
-	trimmed := strings.TrimSpace(selector)
-	if trimmed != "" {
-		if pkg, ok := packages[trimmed]; ok {
-			return pkg, nil
-		}
-		for _, pkg := range packages {
-			if pkg.Path == trimmed {
-				return pkg, nil
-			}
-		}
-		return nil, fmt.Errorf("package %q not found. available: %s", trimmed, strings.Join(availablePackageDescriptions(packages), ", "))
-	}
-
 	if len(packages) == 1 {
 		for _, pkg := range packages {
 			return pkg, nil
@@ -295,10 +1175,91 @@ func selectTargetPackage(selector string, packages map[string]*engine.Package) (
 
 	if len(roots) > 1 {
 		descriptions := packageSliceDescriptions(roots)
-		return nil, fmt.Errorf("multiple candidate packages found (%s). specify --package to choose one", strings.Join(descriptions, ", "))
+		return nil, fmt.Errorf("multiple candidate packages found (%s). specify --filter to choose one", strings.Join(descriptions, ", "))
+	}
+
+	return nil, fmt.Errorf("unable to determine target package. specify --filter. available: %s", strings.Join(availablePackageDescriptions(packages), ", "))
+}
+
+// selectFilteredPackages applies --filter and --tag and, as with --all,
+// narrows the matches to packages that define the task.
+func selectFilteredPackages(taskName string, sel taskSelection, packages map[string]*engine.Package) ([]*engine.Package, error) {
+	matched, err := engine.FilterPackages(packages, sel.filters)
+	if err != nil {
+		return nil, err
+	}
+	if len(sel.tags) > 0 {
+		for name, pkg := range matched {
+			if !pkg.HasAnyTag(sel.tags) {
+				delete(matched, name)
+			}
+		}
+	}
+	if len(matched) == 0 {
+		var criteria []string
+		if len(sel.filters) > 0 {
+			criteria = append(criteria, fmt.Sprintf("--filter %q", sel.filters))
+		}
+		if len(sel.tags) > 0 {
+			criteria = append(criteria, fmt.Sprintf("--tag %q", sel.tags))
+		}
+		return nil, fmt.Errorf("no packages match %s. available: %s", strings.Join(criteria, " "), strings.Join(availablePackageDescriptions(packages), ", "))
+	}
+	return selectTaskPackages(taskName, matched)
+}
+
+// selectTaskPackages returns every package whose package.json declares a
+// script named after the task. When no package declares one, the pipeline
+// command is assumed to apply to all packages.
+func selectTaskPackages(taskName string, packages map[string]*engine.Package) ([]*engine.Package, error) {
+	if len(packages) == 0 {
+		root, err := selectTargetPackage(packages)
+		if err != nil {
+			return nil, err
+		}
+		return []*engine.Package{root}, nil
+	}
+
+	selected := make([]*engine.Package, 0, len(packages))
+	for _, pkg := range packages {
+		if _, ok := pkg.Scripts[taskName]; ok {
+			selected = append(selected, pkg)
+		}
+	}
+	if len(selected) == 0 {
+		for _, pkg := range packages {
+			selected = append(selected, pkg)
+		}
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].Name < selected[j].Name
+	})
+	return selected, nil
+}
+
+func filterAffected(ctx context.Context, ref string, targets []*engine.Package, packages map[string]*engine.Package) ([]*engine.Package, error) {
+	changed, err := engine.ChangedFiles(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("detect changed files: %w", err)
+	}
+
+	lookup := packages
+	if len(lookup) == 0 {
+		lookup = make(map[string]*engine.Package, len(targets))
+		for _, pkg := range targets {
+			lookup[pkg.Name] = pkg
+		}
 	}
+	affected := engine.AffectedPackages(lookup, changed)
 
-	return nil, fmt.Errorf("unable to determine target package. specify --package. available: %s", strings.Join(availablePackageDescriptions(packages), ", "))
+	filtered := make([]*engine.Package, 0, len(targets))
+	for _, pkg := range targets {
+		if _, ok := affected[pkg.Name]; ok {
+			filtered = append(filtered, pkg)
+		}
+	}
+	return filtered, nil
 }
 
 func rootPackages(packages map[string]*engine.Package) []*engine.Package {
@@ -339,19 +1300,63 @@ func packageSliceDescriptions(pkgs []*engine.Package) []string {
 
 func prefix() string { return prefixStyle.Sprint("[VelocityCache]") }
 
-func logTaskHeader(out io.Writer, nodeID string) {
-	fmt.Fprintf(out, "%s %s\n", prefix(), infoStyle.Sprintf("Task %s", nodeID))
+func logTaskHeader(out io.Writer, nodeID, cacheKey string) {
+	if currentLogLevel < logLevelInfo {
+		return
+	}
+	fmt.Fprintf(out, "%s %s %s\n", prefix(), infoStyle.Sprintf("Task %s", nodeID), subtleStyle.Sprintf("(hash %s)", shortHash(cacheKey)))
 }
 
 func logCacheHit(out io.Writer, scope string, elapsed time.Duration) {
+	if currentLogLevel < logLevelInfo {
+		return
+	}
 	fmt.Fprintf(out, "%s %s in %s\n", prefix(), hitStyle.Sprintf("CACHE HIT (%s)", scope), elapsed.Round(time.Millisecond))
 }
 
 func logCacheMissExecuting(out io.Writer, command string) {
+	if currentLogLevel < logLevelInfo {
+		return
+	}
 	fmt.Fprintf(out, "%s %s %s\n", prefix(), missStyle.Sprint("CACHE MISS."), infoStyle.Sprintf("Executing %q...", command))
 }
 
+// envEnabled reports whether a boolean environment variable is switched on.
+func envEnabled(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+func logCacheBypassExecuting(out io.Writer, command string) {
+	if currentLogLevel < logLevelInfo {
+		return
+	}
+	fmt.Fprintf(out, "%s %s %s\n", prefix(), subtleStyle.Sprint("CACHE DISABLED."), infoStyle.Sprintf("Executing %q...", command))
+}
+
+func logFailures(errOut io.Writer, failed, skipped []*engine.TaskNode) {
+	if len(failed) == 0 {
+		return
+	}
+	fmt.Fprintf(errOut, "%s %s\n", prefix(), errorStyle.Sprintf("%d task(s) failed:", len(failed)))
+	for _, node := range failed {
+		fmt.Fprintf(errOut, "  %s\n", errorStyle.Sprint(node.LastError))
+	}
+	if len(skipped) > 0 {
+		fmt.Fprintf(errOut, "%s %s\n", prefix(), warnStyle.Sprintf("%d dependent task(s) skipped:", len(skipped)))
+		for _, node := range skipped {
+			fmt.Fprintf(errOut, "  %s\n", node.ID)
+		}
+	}
+}
+
 func logInfo(out io.Writer, message string) {
+	if currentLogLevel < logLevelInfo {
+		return
+	}
 	fmt.Fprintf(out, "%s %s\n", prefix(), infoStyle.Sprint(message))
 }
 
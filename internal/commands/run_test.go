@@ -0,0 +1,522 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+func TestSelectTaskPackagesUsesScripts(t *testing.T) {
+	packages := map[string]*engine.Package{
+		"@repo/app":  {Name: "@repo/app", Path: "apps/app", Scripts: map[string]string{"build": "vite build"}},
+		"@repo/lib":  {Name: "@repo/lib", Path: "packages/lib", Scripts: map[string]string{"build": "tsc"}},
+		"@repo/docs": {Name: "@repo/docs", Path: "apps/docs", Scripts: map[string]string{"dev": "next dev"}},
+	}
+
+	selected, err := selectTaskPackages("build", packages)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"@repo/app", "@repo/lib"}, packageSliceDescriptions(selected))
+}
+
+func TestSelectTaskPackagesFallsBackToAllPackages(t *testing.T) {
+	packages := map[string]*engine.Package{
+		"b": {Name: "b", Path: "packages/b"},
+		"a": {Name: "a", Path: "packages/a"},
+	}
+
+	selected, err := selectTaskPackages("build", packages)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, packageSliceDescriptions(selected))
+}
+
+func TestSelectTaskPackagesWithoutPackagesUsesWorkspace(t *testing.T) {
+	selected, err := selectTaskPackages("build", map[string]*engine.Package{})
+	require.NoError(t, err)
+	require.Len(t, selected, 1)
+	assert.Equal(t, ".", selected[0].Path)
+}
+
+func TestRunCommandErrorsOnlyReplaysOutputOnFailure(t *testing.T) {
+	e := &Engine{}
+	pkg := &engine.Package{Name: "__workspace__", Path: t.TempDir()}
+
+	var out, errOut bytes.Buffer
+	ok := &engine.TaskNode{ID: "ok", Package: pkg, TaskConfig: config.TaskConfig{Command: "echo quiet"}}
+	_, err := e.runCommand(t.Context(), ok, pkg.Path, config.OutputLogsErrorsOnly, &out, &errOut, nil)
+	require.NoError(t, err)
+	assert.Empty(t, out.String())
+	assert.Empty(t, errOut.String())
+
+	failing := &engine.TaskNode{ID: "fail", Package: pkg, TaskConfig: config.TaskConfig{Command: "echo loud; exit 2"}}
+	code, err := e.runCommand(t.Context(), failing, pkg.Path, config.OutputLogsErrorsOnly, &out, &errOut, nil)
+	require.Error(t, err)
+	assert.Equal(t, 2, code)
+	assert.Contains(t, errOut.String(), "loud")
+}
+
+func TestOutputModePrefersFlagOverTaskConfig(t *testing.T) {
+	task := &engine.TaskNode{TaskConfig: config.TaskConfig{OutputLogs: config.OutputLogsHashOnly}}
+
+	assert.Equal(t, config.OutputLogsHashOnly, (&Engine{}).outputMode(task))
+	assert.Equal(t, config.OutputLogsNone, (&Engine{outputLogs: config.OutputLogsNone}).outputMode(task))
+	assert.Equal(t, config.OutputLogsFull, (&Engine{}).outputMode(&engine.TaskNode{}))
+}
+
+func TestWatchIgnoresOutputsAndToolingDirs(t *testing.T) {
+	nodes := []*engine.TaskNode{{
+		Package:    &engine.Package{Path: "packages/app"},
+		TaskConfig: config.TaskConfig{Outputs: []string{"dist", "coverage/**"}},
+	}}
+	ignored := outputPaths(nodes)
+
+	assert.True(t, isIgnoredPath("packages/app/dist/index.js", ignored))
+	assert.True(t, isIgnoredPath("packages/app/coverage/lcov.info", ignored))
+	assert.True(t, isIgnoredPath("packages/app/node_modules/react/index.js", ignored))
+	assert.True(t, isIgnoredPath(".velocity/cache/abc.zip", ignored))
+	assert.False(t, isIgnoredPath("packages/app/src/index.ts", ignored))
+	assert.False(t, isIgnoredPath("packages/app/distribution.md", ignored))
+}
+
+func TestExecuteTaskSkipsCacheWhenDisabled(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	disabled := false
+	pkg := &engine.Package{Name: "__workspace__", Path: "."}
+	task := &engine.TaskNode{
+		ID:         "deploy",
+		Package:    pkg,
+		TaskName:   "deploy",
+		TaskConfig: config.TaskConfig{Command: "echo run >> runs.log", Outputs: []string{"dist"}, Cache: &disabled},
+	}
+
+	summary := newRunSummary("deploy")
+	var out bytes.Buffer
+	e := &Engine{ctx: t.Context(), cfg: &config.Config{}, out: &out, errOut: &out, summary: summary, policy: defaultCachePolicy()}
+	require.NoError(t, e.executeTask(t.Context(), task))
+	require.NoError(t, e.executeTask(t.Context(), task))
+
+	data, err := os.ReadFile(filepath.Join(dir, "runs.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "run\nrun\n", string(data))
+	assert.NotEmpty(t, task.CacheKey, "dependents still need the key")
+
+	_, found, err := engine.CheckLocal(task.CacheKey)
+	require.NoError(t, err)
+	assert.False(t, found)
+	for _, record := range summary.Tasks {
+		assert.Equal(t, cacheSourceBypass, record.Cache)
+	}
+}
+
+func TestExecuteTaskRecordsWatchSkipsAsUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	pkg := &engine.Package{Name: "__workspace__", Path: "."}
+	newTask := func() *engine.TaskNode {
+		return &engine.TaskNode{
+			ID:         "build",
+			Package:    pkg,
+			TaskName:   "build",
+			TaskConfig: config.TaskConfig{Command: "echo run >> runs.log", Inputs: []string{"src.txt"}},
+		}
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src.txt"), []byte("v1"), 0o644))
+
+	// Each watch iteration runs a fresh engine sharing the last keys.
+	keys := newWatchKeys()
+	iterate := func() *RunSummary {
+		summary := newRunSummary("build")
+		var out bytes.Buffer
+		e := &Engine{ctx: t.Context(), cfg: &config.Config{}, out: &out, errOut: &out, summary: summary, policy: defaultCachePolicy(), lastKeys: keys}
+		require.NoError(t, e.executeTask(t.Context(), newTask()))
+		summary.finish(nil)
+		return summary
+	}
+	first, second := iterate(), iterate()
+
+	data, err := os.ReadFile(filepath.Join(dir, "runs.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "run\n", string(data))
+	assert.Equal(t, cacheSourceMiss, first.Tasks[0].Cache)
+	assert.Equal(t, 1, first.Totals.Executed)
+	assert.Equal(t, cacheSourceUnchanged, second.Tasks[0].Cache)
+	assert.Equal(t, taskStatusSuccess, second.Tasks[0].Status)
+	assert.Equal(t, RunTotals{Tasks: 1, Unchanged: 1}, second.Totals)
+}
+
+func TestExecuteTaskForceSkipsCacheReads(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	pkg := &engine.Package{Name: "__workspace__", Path: "."}
+	newTask := func() *engine.TaskNode {
+		return &engine.TaskNode{
+			ID:         "build",
+			Package:    pkg,
+			TaskName:   "build",
+			TaskConfig: config.TaskConfig{Command: "mkdir -p dist && echo run >> runs.log", Inputs: []string{}, Outputs: []string{"dist"}},
+		}
+	}
+
+	var out bytes.Buffer
+	e := &Engine{ctx: t.Context(), cfg: &config.Config{}, out: &out, errOut: &out, policy: defaultCachePolicy()}
+	require.NoError(t, e.executeTask(t.Context(), newTask()))
+	require.NoError(t, e.executeTask(t.Context(), newTask()))
+
+	e.force = true
+	task := newTask()
+	require.NoError(t, e.executeTask(t.Context(), task))
+
+	data, err := os.ReadFile(filepath.Join(dir, "runs.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "run\nrun\n", string(data), "the second run is a hit, the forced one executes")
+
+	_, found, err := engine.CheckLocal(task.CacheKey)
+	require.NoError(t, err)
+	assert.True(t, found, "forced runs still write artifacts")
+}
+
+func TestExecuteTaskStrictOutputsFailsWhenNothingIsProduced(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	newTask := func() *engine.TaskNode {
+		return &engine.TaskNode{
+			ID:         "build",
+			Package:    &engine.Package{Name: "__workspace__", Path: "."},
+			TaskName:   "build",
+			TaskConfig: config.TaskConfig{Command: "true", Inputs: []string{}, Outputs: []string{"dist"}},
+		}
+	}
+
+	var out bytes.Buffer
+	e := &Engine{ctx: t.Context(), cfg: &config.Config{}, out: &out, errOut: &out, policy: defaultCachePolicy()}
+	require.NoError(t, e.executeTask(t.Context(), newTask()))
+	assert.Contains(t, out.String(), "produced nothing matching outputs dist")
+
+	e.cfg.StrictOutputs = true
+	e.force = true
+	err := e.executeTask(t.Context(), newTask())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "strict_outputs")
+}
+
+func TestExecuteTaskReplaysCachedFailures(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	newTask := func() *engine.TaskNode {
+		return &engine.TaskNode{
+			ID:       "test",
+			Package:  &engine.Package{Name: "__workspace__", Path: "."},
+			TaskName: "test",
+			TaskConfig: config.TaskConfig{
+				Command:       "echo run >> runs.log; echo broken; exit 3",
+				Inputs:        []string{},
+				CacheFailures: "1h",
+			},
+		}
+	}
+
+	var out bytes.Buffer
+	e := &Engine{ctx: t.Context(), cfg: &config.Config{}, out: &out, errOut: &out, policy: defaultCachePolicy()}
+	err := e.executeTask(t.Context(), newTask())
+	var exitErr ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 3, exitErr.ExitCode())
+
+	out.Reset()
+	err = e.executeTask(t.Context(), newTask())
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 3, exitErr.ExitCode())
+	assert.ErrorIs(t, err, engine.ErrCachedFailure)
+	assert.Contains(t, out.String(), "broken", "the recorded log is replayed")
+
+	e.force = true
+	err = e.executeTask(t.Context(), newTask())
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, engine.ErrCachedFailure)
+
+	data, err := os.ReadFile(filepath.Join(dir, "runs.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "run\nrun\n", string(data))
+}
+
+func TestExecuteTaskReplaysLogOnLocalHit(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	newTask := func() *engine.TaskNode {
+		return &engine.TaskNode{
+			ID:         "build",
+			Package:    &engine.Package{Name: "__workspace__", Path: "."},
+			TaskName:   "build",
+			TaskConfig: config.TaskConfig{Command: "mkdir -p dist && echo compiled", Inputs: []string{}, Outputs: []string{"dist"}, OutputLogs: config.OutputLogsNone},
+		}
+	}
+
+	var out bytes.Buffer
+	e := &Engine{ctx: t.Context(), cfg: &config.Config{}, out: &out, errOut: &out, policy: defaultCachePolicy()}
+	task := newTask()
+	require.NoError(t, e.executeTask(t.Context(), task))
+	assert.NotContains(t, out.String(), "compiled")
+
+	info, err := engine.LoadArtifactInfo(task.CacheKey)
+	require.NoError(t, err)
+	require.NotNil(t, info)
+	assert.Equal(t, "build", info.TaskID)
+	assert.Equal(t, engine.ArtifactSourceLocal, info.Source)
+
+	e.outputLogs = config.OutputLogsFull
+	out.Reset()
+	require.NoError(t, e.executeTask(t.Context(), newTask()))
+	assert.Contains(t, out.String(), "compiled", "the log is recorded even when it was not shown")
+}
+
+func TestEnvEnabled(t *testing.T) {
+	for _, value := range []string{"1", "true", "TRUE", " yes "} {
+		assert.True(t, envEnabled(value), value)
+	}
+	for _, value := range []string{"", "0", "false", "no"} {
+		assert.False(t, envEnabled(value), value)
+	}
+}
+
+func TestExecuteTaskReturnsChildExitCode(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	task := &engine.TaskNode{
+		ID:         "test",
+		Package:    &engine.Package{Name: "__workspace__", Path: "."},
+		TaskName:   "test",
+		TaskConfig: config.TaskConfig{Command: "exit 42"},
+	}
+	e := &Engine{ctx: t.Context(), cfg: &config.Config{}, out: io.Discard, errOut: io.Discard, policy: defaultCachePolicy()}
+
+	err := e.executeTask(t.Context(), task)
+	var exitErr ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 42, exitErr.ExitCode())
+	assert.Contains(t, err.Error(), "task test failed")
+}
+
+func TestInferTaskFromScripts(t *testing.T) {
+	cfg := &config.Config{}
+	targets := []*engine.Package{{Name: "app", Scripts: map[string]string{"storybook": "storybook dev"}}}
+
+	command, err := inferTask(cfg, "storybook", targets)
+	require.NoError(t, err)
+	assert.Equal(t, "{pm} run storybook", command)
+	assert.False(t, cfg.Pipeline["storybook"].CacheEnabled())
+
+	_, err = inferTask(cfg, "missing", targets)
+	assert.ErrorContains(t, err, `task "missing" is not defined`)
+}
+
+func TestParseEnvOverrides(t *testing.T) {
+	env, err := parseEnvOverrides([]string{"NODE_ENV=production", "EMPTY=", "URL=a=b"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"NODE_ENV": "production", "EMPTY": "", "URL": "a=b"}, env)
+
+	_, err = parseEnvOverrides([]string{"NODE_ENV"})
+	assert.ErrorContains(t, err, `invalid --env "NODE_ENV" (expected KEY=VALUE)`)
+}
+
+func TestApplyEnvOverridesReachesDependencies(t *testing.T) {
+	lib := &engine.TaskNode{ID: "lib#build", TaskConfig: config.TaskConfig{EnvKeys: []string{"CI"}}}
+	app := &engine.TaskNode{ID: "app#build", Dependencies: []*engine.TaskNode{lib}}
+
+	applyEnvOverrides([]*engine.TaskNode{app, lib}, map[string]string{"NODE_ENV": "test"})
+
+	assert.Equal(t, []string{"NODE_ENV"}, app.TaskConfig.EnvKeys)
+	assert.Equal(t, []string{"CI", "NODE_ENV"}, lib.TaskConfig.EnvKeys)
+	assert.Equal(t, "test", lib.TaskConfig.Getenv("NODE_ENV"))
+}
+
+func TestExecuteTaskUploadsInBackground(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	release := make(chan struct{})
+	uploaded := make(chan int, 1)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/blob" {
+			<-release
+			body, _ := io.ReadAll(r.Body)
+			uploaded <- len(body)
+			return
+		}
+		if r.URL.Path == "/v1/capabilities" {
+			http.NotFound(w, r)
+			return
+		}
+		var req struct {
+			Action string `json:"action"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		w.Header().Set("Content-Type", "application/json")
+		if req.Action == "download" {
+			w.Write([]byte(`{"status":"missing"}`))
+			return
+		}
+		fmt.Fprintf(w, `{"status":"upload_needed","url":%q}`, server.URL+"/blob")
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	summary := newRunSummary("build")
+	e := &Engine{
+		ctx: t.Context(), cfg: &config.Config{Remote: config.RemoteConfig{URL: server.URL}}, out: &out, errOut: &out,
+		remotes: []*remoteCache{{name: "remote", client: engine.NewRemoteClient(server.URL, ""), write: true}}, policy: defaultCachePolicy(), summary: summary, asyncUpload: true,
+	}
+	task := &engine.TaskNode{
+		ID:         "build",
+		Package:    &engine.Package{Name: "__workspace__", Path: "."},
+		TaskName:   "build",
+		TaskConfig: config.TaskConfig{Command: "mkdir -p dist && echo built > dist/out.txt", Inputs: []string{}, Outputs: []string{"dist"}},
+	}
+	require.NoError(t, e.executeTask(t.Context(), task), "the task should finish while its upload is still blocked")
+	assert.Equal(t, int64(1), e.uploads.pending.Load())
+
+	close(release)
+	e.waitForUploads(io.Discard)
+	assert.Positive(t, <-uploaded)
+	assert.Contains(t, out.String(), "build: Upload complete.")
+
+	summary.finish(nil)
+	assert.Positive(t, summary.Totals.BytesUploaded)
+}
+
+func TestExecuteTaskNegotiatesBeforeCompressing(t *testing.T) {
+	for _, status := range []string{"skipped", "upload_needed"} {
+		t.Run(status, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+
+			var negotiations []string
+			var uploaded int
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/v1/capabilities":
+					json.NewEncoder(w).Encode(engine.Capabilities{Flows: []string{engine.FlowNegotiate}})
+				case "/blob":
+					body, _ := io.ReadAll(r.Body)
+					uploaded = len(body)
+				default:
+					var req struct {
+						Action string `json:"action"`
+					}
+					require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+					negotiations = append(negotiations, req.Action)
+					if req.Action == "download" {
+						http.NotFound(w, r)
+						return
+					}
+					fmt.Fprintf(w, `{"status":%q,"url":%q}`, status, server.URL+"/blob")
+				}
+			}))
+			defer server.Close()
+
+			var out bytes.Buffer
+			profile := newProfiler()
+			e := &Engine{
+				ctx: t.Context(), cfg: &config.Config{Remote: config.RemoteConfig{URL: server.URL}}, out: &out, errOut: &out,
+				remotes: []*remoteCache{{name: "remote", client: engine.NewRemoteClient(server.URL, ""), write: true}}, policy: cachePolicy{remoteRead: true, remoteWrite: true},
+				summary: newRunSummary("build"), profile: profile,
+			}
+			task := &engine.TaskNode{
+				ID:         "build",
+				Package:    &engine.Package{Name: "__workspace__", Path: "."},
+				TaskName:   "build",
+				TaskConfig: config.TaskConfig{Command: "mkdir -p dist && echo built > dist/out.txt", Inputs: []string{}, Outputs: []string{"dist"}},
+			}
+			require.NoError(t, e.executeTask(t.Context(), task))
+
+			var spans []string
+			for _, event := range profile.events {
+				spans = append(spans, event.Name)
+			}
+			assert.Equal(t, []string{"download", "upload"}, negotiations, "the upload is negotiated once, before compressing")
+			if status == "skipped" {
+				assert.NotContains(t, spans, "compress")
+				assert.Zero(t, uploaded)
+				assert.Contains(t, out.String(), "Artifact already exists remotely (skipped).")
+			} else {
+				assert.Contains(t, spans, "compress")
+				assert.Positive(t, uploaded)
+			}
+		})
+	}
+}
+
+func TestExecuteTaskFallsBackAcrossRemotes(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	var lanActions []string
+	lan := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/negotiate" {
+			var req struct {
+				Action string `json:"action"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			lanActions = append(lanActions, req.Action)
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer lan.Close()
+
+	var uploaded int
+	var saas *httptest.Server
+	saas = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/capabilities":
+			http.NotFound(w, r)
+		case "/blob":
+			body, _ := io.ReadAll(r.Body)
+			uploaded = len(body)
+		default:
+			var req struct {
+				Action string `json:"action"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			if req.Action == "download" {
+				fmt.Fprint(w, `{"status":"missing"}`)
+				return
+			}
+			fmt.Fprintf(w, `{"status":"upload_needed","url":%q}`, saas.URL+"/blob")
+		}
+	}))
+	defer saas.Close()
+
+	var out bytes.Buffer
+	e := &Engine{
+		ctx: t.Context(), cfg: &config.Config{}, out: &out, errOut: &out,
+		remotes: []*remoteCache{
+			{name: "lan", client: engine.NewRemoteClient(lan.URL, ""), label: "lan: "},
+			{name: "saas", client: engine.NewRemoteClient(saas.URL, ""), write: true, label: "saas: "},
+		},
+		policy: cachePolicy{remoteRead: true, remoteWrite: true}, summary: newRunSummary("build"),
+	}
+	task := &engine.TaskNode{
+		ID:         "build",
+		Package:    &engine.Package{Name: "__workspace__", Path: "."},
+		TaskName:   "build",
+		TaskConfig: config.TaskConfig{Command: "mkdir -p dist && echo built > dist/out.txt", Inputs: []string{}, Outputs: []string{"dist"}},
+	}
+	require.NoError(t, e.executeTask(t.Context(), task), "a failing remote does not fail the run")
+
+	assert.Equal(t, []string{"download"}, lanActions, "the read-only remote is only read from")
+	assert.Positive(t, uploaded)
+}
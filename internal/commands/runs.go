@@ -0,0 +1,228 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	runsPath      = ".velocity/runs"
+	maxRunHistory = 100
+)
+
+func newRunsCommand() *cobra.Command {
+	var limit int
+	cmd := &cobra.Command{
+		Use:   "runs",
+		Short: "Show recent run history",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listRuns(cmd.OutOrStdout(), limit)
+		},
+	}
+	cmd.Flags().IntVarP(&limit, "limit", "n", 10, "Number of runs to show")
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List recent runs with hit rates and time saved",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listRuns(cmd.OutOrStdout(), limit)
+		},
+	}
+	list.Flags().IntVarP(&limit, "limit", "n", 10, "Number of runs to show")
+
+	show := &cobra.Command{
+		Use:   "show <run-id>",
+		Short: "Show the tasks of a single run",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return showRun(cmd.OutOrStdout(), args[0])
+		},
+	}
+
+	cmd.AddCommand(list, show)
+	return cmd
+}
+
+// saveRunHistory persists the summary under .velocity/runs and trims the
+// history to the most recent maxRunHistory entries.
+func saveRunHistory(summary *RunSummary) error {
+	if err := os.MkdirAll(runsPath, 0o755); err != nil {
+		return fmt.Errorf("save run history: %w", err)
+	}
+	if err := writeSummaryFile(filepath.Join(runsPath, summary.ID+".json"), summary, nil); err != nil {
+		return err
+	}
+
+	ids, err := runIDs()
+	if err != nil {
+		return err
+	}
+	for len(ids) > maxRunHistory {
+		if err := os.Remove(filepath.Join(runsPath, ids[0]+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("trim run history: %w", err)
+		}
+		ids = ids[1:]
+	}
+	return nil
+}
+
+func runIDs() ([]string, error) {
+	entries, err := os.ReadDir(runsPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read run history: %w", err)
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func loadRun(id string) (*RunSummary, error) {
+	data, err := os.ReadFile(filepath.Join(runsPath, id+".json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("run %q not found", id)
+		}
+		return nil, fmt.Errorf("read run %s: %w", id, err)
+	}
+	var summary RunSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return nil, fmt.Errorf("parse run %s: %w", id, err)
+	}
+	return &summary, nil
+}
+
+// loadRunHistory returns all recorded runs, oldest first.
+func loadRunHistory() ([]*RunSummary, error) {
+	ids, err := runIDs()
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]*RunSummary, 0, len(ids))
+	for _, id := range ids {
+		run, err := loadRun(id)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// timeSaved estimates how long each run's cache hits saved, using the
+// duration of the most recent earlier execution of the same hash.
+func timeSaved(runs []*RunSummary) map[string]time.Duration {
+	executed := make(map[string]int64)
+	saved := make(map[string]time.Duration, len(runs))
+
+	for _, run := range runs {
+		var total time.Duration
+		for _, task := range run.Tasks {
+			if task.Status != taskStatusSuccess {
+				continue
+			}
+			if task.Cache == cacheSourceMiss || task.Cache == cacheSourceUnchanged {
+				continue
+			}
+			if original, ok := executed[task.Hash]; ok && original > task.DurationMs {
+				total += time.Duration(original-task.DurationMs) * time.Millisecond
+			}
+		}
+		saved[run.ID] = total
+
+		for _, task := range run.Tasks {
+			if task.Status == taskStatusSuccess && task.Cache == cacheSourceMiss {
+				executed[task.Hash] = task.DurationMs
+			}
+		}
+	}
+	return saved
+}
+
+func hitRate(run *RunSummary) float64 {
+	if run.Totals.Tasks == 0 {
+		return 0
+	}
+	return float64(run.Totals.LocalHits+run.Totals.RemoteHits) / float64(run.Totals.Tasks) * 100
+}
+
+func listRuns(out io.Writer, limit int) error {
+	runs, err := loadRunHistory()
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		logInfo(out, "No runs recorded yet.")
+		return nil
+	}
+
+	saved := timeSaved(runs)
+	if limit > 0 && len(runs) > limit {
+		runs = runs[len(runs)-limit:]
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTASK\tTASKS\tHIT RATE\tDURATION\tSAVED\tSTATUS")
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+		status := "ok"
+		if !run.Success {
+			status = fmt.Sprintf("failed (%d)", run.ExitCode)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.0f%%\t%s\t%s\t%s\n",
+			run.ID,
+			run.Task,
+			run.Totals.Tasks,
+			hitRate(run),
+			formatMillis(run.DurationMs),
+			saved[run.ID].Round(time.Millisecond),
+			status,
+		)
+	}
+	return w.Flush()
+}
+
+func showRun(out io.Writer, id string) error {
+	run, err := loadRun(id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Run %s (%s)\n", run.ID, run.Task)
+	fmt.Fprintf(out, "Started:  %s\n", run.StartedAt.Local().Format(time.RFC3339))
+	fmt.Fprintf(out, "Duration: %s\n", formatMillis(run.DurationMs))
+	fmt.Fprintf(out, "Hit rate: %.0f%% (%d local, %d remote, %d executed, %d failed, %d skipped)\n\n",
+		hitRate(run), run.Totals.LocalHits, run.Totals.RemoteHits, run.Totals.Executed, run.Totals.Failed, run.Totals.Skipped)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TASK\tHASH\tCACHE\tSTATUS\tDURATION")
+	for _, task := range run.Tasks {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", task.ID, shortHash(task.Hash), task.Cache, task.Status, formatMillis(task.DurationMs))
+	}
+	return w.Flush()
+}
+
+func formatMillis(ms int64) string {
+	return (time.Duration(ms) * time.Millisecond).String()
+}
@@ -0,0 +1,23 @@
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeSavedUsesEarlierExecutions(t *testing.T) {
+	first := &RunSummary{ID: "1", Tasks: []TaskSummary{
+		{Hash: "aaa", Cache: cacheSourceMiss, Status: taskStatusSuccess, DurationMs: 900},
+		{Hash: "bbb", Cache: cacheSourceMiss, Status: taskStatusFailed, DurationMs: 500},
+	}}
+	second := &RunSummary{ID: "2", Tasks: []TaskSummary{
+		{Hash: "aaa", Cache: cacheSourceLocal, Status: taskStatusSuccess, DurationMs: 100},
+		{Hash: "bbb", Cache: cacheSourceRemote, Status: taskStatusSuccess, DurationMs: 50},
+	}}
+
+	saved := timeSaved([]*RunSummary{first, second})
+	assert.Equal(t, time.Duration(0), saved["1"])
+	assert.Equal(t, 800*time.Millisecond, saved["2"], "failed executions should not count as a baseline")
+}
@@ -0,0 +1,231 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+const (
+	cacheSourceLocal  = "local"
+	cacheSourceRemote = "remote"
+	cacheSourceMiss   = "miss"
+	cacheSourceBypass = "bypass"
+	// cacheSourceFailure marks a failure replayed from the failure cache.
+	cacheSourceFailure = "failure"
+	// cacheSourceUnchanged marks a task watch mode did not re-run because its
+	// inputs are unchanged since its last successful run.
+	cacheSourceUnchanged = "unchanged"
+
+	taskStatusSuccess = "success"
+	taskStatusFailed  = "failed"
+	taskStatusSkipped = "skipped"
+	taskStatusTimeout = "timeout"
+)
+
+type RunSummary struct {
+	ID         string        `json:"id"`
+	Task       string        `json:"task"`
+	StartedAt  time.Time     `json:"started_at"`
+	EndedAt    time.Time     `json:"ended_at"`
+	DurationMs int64         `json:"duration_ms"`
+	Success    bool          `json:"success"`
+	ExitCode   int           `json:"exit_code"`
+	Totals     RunTotals     `json:"totals"`
+	Tasks      []TaskSummary `json:"tasks"`
+
+	mu         sync.Mutex
+	transferMs int64
+	uploaded   map[string]int64
+}
+
+type RunTotals struct {
+	Tasks           int   `json:"tasks"`
+	LocalHits       int   `json:"local_hits"`
+	RemoteHits      int   `json:"remote_hits"`
+	Executed        int   `json:"executed"`
+	Failed          int   `json:"failed"`
+	Skipped         int   `json:"skipped"`
+	Unchanged       int   `json:"unchanged,omitempty"`
+	TimedOut        int   `json:"timed_out"`
+	BytesUploaded   int64 `json:"bytes_uploaded"`
+	BytesDownloaded int64 `json:"bytes_downloaded"`
+	// TransferMs is the wall time during which artifact transfers ran.
+	TransferMs int64 `json:"transfer_ms,omitempty"`
+}
+
+type TaskSummary struct {
+	ID              string    `json:"id"`
+	Package         string    `json:"package"`
+	Task            string    `json:"task"`
+	Hash            string    `json:"hash"`
+	Command         string    `json:"command"`
+	Cache           string    `json:"cache"`
+	Status          string    `json:"status"`
+	StartedAt       time.Time `json:"started_at"`
+	DurationMs      int64     `json:"duration_ms"`
+	ExitCode        int       `json:"exit_code"`
+	Attempts        int       `json:"attempts,omitempty"`
+	BytesUploaded   int64     `json:"bytes_uploaded"`
+	BytesDownloaded int64     `json:"bytes_downloaded"`
+	Error           string    `json:"error,omitempty"`
+}
+
+func newRunSummary(task string) *RunSummary {
+	now := time.Now()
+	return &RunSummary{
+		ID:        now.UTC().Format("20060102T150405.000Z"),
+		Task:      task,
+		StartedAt: now,
+		Tasks:     make([]TaskSummary, 0),
+	}
+}
+
+func newTaskSummary(node *engine.TaskNode) *TaskSummary {
+	summary := &TaskSummary{
+		ID:        node.ID,
+		Task:      node.TaskName,
+		Command:   node.TaskConfig.Command,
+		Cache:     cacheSourceMiss,
+		Status:    taskStatusSuccess,
+		StartedAt: time.Now(),
+		Attempts:  node.Attempts,
+	}
+	if node.Package != nil {
+		summary.Package = node.Package.Name
+	}
+	return summary
+}
+
+// record adds a finished task to the summary. A retried task replaces the
+// record of its previous attempt.
+func (s *RunSummary) record(task *TaskSummary) {
+	if s == nil {
+		return
+	}
+	task.DurationMs = time.Since(task.StartedAt).Milliseconds()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.Tasks {
+		if s.Tasks[i].ID == task.ID {
+			task.StartedAt = s.Tasks[i].StartedAt
+			task.DurationMs = time.Since(task.StartedAt).Milliseconds()
+			s.Tasks[i] = *task
+			return
+		}
+	}
+	s.Tasks = append(s.Tasks, *task)
+}
+
+// recordUpload notes the bytes a task's background upload sent. They are
+// added to the task when the summary is finished, since the upload may end
+// before the task is recorded.
+func (s *RunSummary) recordUpload(id string, bytes int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.uploaded == nil {
+		s.uploaded = make(map[string]int64)
+	}
+	s.uploaded[id] += bytes
+}
+
+// setTransferTime records how long the run's artifact transfers took.
+func (s *RunSummary) setTransferTime(busy time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transferMs = busy.Milliseconds()
+}
+
+// finish freezes the summary once the run has completed, computing totals
+// and the overall outcome from the recorded tasks.
+func (s *RunSummary) finish(runErr error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.EndedAt = time.Now()
+	s.DurationMs = s.EndedAt.Sub(s.StartedAt).Milliseconds()
+	s.Success = runErr == nil
+	if runErr != nil {
+		s.ExitCode = 1
+		var exitErr ExitError
+		if errors.As(runErr, &exitErr) {
+			s.ExitCode = exitErr.ExitCode()
+		}
+	}
+
+	sort.SliceStable(s.Tasks, func(i, j int) bool {
+		return s.Tasks[i].StartedAt.Before(s.Tasks[j].StartedAt)
+	})
+
+	totals := RunTotals{Tasks: len(s.Tasks), TransferMs: s.transferMs}
+	for i := range s.Tasks {
+		s.Tasks[i].BytesUploaded += s.uploaded[s.Tasks[i].ID]
+	}
+	s.uploaded = nil
+	for _, task := range s.Tasks {
+		switch {
+		case task.Status == taskStatusFailed:
+			totals.Failed++
+		case task.Status == taskStatusTimeout:
+			totals.Failed++
+			totals.TimedOut++
+		case task.Status == taskStatusSkipped:
+			totals.Skipped++
+		case task.Cache == cacheSourceLocal:
+			totals.LocalHits++
+		case task.Cache == cacheSourceRemote:
+			totals.RemoteHits++
+		case task.Cache == cacheSourceUnchanged:
+			totals.Unchanged++
+		default:
+			totals.Executed++
+		}
+		totals.BytesUploaded += task.BytesUploaded
+		totals.BytesDownloaded += task.BytesDownloaded
+	}
+	s.Totals = totals
+}
+
+func (s *RunSummary) write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(s)
+}
+
+// writeSummaryFile writes the summary to path, or to stdout when path is "-".
+func writeSummaryFile(path string, summary *RunSummary, stdout io.Writer) error {
+	if path == "-" {
+		return summary.write(stdout)
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("write summary: %w", err)
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("write summary: %w", err)
+	}
+	if err := summary.write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("write summary: %w", err)
+	}
+	return f.Close()
+}
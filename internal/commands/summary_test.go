@@ -0,0 +1,53 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunSummaryTotals(t *testing.T) {
+	summary := newRunSummary("build")
+	summary.record(&TaskSummary{ID: "a#build", Cache: cacheSourceLocal, Status: taskStatusSuccess})
+	summary.record(&TaskSummary{ID: "b#build", Cache: cacheSourceRemote, Status: taskStatusSuccess, BytesDownloaded: 128})
+	summary.record(&TaskSummary{ID: "c#build", Cache: cacheSourceMiss, Status: taskStatusSuccess, BytesUploaded: 64})
+	summary.record(&TaskSummary{ID: "d#build", Cache: cacheSourceMiss, Status: taskStatusFailed, ExitCode: 2})
+
+	summary.finish(errors.New("task failed"))
+
+	assert.False(t, summary.Success)
+	assert.Equal(t, 1, summary.ExitCode)
+	assert.Equal(t, RunTotals{
+		Tasks:           4,
+		LocalHits:       1,
+		RemoteHits:      1,
+		Executed:        1,
+		Failed:          1,
+		BytesUploaded:   64,
+		BytesDownloaded: 128,
+	}, summary.Totals)
+
+	var out bytes.Buffer
+	require.NoError(t, writeSummaryFile("-", summary, &out))
+
+	var decoded RunSummary
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, "build", decoded.Task)
+	assert.Len(t, decoded.Tasks, 4)
+}
+
+func TestRunSummaryCountsUnchangedTasksSeparately(t *testing.T) {
+	summary := newRunSummary("build")
+	summary.record(&TaskSummary{ID: "a#build", Cache: cacheSourceUnchanged, Status: taskStatusSuccess})
+	summary.record(&TaskSummary{ID: "b#build", Cache: cacheSourceMiss, Status: taskStatusSuccess})
+
+	summary.finish(nil)
+
+	assert.Equal(t, 1, summary.Totals.Unchanged)
+	assert.Equal(t, 1, summary.Totals.Executed)
+	assert.Zero(t, hitRate(summary))
+}
@@ -0,0 +1,43 @@
+package commands
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32                   = syscall.NewLazyDLL("kernel32.dll")
+	getConsoleMode             = kernel32.NewProc("GetConsoleMode")
+	setConsoleMode             = kernel32.NewProc("SetConsoleMode")
+	getConsoleScreenBufferInfo = kernel32.NewProc("GetConsoleScreenBufferInfo")
+)
+
+const enableVirtualTerminalProcessing = 0x0004
+
+type consoleScreenBufferInfo struct {
+	Size, CursorPosition     struct{ X, Y int16 }
+	Attributes               uint16
+	Left, Top, Right, Bottom int16
+	MaximumWindowSize        struct{ X, Y int16 }
+}
+
+// terminalSize returns the size of the console attached to f, or false when
+// f is not a console or the console cannot interpret ANSI escape sequences.
+func terminalSize(f *os.File) (width, height int, ok bool) {
+	handle := f.Fd()
+	var mode uint32
+	if ret, _, _ := getConsoleMode.Call(handle, uintptr(unsafe.Pointer(&mode))); ret == 0 {
+		return 0, 0, false
+	}
+	if mode&enableVirtualTerminalProcessing == 0 {
+		if ret, _, _ := setConsoleMode.Call(handle, uintptr(mode|enableVirtualTerminalProcessing)); ret == 0 {
+			return 0, 0, false
+		}
+	}
+	var info consoleScreenBufferInfo
+	if ret, _, _ := getConsoleScreenBufferInfo.Call(handle, uintptr(unsafe.Pointer(&info))); ret == 0 {
+		return 0, 0, false
+	}
+	return int(info.Right-info.Left) + 1, int(info.Bottom-info.Top) + 1, true
+}
@@ -0,0 +1,323 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+const (
+	rowQueued  = "queued"
+	rowRunning = "running"
+	rowHit     = "hit"
+	rowMiss    = "miss"
+	rowFailed  = "failed"
+	rowSkipped = "skipped"
+)
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+type taskRow struct {
+	id     string
+	state  string
+	detail string
+	start  time.Time
+	end    time.Time
+
+	transfer    string
+	transferred atomic.Int64
+
+	// output holds the task's logs, which are printed after the view
+	// stops if the task failed.
+	output bytes.Buffer
+}
+
+// liveView redraws a table of task states in place while a run executes.
+// Anything written to the view itself is printed above the table.
+type liveView struct {
+	out           io.Writer
+	width, height int
+	now           func() time.Time
+
+	mu      sync.Mutex
+	rows    []*taskRow
+	byID    map[string]*taskRow
+	pending []byte
+	lines   int
+	frame   int
+	started time.Time
+
+	stopCh chan struct{}
+	done   chan struct{}
+}
+
+// newLiveView returns a view drawing to out, or nil when out is not a
+// terminal that can be redrawn, in which case plain logs are used.
+func newLiveView(out io.Writer) *liveView {
+	file, ok := out.(*os.File)
+	if !ok || currentCI != ciNone || os.Getenv("TERM") == "dumb" {
+		return nil
+	}
+	width, height, ok := terminalSize(file)
+	if !ok {
+		return nil
+	}
+	return &liveView{out: out, width: width, height: height, now: time.Now}
+}
+
+func (v *liveView) start(nodes []*engine.TaskNode) {
+	v.mu.Lock()
+	v.rows = make([]*taskRow, 0, len(nodes))
+	v.byID = make(map[string]*taskRow, len(nodes))
+	for _, node := range nodes {
+		row := &taskRow{id: node.ID, state: rowQueued}
+		v.rows = append(v.rows, row)
+		v.byID[node.ID] = row
+	}
+	v.started = v.now()
+	v.stopCh = make(chan struct{})
+	v.done = make(chan struct{})
+	v.render()
+	v.mu.Unlock()
+
+	go func() {
+		defer close(v.done)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				v.mu.Lock()
+				v.frame++
+				v.render()
+				v.mu.Unlock()
+			case <-v.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// stop draws the final table, leaving it on screen, and prints the logs of
+// failed tasks below it.
+func (v *liveView) stop() {
+	close(v.stopCh)
+	<-v.done
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, row := range v.rows {
+		if row.state == rowQueued {
+			row.state = rowSkipped
+		}
+	}
+	v.render()
+	for _, row := range v.rows {
+		if row.state == rowFailed && row.output.Len() > 0 {
+			fmt.Fprintf(v.out, "\n%s %s\n", prefix(), errorStyle.Sprintf("Output of %s:", row.id))
+			v.out.Write(row.output.Bytes())
+		}
+	}
+}
+
+func (v *liveView) Write(p []byte) (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.pending = append(v.pending, p...)
+	return len(p), nil
+}
+
+func (v *liveView) taskOutput(id string) io.Writer {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if row := v.byID[id]; row != nil {
+		return &row.output
+	}
+	return io.Discard
+}
+
+func (v *liveView) setState(id, state, detail string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	row := v.byID[id]
+	if row == nil {
+		return
+	}
+	if row.start.IsZero() {
+		row.start = v.now()
+	}
+	row.state, row.detail = state, detail
+	if state != rowRunning {
+		row.end = v.now()
+		row.transfer = ""
+	}
+}
+
+// trackTransfer starts showing a transfer on the task's row; the returned
+// function adds transferred bytes.
+func (v *liveView) trackTransfer(id, direction string) func(n int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	row := v.byID[id]
+	if row == nil {
+		return func(int) {}
+	}
+	row.transfer = direction
+	row.transferred.Store(0)
+	return func(n int) { row.transferred.Add(int64(n)) }
+}
+
+func (v *liveView) render() {
+	var b strings.Builder
+	if v.lines > 0 {
+		fmt.Fprintf(&b, "\r\x1b[%dA", v.lines)
+	}
+	b.WriteString("\x1b[J")
+
+	if i := bytes.LastIndexByte(v.pending, '\n'); i >= 0 {
+		b.Write(v.pending[:i+1])
+		v.pending = append(v.pending[:0], v.pending[i+1:]...)
+	}
+
+	rows := v.visibleRows()
+	idWidth := 0
+	for _, row := range rows {
+		idWidth = max(idWidth, len(row.id))
+	}
+	idWidth = min(idWidth, max(v.width-32, 10))
+
+	now := v.now()
+	for _, row := range rows {
+		b.WriteString(v.formatRow(row, idWidth, now))
+		b.WriteByte('\n')
+	}
+	hidden := len(v.rows) - len(rows)
+	if hidden > 0 {
+		b.WriteString(subtleStyle.Sprintf("  … %d more task(s)", hidden))
+		b.WriteByte('\n')
+	}
+	b.WriteString(v.summaryLine(now))
+	b.WriteByte('\n')
+
+	v.lines = len(rows) + 1
+	if hidden > 0 {
+		v.lines++
+	}
+	io.WriteString(v.out, b.String())
+}
+
+// visibleRows keeps the table within the terminal height, preferring
+// running and failed tasks over queued and finished ones.
+func (v *liveView) visibleRows() []*taskRow {
+	limit := v.height - 3
+	if limit < 1 || len(v.rows) <= limit {
+		return v.rows
+	}
+	rank := func(row *taskRow) int {
+		switch row.state {
+		case rowRunning:
+			return 0
+		case rowFailed:
+			return 1
+		case rowQueued:
+			return 3
+		}
+		return 2
+	}
+	show := make(map[*taskRow]bool, limit)
+	for r := 0; r <= 3 && len(show) < limit; r++ {
+		for _, row := range v.rows {
+			if rank(row) == r && len(show) < limit {
+				show[row] = true
+			}
+		}
+	}
+	rows := make([]*taskRow, 0, limit)
+	for _, row := range v.rows {
+		if show[row] {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+func (v *liveView) formatRow(row *taskRow, idWidth int, now time.Time) string {
+	id := row.id
+	if len(id) > idWidth {
+		id = id[:idWidth-1] + "…"
+	}
+
+	var icon, label string
+	switch row.state {
+	case rowQueued:
+		icon, label = subtleStyle.Sprint("·"), subtleStyle.Sprint("queued")
+	case rowRunning:
+		icon, label = infoStyle.Sprint(spinnerFrames[v.frame%len(spinnerFrames)]), infoStyle.Sprint(row.detail)
+	case rowHit:
+		icon, label = hitStyle.Sprint("✓"), hitStyle.Sprintf("hit (%s)", row.detail)
+	case rowMiss:
+		icon, label = hitStyle.Sprint("✓"), missStyle.Sprint("miss")
+	case rowFailed:
+		icon, label = errorStyle.Sprint("✗"), errorStyle.Sprint("failed")
+	case rowSkipped:
+		icon, label = subtleStyle.Sprint("-"), subtleStyle.Sprint("skipped")
+	}
+
+	line := fmt.Sprintf("  %s %-*s  %s", icon, idWidth, id, label)
+	if !row.start.IsZero() {
+		end := row.end
+		if row.state == rowRunning {
+			end = now
+		}
+		line += subtleStyle.Sprintf("  %s", formatElapsed(end.Sub(row.start)))
+	}
+	if row.transfer != "" {
+		line += subtleStyle.Sprintf("  %s %s", row.transfer, formatBytes(row.transferred.Load()))
+	}
+	return line
+}
+
+func (v *liveView) summaryLine(now time.Time) string {
+	var finished, running, failed int
+	for _, row := range v.rows {
+		switch row.state {
+		case rowRunning:
+			running++
+		case rowFailed:
+			failed++
+			finished++
+		case rowHit, rowMiss, rowSkipped:
+			finished++
+		}
+	}
+	line := fmt.Sprintf("%s %d/%d done, %d running", prefix(), finished, len(v.rows), running)
+	if failed > 0 {
+		line += errorStyle.Sprintf(", %d failed", failed)
+	}
+	return line + subtleStyle.Sprintf("  %s", formatElapsed(now.Sub(v.started)))
+}
+
+func formatElapsed(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	}
+	return d.Round(time.Second).String()
+}
+
+type progressReader struct {
+	r   io.Reader
+	add func(int)
+}
+
+func (p progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.add(n)
+	return n, err
+}
@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+func newTestLiveView(out *bytes.Buffer, height int) *liveView {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &liveView{out: out, width: 80, height: height, now: func() time.Time { return now }}
+}
+
+func TestLiveViewFinalTable(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = true
+	t.Cleanup(func() { color.NoColor = noColor })
+
+	var out bytes.Buffer
+	view := newTestLiveView(&out, 24)
+	view.start([]*engine.TaskNode{{ID: "lib#build"}, {ID: "app#build"}, {ID: "docs#build"}})
+
+	view.setState("lib#build", rowRunning, "downloading")
+	add := view.trackTransfer("lib#build", "↓")
+	add(2048)
+	view.setState("lib#build", rowHit, cacheSourceRemote)
+	view.setState("app#build", rowRunning, "executing")
+	fmt.Fprintln(view.taskOutput("app#build"), "compile error")
+	view.setState("app#build", rowFailed, "")
+	fmt.Fprintln(view, "retrying")
+	view.stop()
+
+	final := out.String()[strings.LastIndex(out.String(), "\x1b[J")+len("\x1b[J"):]
+	assert.Contains(t, final, "retrying\n")
+	assert.Contains(t, final, "  ✓ lib#build   hit (remote)  0.0s\n")
+	assert.Contains(t, final, "  ✗ app#build   failed  0.0s\n")
+	assert.Contains(t, final, "  - docs#build  skipped\n")
+	assert.Contains(t, final, "3/3 done, 0 running, 1 failed")
+	assert.Contains(t, final, "Output of app#build:\ncompile error\n")
+}
+
+func TestLiveViewLimitsRowsToTerminalHeight(t *testing.T) {
+	var out bytes.Buffer
+	view := newTestLiveView(&out, 5)
+	view.byID = make(map[string]*taskRow)
+	for i := 0; i < 6; i++ {
+		row := &taskRow{id: fmt.Sprintf("pkg%d#build", i), state: rowQueued}
+		view.rows = append(view.rows, row)
+		view.byID[row.id] = row
+	}
+	view.byID["pkg4#build"].state = rowRunning
+	view.byID["pkg5#build"].state = rowFailed
+
+	rows := view.visibleRows()
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.id)
+	}
+	assert.Equal(t, []string{"pkg4#build", "pkg5#build"}, ids)
+}
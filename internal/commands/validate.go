@@ -0,0 +1,56 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+)
+
+func newValidateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate [path]",
+		Short: "Check velocity.yml for mistakes",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := configFileName
+			if len(args) == 1 {
+				path = args[0]
+			}
+			return validateConfig(cmd, path)
+		},
+	}
+}
+
+func validateConfig(cmd *cobra.Command, path string) error {
+	issues, err := config.ValidateFile(path)
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	errorCount, warningCount := 0, 0
+	for _, issue := range issues {
+		style := warnStyle
+		if issue.Severity == config.SeverityError {
+			style = errorStyle
+			errorCount++
+		} else {
+			warningCount++
+		}
+		fmt.Fprintf(out, "%s:%d:%d: %s %s\n", path, issue.Line, issue.Column, style.Sprint(issue.Severity+":"), issue.Message)
+	}
+
+	if errorCount > 0 {
+		err := fmt.Errorf("%s has %d error(s) and %d warning(s)", path, errorCount, warningCount)
+		fmt.Fprintf(cmd.ErrOrStderr(), "%s %s\n", prefix(), errorStyle.Sprint(err.Error()))
+		return newExitError(1, err)
+	}
+	if warningCount > 0 {
+		logInfo(out, fmt.Sprintf("%s is valid with %d warning(s).", path, warningCount))
+		return nil
+	}
+	logInfo(out, fmt.Sprintf("%s is valid.", path))
+	return nil
+}
@@ -0,0 +1,213 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+const (
+	warmDownloaded = "downloaded"
+	warmLocal      = "local"
+	warmMissing    = "missing"
+	warmFailed     = "failed"
+	warmSkipped    = "skipped"
+)
+
+type warmResult struct {
+	Task   string `json:"task"`
+	Key    string `json:"key"`
+	Status string `json:"status"`
+	Bytes  int64  `json:"bytes,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newWarmCommand() *cobra.Command {
+	var sel taskSelection
+	var concurrency int
+	cmd := &cobra.Command{
+		Use:   "warm <task-name>",
+		Short: "Download remote cache hits for a task graph into the local cache",
+		Long: "Compute the cache key of every task a run would execute and download the\n" +
+			"artifacts the remote cache already has, so the next `velocity run` is served\n" +
+			"entirely from " + cachePath + ". Nothing is executed.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if concurrency < 1 {
+				return fmt.Errorf("--concurrency must be at least 1, got %d", concurrency)
+			}
+			cfg, roots, err := loadTaskGraph(cmd, args[0], sel)
+			if err != nil {
+				return err
+			}
+			remotes, err := newRemoteCaches(cfg)
+			if err != nil {
+				return err
+			}
+			if len(remotes) == 0 {
+				return fmt.Errorf("remote caching is not enabled in %s", configFileName)
+			}
+			maxBytes, err := cfg.LocalCacheMaxBytes()
+			if err != nil {
+				return err
+			}
+			engine.SetLocalCacheMaxBytes(maxBytes)
+
+			nodes, err := hashGraph(cmd, roots)
+			if err != nil {
+				return err
+			}
+			results := warmCache(cmd.Context(), remotes, nodes, concurrency, cmd.ErrOrStderr())
+			for _, remote := range remotes {
+				if err := remote.client.SaveMisses(); err != nil {
+					logWarning(cmd.ErrOrStderr(), err.Error())
+				}
+			}
+			return writeWarmSummary(cmd.OutOrStdout(), results)
+		},
+	}
+	sel.bindFlags(cmd)
+	cmd.Flags().IntVar(&concurrency, "concurrency", runtime.NumCPU(), "Maximum number of parallel downloads")
+	return cmd
+}
+
+// warmCache fetches the artifact of every cacheable node that is not yet in
+// the local cache from the first of remotes that has it. Results are
+// returned in the order of nodes.
+func warmCache(ctx context.Context, remotes []*remoteCache, nodes []*engine.TaskNode, concurrency int, errOut io.Writer) []warmResult {
+	results := make([]warmResult, len(nodes))
+	sem := make(chan struct{}, concurrency)
+	var logMu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i, node := range nodes {
+		results[i] = warmResult{Task: node.ID, Key: node.CacheKey}
+		if !node.TaskConfig.CacheEnabled() {
+			results[i].Status = warmSkipped
+			continue
+		}
+		if _, found, err := engine.CheckLocal(node.CacheKey); err == nil && found {
+			results[i].Status = warmLocal
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *warmResult, node *engine.TaskNode) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				result.Status, result.Error = warmFailed, ctx.Err().Error()
+				return
+			}
+			defer func() { <-sem }()
+
+			size, found, err := downloadFromRemotes(ctx, remotes, node)
+			switch {
+			case err != nil:
+				result.Status, result.Error = warmFailed, err.Error()
+				logMu.Lock()
+				logWarning(errOut, fmt.Sprintf("%s: %v", node.ID, err))
+				logMu.Unlock()
+			case !found:
+				result.Status = warmMissing
+			default:
+				result.Status, result.Bytes = warmDownloaded, size
+				logMu.Lock()
+				saveMetadata(errOut, node, engine.ArtifactSourceRemote, size, 0, nil)
+				logMu.Unlock()
+			}
+		}(&results[i], node)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// downloadFromRemotes tries remotes in turn until one provides the node's
+// artifact. An error is returned only when none did and one of them failed.
+func downloadFromRemotes(ctx context.Context, remotes []*remoteCache, node *engine.TaskNode) (int64, bool, error) {
+	var lastErr error
+	for _, remote := range remotes {
+		size, found, err := downloadArtifact(ctx, remote.client, node)
+		if err != nil {
+			lastErr = fmt.Errorf("%s%w", remote.label, err)
+			continue
+		}
+		if found {
+			return size, true, nil
+		}
+	}
+	return 0, false, lastErr
+}
+
+func downloadArtifact(ctx context.Context, remote *engine.RemoteClient, node *engine.TaskNode) (int64, bool, error) {
+	resp, err := remote.Negotiate(ctx, node.CacheKey, "download")
+	if err != nil {
+		return 0, false, fmt.Errorf("negotiate download: %w", err)
+	}
+	if resp.Status != "found" {
+		return 0, false, nil
+	}
+
+	tmp, err := os.CreateTemp("", "velo-warm-*.zip")
+	if err != nil {
+		return 0, false, fmt.Errorf("create temp archive: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	err = remote.Download(ctx, node.CacheKey, resp, tmp, nil)
+	tmp.Close()
+	if err != nil {
+		return 0, false, fmt.Errorf("download: %w", err)
+	}
+	if err := remote.OpenArtifact(node.CacheKey, tmp.Name()); err != nil {
+		return 0, false, fmt.Errorf("open artifact: %w", err)
+	}
+
+	stat, err := os.Stat(tmp.Name())
+	if err != nil {
+		return 0, false, fmt.Errorf("stat download: %w", err)
+	}
+	if _, err := engine.SaveLocal(node.CacheKey, tmp.Name()); err != nil {
+		return 0, false, err
+	}
+	return stat.Size(), true, nil
+}
+
+func writeWarmSummary(out io.Writer, results []warmResult) error {
+	var downloaded, local, missing, failed int
+	var bytes int64
+	for _, result := range results {
+		label := subtleStyle.Sprint(result.Status)
+		switch result.Status {
+		case warmDownloaded:
+			downloaded++
+			bytes += result.Bytes
+			label = hitStyle.Sprintf("%s (%s)", result.Status, formatBytes(result.Bytes))
+		case warmLocal:
+			local++
+			label = hitStyle.Sprint(result.Status)
+		case warmMissing:
+			missing++
+			label = missStyle.Sprint(result.Status)
+		case warmFailed:
+			failed++
+			label = errorStyle.Sprint(result.Status)
+		}
+		fmt.Fprintf(out, "%s %s %s\n", prefix(), result.Task, label)
+	}
+
+	logInfo(out, fmt.Sprintf("Downloaded %d artifact(s) (%s); %d already local, %d not in the remote cache.",
+		downloaded, formatBytes(bytes), local, missing))
+	if failed > 0 {
+		return fmt.Errorf("%d download(s) failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+func TestWarmCacheDownloadsRemoteHits(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/negotiate":
+			var req struct{ Hash string }
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			if req.Hash != "remote-key" {
+				json.NewEncoder(w).Encode(map[string]string{"status": "not_found"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"status": "found", "url": server.URL + "/blob/" + req.Hash})
+		case strings.HasPrefix(r.URL.Path, "/blob/"):
+			io.WriteString(w, "zipdata")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	localZip := filepath.Join(t.TempDir(), "local.zip")
+	require.NoError(t, os.WriteFile(localZip, []byte("zipdata"), 0o644))
+	_, err := engine.SaveLocal("local-key", localZip)
+	require.NoError(t, err)
+
+	disabled := false
+	nodes := []*engine.TaskNode{
+		{ID: "lib#build", CacheKey: "remote-key"},
+		{ID: "app#build", CacheKey: "local-key"},
+		{ID: "docs#build", CacheKey: "absent-key"},
+		{ID: "dev#serve", CacheKey: "nocache-key", TaskConfig: config.TaskConfig{Cache: &disabled}},
+	}
+	cfg := &config.Config{Remote: config.RemoteConfig{Enabled: true, URL: server.URL}}
+
+	results := warmCache(context.Background(), []*remoteCache{{name: "remote", client: engine.NewRemoteClient(cfg.Remote.URL, cfg.Remote.Token)}}, nodes, 2, io.Discard)
+	require.Len(t, results, 4)
+	assert.Equal(t, warmResult{Task: "lib#build", Key: "remote-key", Status: warmDownloaded, Bytes: 7}, results[0])
+	assert.Equal(t, warmLocal, results[1].Status)
+	assert.Equal(t, warmMissing, results[2].Status)
+	assert.Equal(t, warmSkipped, results[3].Status)
+
+	_, found, err := engine.CheckLocal("remote-key")
+	require.NoError(t, err)
+	assert.True(t, found, "downloaded artifact should be in the local cache")
+}
@@ -0,0 +1,222 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+
+	"github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+const watchDebounce = 300 * time.Millisecond
+
+var watchSkipDirs = map[string]struct{}{
+	".git":         {},
+	".velocity":    {},
+	"node_modules": {},
+}
+
+type watchKeys struct {
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+func newWatchKeys() *watchKeys {
+	return &watchKeys{keys: make(map[string]string)}
+}
+
+func (w *watchKeys) unchanged(id, key string) bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.keys[id] == key
+}
+
+func (w *watchKeys) store(id, key string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.keys[id] = key
+}
+
+// watchTask runs the task once and then re-runs it whenever files change.
+// Tasks whose cache key did not change since their last successful run are
+// skipped, so only invalidated tasks execute again.
+func watchTask(cmd *cobra.Command, taskName string, opts runOptions) error {
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("start watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	keys := newWatchKeys()
+	var ignored []string
+
+	runOnce := func() {
+		cfg, roots, err := loadTaskGraph(cmd, taskName, opts.selection)
+		if err != nil {
+			logWarning(cmd.ErrOrStderr(), err.Error())
+			return
+		}
+		nodes, err := engine.Plan(roots...)
+		if err != nil {
+			logWarning(cmd.ErrOrStderr(), err.Error())
+			return
+		}
+		ignored = outputPaths(nodes)
+		if err := addWatchDirs(watcher, watchRoots(nodes), ignored); err != nil {
+			logWarning(cmd.ErrOrStderr(), err.Error())
+		}
+
+		exec, err := newEngine(cmd, cfg, taskName, opts)
+		if err != nil {
+			logWarning(cmd.ErrOrStderr(), err.Error())
+			return
+		}
+		exec.lastKeys = keys
+		if err := finishRun(cmd, exec.summary, exec.Run(roots, opts.concurrency), opts.summaryFile); err != nil {
+			logWarning(cmd.ErrOrStderr(), fmt.Sprintf("Run failed: %v", err))
+		}
+		logInfo(cmd.OutOrStdout(), "Watching for changes...")
+	}
+
+	runOnce()
+
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if isIgnoredPath(event.Name, ignored) {
+				continue
+			}
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					addWatchDirs(watcher, []string{event.Name}, ignored)
+				}
+			}
+			timer = time.After(watchDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logWarning(cmd.ErrOrStderr(), fmt.Sprintf("Watcher error: %v", err))
+		case <-timer:
+			timer = nil
+			runOnce()
+		}
+	}
+}
+
+func watchRoots(nodes []*engine.TaskNode) []string {
+	seen := make(map[string]struct{})
+	roots := []string{"."}
+	seen["."] = struct{}{}
+	for _, node := range nodes {
+		if node.Package == nil {
+			continue
+		}
+		path := filepath.Clean(node.Package.Path)
+		if _, ok := seen[path]; ok {
+			continue
+		}
+		seen[path] = struct{}{}
+		roots = append(roots, path)
+	}
+	return roots
+}
+
+// outputPaths returns the directories tasks write to, so changes made by
+// the tasks themselves do not trigger another run.
+func outputPaths(nodes []*engine.TaskNode) []string {
+	paths := make([]string, 0)
+	for _, node := range nodes {
+		base := "."
+		if node.Package != nil {
+			base = node.Package.Path
+		}
+		for _, output := range node.TaskConfig.Outputs {
+			output = strings.TrimPrefix(strings.TrimSpace(output), "!")
+			if i := strings.IndexAny(output, "*?[{"); i >= 0 {
+				output = output[:i]
+			}
+			if output == "" {
+				continue
+			}
+			paths = append(paths, filepath.Clean(filepath.Join(base, output)))
+		}
+	}
+	return paths
+}
+
+func isIgnoredPath(path string, ignored []string) bool {
+	path = filepath.Clean(path)
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if _, skip := watchSkipDirs[part]; skip {
+			return true
+		}
+	}
+	for _, prefix := range ignored {
+		if prefix == "." {
+			continue
+		}
+		if path == prefix || strings.HasPrefix(path, prefix+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func addWatchDirs(watcher *fsnotify.Watcher, roots, ignored []string) error {
+	watched := make(map[string]struct{})
+	for _, path := range watcher.WatchList() {
+		watched[path] = struct{}{}
+	}
+
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			if path != root && isIgnoredPath(path, ignored) {
+				return fs.SkipDir
+			}
+			if _, ok := watched[path]; ok {
+				return nil
+			}
+			if err := watcher.Add(path); err != nil {
+				return fmt.Errorf("watch %s: %w", path, err)
+			}
+			watched[path] = struct{}{}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
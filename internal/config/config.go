@@ -1,32 +1,557 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
 )
 
 type Config struct {
-	Version   int                   `yaml:"version"`
-	ProjectID string                `yaml:"project_id"`
-	Remote    RemoteConfig          `yaml:"remote"`
-	Packages  []string              `yaml:"packages"`
-	Pipeline  map[string]TaskConfig `yaml:"pipeline"`
+	Version   int          `yaml:"version"`
+	ProjectID string       `yaml:"project_id"`
+	Remote    RemoteConfig `yaml:"remote"`
+	// Remotes are further remote caches, e.g. a LAN cache in front of a
+	// hosted one. See RemoteCaches.
+	Remotes  []RemoteConfig        `yaml:"remotes,omitempty"`
+	Cache    LocalCacheConfig      `yaml:"cache,omitempty"`
+	Packages []string              `yaml:"packages"`
+	Tags     map[string][]string   `yaml:"tags,omitempty"`
+	Pipeline map[string]TaskConfig `yaml:"pipeline"`
+
+	// GlobalDependencies and GlobalEnv are hashed into every task's key.
+	GlobalDependencies []string `yaml:"global_dependencies,omitempty"`
+	GlobalEnv          []string `yaml:"global_env,omitempty"`
+
+	// InputDiscovery selects how input globs are resolved: "glob" walks the
+	// filesystem, "git" matches against the git index and untracked files.
+	InputDiscovery string `yaml:"input_discovery,omitempty"`
+
+	// HashAlgorithm selects how input file contents are hashed: "sha256"
+	// (the default) or the much faster, non-cryptographic "xxhash". Cache
+	// keys themselves are always SHA-256.
+	HashAlgorithm string `yaml:"hash_algorithm,omitempty"`
+
+	// ArchiveFormat is the default artifact format, "zip" or "tar.zst".
+	// Tasks may override it.
+	ArchiveFormat string `yaml:"archive_format,omitempty"`
+
+	// PreserveMetadata records each output's modification time and full
+	// permission bits so they are restored as they were built. Artifacts
+	// are then no longer byte-identical across builds of identical outputs.
+	PreserveMetadata bool `yaml:"preserve_metadata,omitempty"`
+
+	// StrictOutputs fails tasks whose declared outputs match nothing after
+	// they run, instead of warning. Tasks may override it.
+	StrictOutputs bool `yaml:"strict_outputs,omitempty"`
 }
 
 type RemoteConfig struct {
 	Enabled bool   `yaml:"enabled"`
 	URL     string `yaml:"url"`
 	Token   string `yaml:"token"`
+	// Bucket, instead of url, stores artifacts straight in an S3-compatible
+	// bucket with the machine's own credentials, without a server.
+	Bucket BucketConfig `yaml:"bucket,omitempty"`
+	// Name labels the remote in logs when several are configured.
+	Name string `yaml:"name,omitempty"`
+	// ReadOnly keeps artifacts from being uploaded to the remote.
+	ReadOnly bool `yaml:"read_only,omitempty"`
+	// EncryptionKey is a base64-encoded 32-byte AES key. When set, artifacts
+	// are encrypted before upload and decrypted after download.
+	EncryptionKey string `yaml:"encryption_key,omitempty"`
+	// SignatureKey is a shared secret used to sign uploaded artifacts with
+	// HMAC-SHA256 and to verify downloaded ones.
+	SignatureKey string `yaml:"signature_key,omitempty"`
+	// RequireSignature rejects downloaded artifacts that are not signed.
+	RequireSignature bool `yaml:"require_signature,omitempty"`
+	// MissTTL, a duration such as "2m", keeps the keys the remote reported
+	// missing for that long across runs, so they are not looked up again.
+	// Within a run, misses are always remembered.
+	MissTTL string `yaml:"miss_ttl,omitempty"`
+	// Transfers bounds how many artifacts are downloaded or uploaded at
+	// once across all tasks of a run. Zero uses the default of 8.
+	Transfers int `yaml:"transfers,omitempty"`
+	// CAFile is a PEM bundle of extra certificate authorities to trust for
+	// the remote and its storage, e.g. for a proxy intercepting TLS.
+	CAFile string `yaml:"ca_file,omitempty"`
+	// InsecureSkipVerify disables TLS certificate verification. Use it only
+	// to try out a self-hosted server.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify,omitempty"`
+	// Retry controls how requests to the remote that fail with a network
+	// error or a status such as 429 or 503 are retried.
+	Retry RetryConfig `yaml:"retry,omitempty"`
+}
+
+// BucketConfig is remote.bucket. Credentials are read from the standard
+// AWS sources such as AWS_ACCESS_KEY_ID or AWS_PROFILE.
+type BucketConfig struct {
+	Name string `yaml:"name,omitempty"`
+	// Region may be left empty to take it from the AWS configuration.
+	Region string `yaml:"region,omitempty"`
+	// Endpoint is the URL of an S3-compatible service, e.g. R2, MinIO or
+	// https://storage.googleapis.com for Google Cloud Storage.
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// Prefix is prepended to every object key, e.g. "velocity/".
+	Prefix string `yaml:"prefix,omitempty"`
+	// VirtualHosted addresses the bucket as a subdomain of the endpoint,
+	// as AWS prefers, instead of in the path, which MinIO needs.
+	VirtualHosted bool `yaml:"virtual_hosted,omitempty"`
+	// RetryMode is the AWS SDK retry mode, "standard" or "adaptive".
+	RetryMode string `yaml:"retry_mode,omitempty"`
+	// MaxAttempts is the number of tries per request to the bucket.
+	MaxAttempts int `yaml:"max_attempts,omitempty"`
+	// Timeout, a duration such as "30s", bounds each request to the bucket
+	// that does not transfer an artifact.
+	Timeout string `yaml:"timeout,omitempty"`
+}
+
+// URL identifies the bucket as s3://<name>/<prefix>.
+func (b BucketConfig) URL() string {
+	prefix, _ := b.CleanPrefix()
+	return "s3://" + b.Name + "/" + prefix
+}
+
+// CleanPrefix checks bucket.prefix and returns it ending in "/", as a
+// server's VC_STORAGE_PREFIX is, or empty when it is not set.
+func (b BucketConfig) CleanPrefix() (string, error) {
+	prefix, err := storage.CleanPrefix(b.Prefix)
+	if err != nil {
+		return "", fmt.Errorf("invalid bucket.prefix: %w", err)
+	}
+	return prefix, nil
+}
+
+// TimeoutDuration parses bucket.timeout, returning zero when it is not set.
+func (b BucketConfig) TimeoutDuration() (time.Duration, error) {
+	value := strings.TrimSpace(b.Timeout)
+	if value == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid bucket.timeout %q (expected a duration such as \"30s\")", b.Timeout)
+	}
+	return timeout, nil
+}
+
+// RetryConfig is remote.retry. Zero values keep the defaults of 3 attempts
+// backing off from 250ms up to 10s.
+type RetryConfig struct {
+	// Attempts is the total number of tries per request.
+	Attempts int `yaml:"attempts,omitempty"`
+	// Backoff, a duration such as "500ms", is the wait before the first
+	// retry. It doubles with every further attempt, up to MaxBackoff.
+	Backoff    string `yaml:"backoff,omitempty"`
+	MaxBackoff string `yaml:"max_backoff,omitempty"`
+}
+
+// Delays parses remote.retry.backoff and remote.retry.max_backoff,
+// returning zero for those that are not set.
+func (r RetryConfig) Delays() (backoff, maxBackoff time.Duration, err error) {
+	if backoff, err = parseRetryDelay("backoff", r.Backoff); err != nil {
+		return 0, 0, err
+	}
+	if maxBackoff, err = parseRetryDelay("max_backoff", r.MaxBackoff); err != nil {
+		return 0, 0, err
+	}
+	return backoff, maxBackoff, nil
+}
+
+func parseRetryDelay(field, value string) (time.Duration, error) {
+	if strings.TrimSpace(value) == "" {
+		return 0, nil
+	}
+	delay, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil || delay < 0 {
+		return 0, fmt.Errorf("invalid remote.retry.%s %q (expected a duration such as \"500ms\")", field, value)
+	}
+	return delay, nil
+}
+
+// RemoteCaches returns the remote caches to use in priority order: remote
+// when it is enabled, then every entry of remotes with a url or bucket. Reads try
+// them in turn; writes go to those not marked read_only. Encryption,
+// signing, retry, TLS and transfer settings are taken from remote for all
+// of them.
+func (c *Config) RemoteCaches() []RemoteConfig {
+	var remotes []RemoteConfig
+	if c.Remote.Enabled {
+		remote := c.Remote
+		if remote.Name == "" {
+			remote.Name = "remote"
+		}
+		remotes = append(remotes, remote)
+	}
+	for i, remote := range c.Remotes {
+		if strings.TrimSpace(remote.URL) == "" && strings.TrimSpace(remote.Bucket.Name) == "" {
+			continue
+		}
+		if remote.Name == "" {
+			remote.Name = fmt.Sprintf("remotes[%d]", i)
+		}
+		remotes = append(remotes, remote)
+	}
+	return remotes
+}
+
+// MissDuration parses remote.miss_ttl, returning zero when it is not set.
+func (r RemoteConfig) MissDuration() (time.Duration, error) {
+	value := strings.TrimSpace(r.MissTTL)
+	if value == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid remote.miss_ttl %q (expected a duration such as \"2m\")", r.MissTTL)
+	}
+	return ttl, nil
+}
+
+// EncryptionKey returns the decoded remote encryption key, with
+// VELOCITY_ENCRYPTION_KEY taking precedence over remote.encryption_key. It
+// returns nil when neither is set.
+func (c *Config) EncryptionKey() ([]byte, error) {
+	value := c.Remote.EncryptionKey
+	if env := strings.TrimSpace(os.Getenv("VELOCITY_ENCRYPTION_KEY")); env != "" {
+		value = env
+	}
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	return ParseEncryptionKey(value)
+}
+
+// SignatureKey returns the remote artifact signing key, with
+// VELOCITY_SIGNATURE_KEY taking precedence over remote.signature_key. It
+// returns nil when neither is set, which is an error if
+// remote.require_signature is enabled.
+func (c *Config) SignatureKey() ([]byte, error) {
+	value := strings.TrimSpace(c.Remote.SignatureKey)
+	if env := strings.TrimSpace(os.Getenv("VELOCITY_SIGNATURE_KEY")); env != "" {
+		value = env
+	}
+	if value == "" {
+		if c.Remote.RequireSignature {
+			return nil, fmt.Errorf("remote.require_signature is set but no remote.signature_key is configured")
+		}
+		return nil, nil
+	}
+	return []byte(value), nil
+}
+
+// ParseEncryptionKey decodes a base64-encoded 32-byte key.
+func ParseEncryptionKey(value string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not valid base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d (generate one with `openssl rand -base64 32`)", len(key))
+	}
+	return key, nil
+}
+
+type LocalCacheConfig struct {
+	MaxSize string `yaml:"max_size,omitempty"`
+	// Version is mixed into every cache key, so bumping it invalidates all
+	// existing artifacts without deleting them.
+	Version string `yaml:"version,omitempty"`
+	// Restore selects how outputs are restored from the local cache:
+	// "copy" (the default), "reflink" or "hardlink". The latter two keep an
+	// unpacked copy of each artifact and link files from it, falling back
+	// to copying where the filesystem does not allow it. Hardlinked outputs
+	// share their contents with the cache and must not be edited in place.
+	Restore string `yaml:"restore,omitempty"`
+}
+
+// CacheVersion returns the cache key version, with VELOCITY_CACHE_VERSION
+// taking precedence over cache.version.
+func (c *Config) CacheVersion() string {
+	if env := strings.TrimSpace(os.Getenv("VELOCITY_CACHE_VERSION")); env != "" {
+		return env
+	}
+	return strings.TrimSpace(c.Cache.Version)
+}
+
+// LocalCacheMaxBytes returns the size budget of the local cache, with
+// VELOCITY_CACHE_MAX_SIZE taking precedence over cache.max_size. Zero means
+// unlimited.
+func (c *Config) LocalCacheMaxBytes() (int64, error) {
+	value := c.Cache.MaxSize
+	if env := strings.TrimSpace(os.Getenv("VELOCITY_CACHE_MAX_SIZE")); env != "" {
+		value = env
+	}
+	if strings.TrimSpace(value) == "" {
+		return 0, nil
+	}
+	size, err := ParseSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("cache max size: %w", err)
+	}
+	return size, nil
 }
 
 type TaskConfig struct {
-	Command   string   `yaml:"command"`
-	Inputs    []string `yaml:"inputs"`
-	Outputs   []string `yaml:"outputs"`
-	DependsOn []string `yaml:"depends_on"`
-	EnvKeys   []string `yaml:"env_keys"`
+	Command    string   `yaml:"command"`
+	Inputs     []string `yaml:"inputs"`
+	Outputs    []string `yaml:"outputs"`
+	DependsOn  []string `yaml:"depends_on"`
+	EnvKeys    []string `yaml:"env_keys"`
+	DotEnv     []string `yaml:"dot_env,omitempty"`
+	OutputLogs string   `yaml:"output_logs,omitempty"`
+	Cache      *bool    `yaml:"cache,omitempty"`
+	Retries    int      `yaml:"retries,omitempty"`
+	Timeout    string   `yaml:"timeout,omitempty"`
+
+	// ToolDependencies names tools (node, go, rustc, ...) whose versions
+	// are part of the cache key.
+	ToolDependencies []string `yaml:"tool_dependencies,omitempty"`
+
+	// Docker hashes a Docker build, its context and Dockerfile, into the
+	// cache key alongside any inputs.
+	Docker *DockerInput `yaml:"docker,omitempty"`
+
+	ArchiveFormat string `yaml:"archive_format,omitempty"`
+	StrictOutputs *bool  `yaml:"strict_outputs,omitempty"`
+
+	// CacheFailures, a duration such as "15m", records failed runs under
+	// the task's cache key so that reruns with unchanged inputs replay the
+	// failure instead of executing again until it expires.
+	CacheFailures string `yaml:"cache_failures,omitempty"`
+
+	Overrides map[string]TaskConfig `yaml:"overrides,omitempty"`
+
+	// Env holds environment overrides given on the command line. They are
+	// set for the command and hashed like env_keys.
+	Env map[string]string `yaml:"-"`
+}
+
+// DockerInput describes a Docker build whose context, filtered by its
+// .dockerignore, and Dockerfile are hashed as task inputs. Both paths are
+// relative to the package.
+type DockerInput struct {
+	// Context is the build context directory, "." when empty.
+	Context string `yaml:"context,omitempty"`
+	// Dockerfile defaults to the Dockerfile at the root of the context.
+	Dockerfile string `yaml:"dockerfile,omitempty"`
+}
+
+// SplitTaskRef splits a depends_on entry of the form "<package>#<task>",
+// which names a task in a specific package, into its parts. It reports
+// false for same-package and "^" references.
+func SplitTaskRef(ref string) (pkg, task string, ok bool) {
+	ref = strings.TrimSpace(ref)
+	if strings.HasPrefix(ref, "^") {
+		return "", "", false
+	}
+	i := strings.LastIndex(ref, "#")
+	if i < 0 {
+		return "", "", false
+	}
+	return ref[:i], ref[i+1:], true
+}
+
+// WithEnv returns the task with env applied on top of any earlier overrides
+// and each of its keys added to EnvKeys.
+func (t TaskConfig) WithEnv(env map[string]string) TaskConfig {
+	if len(env) == 0 {
+		return t
+	}
+	merged := make(map[string]string, len(t.Env)+len(env))
+	for key, value := range t.Env {
+		merged[key] = value
+	}
+	keys := append([]string(nil), t.EnvKeys...)
+	for key, value := range env {
+		merged[key] = value
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	t.Env = merged
+	t.EnvKeys = keys
+	return t
+}
+
+// Getenv returns the value of key as seen by the task's command.
+func (t TaskConfig) Getenv(key string) string {
+	if value, ok := t.Env[key]; ok {
+		return value
+	}
+	return os.Getenv(key)
+}
+
+// ForTags returns the task as configured for a package with the given tags.
+// Each matching entry in overrides is applied in tag order, replacing only
+// the fields it sets.
+func (t TaskConfig) ForTags(tags []string) TaskConfig {
+	resolved := t
+	resolved.Overrides = nil
+	for _, tag := range tags {
+		override, ok := t.Overrides[tag]
+		if !ok {
+			continue
+		}
+		if override.Command != "" {
+			resolved.Command = override.Command
+		}
+		if override.Inputs != nil {
+			resolved.Inputs = override.Inputs
+		}
+		if override.Outputs != nil {
+			resolved.Outputs = override.Outputs
+		}
+		if override.DependsOn != nil {
+			resolved.DependsOn = override.DependsOn
+		}
+		if override.EnvKeys != nil {
+			resolved.EnvKeys = override.EnvKeys
+		}
+		if override.DotEnv != nil {
+			resolved.DotEnv = override.DotEnv
+		}
+		if override.Docker != nil {
+			resolved.Docker = override.Docker
+		}
+		if override.ToolDependencies != nil {
+			resolved.ToolDependencies = override.ToolDependencies
+		}
+		if override.OutputLogs != "" {
+			resolved.OutputLogs = override.OutputLogs
+		}
+		if override.Cache != nil {
+			resolved.Cache = override.Cache
+		}
+		if override.Retries != 0 {
+			resolved.Retries = override.Retries
+		}
+		if override.Timeout != "" {
+			resolved.Timeout = override.Timeout
+		}
+		if override.ArchiveFormat != "" {
+			resolved.ArchiveFormat = override.ArchiveFormat
+		}
+		if override.StrictOutputs != nil {
+			resolved.StrictOutputs = override.StrictOutputs
+		}
+		if override.CacheFailures != "" {
+			resolved.CacheFailures = override.CacheFailures
+		}
+	}
+	return resolved
+}
+
+// TimeoutDuration parses the task's timeout, returning zero when none is set.
+func (t TaskConfig) TimeoutDuration() (time.Duration, error) {
+	value := strings.TrimSpace(t.Timeout)
+	if value == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q (expected a positive duration such as \"10m\")", t.Timeout)
+	}
+	return timeout, nil
+}
+
+// FailureTTL parses cache_failures, returning zero when failures are not
+// cached.
+func (t TaskConfig) FailureTTL() (time.Duration, error) {
+	value := strings.TrimSpace(t.CacheFailures)
+	if value == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid cache_failures %q (expected a positive duration such as \"15m\")", t.CacheFailures)
+	}
+	return ttl, nil
+}
+
+// CacheEnabled reports whether the task's outputs may be restored from and
+// saved to the cache. Tasks are cacheable unless they set `cache: false`.
+func (t TaskConfig) CacheEnabled() bool {
+	return t.Cache == nil || *t.Cache
+}
+
+const (
+	OutputLogsFull       = "full"
+	OutputLogsErrorsOnly = "errors-only"
+	OutputLogsHashOnly   = "hash-only"
+	OutputLogsNone       = "none"
+)
+
+const (
+	InputDiscoveryGlob = "glob"
+	InputDiscoveryGit  = "git"
+)
+
+const (
+	HashAlgorithmSHA256 = "sha256"
+	HashAlgorithmXXHash = "xxhash"
+)
+
+const (
+	ArchiveFormatZip     = "zip"
+	ArchiveFormatTarZstd = "tar.zst"
+)
+
+const (
+	RestoreCopy     = "copy"
+	RestoreReflink  = "reflink"
+	RestoreHardlink = "hardlink"
+)
+
+func ValidRestoreMode(mode string) bool {
+	return mode == "" || mode == RestoreCopy || mode == RestoreReflink || mode == RestoreHardlink
+}
+
+func ValidArchiveFormat(format string) bool {
+	return format == "" || format == ArchiveFormatZip || format == ArchiveFormatTarZstd
+}
+
+// ArchiveFormatFor returns the artifact format for a task, falling back to
+// the top-level archive_format and then zip.
+func (c *Config) ArchiveFormatFor(task TaskConfig) string {
+	if task.ArchiveFormat != "" {
+		return task.ArchiveFormat
+	}
+	if c.ArchiveFormat != "" {
+		return c.ArchiveFormat
+	}
+	return ArchiveFormatZip
+}
+
+// StrictOutputsFor reports whether a task fails when its outputs match
+// nothing, falling back to the top-level strict_outputs.
+func (c *Config) StrictOutputsFor(task TaskConfig) bool {
+	if task.StrictOutputs != nil {
+		return *task.StrictOutputs
+	}
+	return c.StrictOutputs
+}
+
+func ValidHashAlgorithm(name string) bool {
+	return name == "" || name == HashAlgorithmSHA256 || name == HashAlgorithmXXHash
+}
+
+func ValidInputDiscovery(mode string) bool {
+	return mode == "" || mode == InputDiscoveryGlob || mode == InputDiscoveryGit
+}
+
+func ValidOutputLogs(mode string) bool {
+	switch mode {
+	case OutputLogsFull, OutputLogsErrorsOnly, OutputLogsHashOnly, OutputLogsNone:
+		return true
+	}
+	return false
 }
 
 func Load() (*Config, error) {
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestTaskInputsDistinguishMissingFromEmpty(t *testing.T) {
+	var cfg Config
+	require.NoError(t, yaml.Unmarshal([]byte("pipeline:\n  build:\n    command: make\n  lint:\n    command: lint\n    inputs: []\n"), &cfg))
+
+	assert.Nil(t, cfg.Pipeline["build"].Inputs, "missing inputs fall back to the package's files")
+	assert.NotNil(t, cfg.Pipeline["lint"].Inputs, "an explicit empty list hashes no files")
+	assert.Empty(t, cfg.Pipeline["lint"].Inputs)
+}
+
+func TestRemoteCachesListsRemoteFirst(t *testing.T) {
+	var cfg Config
+	require.NoError(t, yaml.Unmarshal([]byte("remote:\n  enabled: true\n  url: http://saas\nremotes:\n  - name: lan\n    url: http://cache.lan\n    read_only: true\n  - url: http://backup\n"), &cfg))
+
+	remotes := cfg.RemoteCaches()
+	require.Len(t, remotes, 3)
+	assert.Equal(t, "remote", remotes[0].Name)
+	assert.Equal(t, "lan", remotes[1].Name)
+	assert.True(t, remotes[1].ReadOnly)
+	assert.Equal(t, "remotes[1]", remotes[2].Name)
+
+	cfg.Remote.Enabled = false
+	assert.Len(t, cfg.RemoteCaches(), 2)
+}
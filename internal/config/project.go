@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SetProjectID returns data with the top-level project_id set to id, editing
+// only that line so comments and formatting elsewhere are preserved. An
+// empty id removes the field.
+func SetProjectID(data []byte, id string) ([]byte, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+
+	lines := strings.Split(string(data), "\n")
+	if len(root.Content) == 0 {
+		if id == "" {
+			return data, nil
+		}
+		return []byte(projectIDLine(id, "") + "\n" + string(data)), nil
+	}
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("parse config: top level is not a mapping")
+	}
+
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		key, value := doc.Content[i], doc.Content[i+1]
+		if key.Value != "project_id" {
+			continue
+		}
+		if value.Kind != yaml.ScalarNode || value.Line != key.Line {
+			return nil, fmt.Errorf("line %d: project_id must be a single-line value", key.Line)
+		}
+		index := key.Line - 1
+		if id == "" {
+			lines = append(lines[:index], lines[index+1:]...)
+		} else {
+			lines[index] = projectIDLine(id, value.LineComment)
+		}
+		return []byte(strings.Join(lines, "\n")), nil
+	}
+
+	if id == "" {
+		return data, nil
+	}
+	// Keep the conventional order by placing project_id right after version.
+	index := doc.Content[0].Line - 1
+	if version := mappingValue(doc, "version"); version != nil && version.Kind == yaml.ScalarNode {
+		index = version.Line
+	}
+	lines = append(lines[:index], append([]string{projectIDLine(id, "")}, lines[index:]...)...)
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+func projectIDLine(id, comment string) string {
+	encoded, _ := yaml.Marshal(id)
+	line := "project_id: " + strings.TrimSpace(string(encoded))
+	if comment != "" {
+		line += " " + comment
+	}
+	return line
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetProjectID(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		id    string
+		want  string
+	}{
+		{
+			name:  "replace keeps comments",
+			input: "# team config\nversion: 1\nproject_id: old # set by link\nremote:\n  enabled: false\n",
+			id:    "acme-web",
+			want:  "# team config\nversion: 1\nproject_id: acme-web # set by link\nremote:\n  enabled: false\n",
+		},
+		{
+			name:  "insert after version",
+			input: "# team config\nversion: 1\n\npipeline: {}\n",
+			id:    "acme-web",
+			want:  "# team config\nversion: 1\nproject_id: acme-web\n\npipeline: {}\n",
+		},
+		{
+			name:  "insert without version",
+			input: "# team config\npipeline: {}\n",
+			id:    "acme-web",
+			want:  "# team config\nproject_id: acme-web\npipeline: {}\n",
+		},
+		{
+			name:  "quotes ids that need it",
+			input: "version: 1\n",
+			id:    "123",
+			want:  "version: 1\nproject_id: \"123\"\n",
+		},
+		{
+			name:  "empty id removes the field",
+			input: "version: 1\nproject_id: acme-web\npipeline: {}\n",
+			id:    "",
+			want:  "version: 1\npipeline: {}\n",
+		},
+		{
+			name:  "empty id without field is a no-op",
+			input: "version: 1\n",
+			id:    "",
+			want:  "version: 1\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := SetProjectID([]byte(tc.input), tc.id)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, string(got))
+		})
+	}
+}
+
+func TestSetProjectIDRejectsMultilineValue(t *testing.T) {
+	_, err := SetProjectID([]byte("project_id:\n  nested: true\n"), "acme-web")
+	assert.Error(t, err)
+}
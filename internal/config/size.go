@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  float64
+}{
+	{"tib", 1 << 40}, {"tb", 1 << 40}, {"t", 1 << 40},
+	{"gib", 1 << 30}, {"gb", 1 << 30}, {"g", 1 << 30},
+	{"mib", 1 << 20}, {"mb", 1 << 20}, {"m", 1 << 20},
+	{"kib", 1 << 10}, {"kb", 1 << 10}, {"k", 1 << 10},
+	{"b", 1},
+}
+
+// ParseSize parses sizes such as "500MB", "1.5GiB" or "2048". Units are
+// binary, so 1GB and 1GiB are both 1<<30 bytes.
+func ParseSize(value string) (int64, error) {
+	trimmed := strings.ToLower(strings.TrimSpace(value))
+	if trimmed == "" {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+
+	multiplier := 1.0
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(trimmed, unit.suffix) {
+			trimmed = strings.TrimSpace(strings.TrimSuffix(trimmed, unit.suffix))
+			multiplier = unit.bytes
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(trimmed, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q (expected a value such as \"500MB\" or \"5GB\")", value)
+	}
+	return int64(number * multiplier), nil
+}
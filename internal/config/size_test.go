@@ -0,0 +1,58 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"2048":   2048,
+		"10b":    10,
+		"500MB":  500 << 20,
+		"5GB":    5 << 30,
+		"1.5GiB": 3 << 29,
+		"64k":    64 << 10,
+		" 1 TB ": 1 << 40,
+	}
+	for input, want := range cases {
+		got, err := ParseSize(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	for _, input := range []string{"", "GB", "-1GB", "five gigs"} {
+		_, err := ParseSize(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestLocalCacheMaxBytesPrefersEnv(t *testing.T) {
+	cfg := &Config{Cache: LocalCacheConfig{MaxSize: "1GB"}}
+
+	t.Setenv("VELOCITY_CACHE_MAX_SIZE", "")
+	size, err := cfg.LocalCacheMaxBytes()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<30), size)
+
+	t.Setenv("VELOCITY_CACHE_MAX_SIZE", "2MB")
+	size, err = cfg.LocalCacheMaxBytes()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2<<20), size)
+
+	t.Setenv("VELOCITY_CACHE_MAX_SIZE", "lots")
+	_, err = cfg.LocalCacheMaxBytes()
+	assert.Error(t, err)
+}
+
+func TestCacheVersionPrefersEnv(t *testing.T) {
+	cfg := &Config{Cache: LocalCacheConfig{Version: " 2 "}}
+
+	t.Setenv("VELOCITY_CACHE_VERSION", "")
+	assert.Equal(t, "2", cfg.CacheVersion())
+
+	t.Setenv("VELOCITY_CACHE_VERSION", "incident-42")
+	assert.Equal(t, "incident-42", cfg.CacheVersion())
+}
@@ -0,0 +1,576 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Issue is a single problem found while validating velocity.yml.
+type Issue struct {
+	Line     int
+	Column   int
+	Severity string
+	Message  string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%d:%d: %s: %s", i.Line, i.Column, i.Severity, i.Message)
+}
+
+// ValidateFile reads and validates the config at path.
+func ValidateFile(path string) ([]Issue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	return Validate(data), nil
+}
+
+// Validate checks raw velocity.yml contents for unknown fields, undefined
+// depends_on references, cyclic pipelines, inputs that overlap outputs and
+// environment variables that will expand to nothing. Issues are sorted by
+// position.
+func Validate(data []byte) []Issue {
+	var issues []Issue
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return []Issue{yamlErrorIssue(err)}
+	}
+	if len(root.Content) == 0 {
+		return []Issue{{Line: 1, Column: 1, Severity: SeverityError, Message: "config is empty"}}
+	}
+	doc := root.Content[0]
+
+	issues = append(issues, checkFields(doc, reflect.TypeOf(Config{}), "")...)
+
+	var cfg Config
+	if err := doc.Decode(&cfg); err != nil {
+		issues = append(issues, yamlErrorIssue(err))
+	}
+
+	if pipeline := mappingValue(doc, "pipeline"); pipeline != nil && pipeline.Kind == yaml.MappingNode {
+		issues = append(issues, checkPipeline(pipeline, cfg.Pipeline)...)
+	}
+	if algorithm := mappingValue(doc, "hash_algorithm"); algorithm != nil && !ValidHashAlgorithm(algorithm.Value) {
+		issues = append(issues, Issue{Line: algorithm.Line, Column: algorithm.Column, Severity: SeverityError,
+			Message: fmt.Sprintf("invalid hash_algorithm %q (expected sha256 or xxhash)", algorithm.Value)})
+	}
+	if remote := mappingValue(doc, "remote"); remote != nil {
+		// Keys usually come from the environment; those are checked at run
+		// time once expanded.
+		if keyNode := mappingValue(remote, "encryption_key"); keyNode != nil && strings.TrimSpace(keyNode.Value) != "" && !strings.Contains(keyNode.Value, "$") {
+			if _, err := ParseEncryptionKey(keyNode.Value); err != nil {
+				issues = append(issues, Issue{Line: keyNode.Line, Column: keyNode.Column, Severity: SeverityError,
+					Message: fmt.Sprintf("invalid remote.encryption_key: %v", err)})
+			}
+		}
+		if ttlNode := mappingValue(remote, "miss_ttl"); ttlNode != nil {
+			if _, err := (RemoteConfig{MissTTL: ttlNode.Value}).MissDuration(); err != nil {
+				issues = append(issues, Issue{Line: ttlNode.Line, Column: ttlNode.Column, Severity: SeverityError,
+					Message: err.Error()})
+			}
+		}
+		if transfersNode := mappingValue(remote, "transfers"); transfersNode != nil {
+			if n, err := strconv.Atoi(transfersNode.Value); err != nil || n < 0 {
+				issues = append(issues, Issue{Line: transfersNode.Line, Column: transfersNode.Column, Severity: SeverityError,
+					Message: fmt.Sprintf("invalid remote.transfers %q (expected a non-negative number)", transfersNode.Value)})
+			}
+		}
+		if bucket := mappingValue(remote, "bucket"); bucket != nil {
+			issues = append(issues, checkBucket(remote, bucket, "remote")...)
+		}
+		if retry := mappingValue(remote, "retry"); retry != nil {
+			if attempts := mappingValue(retry, "attempts"); attempts != nil {
+				if n, err := strconv.Atoi(attempts.Value); err != nil || n < 0 {
+					issues = append(issues, Issue{Line: attempts.Line, Column: attempts.Column, Severity: SeverityError,
+						Message: fmt.Sprintf("invalid remote.retry.attempts %q (expected a non-negative number)", attempts.Value)})
+				}
+			}
+			for _, field := range []string{"backoff", "max_backoff"} {
+				if node := mappingValue(retry, field); node != nil {
+					if _, err := parseRetryDelay(field, node.Value); err != nil {
+						issues = append(issues, Issue{Line: node.Line, Column: node.Column, Severity: SeverityError,
+							Message: err.Error()})
+					}
+				}
+			}
+		}
+	}
+	if remotes := mappingValue(doc, "remotes"); remotes != nil && remotes.Kind == yaml.SequenceNode {
+		for i, remote := range remotes.Content {
+			bucket := mappingValue(remote, "bucket")
+			if bucket != nil {
+				issues = append(issues, checkBucket(remote, bucket, fmt.Sprintf("remotes[%d]", i))...)
+			} else if url := mappingValue(remote, "url"); url == nil || strings.TrimSpace(url.Value) == "" {
+				issues = append(issues, Issue{Line: remote.Line, Column: remote.Column, Severity: SeverityError,
+					Message: fmt.Sprintf("remotes[%d] has no url or bucket", i)})
+			}
+		}
+	}
+	if format := mappingValue(doc, "archive_format"); format != nil && !ValidArchiveFormat(format.Value) {
+		issues = append(issues, Issue{Line: format.Line, Column: format.Column, Severity: SeverityError,
+			Message: fmt.Sprintf("invalid archive_format %q (expected zip or tar.zst)", format.Value)})
+	}
+	if mode := mappingValue(mappingValue(doc, "cache"), "restore"); mode != nil && !ValidRestoreMode(mode.Value) {
+		issues = append(issues, Issue{Line: mode.Line, Column: mode.Column, Severity: SeverityError,
+			Message: fmt.Sprintf("invalid cache.restore %q (expected copy, reflink or hardlink)", mode.Value)})
+	}
+	if mode := mappingValue(doc, "input_discovery"); mode != nil && !ValidInputDiscovery(mode.Value) {
+		issues = append(issues, Issue{Line: mode.Line, Column: mode.Column, Severity: SeverityError,
+			Message: fmt.Sprintf("invalid input_discovery %q (expected glob or git)", mode.Value)})
+	}
+	if keysNode := mappingValue(doc, "global_env"); keysNode != nil && keysNode.Kind == yaml.SequenceNode {
+		for _, keyNode := range keysNode.Content {
+			if !doublestar.ValidatePattern(keyNode.Value) {
+				issues = append(issues, Issue{Line: keyNode.Line, Column: keyNode.Column, Severity: SeverityError,
+					Message: fmt.Sprintf("invalid global_env pattern %q", keyNode.Value)})
+			}
+		}
+	}
+	if maxSize := mappingValue(mappingValue(doc, "cache"), "max_size"); maxSize != nil && strings.TrimSpace(maxSize.Value) != "" {
+		if _, err := ParseSize(maxSize.Value); err != nil {
+			issues = append(issues, Issue{Line: maxSize.Line, Column: maxSize.Column, Severity: SeverityError,
+				Message: fmt.Sprintf("cache.max_size: %v", err)})
+		}
+	}
+	issues = append(issues, checkEnvReferences(data)...)
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Line != issues[j].Line {
+			return issues[i].Line < issues[j].Line
+		}
+		return issues[i].Column < issues[j].Column
+	})
+	return issues
+}
+
+var yamlLinePattern = regexp.MustCompile(`line (\d+)`)
+
+func yamlErrorIssue(err error) Issue {
+	issue := Issue{Line: 1, Column: 1, Severity: SeverityError, Message: err.Error()}
+	if match := yamlLinePattern.FindStringSubmatch(err.Error()); match != nil {
+		fmt.Sscanf(match[1], "%d", &issue.Line)
+	}
+	return issue
+}
+
+// checkBucket reports a bucket without a name and one configured alongside
+// a server url; where names the remote.
+func checkBucket(remote, bucket *yaml.Node, where string) []Issue {
+	var issues []Issue
+	if name := mappingValue(bucket, "name"); name == nil || strings.TrimSpace(name.Value) == "" {
+		issues = append(issues, Issue{Line: bucket.Line, Column: bucket.Column, Severity: SeverityError,
+			Message: fmt.Sprintf("%s.bucket has no name", where)})
+	}
+	if url := mappingValue(remote, "url"); url != nil && strings.TrimSpace(url.Value) != "" {
+		issues = append(issues, Issue{Line: url.Line, Column: url.Column, Severity: SeverityError,
+			Message: fmt.Sprintf("%s has both a url and a bucket; use one", where)})
+	}
+	if mode := mappingValue(bucket, "retry_mode"); mode != nil && mode.Value != "standard" && mode.Value != "adaptive" {
+		issues = append(issues, Issue{Line: mode.Line, Column: mode.Column, Severity: SeverityError,
+			Message: fmt.Sprintf("invalid %s.bucket.retry_mode %q (expected standard or adaptive)", where, mode.Value)})
+	}
+	if attempts := mappingValue(bucket, "max_attempts"); attempts != nil {
+		if n, err := strconv.Atoi(attempts.Value); err != nil || n < 0 {
+			issues = append(issues, Issue{Line: attempts.Line, Column: attempts.Column, Severity: SeverityError,
+				Message: fmt.Sprintf("invalid %s.bucket.max_attempts %q (expected a non-negative number)", where, attempts.Value)})
+		}
+	}
+	if prefix := mappingValue(bucket, "prefix"); prefix != nil {
+		if _, err := (BucketConfig{Prefix: prefix.Value}).CleanPrefix(); err != nil {
+			issues = append(issues, Issue{Line: prefix.Line, Column: prefix.Column, Severity: SeverityError,
+				Message: err.Error()})
+		}
+	}
+	if timeout := mappingValue(bucket, "timeout"); timeout != nil {
+		if _, err := (BucketConfig{Timeout: timeout.Value}).TimeoutDuration(); err != nil {
+			issues = append(issues, Issue{Line: timeout.Line, Column: timeout.Column, Severity: SeverityError,
+				Message: err.Error()})
+		}
+	}
+	return issues
+}
+
+// checkFields reports mapping keys that have no matching yaml tag on typ,
+// recursing into nested structs and maps of structs.
+func checkFields(node *yaml.Node, typ reflect.Type, where string) []Issue {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if node.Kind == yaml.SequenceNode && typ.Kind() == reflect.Slice {
+		var issues []Issue
+		for i, item := range node.Content {
+			issues = append(issues, checkFields(item, typ.Elem(), fmt.Sprintf("%s[%d]", where, i))...)
+		}
+		return issues
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var issues []Issue
+	switch typ.Kind() {
+	case reflect.Struct:
+		fields := yamlFields(typ)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, ok := fields[key.Value]
+			if !ok {
+				issues = append(issues, Issue{
+					Line:     key.Line,
+					Column:   key.Column,
+					Severity: SeverityError,
+					Message:  fmt.Sprintf("unknown field %q%s%s", key.Value, describeLocation(where), suggestField(key.Value, fields)),
+				})
+				continue
+			}
+			issues = append(issues, checkFields(value, field.Type, joinLocation(where, key.Value))...)
+		}
+	case reflect.Map:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			issues = append(issues, checkFields(value, typ.Elem(), joinLocation(where, key.Value))...)
+		}
+	}
+	return issues
+}
+
+func yamlFields(typ reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// suggestField returns a hint for keys that differ from a known field only
+// in case or separators, e.g. dependsOn for depends_on.
+func suggestField(key string, fields map[string]reflect.StructField) string {
+	normalize := func(s string) string {
+		return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(s))
+	}
+	for name := range fields {
+		if normalize(name) == normalize(key) {
+			return fmt.Sprintf(" (did you mean %q?)", name)
+		}
+	}
+	return ""
+}
+
+func joinLocation(where, key string) string {
+	if where == "" {
+		return key
+	}
+	return where + "." + key
+}
+
+func describeLocation(where string) string {
+	if where == "" {
+		return ""
+	}
+	return " in " + where
+}
+
+// LocateDependsOn returns the line of the depends_on entry ref of task in
+// raw velocity.yml contents, looking in tag overrides too. It returns zero
+// when the entry is not found.
+func LocateDependsOn(data []byte, task, ref string) int {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil || len(root.Content) == 0 {
+		return 0
+	}
+	taskNode := mappingValue(mappingValue(root.Content[0], "pipeline"), task)
+	candidates := []*yaml.Node{taskNode}
+	if overrides := mappingValue(taskNode, "overrides"); overrides != nil && overrides.Kind == yaml.MappingNode {
+		for i := 1; i < len(overrides.Content); i += 2 {
+			candidates = append(candidates, overrides.Content[i])
+		}
+	}
+	for _, candidate := range candidates {
+		deps := mappingValue(candidate, "depends_on")
+		if deps == nil || deps.Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, dep := range deps.Content {
+			if strings.TrimSpace(dep.Value) == ref {
+				return dep.Line
+			}
+		}
+	}
+	return 0
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func checkPipeline(node *yaml.Node, pipeline map[string]TaskConfig) []Issue {
+	var issues []Issue
+	keyNodes := make(map[string]*yaml.Node, len(pipeline))
+	edges := make(map[string][]string, len(pipeline))
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		name := key.Value
+		keyNodes[name] = key
+		task := pipeline[name]
+
+		if depsNode := mappingValue(value, "depends_on"); depsNode != nil && depsNode.Kind == yaml.SequenceNode {
+			for _, depNode := range depsNode.Content {
+				dep := strings.TrimSpace(depNode.Value)
+				target := strings.TrimPrefix(dep, "^")
+				pkg, crossTask, crossPackage := SplitTaskRef(dep)
+				if crossPackage {
+					target = crossTask
+				}
+				if target == "" || (crossPackage && pkg == "") {
+					issues = append(issues, Issue{Line: depNode.Line, Column: depNode.Column, Severity: SeverityError,
+						Message: fmt.Sprintf("empty depends_on entry in pipeline.%s", name)})
+					continue
+				}
+				if strings.HasPrefix(dep, "^") && strings.Contains(target, "#") {
+					issues = append(issues, Issue{Line: depNode.Line, Column: depNode.Column, Severity: SeverityError,
+						Message: fmt.Sprintf("pipeline.%s depends on %q: use either ^task or package#task", name, dep)})
+					continue
+				}
+				if _, ok := pipeline[target]; !ok {
+					issues = append(issues, Issue{Line: depNode.Line, Column: depNode.Column, Severity: SeverityError,
+						Message: fmt.Sprintf("pipeline.%s depends on %q, which is not defined in pipeline", name, dep)})
+					continue
+				}
+				// Cycles through other packages depend on the workspace and
+				// are reported when the task graph is built.
+				if !strings.HasPrefix(dep, "^") && !crossPackage {
+					edges[name] = append(edges[name], target)
+				}
+			}
+		}
+
+		if keysNode := mappingValue(value, "env_keys"); keysNode != nil && keysNode.Kind == yaml.SequenceNode {
+			for _, keyNode := range keysNode.Content {
+				if !doublestar.ValidatePattern(keyNode.Value) {
+					issues = append(issues, Issue{Line: keyNode.Line, Column: keyNode.Column, Severity: SeverityError,
+						Message: fmt.Sprintf("pipeline.%s: invalid env_keys pattern %q", name, keyNode.Value)})
+				}
+			}
+		}
+
+		if strings.TrimSpace(task.Command) == "" {
+			issues = append(issues, Issue{Line: key.Line, Column: key.Column, Severity: SeverityError,
+				Message: fmt.Sprintf("pipeline.%s has no command", name)})
+		}
+
+		if _, err := task.TimeoutDuration(); err != nil {
+			line, column := key.Line, key.Column
+			if timeoutNode := mappingValue(value, "timeout"); timeoutNode != nil {
+				line, column = timeoutNode.Line, timeoutNode.Column
+			}
+			issues = append(issues, Issue{Line: line, Column: column, Severity: SeverityError,
+				Message: fmt.Sprintf("pipeline.%s: %v", name, err)})
+		}
+
+		if _, err := task.FailureTTL(); err != nil {
+			line, column := key.Line, key.Column
+			if ttlNode := mappingValue(value, "cache_failures"); ttlNode != nil {
+				line, column = ttlNode.Line, ttlNode.Column
+			}
+			issues = append(issues, Issue{Line: line, Column: column, Severity: SeverityError,
+				Message: fmt.Sprintf("pipeline.%s: %v", name, err)})
+		}
+
+		if task.OutputLogs != "" && !ValidOutputLogs(task.OutputLogs) {
+			line, column := key.Line, key.Column
+			if logsNode := mappingValue(value, "output_logs"); logsNode != nil {
+				line, column = logsNode.Line, logsNode.Column
+			}
+			issues = append(issues, Issue{Line: line, Column: column, Severity: SeverityError,
+				Message: fmt.Sprintf("pipeline.%s: invalid output_logs %q (expected full, errors-only, hash-only or none)", name, task.OutputLogs)})
+		}
+
+		if !ValidArchiveFormat(task.ArchiveFormat) {
+			line, column := key.Line, key.Column
+			if formatNode := mappingValue(value, "archive_format"); formatNode != nil {
+				line, column = formatNode.Line, formatNode.Column
+			}
+			issues = append(issues, Issue{Line: line, Column: column, Severity: SeverityError,
+				Message: fmt.Sprintf("pipeline.%s: invalid archive_format %q (expected zip or tar.zst)", name, task.ArchiveFormat)})
+		}
+
+		issues = append(issues, checkOverlap(name, value, task)...)
+	}
+
+	for _, cycle := range findCycles(edges) {
+		start := keyNodes[cycle[0]]
+		issues = append(issues, Issue{Line: start.Line, Column: start.Column, Severity: SeverityError,
+			Message: fmt.Sprintf("cyclic depends_on: %s", strings.Join(cycle, " -> "))})
+	}
+	return issues
+}
+
+// findCycles returns each cycle in the same-package dependency graph once,
+// as a path that ends where it starts.
+func findCycles(edges map[string][]string) [][]string {
+	names := make([]string, 0, len(edges))
+	for name := range edges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var stack []string
+	var cycles [][]string
+
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, dep := range edges[name] {
+			switch state[dep] {
+			case unvisited:
+				visit(dep)
+			case visiting:
+				for i, entry := range stack {
+					if entry == dep {
+						cycle := append(append([]string{}, stack[i:]...), dep)
+						cycles = append(cycles, cycle)
+						break
+					}
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+	}
+
+	for _, name := range names {
+		if state[name] == unvisited {
+			visit(name)
+		}
+	}
+	return cycles
+}
+
+// checkOverlap warns when an input glob can match files inside a declared
+// output, which makes the cache key change every time the task runs.
+func checkOverlap(name string, node *yaml.Node, task TaskConfig) []Issue {
+	inputsNode := mappingValue(node, "inputs")
+	if inputsNode == nil || inputsNode.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	var issues []Issue
+	for _, inputNode := range inputsNode.Content {
+		input := strings.TrimSpace(inputNode.Value)
+		if input == "" || strings.HasPrefix(input, "!") {
+			continue
+		}
+		for _, output := range task.Outputs {
+			if strings.HasPrefix(output, "!") {
+				continue
+			}
+			if globsOverlap(input, output) {
+				issues = append(issues, Issue{Line: inputNode.Line, Column: inputNode.Column, Severity: SeverityWarning,
+					Message: fmt.Sprintf("pipeline.%s: input %q overlaps output %q", name, input, output)})
+			}
+		}
+	}
+	return issues
+}
+
+func globsOverlap(input, output string) bool {
+	inputBase := staticPrefix(input)
+	outputBase := staticPrefix(output)
+	if inputBase == "" || outputBase == "" {
+		return false
+	}
+	return withinDir(inputBase, outputBase) || (strings.Contains(input, "**") && withinDir(outputBase, inputBase))
+}
+
+// staticPrefix returns the leading directory of a glob that contains no
+// pattern characters.
+func staticPrefix(pattern string) string {
+	pattern = path.Clean(strings.TrimPrefix(strings.ReplaceAll(pattern, "\\", "/"), "./"))
+	parts := strings.Split(pattern, "/")
+	static := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if strings.ContainsAny(part, "*?[{") {
+			break
+		}
+		static = append(static, part)
+	}
+	prefix := strings.Join(static, "/")
+	if prefix == "." {
+		return ""
+	}
+	return prefix
+}
+
+func withinDir(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+var envReferencePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// checkEnvReferences warns about ${VAR} references whose variable is unset,
+// since Load expands them to empty strings.
+func checkEnvReferences(data []byte) []Issue {
+	var issues []Issue
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if comment := strings.Index(text, "#"); comment >= 0 && (comment == 0 || text[comment-1] == ' ') {
+			text = text[:comment]
+		}
+		for _, match := range envReferencePattern.FindAllStringSubmatchIndex(text, -1) {
+			name := ""
+			if match[2] >= 0 {
+				name = text[match[2]:match[3]]
+			} else {
+				name = text[match[4]:match[5]]
+			}
+			if _, ok := os.LookupEnv(name); ok {
+				continue
+			}
+			issues = append(issues, Issue{Line: line, Column: match[0] + 1, Severity: SeverityWarning,
+				Message: fmt.Sprintf("environment variable %s is not set and will expand to an empty string", name)})
+		}
+	}
+	return issues
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateReportsProblemsWithLines(t *testing.T) {
+	t.Setenv("VC_SET_TOKEN", "secret")
+
+	data := []byte(`version: 1
+remote:
+  enabled: true
+  url: "${VC_UNSET_URL}"
+  token: "${VC_SET_TOKEN}"
+pipeline:
+  build:
+    command: "npm run build"
+    inputs: ["src/**", "dist/**"]
+    outputs: ["dist"]
+    dependsOn: ["^build"]
+  test:
+    command: "npm test"
+    depends_on: ["lint", "deploy"]
+  lint:
+    command: "npm run lint"
+    depends_on: ["test"]
+`)
+
+	issues := Validate(data)
+	messages := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		messages = append(messages, issue.String())
+	}
+	joined := strings.Join(messages, "\n")
+
+	assert.Contains(t, joined, `4:9: warning: environment variable VC_UNSET_URL is not set`)
+	assert.NotContains(t, joined, "VC_SET_TOKEN")
+	assert.Contains(t, joined, `9:24: warning: pipeline.build: input "dist/**" overlaps output "dist"`)
+	assert.Contains(t, joined, `11:5: error: unknown field "dependsOn" in pipeline.build (did you mean "depends_on"?)`)
+	assert.Contains(t, joined, `14:26: error: pipeline.test depends on "deploy", which is not defined in pipeline`)
+	assert.Contains(t, joined, `15:3: error: cyclic depends_on: lint -> test -> lint`)
+	assert.NotContains(t, joined, `"src/**" overlaps`)
+}
+
+func TestValidateAcceptsCleanConfig(t *testing.T) {
+	data := []byte(`version: 1
+pipeline:
+  build:
+    command: "go build ./..."
+    inputs: ["**/*.go"]
+    outputs: ["bin/"]
+    depends_on: ["^build"]
+    timeout: "10m"
+`)
+	assert.Empty(t, Validate(data))
+}
+
+func TestValidateReportsSyntaxErrors(t *testing.T) {
+	issues := Validate([]byte("pipeline:\n  build:\n    command: [\n"))
+	require.Len(t, issues, 1)
+	assert.Equal(t, SeverityError, issues[0].Severity)
+}
+
+func TestValidateReportsInvalidCacheMaxSize(t *testing.T) {
+	issues := Validate([]byte("version: 1\ncache:\n  max_size: huge\npipeline:\n  build:\n    command: make\n"))
+	require.Len(t, issues, 1)
+	assert.Equal(t, 3, issues[0].Line)
+	assert.Contains(t, issues[0].Message, "cache.max_size")
+}
+
+func TestValidateReportsInvalidEnvKeyPattern(t *testing.T) {
+	issues := Validate([]byte("version: 1\npipeline:\n  build:\n    command: make\n    env_keys: [NODE_ENV, \"NEXT_PUBLIC_[\"]\n"))
+	require.Len(t, issues, 1)
+	assert.Equal(t, 5, issues[0].Line)
+	assert.Contains(t, issues[0].Message, "env_keys")
+}
+
+func TestValidateReportsUnknownHashAlgorithm(t *testing.T) {
+	issues := Validate([]byte("version: 1\nhash_algorithm: md5\npipeline:\n  build:\n    command: make\n"))
+	require.Len(t, issues, 1)
+	assert.Equal(t, 2, issues[0].Line)
+	assert.Contains(t, issues[0].Message, "hash_algorithm")
+}
+
+func TestValidateReportsInvalidEncryptionKey(t *testing.T) {
+	issues := Validate([]byte("version: 1\nremote:\n  encryption_key: c2hvcnQ=\npipeline:\n  build:\n    command: make\n"))
+	require.Len(t, issues, 1)
+	assert.Equal(t, 3, issues[0].Line)
+	assert.Contains(t, issues[0].Message, "32 bytes")
+
+	t.Setenv("VELOCITY_KEY", "from-the-environment")
+	issues = Validate([]byte("version: 1\nremote:\n  encryption_key: ${VELOCITY_KEY}\npipeline:\n  build:\n    command: make\n"))
+	assert.Empty(t, issues)
+}
+
+func TestValidateReportsInvalidCacheFailures(t *testing.T) {
+	issues := Validate([]byte("version: 1\npipeline:\n  build:\n    command: make\n    cache_failures: forever\n"))
+	require.Len(t, issues, 1)
+	assert.Equal(t, 5, issues[0].Line)
+	assert.Contains(t, issues[0].Message, "cache_failures")
+}
+
+func TestValidateChecksCrossPackageDependencies(t *testing.T) {
+	issues := Validate([]byte("version: 1\npipeline:\n  generate:\n    command: gen\n  build:\n    command: make\n    depends_on: [\"@repo/lib#generate\", \"@repo/lib#deploy\", \"^@repo/lib#generate\"]\n"))
+	require.Len(t, issues, 2)
+	assert.Contains(t, issues[0].Message, `depends on "@repo/lib#deploy", which is not defined in pipeline`)
+	assert.Contains(t, issues[1].Message, "use either ^task or package#task")
+}
+
+func TestValidateReportsUnknownRestoreMode(t *testing.T) {
+	issues := Validate([]byte("version: 1\ncache:\n  restore: symlink\npipeline:\n  build:\n    command: make\n"))
+	require.Len(t, issues, 1)
+	assert.Equal(t, 3, issues[0].Line)
+	assert.Contains(t, issues[0].Message, "cache.restore")
+}
+
+func TestLocateDependsOn(t *testing.T) {
+	data := []byte("version: 1\npipeline:\n  build:\n    command: make\n    depends_on:\n      - lint\n      - test\n    overrides:\n      ci:\n        depends_on: [\"^build\"]\n")
+	assert.Equal(t, 7, LocateDependsOn(data, "build", "test"))
+	assert.Equal(t, 10, LocateDependsOn(data, "build", "^build"))
+	assert.Zero(t, LocateDependsOn(data, "build", "deploy"))
+	assert.Zero(t, LocateDependsOn(data, "test", "build"))
+}
+
+func TestValidateReportsInvalidTransfers(t *testing.T) {
+	issues := Validate([]byte("version: 1\nremote:\n  transfers: -2\npipeline:\n  build:\n    command: make\n"))
+	require.Len(t, issues, 1)
+	assert.Equal(t, 3, issues[0].Line)
+	assert.Contains(t, issues[0].Message, "remote.transfers")
+}
+
+func TestValidateReportsInvalidRetry(t *testing.T) {
+	issues := Validate([]byte("version: 1\nremote:\n  retry:\n    attempts: 5\n    backoff: soon\npipeline:\n  build:\n    command: make\n"))
+	require.Len(t, issues, 1)
+	assert.Equal(t, 5, issues[0].Line)
+	assert.Contains(t, issues[0].Message, "remote.retry.backoff")
+}
+
+func TestValidateReportsRemoteWithoutURL(t *testing.T) {
+	issues := Validate([]byte("version: 1\nremotes:\n  - name: lan\n    url: http://cache.lan\n  - name: saas\n    read_only: true\npipeline:\n  build:\n    command: make\n"))
+	require.Len(t, issues, 1)
+	assert.Equal(t, 5, issues[0].Line)
+	assert.Contains(t, issues[0].Message, "remotes[1]")
+}
+
+func TestValidateReportsRemoteWithURLAndBucket(t *testing.T) {
+	issues := Validate([]byte("version: 1\nremote:\n  url: http://cache\n  bucket:\n    name: builds\npipeline:\n  build:\n    command: make\n"))
+	require.Len(t, issues, 1)
+	assert.Equal(t, 3, issues[0].Line)
+	assert.Contains(t, issues[0].Message, "both a url and a bucket")
+}
+
+func TestValidateReportsInvalidBucketPrefix(t *testing.T) {
+	issues := Validate([]byte("version: 1\nremote:\n  bucket:\n    name: builds\n    prefix: team-a/../team-b\npipeline:\n  build:\n    command: make\n"))
+	require.Len(t, issues, 1)
+	assert.Equal(t, 5, issues[0].Line)
+	assert.Contains(t, issues[0].Message, "invalid bucket.prefix")
+}
+
+func TestValidateReportsInvalidBucketTimeout(t *testing.T) {
+	issues := Validate([]byte("version: 1\nremote:\n  bucket:\n    name: builds\n    retry_mode: standard\n    timeout: soon\npipeline:\n  build:\n    command: make\n"))
+	require.Len(t, issues, 1)
+	assert.Equal(t, 6, issues[0].Line)
+	assert.Contains(t, issues[0].Message, "bucket.timeout")
+}
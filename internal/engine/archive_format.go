@@ -0,0 +1,318 @@
+package engine
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+)
+
+var (
+	zipMagic  = []byte("PK")
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// ArtifactContentType returns the media type of an artifact from its first
+// bytes, so the remote cache can label uploads of either format.
+func ArtifactContentType(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, zstdMagic):
+		return "application/zstd"
+	case bytes.HasPrefix(header, zipMagic):
+		return "application/zip"
+	}
+	return "application/octet-stream"
+}
+
+// ArchiveOptions controls how outputs are archived.
+type ArchiveOptions struct {
+	// Format is "zip" (the default) or "tar.zst".
+	Format string
+	// PreserveMetadata records real modification times and full permission
+	// bits instead of normalizing them.
+	PreserveMetadata bool
+}
+
+// Unless metadata is preserved, archiveModTime is recorded for every entry,
+// and modes are reduced to 0755 or 0644, so identical outputs always produce
+// byte-identical artifacts regardless of when or where they were built.
+// Entries carrying archiveModTime keep the time they are restored at.
+var archiveModTime = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// archiveModeBits are the mode bits restored from an artifact.
+const archiveModeBits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+// entryMetadata returns the modification time and mode to record for info.
+func entryMetadata(info fs.FileInfo, preserve bool) (time.Time, fs.FileMode) {
+	if !preserve {
+		return archiveModTime, archiveMode(info)
+	}
+	mode := info.Mode()
+	if mode&fs.ModeSymlink != 0 {
+		return info.ModTime(), fs.ModeSymlink | 0o777
+	}
+	return info.ModTime(), mode & (fs.ModeDir | archiveModeBits)
+}
+
+func archiveMode(info fs.FileInfo) fs.FileMode {
+	mode := info.Mode()
+	switch {
+	case mode.IsDir():
+		return fs.ModeDir | 0o755
+	case mode&fs.ModeSymlink != 0:
+		return fs.ModeSymlink | 0o777
+	case mode&0o111 != 0:
+		return 0o755
+	}
+	return 0o644
+}
+
+// archiveWriter adds entries to an artifact. Names are slash-separated and
+// directories end in "/".
+type archiveWriter interface {
+	writeDir(name string, info fs.FileInfo) error
+	writeFile(name string, info fs.FileInfo, r io.Reader) error
+	writeSymlink(name string, info fs.FileInfo, target string) error
+	close() error
+}
+
+func newArchiveWriter(w io.Writer, opts ArchiveOptions) (archiveWriter, error) {
+	switch opts.Format {
+	case "", config.ArchiveFormatZip:
+		return &zipArchiveWriter{zw: zip.NewWriter(w), preserve: opts.PreserveMetadata}, nil
+	case config.ArchiveFormatTarZstd:
+		enc, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		return &tarArchiveWriter{zw: enc, tw: tar.NewWriter(enc), preserve: opts.PreserveMetadata}, nil
+	}
+	return nil, fmt.Errorf("unknown archive format %q", opts.Format)
+}
+
+type zipArchiveWriter struct {
+	zw       *zip.Writer
+	preserve bool
+}
+
+func (a *zipArchiveWriter) create(name string, info fs.FileInfo, method uint16) (io.Writer, error) {
+	modTime, mode := entryMetadata(info, a.preserve)
+	header := &zip.FileHeader{Name: name, Method: method, Modified: modTime}
+	header.SetMode(mode)
+	return a.zw.CreateHeader(header)
+}
+
+func (a *zipArchiveWriter) writeDir(name string, info fs.FileInfo) error {
+	_, err := a.create(name, info, zip.Store)
+	return err
+}
+
+func (a *zipArchiveWriter) writeFile(name string, info fs.FileInfo, r io.Reader) error {
+	entry, err := a.create(name, info, zip.Deflate)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, r)
+	return err
+}
+
+// writeSymlink stores the link target as the entry's content, as zip tools
+// do.
+func (a *zipArchiveWriter) writeSymlink(name string, info fs.FileInfo, target string) error {
+	entry, err := a.create(name, info, zip.Store)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(entry, target)
+	return err
+}
+
+func (a *zipArchiveWriter) close() error {
+	return a.zw.Close()
+}
+
+type tarArchiveWriter struct {
+	zw       *zstd.Encoder
+	tw       *tar.Writer
+	preserve bool
+}
+
+func (a *tarArchiveWriter) writeHeader(typeflag byte, name string, info fs.FileInfo, size int64, link string) error {
+	modTime, mode := entryMetadata(info, a.preserve)
+	header := &tar.Header{
+		Typeflag: typeflag,
+		Name:     name,
+		Linkname: link,
+		Size:     size,
+		Mode:     tarMode(mode),
+		ModTime:  modTime,
+	}
+	if a.preserve {
+		// PAX keeps sub-second modification times.
+		header.Format = tar.FormatPAX
+	}
+	return a.tw.WriteHeader(header)
+}
+
+// tarMode returns the tar header mode bits for mode.
+func tarMode(mode fs.FileMode) int64 {
+	bits := int64(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		bits |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		bits |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		bits |= 0o1000
+	}
+	return bits
+}
+
+func (a *tarArchiveWriter) writeDir(name string, info fs.FileInfo) error {
+	return a.writeHeader(tar.TypeDir, name, info, 0, "")
+}
+
+func (a *tarArchiveWriter) writeFile(name string, info fs.FileInfo, r io.Reader) error {
+	if err := a.writeHeader(tar.TypeReg, name, info, info.Size(), ""); err != nil {
+		return err
+	}
+	_, err := io.Copy(a.tw, r)
+	return err
+}
+
+func (a *tarArchiveWriter) writeSymlink(name string, info fs.FileInfo, target string) error {
+	return a.writeHeader(tar.TypeSymlink, name, info, 0, target)
+}
+
+func (a *tarArchiveWriter) close() error {
+	if err := a.tw.Close(); err != nil {
+		a.zw.Close()
+		return err
+	}
+	return a.zw.Close()
+}
+
+// archiveEntry is one entry read back from an artifact.
+type archiveEntry struct {
+	name       string
+	mode       fs.FileMode
+	modTime    time.Time
+	linkTarget string
+	open       func() (io.ReadCloser, error)
+	// source is the file's path when read from an unpacked artifact.
+	source string
+}
+
+// readArchive calls fn for each entry of the artifact at path, in archive
+// order. The format is detected from the file's content, so artifacts
+// written in either format can be restored regardless of configuration.
+// A directory is read as an unpacked artifact.
+func readArchive(path string, fn func(archiveEntry) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err == nil && info.IsDir() {
+		return readTree(path, fn)
+	}
+
+	header := make([]byte, len(zstdMagic))
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	if bytes.HasPrefix(header[:n], zstdMagic) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return readTarZstd(file, fn)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	reader, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return err
+	}
+	for _, f := range reader.File {
+		entry := archiveEntry{name: f.Name, mode: f.Mode(), modTime: f.Modified, open: f.Open}
+		if entry.mode&os.ModeSymlink != 0 {
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("open symlink %s: %w", f.Name, err)
+			}
+			target, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("read symlink %s: %w", f.Name, err)
+			}
+			entry.linkTarget = string(target)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkArchive reports whether the artifact at path can be read to the end,
+// which catches truncated files.
+func checkArchive(path string) error {
+	return readArchive(path, func(entry archiveEntry) error {
+		if entry.open == nil {
+			return nil
+		}
+		rc, err := entry.open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		_, err = io.Copy(io.Discard, rc)
+		return err
+	})
+}
+
+func readTarZstd(r io.Reader, fn func(archiveEntry) error) error {
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+
+	tr := tar.NewReader(dec)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		entry := archiveEntry{name: header.Name, mode: header.FileInfo().Mode(), modTime: header.ModTime}
+		switch header.Typeflag {
+		case tar.TypeDir:
+		case tar.TypeSymlink:
+			entry.linkTarget = header.Linkname
+		case tar.TypeReg:
+			entry.open = func() (io.ReadCloser, error) { return io.NopCloser(tr), nil }
+		default:
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
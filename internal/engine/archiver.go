@@ -1,7 +1,7 @@
 package engine
 
 import (
-	"archive/zip"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -9,32 +9,26 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
 )
 
-func compress(outputs []string, targetZip string, packagePath string) (err error) {
-	if len(outputs) == 0 {
-		return errors.New("compress: no outputs provided")
-	}
+func compress(ctx context.Context, outputs []string, targetZip string, packagePath string) error {
+	return compressArchive(ctx, outputs, targetZip, packagePath, ArchiveOptions{Format: config.ArchiveFormatZip})
+}
 
-	originalWd := ""
-	if strings.TrimSpace(packagePath) != "" {
-		wd, err := os.Getwd()
-		if err != nil {
-			return fmt.Errorf("compress: getwd: %w", err)
-		}
-		if err := os.Chdir(packagePath); err != nil {
-			return fmt.Errorf("compress: chdir to %s: %w", packagePath, err)
-		}
-		originalWd = wd
-		defer func() {
-			if originalWd != "" {
-				_ = os.Chdir(originalWd)
-			}
-		}()
+func compressArchive(ctx context.Context, outputs []string, target string, packagePath string, opts ArchiveOptions) (err error) {
+	spec := parseOutputs(outputs)
+	if len(spec.literals) == 0 && len(spec.globs) == 0 {
+		return errors.New("compress: no outputs provided")
 	}
 
-	absTarget, err := filepath.Abs(targetZip)
+	absTarget, err := filepath.Abs(target)
 	if err != nil {
 		return fmt.Errorf("compress: resolve target path: %w", err)
 	}
@@ -47,6 +41,11 @@ func compress(outputs []string, targetZip string, packagePath string) (err error
 	if err != nil {
 		return fmt.Errorf("compress: create archive: %w", err)
 	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(absTarget)
+		}
+	}()
 	defer func() {
 		closeErr := archiveFile.Close()
 		if err == nil && closeErr != nil {
@@ -54,19 +53,42 @@ func compress(outputs []string, targetZip string, packagePath string) (err error
 		}
 	}()
 
-	writer := zip.NewWriter(archiveFile)
+	writer, err := newArchiveWriter(archiveFile, opts)
+	if err != nil {
+		return fmt.Errorf("compress: %w", err)
+	}
 	defer func() {
-		closeErr := writer.Close()
+		closeErr := writer.close()
 		if err == nil && closeErr != nil {
 			err = fmt.Errorf("compress: finalize archive: %w", closeErr)
 		}
 	}()
 
 	seenBases := make(map[string]struct{}, len(outputs))
+	seenFiles := make(map[string]struct{})
+	addFile := func(name, filePath string) error {
+		if _, ok := seenFiles[name]; ok {
+			return nil
+		}
+		seenFiles[name] = struct{}{}
+		absPath, absErr := filepath.Abs(filePath)
+		if absErr != nil {
+			return absErr
+		}
+		if absPath == absTarget {
+			return nil
+		}
+		info, statErr := os.Stat(filePath)
+		if statErr != nil {
+			return statErr
+		}
+		return addArchiveFile(writer, outputFilesRoot+"/"+name, filePath, info)
+	}
 
-	for _, output := range outputs {
+	for _, output := range spec.literals {
 		cleaned := filepath.Clean(output)
-		info, statErr := os.Stat(cleaned)
+		dir := inPackage(packagePath, cleaned)
+		info, statErr := os.Stat(dir)
 		if statErr != nil {
 			if os.IsNotExist(statErr) {
 				continue
@@ -74,7 +96,17 @@ func compress(outputs []string, targetZip string, packagePath string) (err error
 			return fmt.Errorf("compress: stat %s: %w", cleaned, statErr)
 		}
 		if !info.IsDir() {
-			return fmt.Errorf("compress: %s is not a directory", cleaned)
+			name, nameErr := outputFileName(cleaned)
+			if nameErr != nil {
+				return fmt.Errorf("compress: %w", nameErr)
+			}
+			if spec.excluded(name) {
+				continue
+			}
+			if err := addFile(name, dir); err != nil {
+				return fmt.Errorf("compress: add %s: %w", cleaned, err)
+			}
+			continue
 		}
 
 		base := filepath.Base(cleaned)
@@ -86,10 +118,13 @@ func compress(outputs []string, targetZip string, packagePath string) (err error
 		}
 		seenBases[base] = struct{}{}
 
-		walkErr := filepath.WalkDir(cleaned, func(path string, d fs.DirEntry, walkErr error) error {
+		walkErr := filepath.WalkDir(dir, func(path string, d fs.DirEntry, walkErr error) error {
 			if walkErr != nil {
 				return walkErr
 			}
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
 
 			absPath, absErr := filepath.Abs(path)
 			if absErr != nil {
@@ -102,10 +137,16 @@ func compress(outputs []string, targetZip string, packagePath string) (err error
 				return nil
 			}
 
-			rel, relErr := filepath.Rel(cleaned, path)
+			rel, relErr := filepath.Rel(dir, path)
 			if relErr != nil {
 				return relErr
 			}
+			if rel != "." && spec.excluded(filepath.ToSlash(filepath.Join(cleaned, rel))) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
 
 			archiveName := base
 			if rel != "." {
@@ -118,94 +159,115 @@ func compress(outputs []string, targetZip string, packagePath string) (err error
 				return infoErr
 			}
 
-			if entryInfo.IsDir() {
+			switch {
+			case entryInfo.IsDir():
 				if !strings.HasSuffix(archiveName, "/") {
 					archiveName += "/"
 				}
-
-				header, headerErr := zip.FileInfoHeader(entryInfo)
-				if headerErr != nil {
-					return headerErr
+				return writer.writeDir(archiveName, entryInfo)
+			case entryInfo.Mode()&os.ModeSymlink != 0:
+				linkTarget, linkErr := os.Readlink(path)
+				if linkErr != nil {
+					return linkErr
 				}
-				header.Name = archiveName
-				_, createErr := writer.CreateHeader(header)
-				return createErr
+				return writer.writeSymlink(archiveName, entryInfo, linkTarget)
 			}
+			return addArchiveFile(writer, archiveName, path, entryInfo)
+		})
+		if walkErr != nil {
+			return walkErr
+		}
+	}
 
-			header, headerErr := zip.FileInfoHeader(entryInfo)
-			if headerErr != nil {
-				return headerErr
+	for _, pattern := range spec.globs {
+		matches, globErr := globInPackage(pattern, packagePath)
+		if globErr != nil {
+			return fmt.Errorf("compress: glob %q: %w", pattern, globErr)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
 			}
-			header.Name = archiveName
-			header.Method = zip.Deflate
-
-			archiveEntry, createErr := writer.CreateHeader(header)
-			if createErr != nil {
-				return createErr
+			info, statErr := os.Stat(match)
+			if statErr != nil {
+				if os.IsNotExist(statErr) {
+					continue
+				}
+				return fmt.Errorf("compress: stat %s: %w", match, statErr)
 			}
-
-			file, openErr := os.Open(path)
-			if openErr != nil {
-				return openErr
+			if info.IsDir() {
+				continue
 			}
-
-			_, copyErr := io.Copy(archiveEntry, file)
-			closeErr := file.Close()
-			if copyErr != nil {
-				return copyErr
+			rel := match
+			if strings.TrimSpace(packagePath) != "" {
+				if rel, err = filepath.Rel(packagePath, match); err != nil {
+					return fmt.Errorf("compress: %w", err)
+				}
 			}
-			if closeErr != nil {
-				return closeErr
+			name, nameErr := outputFileName(rel)
+			if nameErr != nil {
+				return fmt.Errorf("compress: %w", nameErr)
+			}
+			if spec.excluded(name) {
+				continue
+			}
+			if err := addFile(name, match); err != nil {
+				return fmt.Errorf("compress: add %s: %w", rel, err)
 			}
-
-			return nil
-		})
-		if walkErr != nil {
-			return walkErr
 		}
 	}
 
 	return nil
 }
 
-func extract(sourceZip string, outputs []string, packagePath string) (err error) {
-	if len(outputs) == 0 {
+func extract(source string, outputs []string, packagePath string) error {
+	spec := parseOutputs(outputs)
+	if len(spec.literals) == 0 && len(spec.globs) == 0 {
 		return errors.New("extract: no outputs provided")
 	}
+	source = filepath.Clean(source)
 
-	originalWd := ""
-	if strings.TrimSpace(packagePath) != "" {
-		wd, err := os.Getwd()
-		if err != nil {
-			return fmt.Errorf("extract: getwd: %w", err)
-		}
-		if err := os.Chdir(packagePath); err != nil {
-			return fmt.Errorf("extract: chdir to %s: %w", packagePath, err)
+	archivedFiles := make(map[string]bool)
+	archivedRoots := make(map[string]bool)
+	err := readArchive(source, func(entry archiveEntry) error {
+		if name, ok := strings.CutPrefix(entry.name, outputFilesRoot+"/"); ok {
+			archivedFiles[name] = true
+			return nil
 		}
-		originalWd = wd
-		defer func() {
-			if originalWd != "" {
-				_ = os.Chdir(originalWd)
-			}
-		}()
-	}
-
-	reader, err := zip.OpenReader(filepath.Clean(sourceZip))
+		archivedRoots[strings.SplitN(strings.ReplaceAll(entry.name, "\\", "/"), "/", 2)[0]] = true
+		return nil
+	})
 	if err != nil {
 		return fmt.Errorf("extract: open archive: %w", err)
 	}
-	defer func() {
-		closeErr := reader.Close()
-		if err == nil && closeErr != nil {
-			err = fmt.Errorf("extract: close archive: %w", closeErr)
-		}
-	}()
+
+	// Outputs are restored next to their final location and swapped into
+	// place only once the whole archive has been read, so a corrupt archive
+	// leaves the previous outputs untouched.
+	stage := newExtractStage()
+	defer stage.discard()
 
 	outputMap := make(map[string]string, len(outputs))
+	var dirTimes []dirTime
 
-	for _, output := range outputs {
+	for _, output := range spec.literals {
 		cleaned := filepath.Clean(output)
+		if name, err := outputFileName(cleaned); err == nil && archivedFiles[name] {
+			// A single-file output; it is written below like glob matches.
+			continue
+		}
 		base := filepath.Base(cleaned)
+		if !archivedRoots[base] {
+			// The output was not produced. Remove a stale file rather than
+			// replacing it with an empty directory.
+			if info, err := os.Lstat(inPackage(packagePath, cleaned)); err == nil && !info.IsDir() {
+				if err := stage.remove(inPackage(packagePath, cleaned)); err != nil {
+					return fmt.Errorf("extract: clean %s: %w", cleaned, err)
+				}
+				continue
+			}
+		}
 		if base == "." || base == string(filepath.Separator) {
 			return fmt.Errorf("extract: invalid directory name %s", cleaned)
 		}
@@ -213,35 +275,49 @@ func extract(sourceZip string, outputs []string, packagePath string) (err error)
 			return fmt.Errorf("extract: duplicate directory name %s", base)
 		}
 
-		if err := os.RemoveAll(cleaned); err != nil {
-			return fmt.Errorf("extract: clean %s: %w", cleaned, err)
+		dir, err := stage.path(inPackage(packagePath, cleaned))
+		if err != nil {
+			return fmt.Errorf("extract: stage %s: %w", cleaned, err)
 		}
-		if err := os.MkdirAll(cleaned, 0o755); err != nil {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
 			return fmt.Errorf("extract: ensure %s: %w", cleaned, err)
 		}
 
-		outputMap[base] = cleaned
+		outputMap[base] = dir
 	}
 
-	for _, file := range reader.File {
-		name := strings.ReplaceAll(file.Name, "\\", "/")
+	err = readArchive(source, func(entry archiveEntry) error {
+		name := strings.ReplaceAll(entry.name, "\\", "/")
 		if name == "" {
-			continue
+			return nil
 		}
 
 		clean := path.Clean(name)
 		if clean == "." {
-			continue
+			return nil
 		}
 		if strings.HasPrefix(clean, "../") || clean == ".." || strings.HasPrefix(clean, "/") {
-			return fmt.Errorf("extract: invalid path %s", file.Name)
+			return fmt.Errorf("extract: invalid path %s", entry.name)
 		}
 
 		parts := strings.SplitN(clean, "/", 2)
 		top := parts[0]
+		if top == outputFilesRoot {
+			if len(parts) < 2 || !spec.matchesFile(parts[1]) {
+				return fmt.Errorf("extract: unexpected output file %s", entry.name)
+			}
+			if entry.open == nil {
+				return nil
+			}
+			target, err := stage.path(inPackage(packagePath, filepath.FromSlash(parts[1])))
+			if err != nil {
+				return fmt.Errorf("extract: stage %s: %w", parts[1], err)
+			}
+			return extractArchiveFile(entry, target)
+		}
 		targetRoot, ok := outputMap[top]
 		if !ok {
-			return fmt.Errorf("extract: unexpected archive root %s", file.Name)
+			return fmt.Errorf("extract: unexpected archive root %s", entry.name)
 		}
 
 		rel := ""
@@ -254,83 +330,276 @@ func extract(sourceZip string, outputs []string, packagePath string) (err error)
 			targetPath = filepath.Join(targetRoot, filepath.FromSlash(rel))
 		}
 
-		mode := file.Mode()
+		mode := entry.mode
 		if mode&os.ModeSymlink != 0 {
 			if rel == "" {
-				return fmt.Errorf("extract: invalid symlink %s", file.Name)
-			}
-
-			rc, openErr := file.Open()
-			if openErr != nil {
-				return fmt.Errorf("extract: open symlink %s: %w", file.Name, openErr)
+				return fmt.Errorf("extract: invalid symlink %s", entry.name)
 			}
-
-			linkTarget, readErr := io.ReadAll(rc)
-			rc.Close()
-			if readErr != nil {
-				return fmt.Errorf("extract: read symlink %s: %w", file.Name, readErr)
-			}
-
 			if err := os.MkdirAll(filepath.Dir(targetPath), 0o755); err != nil {
 				return fmt.Errorf("extract: prepare symlink %s: %w", targetPath, err)
 			}
-			if err := os.Symlink(string(linkTarget), targetPath); err != nil {
+			if err := os.Symlink(entry.linkTarget, targetPath); err != nil {
 				return fmt.Errorf("extract: create symlink %s: %w", targetPath, err)
 			}
-			continue
+			return nil
 		}
 
-		if mode.IsDir() || strings.HasSuffix(file.Name, "/") {
+		if mode.IsDir() || strings.HasSuffix(entry.name, "/") {
 			if err := os.MkdirAll(targetPath, 0o755); err != nil {
 				return fmt.Errorf("extract: create directory %s: %w", targetPath, err)
 			}
-			if chmodErr := os.Chmod(targetPath, mode.Perm()); chmodErr != nil && !errors.Is(chmodErr, os.ErrPermission) {
+			if chmodErr := os.Chmod(targetPath, mode&archiveModeBits); chmodErr != nil && !errors.Is(chmodErr, os.ErrPermission) {
 				return fmt.Errorf("extract: chmod %s: %w", targetPath, chmodErr)
 			}
-			continue
+			if restoredModTime(entry) {
+				// Writing the directory's contents would update it, so its
+				// time is set once everything is in place.
+				dirTimes = append(dirTimes, dirTime{path: targetPath, modTime: entry.modTime})
+			}
+			return nil
 		}
 
 		if rel == "" {
-			return fmt.Errorf("extract: unexpected file at root %s", file.Name)
+			return fmt.Errorf("extract: unexpected file at root %s", entry.name)
+		}
+		return extractArchiveFile(entry, targetPath)
+	})
+	if err != nil {
+		return err
+	}
+	if err := stage.commit(); err != nil {
+		return fmt.Errorf("extract: %w", err)
+	}
+	for i := len(dirTimes) - 1; i >= 0; i-- {
+		dir := stage.finalPath(dirTimes[i].path)
+		if err := os.Chtimes(dir, dirTimes[i].modTime, dirTimes[i].modTime); err != nil {
+			return fmt.Errorf("extract: set time of %s: %w", dir, err)
 		}
+	}
+	return nil
+}
+
+type dirTime struct {
+	path    string
+	modTime time.Time
+}
 
-		if err := os.MkdirAll(filepath.Dir(targetPath), 0o755); err != nil {
-			return fmt.Errorf("extract: prepare file %s: %w", targetPath, err)
+// restoredModTime reports whether entry records a real modification time
+// rather than the normalized archiveModTime.
+func restoredModTime(entry archiveEntry) bool {
+	return !entry.modTime.IsZero() && !entry.modTime.Equal(archiveModTime)
+}
+
+// outputFilesRoot is the archive directory holding outputs that are single
+// files or glob matches, stored by their package-relative path. Directory
+// outputs are stored under their base name.
+const outputFilesRoot = ".velocity-files"
+
+// outputSpec splits a task's outputs into literal paths (directories or
+// single files), glob patterns and `!` exclusions.
+type outputSpec struct {
+	literals []string
+	globs    []string
+	excludes []string
+}
+
+func parseOutputs(outputs []string) outputSpec {
+	var spec outputSpec
+	for _, output := range outputs {
+		output = strings.TrimSpace(output)
+		switch {
+		case output == "":
+		case strings.HasPrefix(output, "!"):
+			if pattern := strings.TrimSpace(output[1:]); pattern != "" {
+				spec.excludes = append(spec.excludes, path.Clean(filepath.ToSlash(pattern)))
+			}
+		case strings.ContainsAny(output, "*?[{"):
+			spec.globs = append(spec.globs, output)
+		default:
+			spec.literals = append(spec.literals, output)
 		}
+	}
+	return spec
+}
 
-		rc, openErr := file.Open()
-		if openErr != nil {
-			return fmt.Errorf("extract: open file %s: %w", file.Name, openErr)
+// excluded reports whether a package-relative, slash-separated path matches
+// one of the exclusions.
+func (s outputSpec) excluded(name string) bool {
+	for _, pattern := range s.excludes {
+		if ok, _ := doublestar.Match(pattern, name); ok {
+			return true
 		}
+	}
+	return false
+}
 
-		outFile, createErr := os.OpenFile(targetPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm())
-		if createErr != nil {
-			rc.Close()
-			return fmt.Errorf("extract: create file %s: %w", targetPath, createErr)
+// matchesFile reports whether an archived single-file output belongs to
+// one of the task's outputs.
+func (s outputSpec) matchesFile(name string) bool {
+	if s.excluded(name) {
+		return false
+	}
+	for _, literal := range s.literals {
+		if literalName, err := outputFileName(filepath.Clean(literal)); err == nil && literalName == name {
+			return true
+		}
+	}
+	for _, pattern := range s.globs {
+		if ok, _ := doublestar.Match(path.Clean(filepath.ToSlash(pattern)), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// outputFileName returns the archive name of a package-relative file,
+// rejecting paths that leave the package.
+func outputFileName(rel string) (string, error) {
+	name := path.Clean(filepath.ToSlash(rel))
+	if filepath.IsAbs(rel) || name == ".." || strings.HasPrefix(name, "../") || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("output file %s is outside the package", rel)
+	}
+	return name, nil
+}
+
+func addArchiveFile(writer archiveWriter, name, filePath string, info fs.FileInfo) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return writer.writeFile(name, info, file)
+}
+
+func extractArchiveFile(entry archiveEntry, targetPath string) error {
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0o755); err != nil {
+		return fmt.Errorf("extract: prepare file %s: %w", targetPath, err)
+	}
+	if entry.source != "" {
+		if linked, shared := linkTreeFile(entry.source, targetPath, entry.mode); shared {
+			return nil
+		} else if linked {
+			return restoreFileMetadata(entry, targetPath)
 		}
+	}
+	rc, err := entry.open()
+	if err != nil {
+		return fmt.Errorf("extract: open file %s: %w", entry.name, err)
+	}
+	defer rc.Close()
 
-		if _, copyErr := io.Copy(outFile, rc); copyErr != nil {
-			rc.Close()
-			outFile.Close()
-			return fmt.Errorf("extract: write file %s: %w", targetPath, copyErr)
+	outFile, err := os.OpenFile(targetPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, entry.mode.Perm())
+	if err != nil {
+		return fmt.Errorf("extract: create file %s: %w", targetPath, err)
+	}
+	if _, err := io.Copy(outFile, rc); err != nil {
+		outFile.Close()
+		return fmt.Errorf("extract: write file %s: %w", targetPath, err)
+	}
+	if err := outFile.Close(); err != nil {
+		return fmt.Errorf("extract: close file %s: %w", targetPath, err)
+	}
+	return restoreFileMetadata(entry, targetPath)
+}
+
+func restoreFileMetadata(entry archiveEntry, targetPath string) error {
+	// The umask applied on create may have dropped bits.
+	if err := os.Chmod(targetPath, entry.mode&archiveModeBits); err != nil && !errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("extract: chmod %s: %w", targetPath, err)
+	}
+	if restoredModTime(entry) {
+		if err := os.Chtimes(targetPath, entry.modTime, entry.modTime); err != nil {
+			return fmt.Errorf("extract: set time of %s: %w", targetPath, err)
 		}
+	}
+	return nil
+}
+
+// inPackage resolves a path given relative to packagePath.
+func inPackage(packagePath, p string) string {
+	if strings.TrimSpace(packagePath) == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(packagePath, p)
+}
+
+// MissingOutputs returns the declared outputs that match nothing: literal
+// paths that do not exist or are directories without files, and globs
+// without a matching file. Files removed by `!` exclusions do not count.
+func MissingOutputs(outputs []string, packagePath string) ([]string, error) {
+	spec := parseOutputs(outputs)
+	var missing []string
 
-		if closeErr := rc.Close(); closeErr != nil {
-			outFile.Close()
-			return fmt.Errorf("extract: close reader %s: %w", targetPath, closeErr)
+	for _, output := range spec.literals {
+		cleaned := filepath.Clean(output)
+		dir := inPackage(packagePath, cleaned)
+		found := false
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return fs.SkipAll
+				}
+				return err
+			}
+			rel, relErr := filepath.Rel(dir, path)
+			if relErr != nil {
+				return relErr
+			}
+			if rel != "." && spec.excluded(filepath.ToSlash(filepath.Join(cleaned, rel))) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if !d.IsDir() {
+				found = true
+				return fs.SkipAll
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("check output %s: %w", cleaned, err)
 		}
-		if closeErr := outFile.Close(); closeErr != nil {
-			return fmt.Errorf("extract: close file %s: %w", targetPath, closeErr)
+		if !found {
+			missing = append(missing, output)
 		}
 	}
 
-	return nil
+	for _, pattern := range spec.globs {
+		matches, err := globInPackage(pattern, packagePath)
+		if err != nil {
+			return nil, fmt.Errorf("check output %q: %w", pattern, err)
+		}
+		found := false
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			rel := match
+			if strings.TrimSpace(packagePath) != "" {
+				if rel, err = filepath.Rel(packagePath, match); err != nil {
+					continue
+				}
+			}
+			if !spec.excluded(filepath.ToSlash(rel)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, pattern)
+		}
+	}
+	return missing, nil
 }
 
-func Compress(outputs []string, targetZip string, packagePath string) error {
-	return compress(outputs, targetZip, packagePath)
+// Compress archives the outputs as configured by opts.
+func Compress(ctx context.Context, outputs []string, target string, packagePath string, opts ArchiveOptions) error {
+	return compressArchive(ctx, outputs, target, packagePath, opts)
 }
 
-func Extract(sourceZip string, outputs []string, packagePath string) error {
-	return extract(sourceZip, outputs, packagePath)
+// Extract restores the outputs from an artifact of either format.
+func Extract(source string, outputs []string, packagePath string) error {
+	return extract(source, outputs, packagePath)
 }
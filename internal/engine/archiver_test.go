@@ -2,11 +2,17 @@ package engine
 
 import (
 	"archive/zip"
+	"bytes"
+	"context"
 	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
 )
 
 func TestCompressExtractRoundTrip(t *testing.T) {
@@ -27,7 +33,7 @@ func TestCompressExtractRoundTrip(t *testing.T) {
 
 	archivePath := filepath.Join(tempDir, "artifact.zip")
 
-	if err := compress([]string{alpha, beta}, archivePath, ""); err != nil {
+	if err := compress(context.Background(), []string{alpha, beta}, archivePath, ""); err != nil {
 		t.Fatalf("compress returned error: %v", err)
 	}
 
@@ -74,7 +80,7 @@ func TestCompressDuplicateBaseName(t *testing.T) {
 	mustMkdirAll(t, first)
 	mustMkdirAll(t, second)
 
-	err := compress([]string{first, second}, filepath.Join(tempDir, "dup.zip"), "")
+	err := compress(context.Background(), []string{first, second}, filepath.Join(tempDir, "dup.zip"), "")
 	if err == nil || !strings.Contains(err.Error(), "duplicate") {
 		t.Fatalf("expected duplicate base name error, got %v", err)
 	}
@@ -83,7 +89,7 @@ func TestCompressDuplicateBaseName(t *testing.T) {
 func TestCompressMissingDirectory(t *testing.T) {
 	tempDir := t.TempDir()
 
-	err := compress([]string{filepath.Join(tempDir, "missing")}, filepath.Join(tempDir, "missing.zip"), "")
+	err := compress(context.Background(), []string{filepath.Join(tempDir, "missing")}, filepath.Join(tempDir, "missing.zip"), "")
 	if err != nil {
 		t.Fatalf("expected no error for missing directory (should be ignored), got %v", err)
 	}
@@ -164,3 +170,305 @@ func createZip(t *testing.T, path string, files map[string]string) {
 		}
 	}
 }
+
+func TestCompressRemovesPartialArchiveOnCancel(t *testing.T) {
+	tempDir := t.TempDir()
+	dist := filepath.Join(tempDir, "dist")
+	mustMkdirAll(t, dist)
+	mustWriteFile(t, filepath.Join(dist, "out.txt"), "data")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	archivePath := filepath.Join(tempDir, "out.zip")
+	if err := compress(ctx, []string{dist}, archivePath, ""); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if _, err := os.Stat(archivePath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected partial archive to be removed, got %v", err)
+	}
+}
+
+func TestCompressExtractFileAndGlobOutputs(t *testing.T) {
+	pkg := t.TempDir()
+	mustWriteFile(t, filepath.Join(pkg, "dist", "index.js"), "index")
+	mustWriteFile(t, filepath.Join(pkg, "dist", "index.js.map"), "map")
+	mustWriteFile(t, filepath.Join(pkg, "coverage", "lcov.info"), "lcov")
+	mustWriteFile(t, filepath.Join(pkg, "coverage", "report.html"), "html")
+	mustWriteFile(t, filepath.Join(pkg, "tsconfig.tsbuildinfo"), "buildinfo")
+	mustWriteFile(t, filepath.Join(pkg, "lib", "a.js"), "a")
+	mustWriteFile(t, filepath.Join(pkg, "lib", "nested", "b.js"), "b")
+	mustWriteFile(t, filepath.Join(pkg, "lib", "nested", "b.d.ts"), "types")
+
+	outputs := []string{"dist", "coverage/lcov.info", "*.tsbuildinfo", "lib/**/*.js", "!dist/**/*.map"}
+	archivePath := filepath.Join(t.TempDir(), "artifact.zip")
+	if err := compress(context.Background(), outputs, archivePath, pkg); err != nil {
+		t.Fatalf("compress returned error: %v", err)
+	}
+
+	for _, name := range []string{"dist", "coverage", "tsconfig.tsbuildinfo", "lib"} {
+		if err := os.RemoveAll(filepath.Join(pkg, name)); err != nil {
+			t.Fatalf("remove %s: %v", name, err)
+		}
+	}
+
+	if err := extract(archivePath, outputs, pkg); err != nil {
+		t.Fatalf("extract returned error: %v", err)
+	}
+
+	assertFileContent(t, filepath.Join(pkg, "dist", "index.js"), "index")
+	assertFileContent(t, filepath.Join(pkg, "coverage", "lcov.info"), "lcov")
+	assertFileContent(t, filepath.Join(pkg, "tsconfig.tsbuildinfo"), "buildinfo")
+	assertFileContent(t, filepath.Join(pkg, "lib", "a.js"), "a")
+	assertFileContent(t, filepath.Join(pkg, "lib", "nested", "b.js"), "b")
+	for _, name := range []string{
+		filepath.Join("dist", "index.js.map"),
+		filepath.Join("coverage", "report.html"),
+		filepath.Join("lib", "nested", "b.d.ts"),
+	} {
+		if _, err := os.Stat(filepath.Join(pkg, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected %s not to be restored, got %v", name, err)
+		}
+	}
+}
+
+func TestExtractRejectsUnexpectedOutputFile(t *testing.T) {
+	tempDir := t.TempDir()
+	archive := filepath.Join(tempDir, "bad.zip")
+	createZip(t, archive, map[string]string{outputFilesRoot + "/package.json": "{}"})
+
+	err := extract(archive, []string{"*.tsbuildinfo"}, tempDir)
+	if err == nil || !strings.Contains(err.Error(), "unexpected output file") {
+		t.Fatalf("expected unexpected output file error, got %v", err)
+	}
+	if _, statErr := os.Stat(filepath.Join(tempDir, "package.json")); !errors.Is(statErr, os.ErrNotExist) {
+		t.Fatalf("expected package.json not to be written, got %v", statErr)
+	}
+}
+
+func TestCompressRejectsOutputFilesOutsidePackage(t *testing.T) {
+	root := t.TempDir()
+	pkg := filepath.Join(root, "pkg")
+	mustMkdirAll(t, pkg)
+	mustWriteFile(t, filepath.Join(root, "shared.txt"), "shared")
+
+	err := compress(context.Background(), []string{"../shared.txt"}, filepath.Join(root, "out.zip"), pkg)
+	if err == nil || !strings.Contains(err.Error(), "outside the package") {
+		t.Fatalf("expected outside the package error, got %v", err)
+	}
+}
+
+func TestCompressExtractTarZstd(t *testing.T) {
+	pkg := t.TempDir()
+	mustWriteFile(t, filepath.Join(pkg, "dist", "app.js"), "app")
+	mustWriteFile(t, filepath.Join(pkg, "dist", "lib", "util.js"), "util")
+	mustWriteFile(t, filepath.Join(pkg, "report.txt"), "report")
+	if err := os.Symlink("app.js", filepath.Join(pkg, "dist", "index.js")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	outputs := []string{"dist", "report.txt"}
+	archivePath := filepath.Join(t.TempDir(), "artifact.tar.zst")
+	if err := compressArchive(context.Background(), outputs, archivePath, pkg, ArchiveOptions{Format: config.ArchiveFormatTarZstd}); err != nil {
+		t.Fatalf("compress returned error: %v", err)
+	}
+
+	header := make([]byte, 4)
+	file, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	_, err = io.ReadFull(file, header)
+	file.Close()
+	if err != nil {
+		t.Fatalf("read archive header: %v", err)
+	}
+	if got := ArtifactContentType(header); got != "application/zstd" {
+		t.Fatalf("expected a zstd artifact, got %s", got)
+	}
+
+	if err := os.RemoveAll(filepath.Join(pkg, "dist")); err != nil {
+		t.Fatalf("remove dist: %v", err)
+	}
+	mustWriteFile(t, filepath.Join(pkg, "report.txt"), "stale")
+
+	if err := extract(archivePath, outputs, pkg); err != nil {
+		t.Fatalf("extract returned error: %v", err)
+	}
+
+	assertFileContent(t, filepath.Join(pkg, "dist", "app.js"), "app")
+	assertFileContent(t, filepath.Join(pkg, "dist", "lib", "util.js"), "util")
+	assertFileContent(t, filepath.Join(pkg, "report.txt"), "report")
+	target, err := os.Readlink(filepath.Join(pkg, "dist", "index.js"))
+	if err != nil || target != "app.js" {
+		t.Fatalf("expected dist/index.js -> app.js, got %q (%v)", target, err)
+	}
+}
+
+func TestCompressIsDeterministic(t *testing.T) {
+	for _, format := range []string{config.ArchiveFormatZip, config.ArchiveFormatTarZstd} {
+		t.Run(format, func(t *testing.T) {
+			pkg := t.TempDir()
+			mustWriteFile(t, filepath.Join(pkg, "dist", "b.js"), "b")
+			mustWriteFile(t, filepath.Join(pkg, "dist", "a", "a.js"), "a")
+			mustWriteFile(t, filepath.Join(pkg, "out.txt"), "out")
+			outputs := []string{"dist", "*.txt"}
+
+			first := filepath.Join(t.TempDir(), "first")
+			if err := compressArchive(context.Background(), outputs, first, pkg, ArchiveOptions{Format: format}); err != nil {
+				t.Fatalf("compress returned error: %v", err)
+			}
+
+			later := time.Now().Add(time.Hour)
+			for _, name := range []string{"dist/b.js", "dist/a/a.js", "out.txt", "dist/a", "dist"} {
+				if err := os.Chtimes(filepath.Join(pkg, name), later, later); err != nil {
+					t.Fatalf("chtimes %s: %v", name, err)
+				}
+			}
+			if err := os.Chmod(filepath.Join(pkg, "out.txt"), 0o600); err != nil {
+				t.Fatalf("chmod: %v", err)
+			}
+
+			second := filepath.Join(t.TempDir(), "second")
+			if err := compressArchive(context.Background(), outputs, second, pkg, ArchiveOptions{Format: format}); err != nil {
+				t.Fatalf("compress returned error: %v", err)
+			}
+
+			a, err := os.ReadFile(first)
+			if err != nil {
+				t.Fatalf("read %s: %v", first, err)
+			}
+			b, err := os.ReadFile(second)
+			if err != nil {
+				t.Fatalf("read %s: %v", second, err)
+			}
+			if !bytes.Equal(a, b) {
+				t.Fatalf("expected identical archives for identical outputs")
+			}
+		})
+	}
+}
+
+func TestExtractKeepsPreviousOutputsWhenArchiveIsCorrupt(t *testing.T) {
+	pkg := t.TempDir()
+	mustWriteFile(t, filepath.Join(pkg, "dist", "app.js"), "new")
+	mustWriteFile(t, filepath.Join(pkg, "report.txt"), "new report")
+	outputs := []string{"dist", "report.txt"}
+
+	archivePath := filepath.Join(t.TempDir(), "artifact.tar.zst")
+	if err := compressArchive(context.Background(), outputs, archivePath, pkg, ArchiveOptions{Format: config.ArchiveFormatTarZstd}); err != nil {
+		t.Fatalf("compress returned error: %v", err)
+	}
+	data, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatalf("read archive: %v", err)
+	}
+	if err := os.WriteFile(archivePath, data[:len(data)-8], 0o644); err != nil {
+		t.Fatalf("truncate archive: %v", err)
+	}
+
+	mustWriteFile(t, filepath.Join(pkg, "dist", "app.js"), "previous")
+	mustWriteFile(t, filepath.Join(pkg, "report.txt"), "previous report")
+
+	if err := extract(archivePath, outputs, pkg); err == nil {
+		t.Fatalf("expected extracting a truncated archive to fail")
+	}
+	assertFileContent(t, filepath.Join(pkg, "dist", "app.js"), "previous")
+	assertFileContent(t, filepath.Join(pkg, "report.txt"), "previous report")
+
+	entries, err := os.ReadDir(pkg)
+	if err != nil {
+		t.Fatalf("read package: %v", err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), extractStageDir) {
+			t.Fatalf("expected staging directory %s to be removed", entry.Name())
+		}
+	}
+}
+
+func TestCompressExtractPreservesMetadata(t *testing.T) {
+	for _, format := range []string{config.ArchiveFormatZip, config.ArchiveFormatTarZstd} {
+		t.Run(format, func(t *testing.T) {
+			pkg := t.TempDir()
+			mustWriteFile(t, filepath.Join(pkg, "dist", "bin", "tool"), "#!/bin/sh")
+			mustWriteFile(t, filepath.Join(pkg, "dist", "index.js"), "index")
+			if err := os.Chmod(filepath.Join(pkg, "dist", "bin", "tool"), 0o750); err != nil {
+				t.Fatalf("chmod: %v", err)
+			}
+			built := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+			for _, name := range []string{"dist/bin/tool", "dist/index.js", "dist/bin", "dist"} {
+				if err := os.Chtimes(filepath.Join(pkg, name), built, built); err != nil {
+					t.Fatalf("chtimes %s: %v", name, err)
+				}
+			}
+
+			archivePath := filepath.Join(t.TempDir(), "artifact")
+			opts := ArchiveOptions{Format: format, PreserveMetadata: true}
+			if err := compressArchive(context.Background(), []string{"dist"}, archivePath, pkg, opts); err != nil {
+				t.Fatalf("compress returned error: %v", err)
+			}
+			if err := os.RemoveAll(filepath.Join(pkg, "dist")); err != nil {
+				t.Fatalf("remove dist: %v", err)
+			}
+			if err := extract(archivePath, []string{"dist"}, pkg); err != nil {
+				t.Fatalf("extract returned error: %v", err)
+			}
+
+			for _, name := range []string{"dist/bin/tool", "dist/index.js", "dist/bin", "dist"} {
+				info, err := os.Stat(filepath.Join(pkg, name))
+				if err != nil {
+					t.Fatalf("stat %s: %v", name, err)
+				}
+				if !info.ModTime().Equal(built) {
+					t.Fatalf("expected %s to be restored with mtime %v, got %v", name, built, info.ModTime())
+				}
+			}
+			info, err := os.Stat(filepath.Join(pkg, "dist", "bin", "tool"))
+			if err != nil {
+				t.Fatalf("stat tool: %v", err)
+			}
+			if info.Mode().Perm() != 0o750 {
+				t.Fatalf("expected mode 0750, got %v", info.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestExtractNormalizedArchiveUsesRestoreTime(t *testing.T) {
+	pkg := t.TempDir()
+	mustWriteFile(t, filepath.Join(pkg, "dist", "index.js"), "index")
+	archivePath := filepath.Join(t.TempDir(), "artifact.zip")
+	if err := compress(context.Background(), []string{"dist"}, archivePath, pkg); err != nil {
+		t.Fatalf("compress returned error: %v", err)
+	}
+
+	before := time.Now().Add(-time.Minute)
+	if err := extract(archivePath, []string{"dist"}, pkg); err != nil {
+		t.Fatalf("extract returned error: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(pkg, "dist", "index.js"))
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.ModTime().Before(before) {
+		t.Fatalf("expected a normalized entry to keep its restore time, got %v", info.ModTime())
+	}
+}
+
+func TestMissingOutputs(t *testing.T) {
+	pkg := t.TempDir()
+	mustWriteFile(t, filepath.Join(pkg, "dist", "app.js"), "app")
+	mustMkdirAll(t, filepath.Join(pkg, "empty"))
+	mustWriteFile(t, filepath.Join(pkg, "coverage", "lcov.info"), "lcov")
+	mustWriteFile(t, filepath.Join(pkg, "types", "index.d.ts"), "types")
+
+	missing, err := MissingOutputs([]string{"dist", "empty", "build", "coverage", "!coverage/**", "*.tsbuildinfo", "types/*.d.ts"}, pkg)
+	if err != nil {
+		t.Fatalf("MissingOutputs returned error: %v", err)
+	}
+	want := []string{"empty", "build", "coverage", "*.tsbuildinfo"}
+	if strings.Join(missing, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected missing outputs %v, got %v", want, missing)
+	}
+}
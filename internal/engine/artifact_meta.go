@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheLogExt names the output a task printed when it produced an
+// artifact, replayed on cache hits.
+const cacheLogExt = ".log"
+
+const (
+	ArtifactSourceLocal  = "local"
+	ArtifactSourceRemote = "remote"
+)
+
+// ArtifactInfo describes how a locally cached artifact was produced. It is
+// stored in the artifact's .meta.json alongside the input manifest.
+type ArtifactInfo struct {
+	TaskID     string `json:"task_id"`
+	Command    string `json:"command,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Size       int64  `json:"size"`
+	// ManifestDigest is the SHA-256 of the input manifest the artifact
+	// was stored with.
+	ManifestDigest string    `json:"manifest_digest,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	// Source is ArtifactSourceLocal for artifacts built on this machine
+	// and ArtifactSourceRemote for downloaded ones.
+	Source string `json:"source"`
+}
+
+// artifactMetadata is the layout of .meta.json: the input manifest's fields
+// at the top level, which older versions wrote on their own, and the
+// artifact's description under "artifact".
+type artifactMetadata struct {
+	*KeyManifest
+	Artifact *ArtifactInfo `json:"artifact,omitempty"`
+}
+
+func localCacheLog(cacheKey string) (string, error) {
+	dir, err := localCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, cacheKey+cacheLogExt), nil
+}
+
+// SaveLocalMetadata records the input manifest and description of the
+// artifact stored for manifest.Key, along with the log its task printed.
+// An empty log removes any previously stored one.
+func SaveLocalMetadata(manifest *KeyManifest, info ArtifactInfo, log []byte) error {
+	if manifest == nil {
+		return nil
+	}
+	path, err := localCacheMetadata(manifest.Key)
+	if err != nil {
+		return err
+	}
+	digest, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("encode manifest: %w", err)
+	}
+	info.ManifestDigest = hashString(string(digest))
+	if info.CreatedAt.IsZero() {
+		info.CreatedAt = time.Now()
+	}
+	data, err := json.MarshalIndent(artifactMetadata{KeyManifest: manifest, Artifact: &info}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode metadata: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("save metadata ensure dir: %w", err)
+	}
+	if err := writeAtomically(path, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("save metadata: %w", err)
+	}
+
+	logPath, err := localCacheLog(manifest.Key)
+	if err != nil {
+		return err
+	}
+	if len(log) == 0 {
+		if err := os.Remove(logPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove log %s: %w", logPath, err)
+		}
+		return nil
+	}
+	if err := writeAtomically(logPath, bytes.NewReader(log)); err != nil {
+		return fmt.Errorf("save log: %w", err)
+	}
+	return nil
+}
+
+// LoadArtifactInfo returns the description stored for cacheKey's artifact,
+// or nil when it has none.
+func LoadArtifactInfo(cacheKey string) (*ArtifactInfo, error) {
+	path, err := localCacheMetadata(cacheKey)
+	if err != nil {
+		return nil, err
+	}
+	_, info, err := readMetadata(path)
+	return info, err
+}
+
+// LocalLog returns the output recorded with cacheKey's artifact, or nil
+// when none was.
+func LocalLog(cacheKey string) ([]byte, error) {
+	if err := validateCacheKey(cacheKey); err != nil {
+		return nil, err
+	}
+	path, err := localCacheLog(cacheKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read log %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// readMetadata reads a .meta.json file, returning nils when it does not
+// exist. Files written before artifacts were described have no info.
+func readMetadata(path string) (*KeyManifest, *ArtifactInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("read manifest %s: %w", path, err)
+	}
+	metadata := artifactMetadata{KeyManifest: &KeyManifest{}}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, nil, fmt.Errorf("decode manifest %s: %w", path, err)
+	}
+	return metadata.KeyManifest, metadata.Artifact, nil
+}
@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveLocalMetadataDescribesArtifact(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		src := filepath.Join(root, "source.zip")
+		require.NoError(t, os.WriteFile(src, []byte("zipdata"), 0o644))
+		_, err := saveLocal("key", src)
+		require.NoError(t, err)
+
+		manifest := &KeyManifest{Key: "key", Task: "app#build", Command: "make"}
+		info := ArtifactInfo{TaskID: "app#build", Command: "make", DurationMs: 1500, Size: 7, Source: ArtifactSourceLocal}
+		require.NoError(t, SaveLocalMetadata(manifest, info, []byte("compiled\n")))
+
+		loaded, err := LoadLocalManifest("key")
+		require.NoError(t, err)
+		assert.Equal(t, manifest, loaded, "the manifest is still readable on its own")
+
+		entries, err := ListLocal()
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.NotNil(t, entries[0].Info)
+		assert.Equal(t, "app#build", entries[0].Task)
+		assert.Equal(t, int64(1500), entries[0].Info.DurationMs)
+		assert.Equal(t, ArtifactSourceLocal, entries[0].Info.Source)
+		assert.NotEmpty(t, entries[0].Info.ManifestDigest)
+		assert.WithinDuration(t, time.Now(), entries[0].Info.CreatedAt, time.Minute)
+
+		log, err := LocalLog("key")
+		require.NoError(t, err)
+		assert.Equal(t, "compiled\n", string(log))
+
+		_, err = RemoveLocal("key")
+		require.NoError(t, err)
+		log, err = LocalLog("key")
+		require.NoError(t, err)
+		assert.Nil(t, log)
+	})
+}
+
+func TestPruneLocalRemovesOrphanedMetadata(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		require.NoError(t, SaveLocalMetadata(&KeyManifest{Key: "gone"}, ArtifactInfo{Source: ArtifactSourceLocal}, []byte("log")))
+
+		_, err := PruneLocal(time.Time{}, 0)
+		require.NoError(t, err)
+
+		dir := filepath.Join(root, ".velocity", "cache")
+		for _, name := range []string{"gone" + cacheMetaExt, "gone" + cacheLogExt} {
+			_, err := os.Stat(filepath.Join(dir, name))
+			assert.ErrorIs(t, err, os.ErrNotExist, name)
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package engine
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExportLocal writes the artifacts for keys, with their manifests when
+// present, to w as a tar stream.
+func ExportLocal(w io.Writer, keys []string) error {
+	tw := tar.NewWriter(w)
+	for _, key := range keys {
+		zipPath, found, err := checkLocal(key)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("cache entry %s not found", key)
+		}
+		if err := addBundleFile(tw, zipPath); err != nil {
+			return err
+		}
+		metaPath, err := localCacheMetadata(key)
+		if err != nil {
+			return err
+		}
+		sumPath, err := localCacheChecksum(key)
+		if err != nil {
+			return err
+		}
+		logPath, err := localCacheLog(key)
+		if err != nil {
+			return err
+		}
+		for _, extra := range []string{metaPath, sumPath, logPath} {
+			if err := addBundleFile(tw, extra); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("finish bundle: %w", err)
+	}
+	return nil
+}
+
+func addBundleFile(tw *tar.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", path, err)
+	}
+	header := &tar.Header{
+		Name:    filepath.Base(path),
+		Mode:    0o644,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("write bundle header %s: %w", header.Name, err)
+	}
+	if _, err := io.Copy(tw, file); err != nil {
+		return fmt.Errorf("write bundle entry %s: %w", header.Name, err)
+	}
+	return nil
+}
+
+// ImportLocal unpacks a bundle written by ExportLocal into the local cache.
+// Artifacts that are already cached are left untouched. It returns the keys
+// of the artifacts it added.
+func ImportLocal(r io.Reader) ([]string, error) {
+	dir, err := localCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("import ensure dir %s: %w", dir, err)
+	}
+
+	var imported []string
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return imported, nil
+		}
+		if err != nil {
+			return imported, fmt.Errorf("read bundle: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := header.Name
+		key, isArtifact := strings.CutSuffix(name, cacheFileExt)
+		if !isArtifact {
+			var isExtra bool
+			for _, ext := range []string{cacheMetaExt, cacheSumExt, cacheLogExt} {
+				if key, isExtra = strings.CutSuffix(name, ext); isExtra {
+					break
+				}
+			}
+			if !isExtra {
+				return imported, fmt.Errorf("unexpected bundle entry %q", name)
+			}
+		}
+		if err := validateCacheKey(key); err != nil {
+			return imported, fmt.Errorf("bundle entry %q: %w", name, err)
+		}
+
+		destination := filepath.Join(dir, name)
+		if _, err := os.Stat(destination); err == nil {
+			continue
+		}
+		if err := writeAtomically(destination, tr); err != nil {
+			return imported, err
+		}
+		if isArtifact {
+			imported = append(imported, key)
+		}
+	}
+}
+
+func writeAtomically(destination string, r io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(destination), ".import-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write %s: %w", destination, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", destination, err)
+	}
+	if err := os.Rename(tmp.Name(), destination); err != nil {
+		return fmt.Errorf("rename %s: %w", destination, err)
+	}
+	return nil
+}
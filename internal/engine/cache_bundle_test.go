@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportImportLocalRoundTrip(t *testing.T) {
+	var bundle bytes.Buffer
+	withTempWorkdir(t, func(root string) {
+		srcZip := filepath.Join(root, "source.zip")
+		if err := os.WriteFile(srcZip, []byte("zipdata"), 0o644); err != nil {
+			t.Fatalf("write source zip: %v", err)
+		}
+		for _, key := range []string{"one", "two"} {
+			if _, err := saveLocal(key, srcZip); err != nil {
+				t.Fatalf("saveLocal error: %v", err)
+			}
+		}
+		if err := SaveLocalManifest(&KeyManifest{Key: "one", Task: "app#build"}); err != nil {
+			t.Fatalf("SaveLocalManifest error: %v", err)
+		}
+		if err := ExportLocal(&bundle, []string{"one"}); err != nil {
+			t.Fatalf("ExportLocal error: %v", err)
+		}
+	})
+
+	withTempWorkdir(t, func(root string) {
+		imported, err := ImportLocal(bytes.NewReader(bundle.Bytes()))
+		if err != nil {
+			t.Fatalf("ImportLocal error: %v", err)
+		}
+		if len(imported) != 1 || imported[0] != "one" {
+			t.Fatalf("unexpected imported keys: %v", imported)
+		}
+		entries, err := ListLocal()
+		if err != nil {
+			t.Fatalf("ListLocal error: %v", err)
+		}
+		if len(entries) != 1 || entries[0].Task != "app#build" {
+			t.Fatalf("unexpected entries after import: %+v", entries)
+		}
+
+		imported, err = ImportLocal(bytes.NewReader(bundle.Bytes()))
+		if err != nil {
+			t.Fatalf("second ImportLocal error: %v", err)
+		}
+		if len(imported) != 0 {
+			t.Fatalf("expected existing entries to be skipped, got %v", imported)
+		}
+	})
+}
+
+func TestImportLocalRejectsUnsafeNames(t *testing.T) {
+	var bundle bytes.Buffer
+	tw := tar.NewWriter(&bundle)
+	if err := tw.WriteHeader(&tar.Header{Name: "../evil.zip", Mode: 0o644, Size: 1}); err != nil {
+		t.Fatalf("write header: %v", err)
+	}
+	if _, err := tw.Write([]byte("x")); err != nil {
+		t.Fatalf("write body: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar: %v", err)
+	}
+
+	withTempWorkdir(t, func(root string) {
+		if _, err := ImportLocal(&bundle); err == nil {
+			t.Fatalf("expected an error for an unsafe entry name")
+		}
+		if _, err := os.Stat(filepath.Join(root, ".velocity", "evil.zip")); !os.IsNotExist(err) {
+			t.Fatalf("unsafe entry was written, stat err: %v", err)
+		}
+	})
+}
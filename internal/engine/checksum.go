@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrChecksumMismatch is returned when an artifact's contents do not match
+// the SHA-256 recorded when it was produced.
+var ErrChecksumMismatch = errors.New("artifact checksum mismatch")
+
+// ErrCorruptArtifact is returned when a cached artifact without a recorded
+// checksum cannot be read as an archive.
+var ErrCorruptArtifact = errors.New("artifact is not a readable archive")
+
+// checksumHeader carries an artifact's hex SHA-256 on downloads. Storing it
+// as S3 user metadata means it is returned on ranged GETs too.
+const checksumHeader = "X-Amz-Meta-Sha256"
+
+// ArtifactChecksum returns the hex SHA-256 of the file at path.
+func ArtifactChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open artifact: %w", err)
+	}
+	defer file.Close()
+
+	sum := sha256.New()
+	if _, err := io.Copy(sum, file); err != nil {
+		return "", fmt.Errorf("hash artifact %s: %w", path, err)
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// responseChecksum returns the hex SHA-256 a download response advertises,
+// or "" if it has none.
+func responseChecksum(header map[string][]string) string {
+	get := func(name string) string {
+		if values := header[name]; len(values) > 0 {
+			return strings.TrimSpace(values[0])
+		}
+		return ""
+	}
+	if sum := get(checksumHeader); sum != "" {
+		return strings.ToLower(sum)
+	}
+	if sum, err := base64.StdEncoding.DecodeString(get("X-Amz-Checksum-Sha256")); err == nil && len(sum) == sha256.Size {
+		return hex.EncodeToString(sum)
+	}
+	return ""
+}
+
+func verifyChecksum(got, want string) error {
+	if got != want {
+		return fmt.Errorf("%w: expected sha256 %.12s, got %.12s", ErrChecksumMismatch, want, got)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package engine
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// TaskConflicts checks the planned tasks for outputs that can clash. Two
+// tasks that may run in parallel and write to the same output path are an
+// error. A task whose declared inputs cover another task's outputs without
+// depending on it, or its own outputs, is reported as a warning, since its
+// cache key then depends on whether the other task ran first.
+//
+// Patterns are compared without touching the filesystem, so overlaps are
+// approximate: two globs that could match the same path are treated as
+// overlapping. Tasks without explicit inputs are not checked for reads.
+func TaskConflicts(nodes []*TaskNode) (warnings []string, err error) {
+	ancestors := make(map[*TaskNode]map[*TaskNode]bool, len(nodes))
+	var collect func(node *TaskNode) map[*TaskNode]bool
+	collect = func(node *TaskNode) map[*TaskNode]bool {
+		if set, ok := ancestors[node]; ok {
+			return set
+		}
+		set := make(map[*TaskNode]bool)
+		ancestors[node] = set
+		for _, dep := range node.Dependencies {
+			set[dep] = true
+			for ancestor := range collect(dep) {
+				set[ancestor] = true
+			}
+		}
+		return set
+	}
+	ordered := func(a, b *TaskNode) bool {
+		return collect(a)[b] || collect(b)[a]
+	}
+
+	outputs := make([][]string, len(nodes))
+	inputs := make([][]string, len(nodes))
+	for i, node := range nodes {
+		outputs[i] = workspacePatterns(node.TaskConfig.Outputs, node.Package)
+		if node.TaskConfig.Inputs != nil {
+			inputs[i] = workspacePatterns(node.TaskConfig.Inputs, node.Package)
+		}
+	}
+
+	var clashes []string
+	for i, writer := range nodes {
+		if shared := overlappingPatterns(inputs[i], outputs[i]); shared != "" {
+			warnings = append(warnings, fmt.Sprintf("task %s reads its own outputs (%s); its cache key changes after every run", writer.ID, shared))
+		}
+		for j, other := range nodes {
+			if i == j {
+				continue
+			}
+			if j > i && !ordered(writer, other) {
+				if shared := overlappingPatterns(outputs[i], outputs[j]); shared != "" {
+					clashes = append(clashes, fmt.Sprintf("%s and %s both write %s", writer.ID, other.ID, shared))
+				}
+			}
+			if collect(other)[writer] {
+				continue
+			}
+			if shared := overlappingPatterns(inputs[j], outputs[i]); shared != "" {
+				warnings = append(warnings, fmt.Sprintf("task %s reads outputs of %s (%s) without depending on it", other.ID, writer.ID, shared))
+			}
+		}
+	}
+
+	if len(clashes) > 0 {
+		sort.Strings(clashes)
+		return warnings, fmt.Errorf("tasks that may run in parallel write the same outputs: %s; add a dependency between them or give them separate outputs", strings.Join(clashes, "; "))
+	}
+	return warnings, nil
+}
+
+// workspacePatterns resolves a task's patterns against its package,
+// dropping `!` exclusions.
+func workspacePatterns(patterns []string, pkg *Package) []string {
+	base := ""
+	if pkg != nil && !filepath.IsAbs(pkg.Path) {
+		base = filepath.ToSlash(pkg.Path)
+	}
+	resolved := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" || strings.HasPrefix(pattern, "!") {
+			continue
+		}
+		pattern = filepath.ToSlash(pattern)
+		if !path.IsAbs(pattern) {
+			pattern = path.Join(base, pattern)
+		}
+		resolved = append(resolved, path.Clean(pattern))
+	}
+	return resolved
+}
+
+// overlappingPatterns returns a description of the first pair of patterns
+// from a and b that could match the same path, or "" if there is none.
+func overlappingPatterns(a, b []string) string {
+	for _, left := range a {
+		for _, right := range b {
+			if patternsOverlap(left, right) {
+				if left == right {
+					return left
+				}
+				return left + " ~ " + right
+			}
+		}
+	}
+	return ""
+}
+
+// patternsOverlap reports whether two slash-separated patterns could match
+// the same path. A pattern that ends in a literal segment also covers
+// everything below it, as outputs and inputs naming a directory do.
+func patternsOverlap(a, b string) bool {
+	left := strings.Split(a, "/")
+	right := strings.Split(b, "/")
+	for i := 0; i < len(left) && i < len(right); i++ {
+		if left[i] == "**" || right[i] == "**" {
+			return true
+		}
+		if !segmentsOverlap(left[i], right[i]) {
+			return false
+		}
+	}
+	// One pattern ran out: it names a directory containing the other's
+	// matches, or both name the same path.
+	shorter := left
+	if len(right) < len(left) {
+		shorter = right
+	}
+	return len(left) == len(right) || !hasGlobMeta(shorter[len(shorter)-1])
+}
+
+func segmentsOverlap(a, b string) bool {
+	switch {
+	case !hasGlobMeta(a) && !hasGlobMeta(b):
+		return a == b
+	case !hasGlobMeta(a):
+		ok, err := path.Match(b, a)
+		return ok || err != nil
+	case !hasGlobMeta(b):
+		ok, err := path.Match(a, b)
+		return ok || err != nil
+	}
+	// Both are globs: only rule out an overlap when their literal suffixes,
+	// such as file extensions, differ.
+	suffixA := a[strings.LastIndexAny(a, "*?]}")+1:]
+	suffixB := b[strings.LastIndexAny(b, "*?]}")+1:]
+	return strings.HasSuffix(suffixA, suffixB) || strings.HasSuffix(suffixB, suffixA)
+}
+
+func hasGlobMeta(segment string) bool {
+	return strings.ContainsAny(segment, "*?[{")
+}
@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+)
+
+func TestTaskConflictsRejectsParallelTasksWritingTheSameOutputs(t *testing.T) {
+	pkg := &Package{Name: "app", Path: "packages/app"}
+	build := &TaskNode{ID: "packages/app#build", Package: pkg, TaskConfig: config.TaskConfig{Outputs: []string{"dist"}}}
+	bundle := &TaskNode{ID: "packages/app#bundle", Package: pkg, TaskConfig: config.TaskConfig{Outputs: []string{"dist/*.js"}}}
+
+	_, err := TaskConflicts([]*TaskNode{build, bundle})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "packages/app#build and packages/app#bundle both write")
+
+	bundle.Dependencies = []*TaskNode{build}
+	_, err = TaskConflicts([]*TaskNode{build, bundle})
+	assert.NoError(t, err, "ordered tasks may refine each other's outputs")
+}
+
+func TestTaskConflictsWarnsAboutUndeclaredReads(t *testing.T) {
+	lib := &TaskNode{ID: "packages/lib#build", Package: &Package{Name: "lib", Path: "packages/lib"}, TaskConfig: config.TaskConfig{Outputs: []string{"dist"}}}
+	app := &TaskNode{ID: "packages/app#build", Package: &Package{Name: "app", Path: "packages/app"}, TaskConfig: config.TaskConfig{
+		Inputs:  []string{"src/**/*.ts", "../lib/dist/**"},
+		Outputs: []string{"dist"},
+	}}
+
+	warnings, err := TaskConflicts([]*TaskNode{lib, app})
+	require.NoError(t, err)
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "packages/app#build reads outputs of packages/lib#build")
+
+	app.Dependencies = []*TaskNode{lib}
+	warnings, err = TaskConflicts([]*TaskNode{lib, app})
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+}
+
+func TestTaskConflictsWarnsWhenInputsIncludeOwnOutputs(t *testing.T) {
+	node := &TaskNode{ID: "build", Package: &Package{Name: "__workspace__", Path: "."}, TaskConfig: config.TaskConfig{
+		Inputs:  []string{"**/*.go"},
+		Outputs: []string{"bin"},
+	}}
+
+	warnings, err := TaskConflicts([]*TaskNode{node})
+	require.NoError(t, err)
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "reads its own outputs")
+}
+
+func TestPatternsOverlap(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want bool
+	}{
+		{"dist", "dist", true},
+		{"dist", "dist/index.js", true},
+		{"dist", "src/**", false},
+		{"dist/*.js", "dist/*.css", false},
+		{"dist/*.js", "dist/main.js", true},
+		{"**/*.ts", "packages/lib/dist", true},
+		{"packages/app/dist", "packages/lib/dist", false},
+		{"src/*.go", "src", true},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, patternsOverlap(tc.a, tc.b), "%s ~ %s", tc.a, tc.b)
+	}
+}
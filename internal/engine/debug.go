@@ -0,0 +1,27 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	debugMu     sync.Mutex
+	debugLogger func(string)
+)
+
+// SetDebugLogger installs a sink for the engine's debug messages covering
+// hashing, remote negotiation and transfers. A nil logger disables them.
+func SetDebugLogger(logger func(message string)) {
+	debugMu.Lock()
+	defer debugMu.Unlock()
+	debugLogger = logger
+}
+
+func debugf(format string, args ...interface{}) {
+	debugMu.Lock()
+	defer debugMu.Unlock()
+	if debugLogger != nil {
+		debugLogger(fmt.Sprintf(format, args...))
+	}
+}
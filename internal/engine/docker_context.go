@@ -0,0 +1,190 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+)
+
+// dockerIgnorePattern is one line of a .dockerignore file. Exclusions,
+// written with a leading "!", re-include paths an earlier line ignored.
+type dockerIgnorePattern struct {
+	pattern   string
+	exclusion bool
+}
+
+// dockerInputFiles lists the files a Docker build reads: its Dockerfile,
+// the .dockerignore in effect and every regular file of the build context
+// that the .dockerignore does not exclude. Paths are joined to packagePath.
+func dockerInputFiles(input config.DockerInput, packagePath string) ([]string, error) {
+	contextDir := strings.TrimSpace(input.Context)
+	if contextDir == "" {
+		contextDir = "."
+	}
+	contextDir = filepath.Join(packagePath, filepath.FromSlash(contextDir))
+	dockerfile := filepath.Join(contextDir, "Dockerfile")
+	if name := strings.TrimSpace(input.Dockerfile); name != "" {
+		dockerfile = filepath.Join(packagePath, filepath.FromSlash(name))
+	}
+
+	info, err := os.Stat(contextDir)
+	if err != nil {
+		return nil, fmt.Errorf("docker context %s: %w", contextDir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("docker context %s is not a directory", contextDir)
+	}
+	if _, err := os.Stat(dockerfile); err != nil {
+		return nil, fmt.Errorf("dockerfile %s: %w", dockerfile, err)
+	}
+
+	ignoreFile, patterns, err := loadDockerignore(dockerfile, contextDir)
+	if err != nil {
+		return nil, err
+	}
+	hasExclusions := false
+	for _, pattern := range patterns {
+		hasExclusions = hasExclusions || pattern.exclusion
+	}
+
+	seen := map[string]bool{dockerfile: true}
+	files := []string{dockerfile}
+	if ignoreFile != "" && !seen[ignoreFile] {
+		seen[ignoreFile] = true
+		files = append(files, ignoreFile)
+	}
+
+	err = filepath.WalkDir(contextDir, func(p string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		rel, err := filepath.Rel(contextDir, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		ignored := dockerIgnored(patterns, rel)
+		if d.IsDir() {
+			// The cache directory changes on every run and is never part of
+			// what a build should depend on.
+			if rel == velocityDirName || (ignored && !hasExclusions) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ignored || !d.Type().IsRegular() || seen[p] {
+			return nil
+		}
+		seen[p] = true
+		files = append(files, p)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk docker context %s: %w", contextDir, err)
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// loadDockerignore reads the ignore file Docker uses for dockerfile: one
+// named after the Dockerfile with a .dockerignore suffix next to it, or
+// else the .dockerignore at the root of the context. It returns an empty
+// path when there is neither.
+func loadDockerignore(dockerfile, contextDir string) (string, []dockerIgnorePattern, error) {
+	for _, candidate := range []string{dockerfile + ".dockerignore", filepath.Join(contextDir, ".dockerignore")} {
+		data, err := os.ReadFile(candidate)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("read %s: %w", candidate, err)
+		}
+		patterns, err := parseDockerignore(data)
+		if err != nil {
+			return "", nil, fmt.Errorf("parse %s: %w", candidate, err)
+		}
+		return candidate, patterns, nil
+	}
+	return "", nil, nil
+}
+
+// parseDockerignore parses .dockerignore contents the way Docker does:
+// blank lines and lines starting with "#" are skipped, patterns are
+// cleaned and made relative to the context root.
+func parseDockerignore(data []byte) ([]dockerIgnorePattern, error) {
+	var patterns []dockerIgnorePattern
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var pattern dockerIgnorePattern
+		if strings.HasPrefix(line, "!") {
+			pattern.exclusion = true
+			line = strings.TrimSpace(line[1:])
+		}
+		line = path.Clean(filepath.ToSlash(line))
+		if len(line) > 1 && line[0] == '/' {
+			line = line[1:]
+		}
+		if !doublestar.ValidatePattern(line) {
+			return nil, fmt.Errorf("invalid pattern %q", line)
+		}
+		pattern.pattern = line
+		patterns = append(patterns, pattern)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return patterns, nil
+}
+
+// dockerIgnored reports whether the slash-separated path rel is left out
+// of the build context. A pattern matching a directory also matches
+// everything below it, and the last matching pattern decides.
+func dockerIgnored(patterns []dockerIgnorePattern, rel string) bool {
+	ignored := false
+	for _, pattern := range patterns {
+		// Only patterns that would flip the outcome need matching.
+		if pattern.exclusion != ignored {
+			continue
+		}
+		if dockerPatternMatches(pattern.pattern, rel) {
+			ignored = !pattern.exclusion
+		}
+	}
+	return ignored
+}
+
+func dockerPatternMatches(pattern, rel string) bool {
+	if ok, _ := doublestar.Match(pattern, rel); ok {
+		return true
+	}
+	for i := strings.IndexByte(rel, '/'); i >= 0; {
+		if ok, _ := doublestar.Match(pattern, rel[:i]); ok {
+			return true
+		}
+		next := strings.IndexByte(rel[i+1:], '/')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+)
+
+func TestDockerIgnoredFollowsDockerRules(t *testing.T) {
+	patterns, err := parseDockerignore([]byte("# comment\n/node_modules\n*.log\n!keep.log\ndocs/**/*.md\n"))
+	require.NoError(t, err)
+
+	assert.True(t, dockerIgnored(patterns, "node_modules/pkg/index.js"))
+	assert.True(t, dockerIgnored(patterns, "build.log"))
+	assert.False(t, dockerIgnored(patterns, "keep.log"))
+	assert.True(t, dockerIgnored(patterns, "docs/guide/intro.md"))
+	assert.False(t, dockerIgnored(patterns, "src/nested/build.log"), "expected *.log to match only the context root")
+	assert.False(t, dockerIgnored(patterns, "src/main.go"))
+}
+
+func TestDockerInputFilesRespectDockerignore(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(rel, contents string) {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+	}
+	writeFile("app/Dockerfile", "FROM scratch\n")
+	writeFile("app/.dockerignore", "tmp\n")
+	writeFile("app/main.go", "package main\n")
+	writeFile("app/tmp/scratch.txt", "ignored\n")
+	writeFile("app/.velocity/cache/key.zip", "artifact\n")
+
+	files, err := dockerInputFiles(config.DockerInput{Context: "app"}, dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "app", ".dockerignore"),
+		filepath.Join(dir, "app", "Dockerfile"),
+		filepath.Join(dir, "app", "main.go"),
+	}, files)
+
+	writeFile("docker/app.Dockerfile", "FROM scratch\n")
+	writeFile("docker/app.Dockerfile.dockerignore", "*.go\n")
+	files, err = dockerInputFiles(config.DockerInput{Context: "app", Dockerfile: "docker/app.Dockerfile"}, dir)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(dir, "docker", "app.Dockerfile"),
+		filepath.Join(dir, "docker", "app.Dockerfile.dockerignore"),
+		filepath.Join(dir, "app", ".dockerignore"),
+		filepath.Join(dir, "app", "Dockerfile"),
+		filepath.Join(dir, "app", "tmp", "scratch.txt"),
+	}, files)
+
+	_, err = dockerInputFiles(config.DockerInput{Context: "missing"}, dir)
+	assert.ErrorContains(t, err, "docker context")
+}
+
+func TestDockerContextChangesCacheKey(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM scratch\nCOPY . .\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".dockerignore"), []byte("*.tmp\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.js"), []byte("one"), 0o644))
+	cfg := config.TaskConfig{Command: "docker build .", Inputs: []string{}, Docker: &config.DockerInput{}}
+
+	first, err := GenerateCacheKey(context.Background(), cfg, nil, dir)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "scratch.tmp"), []byte("ignored"), 0o644))
+	ignored, err := GenerateCacheKey(context.Background(), cfg, nil, dir)
+	require.NoError(t, err)
+	assert.Equal(t, first, ignored, "expected files excluded by .dockerignore to leave the key unchanged")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.js"), []byte("two"), 0o644))
+	changed, err := GenerateCacheKey(context.Background(), cfg, nil, dir)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, changed)
+}
@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dotEnvPaths resolves a task's dot_env entries against its package and
+// returns the files that exist, in order.
+func dotEnvPaths(files []string, packagePath string) ([]string, error) {
+	var paths []string
+	for _, file := range files {
+		file = strings.TrimSpace(file)
+		if file == "" {
+			continue
+		}
+		path := file
+		if !filepath.IsAbs(path) && packagePath != "" {
+			path = filepath.Join(packagePath, path)
+		}
+		path = filepath.Clean(path)
+		info, err := os.Stat(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("stat %q: %w", path, err)
+		}
+		if info.IsDir() {
+			return nil, fmt.Errorf("dot_env entry %q is a directory", path)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// loadDotEnv reads the task's dot_env files. Later files override earlier
+// ones; missing files are skipped.
+func loadDotEnv(files []string, packagePath string) (map[string]string, error) {
+	paths, err := dotEnvPaths(files, packagePath)
+	if err != nil {
+		return nil, err
+	}
+	env := make(map[string]string)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read %q: %w", path, err)
+		}
+		values, err := parseDotEnv(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse %q: %w", path, err)
+		}
+		for key, value := range values {
+			env[key] = value
+		}
+	}
+	return env, nil
+}
+
+// parseDotEnv parses KEY=VALUE lines with optional `export` prefixes,
+// comments, single-quoted literals and double-quoted values with \n, \t, \"
+// and \\ escapes.
+func parseDotEnv(data []byte) (map[string]string, error) {
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNo)
+		}
+		value = strings.TrimSpace(value)
+
+		switch {
+		case strings.HasPrefix(value, `"`):
+			end := closingQuote(value)
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated double quote", lineNo)
+			}
+			value = unescapeDotEnv(value[1:end])
+		case strings.HasPrefix(value, "'"):
+			end := strings.Index(value[1:], "'")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated single quote", lineNo)
+			}
+			value = value[1 : end+1]
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		env[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func closingQuote(value string) int {
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+func unescapeDotEnv(value string) string {
+	return strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`).Replace(value)
+}
@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+)
+
+func TestParseDotEnv(t *testing.T) {
+	env, err := parseDotEnv([]byte(`
+# comment
+PLAIN=value # trailing comment
+export EXPORTED=yes
+DOUBLE="line one\nline \"two\""
+SINGLE='no $expansion \n here'
+EMPTY=
+URL=https://example.com/?a=b#frag
+`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"PLAIN":    "value",
+		"EXPORTED": "yes",
+		"DOUBLE":   "line one\nline \"two\"",
+		"SINGLE":   `no $expansion \n here`,
+		"EMPTY":    "",
+		"URL":      "https://example.com/?a=b#frag",
+	}, env)
+
+	_, err = parseDotEnv([]byte("NOT VALID\n"))
+	assert.ErrorContains(t, err, "line 1")
+	_, err = parseDotEnv([]byte(`KEY="open`))
+	assert.ErrorContains(t, err, "unterminated double quote")
+}
+
+func TestLoadDotEnvLaterFilesWin(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("A=1\nB=1\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env.production"), []byte("B=2\n"), 0o644))
+
+	env, err := loadDotEnv([]string{".env", ".env.local", ".env.production"}, dir)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"A": "1", "B": "2"}, env)
+}
+
+func TestDotEnvChangesCacheKey(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.TaskConfig{Command: "npm run build", DotEnv: []string{".env"}}
+
+	missing, err := GenerateCacheKey(context.Background(), cfg, nil, dir)
+	require.NoError(t, err)
+	plain, err := GenerateCacheKey(context.Background(), config.TaskConfig{Command: "npm run build"}, nil, dir)
+	require.NoError(t, err)
+	assert.Equal(t, plain, missing, "expected missing dot_env files to leave the key unchanged")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("API_URL=a\n"), 0o644))
+	first, err := GenerateCacheKey(context.Background(), cfg, nil, dir)
+	require.NoError(t, err)
+	assert.NotEqual(t, missing, first)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("API_URL=b\n"), 0o644))
+	second, err := GenerateCacheKey(context.Background(), cfg, nil, dir)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second, "expected a changed .env file to alter the key")
+}
+
+func TestTaskEnvPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("FROM_FILE=file\nALREADY_SET=file\nOVERRIDDEN=file\n"), 0o644))
+	t.Setenv("ALREADY_SET", "process")
+
+	cfg := config.TaskConfig{DotEnv: []string{".env"}}.WithEnv(map[string]string{"OVERRIDDEN": "flag"})
+	env, err := taskEnv(cfg, dir)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"FROM_FILE": "file", "OVERRIDDEN": "flag"}, env)
+}
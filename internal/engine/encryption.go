@@ -0,0 +1,206 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Encrypted artifacts start with encryptionMagic and an 8-byte random nonce
+// prefix, followed by the artifact in AES-GCM sealed chunks of
+// encryptionChunkSize plaintext bytes. Each chunk's nonce is the prefix and
+// its index; the final chunk, which is shorter than the rest and possibly
+// empty, is authenticated as such so truncation is detected.
+var encryptionMagic = []byte("VELOENC1")
+
+const (
+	encryptionChunkSize   = 64 << 10
+	encryptionNoncePrefix = 8
+)
+
+var (
+	// ErrEncryptedArtifact is returned when a downloaded artifact is
+	// encrypted but no encryption key is configured.
+	ErrEncryptedArtifact = errors.New("artifact is encrypted but no remote.encryption_key is configured")
+	// ErrDecryptArtifact is returned when an artifact cannot be decrypted,
+	// usually because it was encrypted with a different key.
+	ErrDecryptArtifact = errors.New("artifact could not be decrypted with the configured key")
+)
+
+func newArtifactCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, index uint32) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptionNoncePrefix:], index)
+	return nonce
+}
+
+func chunkAAD(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+func sealArtifact(key []byte, r io.Reader, w io.Writer) error {
+	aead, err := newArtifactCipher(key)
+	if err != nil {
+		return err
+	}
+	prefix := make([]byte, encryptionNoncePrefix)
+	if _, err := rand.Read(prefix); err != nil {
+		return fmt.Errorf("generate nonce: %w", err)
+	}
+	if _, err := w.Write(append(append([]byte(nil), encryptionMagic...), prefix...)); err != nil {
+		return err
+	}
+
+	plain := make([]byte, encryptionChunkSize)
+	sealed := make([]byte, 0, encryptionChunkSize+aead.Overhead())
+	for index := uint32(0); ; index++ {
+		n, err := io.ReadFull(r, plain)
+		last := n < encryptionChunkSize
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+		sealed = aead.Seal(sealed[:0], chunkNonce(prefix, index), plain[:n], chunkAAD(last))
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if index == ^uint32(0) {
+			return errors.New("artifact too large to encrypt")
+		}
+	}
+}
+
+func openArtifact(key []byte, r io.Reader, w io.Writer) error {
+	aead, err := newArtifactCipher(key)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, len(encryptionMagic)+encryptionNoncePrefix)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.HasPrefix(header, encryptionMagic) {
+		return fmt.Errorf("%w: missing header", ErrDecryptArtifact)
+	}
+	prefix := header[len(encryptionMagic):]
+
+	sealed := make([]byte, encryptionChunkSize+aead.Overhead())
+	var plain []byte
+	for index := uint32(0); ; index++ {
+		n, err := io.ReadFull(r, sealed)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+		last := n < len(sealed)
+		plain, err = aead.Open(plain[:0], chunkNonce(prefix, index), sealed[:n], chunkAAD(last))
+		if err != nil {
+			return fmt.Errorf("%w: chunk %d", ErrDecryptArtifact, index)
+		}
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// SealArtifact returns the file to upload for the artifact at path under
+// cacheKey: a copy that is encrypted and then signed, as configured, which
+// the caller must remove, or path itself.
+func (c *RemoteClient) SealArtifact(cacheKey, path string) (string, error) {
+	upload := path
+	if len(c.encryptionKey) > 0 {
+		encrypted, err := rewriteArtifact(path, "velo-enc-*", func(r io.Reader, w io.Writer) error {
+			return sealArtifact(c.encryptionKey, r, w)
+		})
+		if err != nil {
+			return "", err
+		}
+		upload = encrypted
+	}
+
+	signed, err := c.signArtifact(cacheKey, upload)
+	if upload != path && (err != nil || signed != upload) {
+		os.Remove(upload)
+	}
+	return signed, err
+}
+
+// OpenArtifact verifies and decrypts a downloaded artifact in place.
+// Unencrypted artifacts are left as they are when no key is configured.
+func (c *RemoteClient) OpenArtifact(cacheKey, path string) error {
+	if err := c.verifyArtifact(cacheKey, path); err != nil {
+		return err
+	}
+	encrypted, err := hasArtifactHeader(path, encryptionMagic)
+	if err != nil {
+		return fmt.Errorf("open artifact: %w", err)
+	}
+	switch {
+	case !encrypted && len(c.encryptionKey) == 0:
+		return nil
+	case !encrypted:
+		return fmt.Errorf("%w: artifact is not encrypted", ErrDecryptArtifact)
+	case len(c.encryptionKey) == 0:
+		return ErrEncryptedArtifact
+	}
+
+	plain, err := rewriteArtifact(path, "velo-dec-*", func(r io.Reader, w io.Writer) error {
+		return openArtifact(c.encryptionKey, r, w)
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(plain, path); err != nil {
+		os.Remove(plain)
+		return fmt.Errorf("replace artifact: %w", err)
+	}
+	return nil
+}
+
+// rewriteArtifact streams path through fn into a new temporary file next to
+// it and returns that file's path.
+func rewriteArtifact(path, pattern string, fn func(io.Reader, io.Writer) error) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open artifact: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(path), pattern)
+	if err != nil {
+		return "", fmt.Errorf("create temp artifact: %w", err)
+	}
+	w := bufio.NewWriter(out)
+	err = fn(bufio.NewReader(in), w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
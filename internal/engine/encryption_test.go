@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealAndOpenArtifactRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	for _, size := range []int{0, 10, encryptionChunkSize, 3*encryptionChunkSize + 5} {
+		plain := make([]byte, size)
+		_, _ = rand.Read(plain)
+
+		var sealed bytes.Buffer
+		require.NoError(t, sealArtifact(key, bytes.NewReader(plain), &sealed))
+		assert.False(t, size > 0 && bytes.Contains(sealed.Bytes(), plain))
+
+		var opened bytes.Buffer
+		require.NoError(t, openArtifact(key, bytes.NewReader(sealed.Bytes()), &opened), "size %d", size)
+		assert.Equal(t, string(plain), opened.String(), "size %d", size)
+	}
+}
+
+func TestOpenArtifactRejectsWrongKeyAndTruncation(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	plain := bytes.Repeat([]byte("x"), 2*encryptionChunkSize+1)
+	var sealed bytes.Buffer
+	require.NoError(t, sealArtifact(key, bytes.NewReader(plain), &sealed))
+
+	err := openArtifact(bytes.Repeat([]byte{8}, 32), bytes.NewReader(sealed.Bytes()), &bytes.Buffer{})
+	assert.ErrorIs(t, err, ErrDecryptArtifact)
+
+	// Dropping the final chunk leaves a full chunk that was not sealed as
+	// the last one.
+	truncated := sealed.Bytes()[:len(encryptionMagic)+encryptionNoncePrefix+2*(encryptionChunkSize+16)]
+	err = openArtifact(key, bytes.NewReader(truncated), &bytes.Buffer{})
+	assert.ErrorIs(t, err, ErrDecryptArtifact)
+}
+
+func TestRemoteClientSealsAndOpensArtifacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "artifact.zip")
+	require.NoError(t, os.WriteFile(path, []byte("build output"), 0o644))
+
+	client := NewRemoteClient("http://cache.example", "")
+	upload, err := client.SealArtifact("key", path)
+	require.NoError(t, err)
+	assert.Equal(t, path, upload, "no key leaves the artifact as is")
+
+	client.SetEncryptionKey(bytes.Repeat([]byte{1}, 32))
+	upload, err = client.SealArtifact("key", path)
+	require.NoError(t, err)
+	defer os.Remove(upload)
+	assert.NotEqual(t, path, upload)
+
+	assert.ErrorIs(t, NewRemoteClient("http://cache.example", "").OpenArtifact("key", upload), ErrEncryptedArtifact)
+
+	require.NoError(t, client.OpenArtifact("key", upload))
+	data, err := os.ReadFile(upload)
+	require.NoError(t, err)
+	assert.Equal(t, "build output", string(data))
+
+	assert.ErrorIs(t, client.OpenArtifact("key", path), ErrDecryptArtifact, "a plain artifact is rejected when a key is configured")
+}
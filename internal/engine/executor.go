@@ -1,56 +1,102 @@
 package engine
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"runtime"
+	"sort"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/bit2swaz/velocity-cache/internal/config"
 )
 
-func Execute(cfg config.TaskConfig, packagePath string) (int, error) {
-	return executeWithWriters(cfg, packagePath, os.Stdout, os.Stderr)
+func Execute(ctx context.Context, cfg config.TaskConfig, packagePath string) (int, error) {
+	return executeWithWriters(ctx, cfg, packagePath, os.Stdout, os.Stderr)
 }
 
-func executeWithWriters(cfg config.TaskConfig, packagePath string, stdout, stderr io.Writer) (int, error) {
+func ExecuteWithWriters(ctx context.Context, cfg config.TaskConfig, packagePath string, stdout, stderr io.Writer) (int, error) {
+	return executeWithWriters(ctx, cfg, packagePath, stdout, stderr)
+}
+
+// TimeoutError is returned when a task runs longer than its configured
+// timeout. It exits with 124, matching timeout(1).
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", e.Timeout)
+}
+
+func (e *TimeoutError) ExitCode() int {
+	return 124
+}
+
+func executeWithWriters(ctx context.Context, cfg config.TaskConfig, packagePath string, stdout, stderr io.Writer) (int, error) {
 	command := strings.TrimSpace(cfg.Command)
 	if command == "" {
 		return -1, errors.New("command is empty")
 	}
 
-	originalWd := ""
+	timeout, err := cfg.TimeoutDuration()
+	if err != nil {
+		return -1, err
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	env, err := taskEnv(cfg, packagePath)
+	if err != nil {
+		return -1, err
+	}
+
+	dir := ""
 	if strings.TrimSpace(packagePath) != "" {
-		wd, err := os.Getwd()
+		info, err := os.Stat(packagePath)
 		if err != nil {
-			return -1, fmt.Errorf("getwd: %w", err)
-		}
-		if err := os.Chdir(packagePath); err != nil {
 			return -1, fmt.Errorf("chdir to %s: %w", packagePath, err)
 		}
-		originalWd = wd
-		defer func() {
-			if originalWd != "" {
-				_ = os.Chdir(originalWd)
-			}
-		}()
+		if !info.IsDir() {
+			return -1, fmt.Errorf("chdir to %s: not a directory", packagePath)
+		}
+		dir = packagePath
 	}
 
 	shell := defaultShell()
-	cmd := exec.Command(shell[0], append(shell[1:], command)...)
+	cmd := exec.CommandContext(ctx, shell[0], append(shell[1:], command)...)
+	cmd.Dir = dir
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
 	cmd.Stdin = os.Stdin
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), envPairs(env)...)
+	}
+	// Run the command in its own process group so that cancellation and
+	// timeouts kill everything it spawned, not just the shell.
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
 
 	if err := cmd.Run(); err != nil {
+		if timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return 124, &TimeoutError{Timeout: timeout}
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return -1, fmt.Errorf("execute command: %w", ctxErr)
+		}
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
-			exitCode := exitCodeFromSys(exitErr.Sys())
-			return exitCode, err
+			return exitCodeOf(exitErr), err
 		}
 
 		return -1, fmt.Errorf("execute command: %w", err)
@@ -59,6 +105,40 @@ func executeWithWriters(cfg config.TaskConfig, packagePath string, stdout, stder
 	return 0, nil
 }
 
+// taskEnv returns the variables to set on top of the process environment:
+// dot_env values for variables that are not already set, then the command
+// line overrides.
+func taskEnv(cfg config.TaskConfig, packagePath string) (map[string]string, error) {
+	env := make(map[string]string, len(cfg.Env))
+	if len(cfg.DotEnv) > 0 {
+		values, err := loadDotEnv(cfg.DotEnv, packagePath)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range values {
+			if _, set := os.LookupEnv(key); !set {
+				env[key] = value
+			}
+		}
+	}
+	for key, value := range cfg.Env {
+		env[key] = value
+	}
+	return env, nil
+}
+
+// envPairs formats env as sorted KEY=VALUE pairs. exec.Cmd uses the last
+// value of a duplicated key, so appending them overrides the inherited
+// environment.
+func envPairs(env map[string]string) []string {
+	pairs := make([]string, 0, len(env))
+	for key, value := range env {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
 func defaultShell() []string {
 	if runtime.GOOS == "windows" {
 		return []string{"cmd", "/C"}
@@ -66,10 +146,14 @@ func defaultShell() []string {
 	return []string{"/bin/sh", "-c"}
 }
 
-func exitCodeFromSys(sys interface{}) int {
-	status, ok := sys.(syscall.WaitStatus)
-	if !ok {
-		return -1
+// exitCodeOf returns the child's exit code, mapping death by signal to the
+// shell convention of 128+signal.
+func exitCodeOf(exitErr *exec.ExitError) int {
+	if code := exitErr.ExitCode(); code >= 0 {
+		return code
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
 	}
-	return status.ExitStatus()
+	return 1
 }
@@ -2,9 +2,13 @@ package engine
 
 import (
 	"bytes"
+	"context"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/bit2swaz/velocity-cache/internal/config"
 	"github.com/stretchr/testify/assert"
@@ -35,21 +39,94 @@ func main() {
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
 
-	code, err := executeWithWriters(cfg, tmpDir, &stdout, &stderr)
+	code, err := executeWithWriters(context.Background(), cfg, tmpDir, &stdout, &stderr)
 	assert.NoError(t, err)
 	assert.Equal(t, 0, code)
 	assert.Contains(t, stdout.String(), "stdout message")
 	assert.Contains(t, stderr.String(), "stderr message")
 }
 
+func TestExecuteAppliesEnvOverrides(t *testing.T) {
+	t.Setenv("VELOCITY_TEST_VALUE", "inherited")
+	tmpDir := t.TempDir()
+
+	script := `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println(os.Getenv("VELOCITY_TEST_VALUE"))
+}
+`
+	progPath := filepath.Join(tmpDir, "main.go")
+	require.NoError(t, os.WriteFile(progPath, []byte(script), 0o644))
+
+	cfg := config.TaskConfig{Command: "go run " + progPath}.WithEnv(map[string]string{"VELOCITY_TEST_VALUE": "override"})
+
+	var stdout bytes.Buffer
+	code, err := executeWithWriters(context.Background(), cfg, tmpDir, &stdout, io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "override\n", stdout.String())
+}
+
 func TestExecuteFailure(t *testing.T) {
 	cfg := config.TaskConfig{Command: "sh -c 'echo fail >&2; exit 1'"}
 
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
 
-	code, err := executeWithWriters(cfg, t.TempDir(), &stdout, &stderr)
+	code, err := executeWithWriters(context.Background(), cfg, t.TempDir(), &stdout, &stderr)
 	assert.Error(t, err)
 	assert.NotEqual(t, 0, code)
 	assert.Contains(t, stderr.String(), "fail")
 }
+
+func TestExecuteTimeoutKillsProcessGroup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses POSIX shell commands")
+	}
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "finished")
+	cfg := config.TaskConfig{
+		Command: "(sleep 1; touch " + marker + ") & sleep 5",
+		Timeout: "100ms",
+	}
+
+	start := time.Now()
+	code, err := executeWithWriters(context.Background(), cfg, dir, io.Discard, io.Discard)
+	var timeoutErr *TimeoutError
+	require.ErrorAs(t, err, &timeoutErr)
+	assert.Equal(t, 124, code)
+	assert.Less(t, time.Since(start), 3*time.Second)
+
+	time.Sleep(1500 * time.Millisecond)
+	assert.NoFileExists(t, marker, "background children must be killed with the group")
+}
+
+func TestExecutePropagatesExitCode(t *testing.T) {
+	code, err := executeWithWriters(context.Background(), config.TaskConfig{Command: "exit 7"}, t.TempDir(), io.Discard, io.Discard)
+	require.Error(t, err)
+	assert.Equal(t, 7, code)
+}
+
+func TestExecuteCancellationKillsProcessGroup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses POSIX shell commands")
+	}
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "finished")
+	cfg := config.TaskConfig{Command: "(sleep 1; touch " + marker + ") & sleep 5"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	_, err := executeWithWriters(ctx, cfg, dir, io.Discard, io.Discard)
+	require.ErrorIs(t, err, context.Canceled)
+
+	time.Sleep(1500 * time.Millisecond)
+	assert.NoFileExists(t, marker)
+}
@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// extractStageDir prefixes the staging directories created next to
+// restored outputs.
+const extractStageDir = ".velocity-extract-"
+
+// extractStage collects restored outputs in staging directories next to
+// their targets, so that moving them into place is a rename on the same
+// filesystem. Until commit, the previous outputs are untouched.
+type extractStage struct {
+	// dirs maps a target's parent directory to its staging directory.
+	dirs map[string]string
+	// targets are swapped in this order; a target that is not in staged
+	// is removed.
+	targets []string
+	staged  map[string]string
+}
+
+func newExtractStage() *extractStage {
+	return &extractStage{dirs: make(map[string]string), staged: make(map[string]string)}
+}
+
+// path returns where target's new content is written. Paths inside an
+// already staged directory resolve into that directory.
+func (s *extractStage) path(target string) (string, error) {
+	target = filepath.Clean(target)
+	if staged, ok := s.staged[target]; ok {
+		return staged, nil
+	}
+	for _, dir := range s.targets {
+		if rel, err := filepath.Rel(dir, target); err == nil && s.staged[dir] != "" && rel != "." && !strings.HasPrefix(rel, "..") {
+			return filepath.Join(s.staged[dir], rel), nil
+		}
+	}
+
+	stagingDir, err := s.stagingDir(filepath.Dir(target))
+	if err != nil {
+		return "", err
+	}
+	staged := filepath.Join(stagingDir, "new", filepath.Base(target))
+	if err := os.MkdirAll(filepath.Dir(staged), 0o755); err != nil {
+		return "", err
+	}
+	s.targets = append(s.targets, target)
+	s.staged[target] = staged
+	return staged, nil
+}
+
+// finalPath returns where a staged path ends up after commit.
+func (s *extractStage) finalPath(staged string) string {
+	for target, stagedTarget := range s.staged {
+		if stagedTarget == "" {
+			continue
+		}
+		if rel, err := filepath.Rel(stagedTarget, staged); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.Join(target, rel)
+		}
+	}
+	return staged
+}
+
+// remove schedules target for removal on commit.
+func (s *extractStage) remove(target string) error {
+	target = filepath.Clean(target)
+	if _, err := s.stagingDir(filepath.Dir(target)); err != nil {
+		return err
+	}
+	s.targets = append(s.targets, target)
+	return nil
+}
+
+func (s *extractStage) stagingDir(parent string) (string, error) {
+	if dir, ok := s.dirs[parent]; ok {
+		return dir, nil
+	}
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp(parent, extractStageDir+"*")
+	if err != nil {
+		return "", err
+	}
+	s.dirs[parent] = dir
+	return dir, nil
+}
+
+type extractSwap struct {
+	target    string
+	backup    string
+	installed bool
+}
+
+// commit moves the previous outputs aside and the staged ones into place.
+// If any move fails, the outputs already swapped are put back.
+func (s *extractStage) commit() error {
+	done := make([]extractSwap, 0, len(s.targets))
+	for _, target := range s.targets {
+		swap := extractSwap{target: target}
+		if _, err := os.Lstat(target); err == nil {
+			swap.backup = filepath.Join(s.dirs[filepath.Dir(target)], "old", filepath.Base(target))
+			err := os.MkdirAll(filepath.Dir(swap.backup), 0o755)
+			if err == nil {
+				err = os.Rename(target, swap.backup)
+			}
+			if err != nil {
+				s.rollback(done)
+				return fmt.Errorf("move aside %s: %w", target, err)
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			s.rollback(done)
+			return fmt.Errorf("stat %s: %w", target, err)
+		}
+
+		if staged := s.staged[target]; staged != "" {
+			if err := os.Rename(staged, target); err != nil {
+				s.rollback(append(done, swap))
+				return fmt.Errorf("move %s into place: %w", target, err)
+			}
+			swap.installed = true
+		}
+		done = append(done, swap)
+	}
+	return nil
+}
+
+func (s *extractStage) rollback(done []extractSwap) {
+	for i := len(done) - 1; i >= 0; i-- {
+		swap := done[i]
+		if swap.installed {
+			os.RemoveAll(swap.target)
+		}
+		if swap.backup != "" {
+			if err := os.Rename(swap.backup, swap.target); err != nil {
+				debugf("extract: restore %s: %v", swap.target, err)
+			}
+		}
+	}
+}
+
+// discard removes the staging directories, along with the previous outputs
+// after a successful commit.
+func (s *extractStage) discard() {
+	for _, dir := range s.dirs {
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cacheFailureExt names the record of a failed run kept next to artifacts.
+const cacheFailureExt = ".failure.json"
+
+// ErrCachedFailure marks a task failure replayed from the failure cache.
+// The scheduler does not retry it.
+var ErrCachedFailure = errors.New("cached failure")
+
+// FailureRecord is the result of a failed run of a task, replayed by runs
+// with the same cache key until it expires.
+type FailureRecord struct {
+	ExitCode   int       `json:"exit_code"`
+	Log        string    `json:"log,omitempty"`
+	RecordedAt time.Time `json:"recorded_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+func localCacheFailure(cacheKey string) (string, error) {
+	if err := validateCacheKey(cacheKey); err != nil {
+		return "", err
+	}
+	dir, err := localCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, cacheKey+cacheFailureExt), nil
+}
+
+// SaveFailure records a failed run for cacheKey.
+func SaveFailure(cacheKey string, record FailureRecord) error {
+	path, err := localCacheFailure(cacheKey)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encode failure record: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("save failure record ensure dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+cacheKey+"-*"+cacheTmpExt)
+	if err != nil {
+		return fmt.Errorf("save failure record %s: %w", path, err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("save failure record %s: %w", path, err)
+	}
+	return nil
+}
+
+// CheckFailure returns the unexpired failure recorded for cacheKey, if any.
+// Expired and unreadable records are removed.
+func CheckFailure(cacheKey string) (*FailureRecord, bool, error) {
+	path, err := localCacheFailure(cacheKey)
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("read failure record %s: %w", path, err)
+	}
+	var record FailureRecord
+	if err := json.Unmarshal(data, &record); err != nil || !time.Now().Before(record.ExpiresAt) {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, false, fmt.Errorf("remove failure record %s: %w", path, err)
+		}
+		return nil, false, nil
+	}
+	return &record, true, nil
+}
+
+// RemoveFailure deletes the failure recorded for cacheKey, if any.
+func RemoveFailure(cacheKey string) error {
+	path, err := localCacheFailure(cacheKey)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove failure record %s: %w", path, err)
+	}
+	return nil
+}
+
+// pruneFailures removes expired failure records.
+func pruneFailures() error {
+	dir, err := localCacheDir()
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read local cache %s: %w", dir, err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, cacheFailureExt) {
+			continue
+		}
+		if _, _, err := CheckFailure(strings.TrimSuffix(name, cacheFailureExt)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailureRecordsExpire(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		now := time.Now()
+		require.NoError(t, SaveFailure("live", FailureRecord{ExitCode: 2, Log: "boom\n", RecordedAt: now, ExpiresAt: now.Add(time.Hour)}))
+		require.NoError(t, SaveFailure("stale", FailureRecord{ExitCode: 1, RecordedAt: now.Add(-time.Hour), ExpiresAt: now.Add(-time.Minute)}))
+
+		record, found, err := CheckFailure("live")
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, 2, record.ExitCode)
+		assert.Equal(t, "boom\n", record.Log)
+
+		_, found, err = CheckFailure("stale")
+		require.NoError(t, err)
+		assert.False(t, found)
+		_, err = os.Stat(filepath.Join(root, ".velocity", "cache", "stale"+cacheFailureExt))
+		assert.ErrorIs(t, err, os.ErrNotExist, "expired records are removed")
+	})
+}
+
+func TestSaveLocalClearsFailureRecord(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		now := time.Now()
+		require.NoError(t, SaveFailure("key", FailureRecord{ExitCode: 1, RecordedAt: now, ExpiresAt: now.Add(time.Hour)}))
+
+		src := filepath.Join(root, "source.zip")
+		require.NoError(t, os.WriteFile(src, []byte("zipdata"), 0o644))
+		_, err := saveLocal("key", src)
+		require.NoError(t, err)
+
+		_, found, err := CheckFailure("key")
+		require.NoError(t, err)
+		assert.False(t, found, "a successful run supersedes the recorded failure")
+	})
+}
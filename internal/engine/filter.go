@@ -0,0 +1,176 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// PackageFilter is a parsed --filter expression.
+//
+//	@repo/*        packages whose name (or path) matches the glob
+//	!@repo/docs    exclude matching packages
+//	...@repo/ui    @repo/ui and every package that depends on it
+//	@repo/web...   @repo/web and everything it depends on
+//	...^@repo/ui   only the dependents, not @repo/ui itself
+type PackageFilter struct {
+	Selector          string
+	Exclude           bool
+	IncludeDependents bool
+	IncludeDeps       bool
+	ExcludeSelf       bool
+}
+
+func ParsePackageFilter(raw string) (PackageFilter, error) {
+	expr := strings.TrimSpace(raw)
+	var filter PackageFilter
+
+	if strings.HasPrefix(expr, "!") {
+		filter.Exclude = true
+		expr = expr[1:]
+	}
+	if strings.HasPrefix(expr, "...") {
+		filter.IncludeDependents = true
+		expr = expr[3:]
+		if strings.HasPrefix(expr, "^") {
+			filter.ExcludeSelf = true
+			expr = expr[1:]
+		}
+	}
+	if strings.HasSuffix(expr, "...") {
+		filter.IncludeDeps = true
+		expr = strings.TrimSuffix(expr, "...")
+		if strings.HasSuffix(expr, "^") {
+			filter.ExcludeSelf = true
+			expr = strings.TrimSuffix(expr, "^")
+		}
+	}
+
+	filter.Selector = strings.TrimPrefix(expr, "./")
+	if filter.Selector == "" {
+		return PackageFilter{}, fmt.Errorf("invalid filter %q: missing package selector", raw)
+	}
+	if !doublestar.ValidatePattern(filter.Selector) {
+		return PackageFilter{}, fmt.Errorf("invalid filter %q: bad glob pattern", raw)
+	}
+	return filter, nil
+}
+
+func (f PackageFilter) matches(pkg *Package) bool {
+	if ok, _ := doublestar.Match(f.Selector, pkg.Name); ok {
+		return true
+	}
+	ok, _ := doublestar.Match(strings.TrimSuffix(f.Selector, "/"), filepath.ToSlash(filepath.Clean(pkg.Path)))
+	return ok
+}
+
+// FilterPackages applies filter expressions to packages. Inclusions are
+// unioned and exclusions removed afterwards; when only exclusions are given
+// they apply to every package.
+func FilterPackages(packages map[string]*Package, filters []string) (map[string]*Package, error) {
+	parsed := make([]PackageFilter, 0, len(filters))
+	hasInclude := false
+	for _, raw := range filters {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		filter, err := ParsePackageFilter(raw)
+		if err != nil {
+			return nil, err
+		}
+		if !filter.Exclude {
+			hasInclude = true
+		}
+		parsed = append(parsed, filter)
+	}
+
+	dependents := make(map[string][]*Package, len(packages))
+	for _, pkg := range packages {
+		for _, dep := range pkg.InternalDepNames {
+			dependents[dep] = append(dependents[dep], pkg)
+		}
+	}
+
+	selected := make(map[string]*Package)
+	if !hasInclude {
+		for name, pkg := range packages {
+			selected[name] = pkg
+		}
+	}
+
+	for _, include := range []bool{true, false} {
+		for _, filter := range parsed {
+			if filter.Exclude == include {
+				continue
+			}
+			for _, pkg := range filter.expand(packages, dependents) {
+				if include {
+					selected[pkg.Name] = pkg
+				} else {
+					delete(selected, pkg.Name)
+				}
+			}
+		}
+	}
+	return selected, nil
+}
+
+// expand returns the packages a filter selects, following dependency edges
+// in the requested directions.
+func (f PackageFilter) expand(packages map[string]*Package, dependents map[string][]*Package) []*Package {
+	result := make(map[string]*Package)
+	var walk func(pkg *Package, next func(*Package) []*Package, seen map[string]bool)
+	walk = func(pkg *Package, next func(*Package) []*Package, seen map[string]bool) {
+		for _, n := range next(pkg) {
+			if seen[n.Name] {
+				continue
+			}
+			seen[n.Name] = true
+			result[n.Name] = n
+			walk(n, next, seen)
+		}
+	}
+	deps := func(pkg *Package) []*Package {
+		out := make([]*Package, 0, len(pkg.InternalDepNames))
+		for _, name := range pkg.InternalDepNames {
+			if dep, ok := packages[name]; ok {
+				out = append(out, dep)
+			}
+		}
+		return out
+	}
+	users := func(pkg *Package) []*Package {
+		return dependents[pkg.Name]
+	}
+
+	var matched []*Package
+	for _, pkg := range packages {
+		if f.matches(pkg) {
+			matched = append(matched, pkg)
+		}
+	}
+	seenDependents, seenDeps := make(map[string]bool), make(map[string]bool)
+	for _, pkg := range matched {
+		if f.IncludeDependents {
+			walk(pkg, users, seenDependents)
+		}
+		if f.IncludeDeps {
+			walk(pkg, deps, seenDeps)
+		}
+	}
+	// With ^, matched packages are only kept when another matched package
+	// reaches them, e.g. ...^@repo/* keeps @repo/ui when @repo/web uses it.
+	if !f.ExcludeSelf {
+		for _, pkg := range matched {
+			result[pkg.Name] = pkg
+		}
+	}
+
+	out := make([]*Package, 0, len(result))
+	for _, pkg := range result {
+		out = append(out, pkg)
+	}
+	return out
+}
@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func filterFixture() map[string]*Package {
+	return map[string]*Package{
+		"@repo/lib":  {Name: "@repo/lib", Path: "packages/lib"},
+		"@repo/ui":   {Name: "@repo/ui", Path: "packages/ui", InternalDepNames: []string{"@repo/lib"}},
+		"@repo/web":  {Name: "@repo/web", Path: "apps/web", InternalDepNames: []string{"@repo/ui"}},
+		"@repo/docs": {Name: "@repo/docs", Path: "apps/docs", InternalDepNames: []string{"@repo/ui"}},
+	}
+}
+
+func TestFilterPackages(t *testing.T) {
+	cases := []struct {
+		name    string
+		filters []string
+		want    []string
+	}{
+		{"name glob", []string{"@repo/*"}, []string{"@repo/docs", "@repo/lib", "@repo/ui", "@repo/web"}},
+		{"path glob", []string{"./apps/*"}, []string{"@repo/docs", "@repo/web"}},
+		{"exclusion", []string{"@repo/*", "!@repo/docs"}, []string{"@repo/lib", "@repo/ui", "@repo/web"}},
+		{"only exclusions", []string{"!apps/*"}, []string{"@repo/lib", "@repo/ui"}},
+		{"dependents", []string{"...@repo/ui"}, []string{"@repo/docs", "@repo/ui", "@repo/web"}},
+		{"dependents without self", []string{"...^@repo/ui"}, []string{"@repo/docs", "@repo/web"}},
+		{"dependencies", []string{"@repo/web..."}, []string{"@repo/lib", "@repo/ui", "@repo/web"}},
+		{"dependencies without self", []string{"@repo/web^..."}, []string{"@repo/lib", "@repo/ui"}},
+		{"union", []string{"@repo/lib", "@repo/docs"}, []string{"@repo/docs", "@repo/lib"}},
+		{"no match", []string{"@other/*"}, []string{}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			selected, err := FilterPackages(filterFixture(), tc.filters)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, sortedPackageNames(selected))
+		})
+	}
+}
+
+func TestParsePackageFilterRejectsEmptySelector(t *testing.T) {
+	_, err := ParsePackageFilter("...")
+	assert.Error(t, err)
+
+	_, err = ParsePackageFilter("!")
+	assert.Error(t, err)
+}
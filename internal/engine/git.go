@@ -0,0 +1,265 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+func runGit(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg != "" {
+			return nil, fmt.Errorf("git %s: %s", strings.Join(args, " "), msg)
+		}
+		return nil, fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+	return out, nil
+}
+
+// ChangedFiles lists files that differ between ref and the working tree,
+// including untracked files that are not ignored. Paths are relative to
+// the current working directory.
+func ChangedFiles(ctx context.Context, ref string) ([]string, error) {
+	if strings.TrimSpace(ref) == "" {
+		return nil, fmt.Errorf("changed files: ref is empty")
+	}
+
+	diff, err := runGit(ctx, "", "diff", "--name-only", "--relative", ref, "--")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := runGit(ctx, "", "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	files := make([]string, 0)
+	for _, line := range strings.Split(string(diff)+"\n"+string(untracked), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		path := filepath.Clean(filepath.FromSlash(line))
+		if _, ok := seen[path]; ok {
+			continue
+		}
+		seen[path] = struct{}{}
+		files = append(files, path)
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// AffectedPackages returns the packages containing any of the changed files
+// together with every package that depends on them, directly or
+// transitively.
+func AffectedPackages(packages map[string]*Package, changed []string) map[string]*Package {
+	affected := make(map[string]*Package)
+	if len(changed) == 0 {
+		return affected
+	}
+
+	for _, file := range changed {
+		if filepath.Base(file) == "velocity.yml" && filepath.Dir(file) == "." {
+			for name, pkg := range packages {
+				affected[name] = pkg
+			}
+			return affected
+		}
+	}
+
+	for name, pkg := range packages {
+		pkgPath := filepath.Clean(pkg.Path)
+		for _, file := range changed {
+			if pkgPath == "." || file == pkgPath || strings.HasPrefix(file, pkgPath+string(filepath.Separator)) {
+				affected[name] = pkg
+				break
+			}
+		}
+	}
+
+	dependents := make(map[string][]string)
+	for name, pkg := range packages {
+		for _, dep := range pkg.InternalDepNames {
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+
+	queue := make([]string, 0, len(affected))
+	for name := range affected {
+		queue = append(queue, name)
+	}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, dependent := range dependents[name] {
+			if _, ok := affected[dependent]; ok {
+				continue
+			}
+			affected[dependent] = packages[dependent]
+			queue = append(queue, dependent)
+		}
+	}
+
+	return affected
+}
+
+var inputDiscovery struct {
+	mu    sync.Mutex
+	mode  string
+	files []string
+	err   error
+	done  bool
+}
+
+// SetInputDiscovery selects how input globs are resolved. With
+// config.InputDiscoveryGit they are matched against `git ls-files` instead
+// of walking the filesystem, which avoids descending into node_modules and
+// build directories.
+func SetInputDiscovery(mode string) {
+	inputDiscovery.mu.Lock()
+	defer inputDiscovery.mu.Unlock()
+	inputDiscovery.mode = mode
+	inputDiscovery.files, inputDiscovery.err, inputDiscovery.done = nil, nil, false
+}
+
+func inputDiscoveryMode() string {
+	inputDiscovery.mu.Lock()
+	defer inputDiscovery.mu.Unlock()
+	return inputDiscovery.mode
+}
+
+// gitIndexFiles lists tracked files that still exist in the working tree
+// plus untracked files that are not ignored, relative to the current
+// directory. The listing is taken once and reused for every task.
+func gitIndexFiles() ([]string, error) {
+	inputDiscovery.mu.Lock()
+	defer inputDiscovery.mu.Unlock()
+	if inputDiscovery.done {
+		return inputDiscovery.files, inputDiscovery.err
+	}
+	inputDiscovery.done = true
+
+	ctx := context.Background()
+	listed, err := runGit(ctx, "", "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	if err != nil {
+		inputDiscovery.err = err
+		return nil, err
+	}
+	deleted, err := runGit(ctx, "", "ls-files", "-z", "--deleted")
+	if err != nil {
+		inputDiscovery.err = err
+		return nil, err
+	}
+
+	gone := make(map[string]bool)
+	for _, name := range strings.Split(string(deleted), "\x00") {
+		gone[name] = true
+	}
+	seen := make(map[string]bool)
+	var files []string
+	for _, name := range strings.Split(string(listed), "\x00") {
+		if name == "" || gone[name] || seen[name] {
+			continue
+		}
+		seen[name] = true
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	debugf("git index: %d file(s)", len(files))
+	inputDiscovery.files = files
+	return files, nil
+}
+
+// collectGitInputFiles resolves input globs against the git index. ok is
+// false when the index cannot be used, either because git failed or a
+// pattern reaches outside the working directory, and the caller should
+// glob the filesystem instead.
+func collectGitInputFiles(patterns []string, packagePath string) (files []string, ok bool, err error) {
+	base := filepath.ToSlash(filepath.Clean(packagePath))
+	if filepath.IsAbs(packagePath) || base == ".." || strings.HasPrefix(base, "../") {
+		return nil, false, nil
+	}
+
+	full := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if strings.TrimSpace(pattern) == "" {
+			continue
+		}
+		if filepath.IsAbs(pattern) {
+			return nil, false, nil
+		}
+		joined := path.Join(base, filepath.ToSlash(pattern))
+		if joined == ".." || strings.HasPrefix(joined, "../") {
+			return nil, false, nil
+		}
+		if !doublestar.ValidatePattern(joined) {
+			return nil, false, fmt.Errorf("glob %q: %w", pattern, doublestar.ErrBadPattern)
+		}
+		full = append(full, joined)
+	}
+
+	indexed, err := gitIndexFiles()
+	if err != nil {
+		debugf("git input discovery unavailable, globbing instead: %v", err)
+		return nil, false, nil
+	}
+
+	matcher, err := loadGitignore(packagePath)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, name := range indexed {
+		matched := false
+		for _, pattern := range full {
+			if ok, _ := doublestar.Match(pattern, name); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		resolvedPath := filepath.FromSlash(name)
+		if matcher != nil {
+			relativePath, err := filepath.Rel(filepath.Clean(packagePath), resolvedPath)
+			if err == nil && matcher.MatchesPath(relativePath) {
+				continue
+			}
+		}
+		info, err := os.Stat(resolvedPath)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, false, fmt.Errorf("stat %q: %w", resolvedPath, err)
+		}
+		if info.IsDir() {
+			continue
+		}
+		files = append(files, resolvedPath)
+	}
+
+	sort.Strings(files)
+	return files, true, nil
+}
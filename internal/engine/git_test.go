@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+)
+
+func TestAffectedPackagesIncludesDependents(t *testing.T) {
+	lib := &Package{Name: "@repo/lib", Path: "packages/lib"}
+	ui := &Package{Name: "@repo/ui", Path: "packages/ui", InternalDepNames: []string{"@repo/lib"}}
+	web := &Package{Name: "@repo/web", Path: "apps/web", InternalDepNames: []string{"@repo/ui"}}
+	docs := &Package{Name: "@repo/docs", Path: "apps/docs"}
+
+	packages := map[string]*Package{lib.Name: lib, ui.Name: ui, web.Name: web, docs.Name: docs}
+
+	affected := AffectedPackages(packages, []string{filepath.Join("packages", "lib", "src", "index.ts")})
+	assert.Equal(t, []string{"@repo/lib", "@repo/ui", "@repo/web"}, sortedPackageNames(affected))
+
+	affected = AffectedPackages(packages, []string{filepath.Join("apps", "docs-extra", "README.md")})
+	assert.Empty(t, affected, "path prefixes must match whole directories")
+
+	affected = AffectedPackages(packages, []string{"velocity.yml"})
+	assert.Len(t, affected, len(packages), "config changes affect every package")
+}
+
+func sortedPackageNames(packages map[string]*Package) []string {
+	names := make([]string, 0, len(packages))
+	for name := range packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestGitInputDiscoveryMatchesGlobbing(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	root := t.TempDir()
+	t.Chdir(root)
+	t.Cleanup(func() { SetInputDiscovery("") })
+
+	write := func(name, content string) {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	write(".gitignore", "node_modules/\n")
+	write("app/src/index.js", "index")
+	write("app/src/gone.js", "gone")
+	write("app/node_modules/dep/index.js", "dep")
+	git := func(args ...string) {
+		_, err := runGit(context.Background(), root, args...)
+		require.NoError(t, err)
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init")
+	write("app/src/new.js", "untracked")
+	require.NoError(t, os.Remove(filepath.Join(root, "app", "src", "gone.js")))
+
+	patterns := []string{"**/*.js"}
+	SetInputDiscovery("")
+	globbed, err := collectInputFiles(patterns, "app")
+	require.NoError(t, err)
+
+	SetInputDiscovery(config.InputDiscoveryGit)
+	indexed, err := collectInputFiles(patterns, "app")
+	require.NoError(t, err)
+
+	expected := []string{filepath.Join("app", "src", "index.js"), filepath.Join("app", "src", "new.js")}
+	assert.Equal(t, expected, indexed)
+	assert.Contains(t, globbed, expected[0])
+	assert.NotContains(t, indexed, filepath.Join("app", "node_modules", "dep", "index.js"))
+}
@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+)
+
+var global struct {
+	mu       sync.Mutex
+	patterns []string
+	envKeys  []string
+
+	// files caches the hashed global_dependencies, which are the same for
+	// every task in a run.
+	files  []FileHash
+	hashed bool
+}
+
+// SetGlobalInputs sets the files (globs relative to the workspace root) and
+// env vars that are part of every task's cache key.
+func SetGlobalInputs(patterns, envKeys []string) {
+	global.mu.Lock()
+	defer global.mu.Unlock()
+	global.patterns = patterns
+	global.envKeys = envKeys
+	global.files = nil
+	global.hashed = false
+}
+
+func globalFileHashes(ctx context.Context) ([]FileHash, error) {
+	global.mu.Lock()
+	defer global.mu.Unlock()
+	if global.hashed {
+		return global.files, nil
+	}
+
+	files, err := collectInputFiles(global.patterns, "")
+	if err != nil {
+		return nil, err
+	}
+	sums, err := hashFiles(ctx, files)
+	if err != nil {
+		return nil, err
+	}
+	var hashes []FileHash
+	for _, path := range files {
+		if sum, ok := sums[path]; ok {
+			hashes = append(hashes, FileHash{Path: path, Hash: sum})
+		}
+	}
+	global.files, global.hashed = hashes, true
+	return hashes, nil
+}
+
+// addGlobalInputs records global_dependencies and global_env in the
+// manifest. Env values come from the task so that --env overrides apply.
+func addGlobalInputs(ctx context.Context, manifest *KeyManifest, cfg config.TaskConfig) error {
+	files, err := globalFileHashes(ctx)
+	if err != nil {
+		return err
+	}
+	global.mu.Lock()
+	envKeys := global.envKeys
+	global.mu.Unlock()
+
+	var parts []string
+	for _, file := range files {
+		parts = append(parts, "file:"+file.Path+":"+file.Hash)
+	}
+	manifest.GlobalFiles = files
+
+	keys := expandEnvKeys(config.TaskConfig{EnvKeys: envKeys, Env: cfg.Env})
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := cfg.Getenv(key)
+		parts = append(parts, "env:"+key+"="+value)
+		manifest.GlobalEnv = append(manifest.GlobalEnv, EnvHash{Name: key, ValueHash: hashString(value)})
+	}
+
+	if len(parts) > 0 {
+		manifest.GlobalHash = hashString(strings.Join(parts, "|"))
+	}
+	return nil
+}
@@ -7,6 +7,14 @@ import (
 	"github.com/bit2swaz/velocity-cache/internal/config"
 )
 
+const (
+	TaskPending = iota
+	TaskRunning
+	TaskDone
+	TaskFailed
+	TaskSkipped
+)
+
 type TaskNode struct {
 	ID           string
 	Package      *Package
@@ -16,10 +24,75 @@ type TaskNode struct {
 
 	State     int
 	CacheKey  string
+	Manifest  *KeyManifest
 	LastError error
+	Attempts  int
+}
+
+// CycleEdge is one depends_on entry along a dependency cycle: Task's
+// configuration lists Ref, which leads to the next node of the cycle.
+type CycleEdge struct {
+	Task string
+	Ref  string
+}
+
+// CycleError reports a dependency cycle found while building a task graph.
+// Path lists the node IDs of the cycle and ends where it starts; Edges holds
+// the depends_on entry behind each step.
+type CycleError struct {
+	Path  []string
+	Edges []CycleEdge
+}
+
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("detected cycle while building task graph: %s", strings.Join(e.Path, " -> "))
+}
+
+// graphFrame is a node being built, with the depends_on entry that led to
+// it from the frame below.
+type graphFrame struct {
+	id       string
+	taskName string
+	ref      string
+}
+
+type graphWalk struct {
+	visiting map[string]bool
+	stack    []graphFrame
+}
+
+// cycle describes the cycle closed by reaching nodeID through ref.
+func (w *graphWalk) cycle(nodeID, ref string) *CycleError {
+	start := 0
+	for i, frame := range w.stack {
+		if frame.id == nodeID {
+			start = i
+			break
+		}
+	}
+	err := &CycleError{}
+	for i, frame := range w.stack[start:] {
+		err.Path = append(err.Path, frame.id)
+		next := ref
+		if start+i+1 < len(w.stack) {
+			next = w.stack[start+i+1].ref
+		}
+		err.Edges = append(err.Edges, CycleEdge{Task: frame.taskName, Ref: next})
+	}
+	err.Path = append(err.Path, nodeID)
+	return err
 }
 
 func BuildTaskGraph(targetTaskName string, targetPackage *Package, allPackages map[string]*Package, cfg *config.Config, visiting map[string]bool) (*TaskNode, error) {
+	if visiting == nil {
+		visiting = make(map[string]bool)
+	}
+	return buildTaskGraph(targetTaskName, "", targetPackage, allPackages, cfg, &graphWalk{visiting: visiting})
+}
+
+// buildTaskGraph builds the graph of targetTaskName in targetPackage,
+// reached through the depends_on entry ref ("" for roots).
+func buildTaskGraph(targetTaskName, ref string, targetPackage *Package, allPackages map[string]*Package, cfg *config.Config, walk *graphWalk) (*TaskNode, error) {
 	if cfg == nil {
 		return nil, fmt.Errorf("task graph requires configuration")
 	}
@@ -34,22 +107,23 @@ func BuildTaskGraph(targetTaskName string, targetPackage *Package, allPackages m
 		}
 	}
 
-	if visiting == nil {
-		visiting = make(map[string]bool)
-	}
-
 	nodeID := fmt.Sprintf("%s#%s", targetPackage.Path, targetTaskName)
-	if visiting[nodeID] {
-		return nil, fmt.Errorf("detected cycle while building task graph at %s", nodeID)
+	if walk.visiting[nodeID] {
+		return nil, walk.cycle(nodeID, ref)
 	}
 
 	taskCfg, ok := cfg.Pipeline[targetTaskName]
 	if !ok {
 		return nil, fmt.Errorf("task %q not defined in configuration", targetTaskName)
 	}
+	taskCfg = taskCfg.ForTags(targetPackage.Tags)
 
-	visiting[nodeID] = true
-	defer delete(visiting, nodeID)
+	walk.visiting[nodeID] = true
+	walk.stack = append(walk.stack, graphFrame{id: nodeID, taskName: targetTaskName, ref: ref})
+	defer func() {
+		delete(walk.visiting, nodeID)
+		walk.stack = walk.stack[:len(walk.stack)-1]
+	}()
 
 	if len(targetPackage.InternalDeps) == 0 && len(targetPackage.InternalDepNames) > 0 {
 		if allPackages == nil {
@@ -73,6 +147,7 @@ func BuildTaskGraph(targetTaskName string, targetPackage *Package, allPackages m
 		TaskName:   targetTaskName,
 		TaskConfig: taskCfg,
 	}
+	node.TaskConfig.Command = ExpandCommand(taskCfg.Command, targetPackage)
 
 	depSeen := make(map[string]struct{})
 
@@ -89,7 +164,7 @@ func BuildTaskGraph(targetTaskName string, targetPackage *Package, allPackages m
 			}
 
 			for _, depPkg := range targetPackage.InternalDeps {
-				child, err := BuildTaskGraph(depTaskName, depPkg, allPackages, cfg, visiting)
+				child, err := buildTaskGraph(depTaskName, depRef, depPkg, allPackages, cfg, walk)
 				if err != nil {
 					return nil, err
 				}
@@ -102,7 +177,18 @@ func BuildTaskGraph(targetTaskName string, targetPackage *Package, allPackages m
 			continue
 		}
 
-		child, err := BuildTaskGraph(depRef, targetPackage, allPackages, cfg, visiting)
+		depPkg, depTaskName := targetPackage, depRef
+		if pkgName, taskName, ok := config.SplitTaskRef(depRef); ok {
+			if pkgName == "" || taskName == "" {
+				return nil, fmt.Errorf("task %q dependency %q must name a package and a task", targetTaskName, depRef)
+			}
+			if depPkg, ok = allPackages[pkgName]; !ok {
+				return nil, fmt.Errorf("task %q depends on %q, but package %q was not found", targetTaskName, depRef, pkgName)
+			}
+			depTaskName = taskName
+		}
+
+		child, err := buildTaskGraph(depTaskName, depRef, depPkg, allPackages, cfg, walk)
 		if err != nil {
 			return nil, err
 		}
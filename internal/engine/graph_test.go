@@ -32,6 +32,12 @@ func TestBuildTaskGraphCycleDetection(t *testing.T) {
 	_, err := BuildTaskGraph("build", pkg, packages, cfg, map[string]bool{})
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "cycle", "should mention cycle in error")
+
+	var cycleErr *CycleError
+	require.ErrorAs(t, err, &cycleErr)
+	assert.Equal(t, []string{"packages/app#build", "packages/app#test", "packages/app#build"}, cycleErr.Path)
+	assert.Equal(t, []CycleEdge{{Task: "build", Ref: "test"}, {Task: "test", Ref: "build"}}, cycleErr.Edges)
+	assert.Contains(t, err.Error(), "packages/app#build -> packages/app#test -> packages/app#build")
 }
 
 func TestBuildTaskGraphTopologicalDependencies(t *testing.T) {
@@ -71,3 +77,27 @@ func TestBuildTaskGraphTopologicalDependencies(t *testing.T) {
 	assert.Equal(t, libPkg, node.Dependencies[0].Package)
 	assert.Equal(t, "build", node.Dependencies[0].TaskName)
 }
+
+func TestBuildTaskGraphCrossPackageDependency(t *testing.T) {
+	cfg := &config.Config{
+		Pipeline: map[string]config.TaskConfig{
+			"generate": {},
+			"build":    {DependsOn: []string{"@repo/lib#generate"}},
+		},
+	}
+
+	libPkg := &Package{Name: "@repo/lib", Path: "packages/lib"}
+	appPkg := &Package{Name: "@repo/app", Path: "packages/app"}
+	packages := map[string]*Package{libPkg.Name: libPkg, appPkg.Name: appPkg}
+
+	node, err := BuildTaskGraph("build", appPkg, packages, cfg, map[string]bool{})
+	require.NoError(t, err)
+	require.Len(t, node.Dependencies, 1)
+	assert.Equal(t, "packages/lib#generate", node.Dependencies[0].ID)
+	assert.Equal(t, libPkg, node.Dependencies[0].Package)
+
+	cfg.Pipeline["build"] = config.TaskConfig{DependsOn: []string{"@repo/missing#generate"}}
+	_, err = BuildTaskGraph("build", appPkg, packages, cfg, map[string]bool{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `package "@repo/missing" was not found`)
+}
@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+)
+
+const hashCacheFileName = "hash-manifest.json"
+
+// racyWindow is how recently a file may have been modified and still have
+// its hash remembered. A file written within the timestamp granularity of
+// its last hash could change again without its mtime moving.
+const racyWindow = 2 * time.Second
+
+type hashCacheEntry struct {
+	Size      int64  `json:"size"`
+	ModTime   int64  `json:"mod_time"`
+	Inode     uint64 `json:"inode,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Hash      string `json:"hash"`
+}
+
+// hashCache memoizes file content hashes by path, size, mtime and inode in
+// .velocity/hash-manifest.json, so unchanged files are not re-read.
+var hashCache struct {
+	mu      sync.Mutex
+	loaded  bool
+	dirty   bool
+	entries map[string]hashCacheEntry
+}
+
+func hashCachePath() string {
+	return filepath.Join(velocityDirName, hashCacheFileName)
+}
+
+func loadHashCacheLocked() {
+	if hashCache.loaded {
+		return
+	}
+	hashCache.loaded = true
+	hashCache.entries = make(map[string]hashCacheEntry)
+
+	data, err := os.ReadFile(hashCachePath())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			debugf("read hash cache: %v", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &hashCache.entries); err != nil {
+		debugf("ignoring corrupt hash cache: %v", err)
+		hashCache.entries = make(map[string]hashCacheEntry)
+	}
+}
+
+// cachedHashFile returns the content hash of path, reading the file only
+// when it changed since it was last hashed.
+func cachedHashFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("stat %q: %w", path, err)
+	}
+	stamp := hashCacheEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Inode: fileInode(info)}
+	if algorithm := currentFileHashAlgorithm(); algorithm != config.HashAlgorithmSHA256 {
+		stamp.Algorithm = algorithm
+	}
+
+	hashCache.mu.Lock()
+	loadHashCacheLocked()
+	entry, ok := hashCache.entries[path]
+	hashCache.mu.Unlock()
+	if ok && entry.Size == stamp.Size && entry.ModTime == stamp.ModTime && entry.Inode == stamp.Inode && entry.Algorithm == stamp.Algorithm {
+		return entry.Hash, nil
+	}
+
+	sum, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+	if time.Since(info.ModTime()) < racyWindow {
+		return sum, nil
+	}
+
+	stamp.Hash = sum
+	hashCache.mu.Lock()
+	hashCache.entries[path] = stamp
+	hashCache.dirty = true
+	hashCache.mu.Unlock()
+	return sum, nil
+}
+
+// SaveHashCache writes the memoized file hashes back to
+// .velocity/hash-manifest.json if any were added, dropping entries for
+// files that no longer exist.
+func SaveHashCache() error {
+	hashCache.mu.Lock()
+	defer hashCache.mu.Unlock()
+	if !hashCache.dirty {
+		return nil
+	}
+
+	for path := range hashCache.entries {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			delete(hashCache.entries, path)
+		}
+	}
+	data, err := json.Marshal(hashCache.entries)
+	if err != nil {
+		return fmt.Errorf("encode hash cache: %w", err)
+	}
+	if err := os.MkdirAll(velocityDirName, 0o755); err != nil {
+		return fmt.Errorf("create %s: %w", velocityDirName, err)
+	}
+	if err := writeAtomically(hashCachePath(), bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("save hash cache: %w", err)
+	}
+	hashCache.dirty = false
+	return nil
+}
+
+// resetHashCache forgets the in-memory hashes so the next lookup reloads
+// them from disk.
+func resetHashCache() {
+	hashCache.mu.Lock()
+	defer hashCache.mu.Unlock()
+	hashCache.loaded, hashCache.dirty, hashCache.entries = false, false, nil
+}
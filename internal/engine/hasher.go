@@ -1,66 +1,125 @@
 package engine
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/bmatcuk/doublestar/v4"
+	"github.com/cespare/xxhash/v2"
 	ignore "github.com/sabhiram/go-gitignore"
 
 	"github.com/bit2swaz/velocity-cache/internal/config"
 )
 
-func GenerateCacheKey(cfg config.TaskConfig, depCacheKeys []string, packagePath string) (string, error) {
-	localHash, err := computeLocalHash(cfg, packagePath)
+// KeyManifest records every component of a task's cache key. Environment
+// values are stored as hashes so manifests can be shared without leaking
+// secrets.
+type KeyManifest struct {
+	Key          string          `json:"key"`
+	Task         string          `json:"task,omitempty"`
+	CacheVersion string          `json:"cache_version,omitempty"`
+	GlobalFiles  []FileHash      `json:"global_files,omitempty"`
+	GlobalEnv    []EnvHash       `json:"global_env,omitempty"`
+	GlobalHash   string          `json:"global_hash,omitempty"`
+	Command      string          `json:"command"`
+	CommandHash  string          `json:"command_hash"`
+	Env          []EnvHash       `json:"env,omitempty"`
+	EnvHash      string          `json:"env_hash,omitempty"`
+	Files        []FileHash      `json:"files,omitempty"`
+	FilesHash    string          `json:"files_hash,omitempty"`
+	DotEnv       []FileHash      `json:"dot_env,omitempty"`
+	DotEnvHash   string          `json:"dot_env_hash,omitempty"`
+	Docker       []FileHash      `json:"docker,omitempty"`
+	DockerHash   string          `json:"docker_hash,omitempty"`
+	Tools        []ToolVersion   `json:"tools,omitempty"`
+	ToolsHash    string          `json:"tools_hash,omitempty"`
+	Dependencies []DependencyKey `json:"dependencies,omitempty"`
+}
+
+type EnvHash struct {
+	Name      string `json:"name"`
+	ValueHash string `json:"value_hash"`
+}
+
+type FileHash struct {
+	Path string `json:"path"`
+	Hash string `json:"hash"`
+}
+
+type ToolVersion struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type DependencyKey struct {
+	Task string `json:"task,omitempty"`
+	Key  string `json:"key"`
+}
+
+func GenerateCacheKey(ctx context.Context, cfg config.TaskConfig, depCacheKeys []string, packagePath string) (string, error) {
+	manifest, err := buildLocalManifest(ctx, cfg, packagePath)
 	if err != nil {
 		return "", err
 	}
+	return manifest.baseKey(depCacheKeys), nil
+}
 
-	parts := []string{localHash}
-	if len(depCacheKeys) > 0 {
-		sorted := make([]string, len(depCacheKeys))
-		copy(sorted, depCacheKeys)
-		sort.Strings(sorted)
-		depString := strings.Join(sorted, "|")
-		parts = append(parts, depString)
+// buildLocalManifest hashes everything owned by the task itself: its
+// command, env_keys, input files, dot_env files, Docker build and tool
+// versions, plus the workspace's global inputs.
+func buildLocalManifest(ctx context.Context, cfg config.TaskConfig, packagePath string) (*KeyManifest, error) {
+	manifest := &KeyManifest{
+		CacheVersion: currentCacheVersion(),
+		Command:      cfg.Command,
+		CommandHash:  hashString(cfg.Command),
+	}
+	if err := addGlobalInputs(ctx, manifest, cfg); err != nil {
+		return nil, err
 	}
 
-	return hashString(strings.Join(parts, "|")), nil
-}
-
-func computeLocalHash(cfg config.TaskConfig, packagePath string) (string, error) {
-	var envHash string
-	if len(cfg.EnvKeys) > 0 {
-		envPairs := make([]string, 0, len(cfg.EnvKeys))
-		for _, key := range cfg.EnvKeys {
-			envPairs = append(envPairs, key+"="+os.Getenv(key))
+	if envKeys := expandEnvKeys(cfg); len(envKeys) > 0 {
+		envPairs := make([]string, 0, len(envKeys))
+		for _, key := range envKeys {
+			value := cfg.Getenv(key)
+			envPairs = append(envPairs, key+"="+value)
+			manifest.Env = append(manifest.Env, EnvHash{Name: key, ValueHash: hashString(value)})
 		}
 		sort.Strings(envPairs)
-		envHash = hashString(strings.Join(envPairs, "|"))
+		sort.SliceStable(manifest.Env, func(i, j int) bool { return manifest.Env[i].Name < manifest.Env[j].Name })
+		manifest.EnvHash = hashString(strings.Join(envPairs, "|"))
 	}
 
-	commandHash := hashString(cfg.Command)
-
-	files, err := collectInputFiles(cfg.Inputs, packagePath)
+	var files []string
+	var err error
+	if cfg.Inputs == nil {
+		files, err = defaultInputFiles(packagePath, cfg.Outputs)
+	} else {
+		files, err = collectInputFiles(cfg.Inputs, packagePath)
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	fileHashes, err := hashFiles(files)
+	fileHashes, err := hashFiles(ctx, files)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	var filesHash string
 	if len(files) > 0 {
 		entries := make([]string, 0, len(files))
 		for _, path := range files {
@@ -69,20 +128,174 @@ func computeLocalHash(cfg config.TaskConfig, packagePath string) (string, error)
 				continue
 			}
 			entries = append(entries, path+":"+sum)
+			manifest.Files = append(manifest.Files, FileHash{Path: path, Hash: sum})
+		}
+		manifest.FilesHash = hashString(strings.Join(entries, "|"))
+	}
+
+	if len(cfg.DotEnv) > 0 {
+		paths, err := dotEnvPaths(cfg.DotEnv, packagePath)
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]string, 0, len(paths))
+		for _, path := range paths {
+			sum, err := hashFile(path)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, path+":"+sum)
+			manifest.DotEnv = append(manifest.DotEnv, FileHash{Path: path, Hash: sum})
+		}
+		if len(entries) > 0 {
+			manifest.DotEnvHash = hashString(strings.Join(entries, "|"))
+		}
+	}
+
+	if cfg.Docker != nil {
+		paths, err := dockerInputFiles(*cfg.Docker, packagePath)
+		if err != nil {
+			return nil, err
+		}
+		hashes, err := hashFiles(ctx, paths)
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]string, 0, len(paths))
+		for _, path := range paths {
+			entries = append(entries, path+":"+hashes[path])
+			manifest.Docker = append(manifest.Docker, FileHash{Path: path, Hash: hashes[path]})
+		}
+		manifest.DockerHash = hashString(strings.Join(entries, "|"))
+	}
+
+	if len(cfg.ToolDependencies) > 0 {
+		names := make([]string, 0, len(cfg.ToolDependencies))
+		for _, name := range cfg.ToolDependencies {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		names = slices.Compact(names)
+		entries := make([]string, 0, len(names))
+		for _, name := range names {
+			version, err := toolVersion(ctx, name, packagePath)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, name+"="+version)
+			manifest.Tools = append(manifest.Tools, ToolVersion{Name: name, Version: version})
+		}
+		if len(entries) > 0 {
+			manifest.ToolsHash = hashString(strings.Join(entries, "|"))
+		}
+	}
+
+	return manifest, nil
+}
+
+// expandEnvKeys resolves env_keys to variable names. Exact names are kept
+// as written, whether or not they are set; entries containing glob
+// characters (NEXT_PUBLIC_*) expand to every matching variable in the
+// process environment or the task's env, in sorted order.
+func expandEnvKeys(cfg config.TaskConfig) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	var patterns []string
+	for _, key := range cfg.EnvKeys {
+		if isEnvPattern(key) {
+			patterns = append(patterns, key)
+			continue
 		}
-		filesHash = hashString(strings.Join(entries, "|"))
+		keys = append(keys, key)
+		seen[key] = true
+	}
+	if len(patterns) == 0 {
+		return keys
 	}
 
-	parts := make([]string, 0, 3)
-	if envHash != "" {
-		parts = append(parts, "env:"+envHash)
+	names := make([]string, 0, len(cfg.Env))
+	for name := range cfg.Env {
+		names = append(names, name)
 	}
-	parts = append(parts, "cmd:"+commandHash)
-	if filesHash != "" {
-		parts = append(parts, "files:"+filesHash)
+	for _, entry := range os.Environ() {
+		if name, _, ok := strings.Cut(entry, "="); ok && name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		for _, pattern := range patterns {
+			if ok, _ := doublestar.Match(pattern, name); ok {
+				keys = append(keys, name)
+				seen[name] = true
+				break
+			}
+		}
 	}
+	return keys
+}
+
+func isEnvPattern(key string) bool {
+	return strings.ContainsAny(key, "*?[{")
+}
+
+var cacheVersion atomic.Value
 
-	return strings.Join(parts, "|"), nil
+// SetCacheVersion sets the version mixed into every cache key. An empty
+// version leaves keys as they were before versions existed.
+func SetCacheVersion(version string) {
+	cacheVersion.Store(version)
+}
+
+func currentCacheVersion() string {
+	version, _ := cacheVersion.Load().(string)
+	return version
+}
+
+func (m *KeyManifest) localHash() string {
+	parts := make([]string, 0, 4)
+	if m.CacheVersion != "" {
+		parts = append(parts, "version:"+m.CacheVersion)
+	}
+	if m.GlobalHash != "" {
+		parts = append(parts, "global:"+m.GlobalHash)
+	}
+	if m.EnvHash != "" {
+		parts = append(parts, "env:"+m.EnvHash)
+	}
+	parts = append(parts, "cmd:"+m.CommandHash)
+	if m.FilesHash != "" {
+		parts = append(parts, "files:"+m.FilesHash)
+	}
+	if m.DotEnvHash != "" {
+		parts = append(parts, "dotenv:"+m.DotEnvHash)
+	}
+	if m.DockerHash != "" {
+		parts = append(parts, "docker:"+m.DockerHash)
+	}
+	if m.ToolsHash != "" {
+		parts = append(parts, "tools:"+m.ToolsHash)
+	}
+	return strings.Join(parts, "|")
+}
+
+func (m *KeyManifest) baseKey(depCacheKeys []string) string {
+	parts := []string{m.localHash()}
+	if len(depCacheKeys) > 0 {
+		sorted := make([]string, len(depCacheKeys))
+		copy(sorted, depCacheKeys)
+		sort.Strings(sorted)
+		depString := strings.Join(sorted, "|")
+		parts = append(parts, depString)
+	}
+
+	return hashString(strings.Join(parts, "|"))
 }
 
 func collectInputFiles(patterns []string, packagePath string) ([]string, error) {
@@ -90,24 +303,17 @@ func collectInputFiles(patterns []string, packagePath string) ([]string, error)
 		return nil, nil
 	}
 
-	originalWd := ""
-	if packagePath != "" {
-		wd, err := os.Getwd()
+	if inputDiscoveryMode() == config.InputDiscoveryGit {
+		files, ok, err := collectGitInputFiles(patterns, packagePath)
 		if err != nil {
-			return nil, fmt.Errorf("getwd: %w", err)
+			return nil, err
 		}
-		if err := os.Chdir(packagePath); err != nil {
-			return nil, fmt.Errorf("chdir to %s: %w", packagePath, err)
+		if ok {
+			return files, nil
 		}
-		originalWd = wd
-		defer func() {
-			if originalWd != "" {
-				_ = os.Chdir(originalWd)
-			}
-		}()
 	}
 
-	matcher, err := loadGitignore()
+	matcher, err := loadGitignore(packagePath)
 	if err != nil {
 		return nil, err
 	}
@@ -120,33 +326,36 @@ func collectInputFiles(patterns []string, packagePath string) ([]string, error)
 			continue
 		}
 
-		matches, err := doublestar.FilepathGlob(pattern)
+		matches, err := globInPackage(pattern, packagePath)
 		if err != nil {
 			return nil, fmt.Errorf("glob %q: %w", pattern, err)
 		}
 
 		for _, match := range matches {
-			relativePath := filepath.Clean(match)
+			resolvedPath := filepath.Clean(match)
 
-			info, err := os.Stat(relativePath)
+			info, err := os.Stat(resolvedPath)
 			if err != nil {
 				if errors.Is(err, os.ErrNotExist) {
 					continue
 				}
-				return nil, fmt.Errorf("stat %q: %w", relativePath, err)
+				return nil, fmt.Errorf("stat %q: %w", resolvedPath, err)
 			}
 
 			if info.IsDir() {
 				continue
 			}
 
-			if matcher != nil && matcher.MatchesPath(relativePath) {
-				continue
-			}
-
-			resolvedPath := relativePath
-			if packagePath != "" && !filepath.IsAbs(resolvedPath) {
-				resolvedPath = filepath.Clean(filepath.Join(packagePath, resolvedPath))
+			if matcher != nil {
+				relativePath := resolvedPath
+				if packagePath != "" && !filepath.IsAbs(relativePath) {
+					if rel, err := filepath.Rel(packagePath, resolvedPath); err == nil {
+						relativePath = rel
+					}
+				}
+				if matcher.MatchesPath(relativePath) {
+					continue
+				}
 			}
 
 			if _, ok := seen[resolvedPath]; ok {
@@ -163,8 +372,130 @@ func collectInputFiles(patterns []string, packagePath string) ([]string, error)
 	return files, nil
 }
 
-func loadGitignore() (*ignore.GitIgnore, error) {
-	_, err := os.Stat(".gitignore")
+// defaultInputSkipDirs are never walked for default inputs.
+var defaultInputSkipDirs = map[string]bool{".git": true, velocityDirName: true, "node_modules": true}
+
+// defaultInputFiles lists the inputs of a task that declares none: every
+// file in its package that is not ignored by .gitignore and not one of the
+// task's outputs.
+func defaultInputFiles(packagePath string, outputs []string) ([]string, error) {
+	spec := parseOutputs(outputs)
+	produced := func(rel string) bool {
+		rel = filepath.ToSlash(rel)
+		for _, literal := range spec.literals {
+			literal = path.Clean(filepath.ToSlash(literal))
+			if rel == literal || strings.HasPrefix(rel, literal+"/") {
+				return true
+			}
+		}
+		for _, pattern := range append(spec.globs, spec.excludes...) {
+			if ok, _ := doublestar.Match(path.Clean(filepath.ToSlash(pattern)), rel); ok {
+				return true
+			}
+		}
+		return false
+	}
+	skipped := func(rel string) bool {
+		for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+			if defaultInputSkipDirs[part] {
+				return true
+			}
+		}
+		return produced(rel)
+	}
+
+	if inputDiscoveryMode() == config.InputDiscoveryGit {
+		files, ok, err := collectGitInputFiles([]string{"**"}, packagePath)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			kept := files[:0]
+			for _, file := range files {
+				rel := file
+				if packagePath != "" {
+					if r, err := filepath.Rel(packagePath, file); err == nil {
+						rel = r
+					}
+				}
+				if !skipped(rel) {
+					kept = append(kept, file)
+				}
+			}
+			return kept, nil
+		}
+	}
+
+	root := packagePath
+	if root == "" {
+		root = "."
+	}
+	if _, err := os.Stat(root); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	matcher, err := loadGitignore(packagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		ignored := skipped(rel) || (matcher != nil && (matcher.MatchesPath(rel) || (d.IsDir() && matcher.MatchesPath(rel+"/"))))
+		if d.IsDir() {
+			if ignored {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ignored || !d.Type().IsRegular() {
+			return nil
+		}
+		files = append(files, filepath.Join(packagePath, rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk %s: %w", root, err)
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// globInPackage expands pattern relative to packagePath without changing
+// the working directory. Matches are relative to the current directory, or
+// absolute for absolute patterns.
+func globInPackage(pattern, packagePath string) ([]string, error) {
+	if packagePath == "" || filepath.IsAbs(pattern) {
+		return doublestar.FilepathGlob(pattern)
+	}
+
+	base, rest := doublestar.SplitPattern(filepath.ToSlash(pattern))
+	dir := filepath.Join(packagePath, filepath.FromSlash(base))
+	matches, err := doublestar.Glob(os.DirFS(dir), rest)
+	if err != nil {
+		return nil, err
+	}
+	for i, match := range matches {
+		matches[i] = filepath.Join(dir, filepath.FromSlash(match))
+	}
+	return matches, nil
+}
+
+// loadGitignore compiles the .gitignore in dir, or the current directory
+// when dir is empty.
+func loadGitignore(dir string) (*ignore.GitIgnore, error) {
+	path := filepath.Join(dir, ".gitignore")
+	_, err := os.Stat(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return nil, nil
@@ -172,7 +503,7 @@ func loadGitignore() (*ignore.GitIgnore, error) {
 		return nil, fmt.Errorf("stat .gitignore: %w", err)
 	}
 
-	matcher, err := ignore.CompileIgnoreFile(".gitignore")
+	matcher, err := ignore.CompileIgnoreFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("compile .gitignore: %w", err)
 	}
@@ -186,7 +517,7 @@ type fileHashResult struct {
 	err  error
 }
 
-func hashFiles(paths []string) (map[string]string, error) {
+func hashFiles(ctx context.Context, paths []string) (map[string]string, error) {
 	if len(paths) == 0 {
 		return nil, nil
 	}
@@ -205,17 +536,24 @@ func hashFiles(paths []string) (map[string]string, error) {
 		go func() {
 			defer wg.Done()
 			for path := range jobs {
-				sum, err := hashFile(path)
+				sum, err := cachedHashFile(path)
 				results <- fileHashResult{path: path, sum: sum, err: err}
 			}
 		}()
 	}
 
 	go func() {
+		defer close(jobs)
 		for _, path := range paths {
-			jobs <- path
+			select {
+			case jobs <- path:
+			case <-ctx.Done():
+				return
+			}
 		}
-		close(jobs)
+	}()
+
+	go func() {
 		wg.Wait()
 		close(results)
 	}()
@@ -235,10 +573,28 @@ func hashFiles(paths []string) (map[string]string, error) {
 	if hashErr != nil {
 		return nil, hashErr
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	return hashes, nil
 }
 
+var fileHashAlgorithm atomic.Value
+
+// SetFileHashAlgorithm selects the hash used for input file contents, one
+// of config.HashAlgorithmSHA256 (the default) or config.HashAlgorithmXXHash.
+func SetFileHashAlgorithm(name string) {
+	fileHashAlgorithm.Store(name)
+}
+
+func currentFileHashAlgorithm() string {
+	if name, _ := fileHashAlgorithm.Load().(string); name != "" {
+		return name
+	}
+	return config.HashAlgorithmSHA256
+}
+
 func hashFile(path string) (string, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -246,7 +602,10 @@ func hashFile(path string) (string, error) {
 	}
 	defer file.Close()
 
-	hasher := sha256.New()
+	var hasher hash.Hash = sha256.New()
+	if currentFileHashAlgorithm() == config.HashAlgorithmXXHash {
+		hasher = xxhash.New()
+	}
 	if _, err := io.Copy(hasher, file); err != nil {
 		return "", fmt.Errorf("read %q: %w", path, err)
 	}
@@ -259,9 +618,37 @@ func hashString(value string) string {
 	return hex.EncodeToString(sum[:])
 }
 
-func GenerateTaskNodeCacheKey(node *TaskNode, depCacheKeys []string) (string, error) {
+func GenerateTaskNodeCacheKey(ctx context.Context, node *TaskNode, depCacheKeys []string) (string, error) {
+	deps := make([]DependencyKey, 0, len(depCacheKeys))
+	for _, key := range depCacheKeys {
+		deps = append(deps, DependencyKey{Key: key})
+	}
+	manifest, err := generateTaskNodeManifest(ctx, node, deps)
+	if err != nil {
+		return "", err
+	}
+	return manifest.Key, nil
+}
+
+// GenerateTaskNodeManifest computes the node's cache key from the keys
+// already assigned to its dependencies and returns it together with the
+// components it was derived from.
+func GenerateTaskNodeManifest(ctx context.Context, node *TaskNode) (*KeyManifest, error) {
+	if node == nil {
+		return nil, fmt.Errorf("task node is nil")
+	}
+	deps := make([]DependencyKey, 0, len(node.Dependencies))
+	for _, dep := range node.Dependencies {
+		if dep.CacheKey != "" {
+			deps = append(deps, DependencyKey{Task: dep.ID, Key: dep.CacheKey})
+		}
+	}
+	return generateTaskNodeManifest(ctx, node, deps)
+}
+
+func generateTaskNodeManifest(ctx context.Context, node *TaskNode, deps []DependencyKey) (*KeyManifest, error) {
 	if node == nil {
-		return "", fmt.Errorf("task node is nil")
+		return nil, fmt.Errorf("task node is nil")
 	}
 
 	packagePath := ""
@@ -269,9 +656,9 @@ func GenerateTaskNodeCacheKey(node *TaskNode, depCacheKeys []string) (string, er
 		packagePath = node.Package.Path
 	}
 
-	baseKey, err := GenerateCacheKey(node.TaskConfig, depCacheKeys, packagePath)
+	manifest, err := buildLocalManifest(ctx, node.TaskConfig, packagePath)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	identifier := node.ID
@@ -281,10 +668,21 @@ func GenerateTaskNodeCacheKey(node *TaskNode, depCacheKeys []string) (string, er
 		} else if node.TaskName != "" {
 			identifier = node.TaskName
 		} else {
-			return "", fmt.Errorf("task node missing identifier")
+			return nil, fmt.Errorf("task node missing identifier")
 		}
 	}
 
-	combined := identifier + ":" + baseKey
-	return hashString("task:" + combined), nil
+	depKeys := make([]string, 0, len(deps))
+	for _, dep := range deps {
+		depKeys = append(depKeys, dep.Key)
+	}
+	sort.SliceStable(deps, func(i, j int) bool { return deps[i].Key < deps[j].Key })
+	manifest.Task = identifier
+	manifest.Dependencies = deps
+
+	combined := identifier + ":" + manifest.baseKey(depKeys)
+	manifest.Key = hashString("task:" + combined)
+	debugf("hash %s: key %s (command %.12s, %d env var(s), %d file(s), %d dependency key(s))",
+		identifier, manifest.Key, manifest.CommandHash, len(manifest.Env), len(manifest.Files), len(deps))
+	return manifest, nil
 }
@@ -1,9 +1,16 @@
 package engine
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/bit2swaz/velocity-cache/internal/config"
 	"github.com/stretchr/testify/assert"
@@ -17,31 +24,76 @@ func TestEnvHashing(t *testing.T) {
 		EnvKeys: []string{"NODE_ENV"},
 	}
 
-	hash1, err := GenerateCacheKey(cfg, nil, "")
+	hash1, err := GenerateCacheKey(context.Background(), cfg, nil, "")
 	require.NoError(t, err, "first hash should succeed")
-	hash2, err := GenerateCacheKey(cfg, nil, "")
+	hash2, err := GenerateCacheKey(context.Background(), cfg, nil, "")
 	require.NoError(t, err, "second hash should succeed")
 	assert.Equal(t, hash1, hash2, "expected deterministic hash")
 
 	t.Setenv("NODE_ENV", "production")
-	hash3, err := GenerateCacheKey(cfg, nil, "")
+	hash3, err := GenerateCacheKey(context.Background(), cfg, nil, "")
 	require.NoError(t, err, "hash with env change should succeed")
 	assert.NotEqual(t, hash1, hash3, "expected env change to alter hash")
 }
 
+func TestEnvOverridesAreHashed(t *testing.T) {
+	t.Setenv("NODE_ENV", "production")
+	cfg := config.TaskConfig{Command: "npm run build"}
+
+	base, err := GenerateCacheKey(context.Background(), cfg, nil, "")
+	require.NoError(t, err)
+	production, err := GenerateCacheKey(context.Background(), cfg.WithEnv(map[string]string{"NODE_ENV": "production"}), nil, "")
+	require.NoError(t, err)
+	test, err := GenerateCacheKey(context.Background(), cfg.WithEnv(map[string]string{"NODE_ENV": "test"}), nil, "")
+	require.NoError(t, err)
+
+	assert.NotEqual(t, base, production, "expected an override to add its key to the hash")
+	assert.NotEqual(t, production, test, "expected the override value to alter the hash")
+
+	withKey, err := GenerateCacheKey(context.Background(), config.TaskConfig{Command: "npm run build", EnvKeys: []string{"NODE_ENV"}}, nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, withKey, production, "expected an override to hash like env_keys with the same value")
+}
+
+func TestEnvKeyPatternsMatchPrefixes(t *testing.T) {
+	t.Setenv("NEXT_PUBLIC_API", "one")
+	t.Setenv("NEXT_PRIVATE", "secret")
+	cfg := config.TaskConfig{Command: "next build", EnvKeys: []string{"NEXT_PUBLIC_*"}}
+
+	manifest, err := buildLocalManifest(context.Background(), cfg, "")
+	require.NoError(t, err)
+	require.Len(t, manifest.Env, 1)
+	assert.Equal(t, "NEXT_PUBLIC_API", manifest.Env[0].Name)
+
+	before := manifest.localHash()
+	t.Setenv("NEXT_PRIVATE", "changed")
+	manifest, err = buildLocalManifest(context.Background(), cfg, "")
+	require.NoError(t, err)
+	assert.Equal(t, before, manifest.localHash(), "expected non-matching variables to be ignored")
+
+	t.Setenv("NEXT_PUBLIC_FLAG", "on")
+	manifest, err = buildLocalManifest(context.Background(), cfg, "")
+	require.NoError(t, err)
+	assert.NotEqual(t, before, manifest.localHash(), "expected a new matching variable to alter the hash")
+
+	withEnv, err := buildLocalManifest(context.Background(), cfg.WithEnv(map[string]string{"NEXT_PUBLIC_API": "two"}), "")
+	require.NoError(t, err)
+	assert.Len(t, withEnv.Env, 2, "expected overridden keys to be hashed once")
+}
+
 func TestCommandHashing(t *testing.T) {
 	cfg := config.TaskConfig{
 		Command: "npm run build",
 	}
 
-	hash1, err := GenerateCacheKey(cfg, nil, "")
+	hash1, err := GenerateCacheKey(context.Background(), cfg, nil, "")
 	require.NoError(t, err, "first hash should succeed")
-	hash2, err := GenerateCacheKey(cfg, nil, "")
+	hash2, err := GenerateCacheKey(context.Background(), cfg, nil, "")
 	require.NoError(t, err, "second hash should succeed")
 	assert.Equal(t, hash1, hash2, "expected deterministic hash")
 
 	cfg.Command = "npm run test"
-	hash3, err := GenerateCacheKey(cfg, nil, "")
+	hash3, err := GenerateCacheKey(context.Background(), cfg, nil, "")
 	require.NoError(t, err, "hash after command change should succeed")
 	assert.NotEqual(t, hash1, hash3, "expected command change to alter hash")
 }
@@ -57,12 +109,12 @@ func TestFileInputsAffectHash(t *testing.T) {
 		Inputs:  []string{"*.txt"},
 	}
 
-	hash1, err := GenerateCacheKey(cfg, nil, tmpDir)
+	hash1, err := GenerateCacheKey(context.Background(), cfg, nil, tmpDir)
 	require.NoError(t, err, "hash with initial files should succeed")
 
 	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "c.txt"), []byte("c"), 0o644))
 
-	hash2, err := GenerateCacheKey(cfg, nil, tmpDir)
+	hash2, err := GenerateCacheKey(context.Background(), cfg, nil, tmpDir)
 	require.NoError(t, err, "hash after adding file should succeed")
 	assert.NotEqual(t, hash1, hash2, "adding matching file should alter hash")
 }
@@ -78,12 +130,12 @@ func TestGitignoreFiltersFiles(t *testing.T) {
 		Inputs:  []string{"*.txt"},
 	}
 
-	hash1, err := GenerateCacheKey(cfg, nil, tmpDir)
+	hash1, err := GenerateCacheKey(context.Background(), cfg, nil, tmpDir)
 	require.NoError(t, err, "hash with ignored file absent should succeed")
 
 	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "ignored.txt"), []byte("ignore"), 0o644))
 
-	hash2, err := GenerateCacheKey(cfg, nil, tmpDir)
+	hash2, err := GenerateCacheKey(context.Background(), cfg, nil, tmpDir)
 	require.NoError(t, err, "hash with ignored file should succeed")
 	assert.Equal(t, hash1, hash2, "ignored file should not alter hash")
 }
@@ -110,7 +162,7 @@ func TestGenerateCacheKeyIntegration(t *testing.T) {
 
 	run := func() string {
 		t.Helper()
-		hash, err := GenerateCacheKey(cfg, nil, tmpDir)
+		hash, err := GenerateCacheKey(context.Background(), cfg, nil, tmpDir)
 		require.NoError(t, err, "GenerateCacheKey should succeed")
 		return hash
 	}
@@ -160,9 +212,9 @@ func TestGenerateTaskNodeCacheKeyIncludesNodeIdentity(t *testing.T) {
 		},
 	}
 
-	keyA, err := GenerateTaskNodeCacheKey(nodeA, nil)
+	keyA, err := GenerateTaskNodeCacheKey(context.Background(), nodeA, nil)
 	require.NoError(t, err, "GenerateTaskNodeCacheKey should succeed for nodeA")
-	keyB, err := GenerateTaskNodeCacheKey(nodeB, nil)
+	keyB, err := GenerateTaskNodeCacheKey(context.Background(), nodeB, nil)
 	require.NoError(t, err, "GenerateTaskNodeCacheKey should succeed for nodeB")
 	assert.NotEqual(t, keyA, keyB, "distinct packages should yield unique cache keys")
 }
@@ -170,12 +222,12 @@ func TestGenerateTaskNodeCacheKeyIncludesNodeIdentity(t *testing.T) {
 func TestGenerateCacheKeyDependsOnDependencyKeys(t *testing.T) {
 	cfg := config.TaskConfig{Command: "npm run build"}
 
-	base, err := GenerateCacheKey(cfg, nil, "")
+	base, err := GenerateCacheKey(context.Background(), cfg, nil, "")
 	require.NoError(t, err, "base key should compute")
 
-	withDepsOrder1, err := GenerateCacheKey(cfg, []string{"dep-b", "dep-a"}, "")
+	withDepsOrder1, err := GenerateCacheKey(context.Background(), cfg, []string{"dep-b", "dep-a"}, "")
 	require.NoError(t, err, "key with deps should compute")
-	withDepsOrder2, err := GenerateCacheKey(cfg, []string{"dep-a", "dep-b"}, "")
+	withDepsOrder2, err := GenerateCacheKey(context.Background(), cfg, []string{"dep-a", "dep-b"}, "")
 	require.NoError(t, err, "key with deps in different order should compute")
 
 	assert.NotEqual(t, base, withDepsOrder1, "dependency keys should influence hash")
@@ -206,13 +258,13 @@ func TestGenerateTaskNodeCacheKeyIncludesDependencyKeys(t *testing.T) {
 		Dependencies: []*TaskNode{depNode},
 	}
 
-	depKey, err := GenerateTaskNodeCacheKey(depNode, nil)
+	depKey, err := GenerateTaskNodeCacheKey(context.Background(), depNode, nil)
 	require.NoError(t, err, "dependency key should compute")
 
-	rootWithoutDeps, err := GenerateTaskNodeCacheKey(rootNode, nil)
+	rootWithoutDeps, err := GenerateTaskNodeCacheKey(context.Background(), rootNode, nil)
 	require.NoError(t, err, "root key without deps should compute")
 
-	rootWithDeps, err := GenerateTaskNodeCacheKey(rootNode, []string{depKey})
+	rootWithDeps, err := GenerateTaskNodeCacheKey(context.Background(), rootNode, []string{depKey})
 	require.NoError(t, err, "root key with deps should compute")
 
 	assert.NotEqual(t, rootWithoutDeps, rootWithDeps, "including dependency keys should alter hash")
@@ -274,7 +326,7 @@ func TestDependencyHashPropagation(t *testing.T) {
 			for _, dep := range n.Dependencies {
 				depKeys = append(depKeys, run(dep))
 			}
-			key, err := GenerateTaskNodeCacheKey(n, depKeys)
+			key, err := GenerateTaskNodeCacheKey(context.Background(), n, depKeys)
 			require.NoError(t, err, "generate cache key for %s", n.ID)
 			cache[n.ID] = key
 			return key
@@ -293,3 +345,280 @@ func TestDependencyHashPropagation(t *testing.T) {
 	assert.NotEqual(t, hashA1, hashA2, "task A hash should change when its inputs change")
 	assert.NotEqual(t, hashB1, hashB2, "task B hash should change when dependency hash changes")
 }
+
+func TestGenerateTaskNodeManifestMatchesCacheKey(t *testing.T) {
+	tmpDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "main.go"), []byte("package main"), 0o644))
+	t.Setenv("VELOCITY_MANIFEST_TEST", "secret")
+
+	dep := &TaskNode{ID: "lib#build", TaskName: "build", TaskConfig: config.TaskConfig{Command: "make lib"}, CacheKey: "dep-key"}
+	node := &TaskNode{
+		ID:       "app#build",
+		TaskName: "build",
+		Package:  &Package{Name: "app", Path: tmpDir},
+		TaskConfig: config.TaskConfig{
+			Command: "go build",
+			Inputs:  []string{"*.go"},
+			EnvKeys: []string{"VELOCITY_MANIFEST_TEST"},
+		},
+		Dependencies: []*TaskNode{dep},
+	}
+
+	manifest, err := GenerateTaskNodeManifest(context.Background(), node)
+	require.NoError(t, err)
+	key, err := GenerateTaskNodeCacheKey(context.Background(), node, []string{"dep-key"})
+	require.NoError(t, err)
+
+	assert.Equal(t, key, manifest.Key)
+	assert.Equal(t, "app#build", manifest.Task)
+	require.Len(t, manifest.Files, 1)
+	assert.Equal(t, filepath.Join(tmpDir, "main.go"), manifest.Files[0].Path)
+	require.Len(t, manifest.Env, 1)
+	assert.Equal(t, "VELOCITY_MANIFEST_TEST", manifest.Env[0].Name)
+	assert.NotEqual(t, "secret", manifest.Env[0].ValueHash, "env values are hashed")
+	assert.Equal(t, []DependencyKey{{Task: "lib#build", Key: "dep-key"}}, manifest.Dependencies)
+}
+
+func TestToolDependenciesAreHashed(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as a fake tool")
+	}
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho\necho \"velotool $(cat " + filepath.Join(bin, "version") + ")\"\n"
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "velotool"), []byte(script), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "version"), []byte("1.0"), 0o644))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cfg := config.TaskConfig{Command: "make", ToolDependencies: []string{"velotool", "velotool"}}
+	manifest, err := buildLocalManifest(context.Background(), cfg, "")
+	require.NoError(t, err)
+	require.Equal(t, []ToolVersion{{Name: "velotool", Version: "velotool 1.0"}}, manifest.Tools)
+
+	without, err := buildLocalManifest(context.Background(), config.TaskConfig{Command: "make"}, "")
+	require.NoError(t, err)
+	assert.NotEqual(t, without.localHash(), manifest.localHash(), "expected tool versions to alter the hash")
+
+	_, err = buildLocalManifest(context.Background(), config.TaskConfig{Command: "make", ToolDependencies: []string{"velotool-missing"}}, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "velotool-missing not found")
+}
+
+func TestCacheVersionAltersEveryKey(t *testing.T) {
+	t.Cleanup(func() { SetCacheVersion("") })
+	cfg := config.TaskConfig{Command: "npm run build", Inputs: []string{}}
+
+	unversioned, err := GenerateCacheKey(context.Background(), cfg, nil, "")
+	require.NoError(t, err)
+
+	SetCacheVersion("2")
+	versioned, err := GenerateCacheKey(context.Background(), cfg, nil, "")
+	require.NoError(t, err)
+	assert.NotEqual(t, unversioned, versioned, "expected the cache version to alter the key")
+
+	manifest, err := buildLocalManifest(context.Background(), cfg, "")
+	require.NoError(t, err)
+	assert.Equal(t, "2", manifest.CacheVersion)
+	changes := DiffManifests(&KeyManifest{Command: cfg.Command, CommandHash: manifest.CommandHash}, manifest)
+	require.Len(t, changes, 1)
+	assert.Equal(t, "cache_version", changes[0].Kind)
+
+	SetCacheVersion("")
+	again, err := GenerateCacheKey(context.Background(), cfg, nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, unversioned, again, "expected an empty version to leave keys unchanged")
+}
+
+func TestGlobalInputsAlterEveryKey(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		t.Cleanup(func() { SetGlobalInputs(nil, nil) })
+		require.NoError(t, os.WriteFile(filepath.Join(root, ".nvmrc"), []byte("20\n"), 0o644))
+		t.Setenv("CI_TARGET", "staging")
+		cfg := config.TaskConfig{Command: "npm run build"}
+
+		base, err := GenerateCacheKey(context.Background(), cfg, nil, "")
+		require.NoError(t, err)
+
+		SetGlobalInputs([]string{".nvmrc"}, []string{"CI_TARGET"})
+		manifest, err := buildLocalManifest(context.Background(), cfg, "")
+		require.NoError(t, err)
+		require.Len(t, manifest.GlobalFiles, 1)
+		assert.Equal(t, ".nvmrc", manifest.GlobalFiles[0].Path)
+		require.Len(t, manifest.GlobalEnv, 1)
+		withGlobals := manifest.baseKey(nil)
+		assert.NotEqual(t, base, withGlobals, "expected global inputs to alter the key")
+
+		require.NoError(t, os.WriteFile(filepath.Join(root, ".nvmrc"), []byte("22\n"), 0o644))
+		SetGlobalInputs([]string{".nvmrc"}, []string{"CI_TARGET"})
+		changed, err := GenerateCacheKey(context.Background(), cfg, nil, "")
+		require.NoError(t, err)
+		assert.NotEqual(t, withGlobals, changed, "expected a global file change to alter the key")
+
+		overridden, err := buildLocalManifest(context.Background(), cfg.WithEnv(map[string]string{"CI_TARGET": "prod"}), "")
+		require.NoError(t, err)
+		require.Len(t, overridden.GlobalEnv, 1)
+		assert.Equal(t, hashString("prod"), overridden.GlobalEnv[0].ValueHash, "expected --env overrides to apply to global_env")
+	})
+}
+
+func TestHashCacheSkipsUnchangedFiles(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		resetHashCache()
+		t.Cleanup(resetHashCache)
+
+		path := "input.txt"
+		require.NoError(t, os.WriteFile(path, []byte("one"), 0o644))
+		old := time.Now().Add(-time.Hour)
+		require.NoError(t, os.Chtimes(path, old, old))
+
+		sum, err := cachedHashFile(path)
+		require.NoError(t, err)
+		require.NoError(t, SaveHashCache())
+		_, err = os.Stat(filepath.Join(root, ".velocity", "hash-manifest.json"))
+		require.NoError(t, err, "expected the hash cache to be written")
+
+		// Same size and mtime: the remembered hash is returned unread.
+		require.NoError(t, os.WriteFile(path, []byte("two"), 0o644))
+		require.NoError(t, os.Chtimes(path, old, old))
+		resetHashCache()
+		cached, err := cachedHashFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, sum, cached)
+
+		changed := old.Add(time.Minute)
+		require.NoError(t, os.Chtimes(path, changed, changed))
+		fresh, err := cachedHashFile(path)
+		require.NoError(t, err)
+		assert.NotEqual(t, sum, fresh, "expected an mtime change to force a re-read")
+	})
+}
+
+func TestHashCacheIgnoresRecentlyModifiedFiles(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		resetHashCache()
+		t.Cleanup(resetHashCache)
+
+		require.NoError(t, os.WriteFile("input.txt", []byte("one"), 0o644))
+		_, err := cachedHashFile("input.txt")
+		require.NoError(t, err)
+		require.NoError(t, SaveHashCache())
+		_, err = os.Stat(filepath.Join(root, ".velocity", "hash-manifest.json"))
+		assert.True(t, os.IsNotExist(err), "expected a just-written file not to be remembered")
+	})
+}
+
+func TestCollectInputFilesIsSafeToRunConcurrently(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		packages := []string{"a", "b", "c", "d"}
+		for _, pkg := range packages {
+			require.NoError(t, os.MkdirAll(filepath.Join(root, pkg, "src"), 0o755))
+			require.NoError(t, os.WriteFile(filepath.Join(root, pkg, "src", pkg+".js"), []byte(pkg), 0o644))
+		}
+		require.NoError(t, os.MkdirAll(filepath.Join(root, "shared"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, "shared", "util.js"), []byte("util"), 0o644))
+
+		before, err := os.Getwd()
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, len(packages)*20)
+		for i := 0; i < 20; i++ {
+			for _, pkg := range packages {
+				wg.Add(1)
+				go func(pkg string) {
+					defer wg.Done()
+					files, err := collectInputFiles([]string{"src/**/*.js", "../shared/*.js"}, pkg)
+					if err != nil {
+						errs <- err
+						return
+					}
+					want := []string{filepath.Join(pkg, "src", pkg+".js"), filepath.Join("shared", "util.js")}
+					sort.Strings(want)
+					if !slices.Equal(files, want) {
+						errs <- fmt.Errorf("package %s: got %v, want %v", pkg, files, want)
+					}
+				}(pkg)
+			}
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+
+		after, err := os.Getwd()
+		require.NoError(t, err)
+		assert.Equal(t, before, after, "expected the working directory to be left alone")
+	})
+}
+
+func TestFileHashAlgorithmXXHash(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		resetHashCache()
+		t.Cleanup(func() {
+			SetFileHashAlgorithm("")
+			resetHashCache()
+		})
+		require.NoError(t, os.WriteFile("input.txt", []byte("content"), 0o644))
+		old := time.Now().Add(-time.Hour)
+		require.NoError(t, os.Chtimes("input.txt", old, old))
+
+		sha, err := cachedHashFile("input.txt")
+		require.NoError(t, err)
+		assert.Len(t, sha, 64)
+
+		SetFileHashAlgorithm(config.HashAlgorithmXXHash)
+		xx, err := cachedHashFile("input.txt")
+		require.NoError(t, err)
+		assert.Len(t, xx, 16, "expected a 64-bit xxhash digest rather than the cached SHA-256")
+
+		cfg := config.TaskConfig{Command: "make", Inputs: []string{"*.txt"}}
+		withXX, err := GenerateCacheKey(context.Background(), cfg, nil, "")
+		require.NoError(t, err)
+		SetFileHashAlgorithm("")
+		withSHA, err := GenerateCacheKey(context.Background(), cfg, nil, "")
+		require.NoError(t, err)
+		assert.NotEqual(t, withSHA, withXX)
+		assert.Len(t, withXX, 64, "expected cache keys to stay SHA-256")
+	})
+}
+
+func TestDefaultInputsCoverUnignoredPackageFiles(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		write := func(name, content string) {
+			path := filepath.Join(root, filepath.FromSlash(name))
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+			require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		}
+		write("app/.gitignore", "*.log\n")
+		write("app/src/index.js", "index")
+		write("app/package.json", "{}")
+		write("app/debug.log", "ignored")
+		write("app/node_modules/dep/index.js", "dep")
+		write("app/dist/out.js", "built")
+		write("app/coverage/lcov.info", "coverage")
+
+		cfg := config.TaskConfig{Command: "npm run build", Outputs: []string{"dist", "coverage/**"}}
+		manifest, err := buildLocalManifest(context.Background(), cfg, "app")
+		require.NoError(t, err)
+		var paths []string
+		for _, file := range manifest.Files {
+			paths = append(paths, filepath.ToSlash(file.Path))
+		}
+		assert.Equal(t, []string{"app/.gitignore", "app/package.json", "app/src/index.js"}, paths)
+
+		write("app/dist/out.js", "rebuilt")
+		unchanged, err := buildLocalManifest(context.Background(), cfg, "app")
+		require.NoError(t, err)
+		assert.Equal(t, manifest.localHash(), unchanged.localHash(), "expected output changes not to alter the key")
+
+		write("app/src/index.js", "edited")
+		edited, err := buildLocalManifest(context.Background(), cfg, "app")
+		require.NoError(t, err)
+		assert.NotEqual(t, manifest.localHash(), edited.localHash(), "expected source edits to alter the key")
+
+		cfg.Inputs = []string{}
+		commandOnly, err := buildLocalManifest(context.Background(), cfg, "app")
+		require.NoError(t, err)
+		assert.Empty(t, commandOnly.Files, "expected an explicit empty inputs list to hash no files")
+	})
+}
@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+)
+
+// TLSOptions configures how remote servers and storage are verified.
+type TLSOptions struct {
+	// CAFile is a PEM bundle of certificate authorities trusted in addition
+	// to the system ones, e.g. for a proxy intercepting TLS.
+	CAFile string
+	// InsecureSkipVerify accepts any certificate. It is meant for testing
+	// self-hosted servers only.
+	InsecureSkipVerify bool
+}
+
+var remoteTransport atomic.Value
+
+// SetTLSOptions sets how the TLS certificates of the remote cache server,
+// and of the storage it hands out URLs for, are verified. Requests go
+// through the proxy given by HTTPS_PROXY, HTTP_PROXY and NO_PROXY either
+// way.
+func SetTLSOptions(opts TLSOptions) error {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if opts.CAFile != "" || opts.InsecureSkipVerify {
+		tlsConfig := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+		if opts.CAFile != "" {
+			pool, err := loadCAFile(opts.CAFile)
+			if err != nil {
+				return err
+			}
+			tlsConfig.RootCAs = pool
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	if opts.InsecureSkipVerify {
+		debugf("TLS certificate verification is disabled")
+	}
+	remoteTransport.Store(transport)
+	return nil
+}
+
+// loadCAFile returns the system certificate pool with the certificates in
+// path added.
+func loadCAFile(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CA file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("read CA file: no PEM certificates in " + path)
+	}
+	return pool, nil
+}
+
+// configuredTransport sends requests through the transport SetTLSOptions
+// last built, or http.DefaultTransport before it is called.
+type configuredTransport struct{}
+
+func (configuredTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if transport, ok := remoteTransport.Load().(*http.Transport); ok {
+		return transport.RoundTrip(req)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
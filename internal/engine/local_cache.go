@@ -6,7 +6,11 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -14,6 +18,8 @@ const (
 	cacheDirName    = "cache"
 	cacheFileExt    = ".zip"
 	cacheMetaExt    = ".meta.json"
+	cacheSumExt     = ".sha256"
+	cacheTmpExt     = ".tmp"
 )
 
 func checkLocal(cacheKey string) (string, bool, error) {
@@ -39,6 +45,17 @@ func checkLocal(cacheKey string) (string, bool, error) {
 	return path, true, nil
 }
 
+var (
+	localCacheMaxBytes atomic.Int64
+	evictMu            sync.Mutex
+)
+
+// SetLocalCacheMaxBytes sets the size budget enforced after every save.
+// Zero or less disables eviction.
+func SetLocalCacheMaxBytes(n int64) {
+	localCacheMaxBytes.Store(n)
+}
+
 func saveLocal(cacheKey, zipPath string) (string, error) {
 	if err := validateCacheKey(cacheKey); err != nil {
 		return "", err
@@ -69,13 +86,161 @@ func saveLocal(cacheKey, zipPath string) (string, error) {
 		return destination, nil
 	}
 
-	if err := copyFile(cleanedZip, destination); err != nil {
+	// Copy to a temporary file and rename it into place so a crash never
+	// leaves a truncated artifact under the key.
+	tmp, err := os.CreateTemp(cacheDir, "."+cacheKey+"-*"+cacheTmpExt)
+	if err != nil {
+		return "", fmt.Errorf("save local cache create temp in %s: %w", cacheDir, err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := copyFile(cleanedZip, tmp.Name()); err != nil {
+		return "", err
+	}
+	sum, err := ArtifactChecksum(tmp.Name())
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), destination); err != nil {
+		return "", fmt.Errorf("save local cache rename to %s: %w", destination, err)
+	}
+	if err := removeLocalTree(cacheKey); err != nil {
+		return "", err
+	}
+	if err := RemoveFailure(cacheKey); err != nil {
+		return "", err
+	}
+	if err := writeLocalChecksum(cacheKey, sum); err != nil {
+		return "", err
+	}
+
+	if _, err := evictLocal(localCacheMaxBytes.Load(), cacheKey); err != nil {
 		return "", err
 	}
 
 	return destination, nil
 }
 
+// evictLocal removes least recently used artifacts until the cache fits in
+// maxBytes, never removing keep.
+func evictLocal(maxBytes int64, keep string) ([]LocalEntry, error) {
+	if maxBytes <= 0 {
+		return nil, nil
+	}
+	evictMu.Lock()
+	defer evictMu.Unlock()
+
+	entries, err := ListLocal()
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+
+	var evicted []LocalEntry
+	for _, entry := range entries {
+		if total <= maxBytes {
+			break
+		}
+		if entry.Key == keep {
+			continue
+		}
+		if _, err := RemoveLocal(entry.Key); err != nil {
+			return evicted, fmt.Errorf("evict %s: %w", entry.Key, err)
+		}
+		total -= entry.Size
+		evicted = append(evicted, entry)
+	}
+	return evicted, nil
+}
+
+// PruneLocal removes artifacts last used before cutoff and then evicts the
+// least recently used ones until the cache fits in maxBytes. A zero cutoff
+// or maxBytes skips that step. Expired failure records, and metadata left
+// behind by artifacts removed without it, are always removed, and partial
+// downloads last written before cutoff with the artifacts.
+func PruneLocal(cutoff time.Time, maxBytes int64) ([]LocalEntry, error) {
+	if err := pruneFailures(); err != nil {
+		return nil, err
+	}
+	if err := pruneOrphanedMetadata(); err != nil {
+		return nil, err
+	}
+	var removed []LocalEntry
+	if !cutoff.IsZero() {
+		if err := prunePartials(cutoff); err != nil {
+			return nil, err
+		}
+		entries, err := ListLocal()
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.ModTime.Before(cutoff) {
+				break
+			}
+			if _, err := RemoveLocal(entry.Key); err != nil {
+				return removed, err
+			}
+			removed = append(removed, entry)
+		}
+	}
+
+	evicted, err := evictLocal(maxBytes, "")
+	return append(removed, evicted...), err
+}
+
+// pruneOrphanedMetadata removes metadata, checksums and logs whose artifact
+// is gone.
+func pruneOrphanedMetadata() error {
+	dir, err := localCacheDir()
+	if err != nil {
+		return err
+	}
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read cache dir %s: %w", dir, err)
+	}
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if dirEntry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		for _, ext := range []string{cacheMetaExt, cacheSumExt, cacheLogExt} {
+			key, ok := strings.CutSuffix(name, ext)
+			if !ok {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(dir, key+cacheFileExt)); errors.Is(err, os.ErrNotExist) {
+				if err := os.Remove(filepath.Join(dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return fmt.Errorf("remove %s: %w", name, err)
+				}
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// TouchLocal marks an artifact as recently used so eviction keeps it. The
+// modification time doubles as the last-access time.
+func TouchLocal(cacheKey string) error {
+	path, err := localCacheFile(cacheKey)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		return fmt.Errorf("touch %s: %w", path, err)
+	}
+	return nil
+}
+
 func cleanLocal() error {
 	dir, err := localCacheDir()
 	if err != nil {
@@ -106,6 +271,80 @@ func localCacheFile(cacheKey string) (string, error) {
 	return filepath.Join(dir, cacheKey+cacheFileExt), nil
 }
 
+func localCacheChecksum(cacheKey string) (string, error) {
+	dir, err := localCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, cacheKey+cacheSumExt), nil
+}
+
+// writeLocalChecksum records the SHA-256 of a stored artifact next to it.
+func writeLocalChecksum(cacheKey, sum string) error {
+	sumPath, err := localCacheChecksum(cacheKey)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(sumPath), "."+cacheKey+"-*"+cacheTmpExt)
+	if err != nil {
+		return fmt.Errorf("write checksum %s: %w", sumPath, err)
+	}
+	_, err = tmp.WriteString(sum + "\n")
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), sumPath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("write checksum %s: %w", sumPath, err)
+	}
+	return nil
+}
+
+// VerifyLocal checks a cached artifact against the checksum recorded when it
+// was stored. Artifacts stored without a checksum, which older versions may
+// have left truncated, must at least be readable archives. A corrupt
+// artifact is removed, along with its manifest, and ErrChecksumMismatch or
+// ErrCorruptArtifact returned.
+func VerifyLocal(cacheKey string) error {
+	path, found, err := checkLocal(cacheKey)
+	if err != nil || !found {
+		return err
+	}
+	sumPath, err := localCacheChecksum(cacheKey)
+	if err != nil {
+		return err
+	}
+	want, err := os.ReadFile(sumPath)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := checkArchive(path); err != nil {
+			debugf("local cache %s: %v; removing it", cacheKey, err)
+			if _, removeErr := RemoveLocal(cacheKey); removeErr != nil {
+				return removeErr
+			}
+			return fmt.Errorf("%w: %v", ErrCorruptArtifact, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read checksum %s: %w", sumPath, err)
+	}
+	got, err := ArtifactChecksum(path)
+	if err != nil {
+		return err
+	}
+	if err := verifyChecksum(got, strings.TrimSpace(string(want))); err != nil {
+		debugf("local cache %s: %v; removing it", cacheKey, err)
+		if _, removeErr := RemoveLocal(cacheKey); removeErr != nil {
+			return removeErr
+		}
+		return err
+	}
+	return nil
+}
+
 func localCacheMetadata(cacheKey string) (string, error) {
 	if err := validateCacheKey(cacheKey); err != nil {
 		return "", err
@@ -144,6 +383,9 @@ func copyFile(src, dst string) error {
 	}
 
 	_, copyErr := io.Copy(out, in)
+	if copyErr == nil {
+		copyErr = out.Sync()
+	}
 	closeErr := out.Close()
 	inCloseErr := in.Close()
 
@@ -196,3 +438,116 @@ func CacheMetadataObjectName(cacheKey string) (string, error) {
 	}
 	return cacheKey + cacheMetaExt, nil
 }
+
+// LocalEntry describes one artifact in the local cache.
+type LocalEntry struct {
+	Key     string
+	Path    string
+	Size    int64
+	ModTime time.Time
+	// Task is read from the artifact's manifest and is empty when the
+	// artifact has none.
+	Task string
+	// Info describes how the artifact was produced; it is nil for
+	// artifacts stored without it.
+	Info *ArtifactInfo
+}
+
+// ListLocal returns every artifact in the local cache, least recently used
+// first.
+func ListLocal() ([]LocalEntry, error) {
+	dir, err := localCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read cache dir %s: %w", dir, err)
+	}
+
+	entries := make([]LocalEntry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if dirEntry.IsDir() || !strings.HasSuffix(name, cacheFileExt) {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		entry := LocalEntry{
+			Key:     strings.TrimSuffix(name, cacheFileExt),
+			Path:    filepath.Join(dir, name),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+		if manifest, info, err := readMetadata(filepath.Join(dir, entry.Key+cacheMetaExt)); err == nil && manifest != nil {
+			entry.Task, entry.Info = manifest.Task, info
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime.Before(entries[j].ModTime)
+	})
+	return entries, nil
+}
+
+// RemoveLocal deletes the artifact stored for cacheKey, its metadata, log
+// and any recorded failure. It reports whether an artifact existed.
+func RemoveLocal(cacheKey string) (bool, error) {
+	path, found, err := checkLocal(cacheKey)
+	if err != nil {
+		return false, err
+	}
+	metaPath, err := localCacheMetadata(cacheKey)
+	if err != nil {
+		return false, err
+	}
+	sumPath, err := localCacheChecksum(cacheKey)
+	if err != nil {
+		return false, err
+	}
+	logPath, err := localCacheLog(cacheKey)
+	if err != nil {
+		return false, err
+	}
+	for _, extra := range []string{metaPath, sumPath, logPath} {
+		if err := os.Remove(extra); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, fmt.Errorf("remove %s: %w", extra, err)
+		}
+	}
+	if err := removeLocalTree(cacheKey); err != nil {
+		return false, err
+	}
+	if err := RemoveFailure(cacheKey); err != nil {
+		return false, err
+	}
+	if !found {
+		return false, nil
+	}
+	if err := os.Remove(path); err != nil {
+		return false, fmt.Errorf("remove %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// removeLocalTree removes the unpacked copy of an artifact, if any.
+func removeLocalTree(cacheKey string) error {
+	tree, err := localCacheTree(cacheKey)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(tree); err != nil {
+		return fmt.Errorf("remove %s: %w", tree, err)
+	}
+	return nil
+}
+
+// ValidateCacheKey reports whether key can name an artifact in the cache.
+func ValidateCacheKey(cacheKey string) error {
+	return validateCacheKey(cacheKey)
+}
@@ -1,9 +1,12 @@
 package engine
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestCheckLocalMissing(t *testing.T) {
@@ -146,3 +149,229 @@ func withTempWorkdir(t *testing.T, fn func(root string)) {
 
 	fn(tempDir)
 }
+
+func TestListLocalReadsManifestTasks(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		srcZip := filepath.Join(root, "source.zip")
+		if err := os.WriteFile(srcZip, []byte("zipdata"), 0o644); err != nil {
+			t.Fatalf("write source zip: %v", err)
+		}
+		for _, key := range []string{"first", "second"} {
+			if _, err := saveLocal(key, srcZip); err != nil {
+				t.Fatalf("saveLocal error: %v", err)
+			}
+		}
+		if err := SaveLocalManifest(&KeyManifest{Key: "second", Task: "app#build"}); err != nil {
+			t.Fatalf("SaveLocalManifest error: %v", err)
+		}
+
+		entries, err := ListLocal()
+		if err != nil {
+			t.Fatalf("ListLocal error: %v", err)
+		}
+		if len(entries) != 2 {
+			t.Fatalf("expected 2 entries, got %d", len(entries))
+		}
+		tasks := map[string]string{}
+		for _, entry := range entries {
+			if entry.Size != int64(len("zipdata")) {
+				t.Fatalf("unexpected size for %s: %d", entry.Key, entry.Size)
+			}
+			tasks[entry.Key] = entry.Task
+		}
+		if tasks["first"] != "" || tasks["second"] != "app#build" {
+			t.Fatalf("unexpected tasks: %v", tasks)
+		}
+	})
+}
+
+func TestRemoveLocalDeletesArtifactAndManifest(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		srcZip := filepath.Join(root, "source.zip")
+		if err := os.WriteFile(srcZip, []byte("zipdata"), 0o644); err != nil {
+			t.Fatalf("write source zip: %v", err)
+		}
+		if _, err := saveLocal("key", srcZip); err != nil {
+			t.Fatalf("saveLocal error: %v", err)
+		}
+		if err := SaveLocalManifest(&KeyManifest{Key: "key", Task: "app#build"}); err != nil {
+			t.Fatalf("SaveLocalManifest error: %v", err)
+		}
+
+		removed, err := RemoveLocal("key")
+		if err != nil || !removed {
+			t.Fatalf("RemoveLocal = %v, %v; want true, nil", removed, err)
+		}
+		for _, name := range []string{"key.zip", "key.meta.json"} {
+			if _, err := os.Stat(filepath.Join(root, ".velocity", "cache", name)); !os.IsNotExist(err) {
+				t.Fatalf("expected %s to be removed, stat err: %v", name, err)
+			}
+		}
+
+		removed, err = RemoveLocal("key")
+		if err != nil || removed {
+			t.Fatalf("second RemoveLocal = %v, %v; want false, nil", removed, err)
+		}
+	})
+}
+
+func TestSaveLocalEvictsLeastRecentlyUsed(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		SetLocalCacheMaxBytes(20)
+		t.Cleanup(func() { SetLocalCacheMaxBytes(0) })
+
+		srcZip := filepath.Join(root, "source.zip")
+		if err := os.WriteFile(srcZip, []byte("0123456789"), 0o644); err != nil {
+			t.Fatalf("write source zip: %v", err)
+		}
+
+		past := time.Now().Add(-time.Hour)
+		for i, key := range []string{"old", "used"} {
+			if _, err := saveLocal(key, srcZip); err != nil {
+				t.Fatalf("saveLocal %s: %v", key, err)
+			}
+			stamp := past.Add(time.Duration(i) * time.Minute)
+			if err := os.Chtimes(filepath.Join(root, ".velocity", "cache", key+".zip"), stamp, stamp); err != nil {
+				t.Fatalf("chtimes: %v", err)
+			}
+		}
+		if err := TouchLocal("old"); err != nil {
+			t.Fatalf("TouchLocal: %v", err)
+		}
+
+		if _, err := saveLocal("new", srcZip); err != nil {
+			t.Fatalf("saveLocal new: %v", err)
+		}
+
+		for key, want := range map[string]bool{"old": true, "used": false, "new": true} {
+			_, found, err := checkLocal(key)
+			if err != nil {
+				t.Fatalf("checkLocal %s: %v", key, err)
+			}
+			if found != want {
+				t.Fatalf("%s found=%v, want %v", key, found, want)
+			}
+		}
+	})
+}
+
+func TestPruneLocalByAgeThenSize(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		srcZip := filepath.Join(root, "source.zip")
+		if err := os.WriteFile(srcZip, []byte("0123456789"), 0o644); err != nil {
+			t.Fatalf("write source zip: %v", err)
+		}
+
+		now := time.Now()
+		ages := map[string]time.Duration{"stale": 48 * time.Hour, "older": 2 * time.Hour, "recent": time.Minute}
+		for key, age := range ages {
+			if _, err := saveLocal(key, srcZip); err != nil {
+				t.Fatalf("saveLocal %s: %v", key, err)
+			}
+			stamp := now.Add(-age)
+			if err := os.Chtimes(filepath.Join(root, ".velocity", "cache", key+".zip"), stamp, stamp); err != nil {
+				t.Fatalf("chtimes: %v", err)
+			}
+		}
+
+		removed, err := PruneLocal(now.Add(-24*time.Hour), 10)
+		if err != nil {
+			t.Fatalf("PruneLocal: %v", err)
+		}
+		if len(removed) != 2 || removed[0].Key != "stale" || removed[1].Key != "older" {
+			t.Fatalf("unexpected removals: %+v", removed)
+		}
+		if _, found, _ := checkLocal("recent"); !found {
+			t.Fatalf("expected recent artifact to be kept")
+		}
+	})
+}
+
+func TestVerifyLocalRemovesCorruptArtifact(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		srcZip := filepath.Join(root, "source.zip")
+		if err := os.WriteFile(srcZip, []byte("zipdata"), 0o644); err != nil {
+			t.Fatalf("write source zip: %v", err)
+		}
+		dest, err := saveLocal("key", srcZip)
+		if err != nil {
+			t.Fatalf("saveLocal error: %v", err)
+		}
+		if err := VerifyLocal("key"); err != nil {
+			t.Fatalf("expected intact artifact to verify, got %v", err)
+		}
+
+		if err := os.WriteFile(dest, []byte("garbage"), 0o644); err != nil {
+			t.Fatalf("corrupt artifact: %v", err)
+		}
+		if err := VerifyLocal("key"); !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("expected checksum mismatch, got %v", err)
+		}
+		if _, found, _ := checkLocal("key"); found {
+			t.Fatalf("expected corrupt artifact to be removed")
+		}
+		sumPath, _ := localCacheChecksum("key")
+		if _, err := os.Stat(sumPath); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected checksum file to be removed, got %v", err)
+		}
+	})
+}
+
+func TestSaveLocalLeavesNoTempFiles(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		srcZip := filepath.Join(root, "source.zip")
+		if err := os.WriteFile(srcZip, []byte("zipdata"), 0o644); err != nil {
+			t.Fatalf("write source zip: %v", err)
+		}
+		if _, err := saveLocal("key", srcZip); err != nil {
+			t.Fatalf("saveLocal error: %v", err)
+		}
+		entries, err := os.ReadDir(filepath.Join(root, ".velocity", "cache"))
+		if err != nil {
+			t.Fatalf("read cache dir: %v", err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		if len(names) != 2 || names[0] != "key.sha256" || names[1] != "key.zip" {
+			t.Fatalf("unexpected cache dir contents: %v", names)
+		}
+	})
+}
+
+func TestVerifyLocalRejectsTruncatedArtifactWithoutChecksum(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		dist := filepath.Join(root, "dist")
+		mustWriteFile(t, filepath.Join(dist, "out.txt"), "data")
+		srcZip := filepath.Join(root, "source.zip")
+		if err := compress(context.Background(), []string{"dist"}, srcZip, root); err != nil {
+			t.Fatalf("compress: %v", err)
+		}
+		dest, err := saveLocal("key", srcZip)
+		if err != nil {
+			t.Fatalf("saveLocal error: %v", err)
+		}
+		sumPath, _ := localCacheChecksum("key")
+		if err := os.Remove(sumPath); err != nil {
+			t.Fatalf("remove checksum: %v", err)
+		}
+		if err := VerifyLocal("key"); err != nil {
+			t.Fatalf("expected readable artifact to verify, got %v", err)
+		}
+
+		data, err := os.ReadFile(dest)
+		if err != nil {
+			t.Fatalf("read artifact: %v", err)
+		}
+		if err := os.WriteFile(dest, data[:len(data)/2], 0o644); err != nil {
+			t.Fatalf("truncate artifact: %v", err)
+		}
+		if err := VerifyLocal("key"); !errors.Is(err, ErrCorruptArtifact) {
+			t.Fatalf("expected corrupt artifact error, got %v", err)
+		}
+		if _, found, _ := checkLocal("key"); found {
+			t.Fatalf("expected truncated artifact to be removed")
+		}
+	})
+}
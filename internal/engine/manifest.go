@@ -0,0 +1,218 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+)
+
+// ManifestChange is one difference between two manifests of the same task.
+type ManifestChange struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name,omitempty"`
+	Change string `json:"change"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+// SaveLocalManifest writes the manifest next to the artifact it describes.
+func SaveLocalManifest(manifest *KeyManifest) error {
+	if manifest == nil {
+		return nil
+	}
+	path, err := localCacheMetadata(manifest.Key)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode manifest: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("save manifest ensure dir: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("save manifest %s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadLocalManifest reads the manifest stored for cacheKey, returning nil
+// when the artifact has none.
+func LoadLocalManifest(cacheKey string) (*KeyManifest, error) {
+	path, err := localCacheMetadata(cacheKey)
+	if err != nil {
+		return nil, err
+	}
+	return readManifest(path)
+}
+
+// LatestLocalManifest returns the most recently written manifest for task,
+// or nil when no cached artifact of the task has one.
+func LatestLocalManifest(task string) (*KeyManifest, error) {
+	dir, err := localCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read cache dir %s: %w", dir, err)
+	}
+
+	var latest *KeyManifest
+	var latestTime time.Time
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), cacheMetaExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		manifest, err := readManifest(filepath.Join(dir, entry.Name()))
+		if err != nil || manifest == nil || manifest.Task != task {
+			continue
+		}
+		if latest == nil || info.ModTime().After(latestTime) {
+			latest, latestTime = manifest, info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func readManifest(path string) (*KeyManifest, error) {
+	manifest, _, err := readMetadata(path)
+	return manifest, err
+}
+
+// DiffManifests lists what changed between a previously cached manifest and
+// the current one, in cache version, global, command, env, file, dot_env,
+// docker, tool, dependency order.
+func DiffManifests(previous, current *KeyManifest) []ManifestChange {
+	var changes []ManifestChange
+	if previous.CacheVersion != current.CacheVersion {
+		changes = append(changes, ManifestChange{Kind: "cache_version", Change: ChangeModified, Old: previous.CacheVersion, New: current.CacheVersion})
+	}
+
+	var oldGlobal, newGlobal []namedHash
+	for _, file := range previous.GlobalFiles {
+		oldGlobal = append(oldGlobal, namedHash{file.Path, file.Hash})
+	}
+	for _, file := range current.GlobalFiles {
+		newGlobal = append(newGlobal, namedHash{file.Path, file.Hash})
+	}
+	changes = append(changes, diffHashes("global_file", oldGlobal, newGlobal)...)
+
+	var oldGlobalEnv, newGlobalEnv []namedHash
+	for _, env := range previous.GlobalEnv {
+		oldGlobalEnv = append(oldGlobalEnv, namedHash{env.Name, env.ValueHash})
+	}
+	for _, env := range current.GlobalEnv {
+		newGlobalEnv = append(newGlobalEnv, namedHash{env.Name, env.ValueHash})
+	}
+	changes = append(changes, diffHashes("global_env", oldGlobalEnv, newGlobalEnv)...)
+
+	if previous.CommandHash != current.CommandHash {
+		changes = append(changes, ManifestChange{Kind: "command", Change: ChangeModified, Old: previous.Command, New: current.Command})
+	}
+
+	var oldEnv, newEnv []namedHash
+	for _, env := range previous.Env {
+		oldEnv = append(oldEnv, namedHash{env.Name, env.ValueHash})
+	}
+	for _, env := range current.Env {
+		newEnv = append(newEnv, namedHash{env.Name, env.ValueHash})
+	}
+	changes = append(changes, diffHashes("env", oldEnv, newEnv)...)
+
+	var oldFiles, newFiles []namedHash
+	for _, file := range previous.Files {
+		oldFiles = append(oldFiles, namedHash{file.Path, file.Hash})
+	}
+	for _, file := range current.Files {
+		newFiles = append(newFiles, namedHash{file.Path, file.Hash})
+	}
+	changes = append(changes, diffHashes("file", oldFiles, newFiles)...)
+
+	var oldDotEnv, newDotEnv []namedHash
+	for _, file := range previous.DotEnv {
+		oldDotEnv = append(oldDotEnv, namedHash{file.Path, file.Hash})
+	}
+	for _, file := range current.DotEnv {
+		newDotEnv = append(newDotEnv, namedHash{file.Path, file.Hash})
+	}
+	changes = append(changes, diffHashes("dot_env", oldDotEnv, newDotEnv)...)
+
+	var oldDocker, newDocker []namedHash
+	for _, file := range previous.Docker {
+		oldDocker = append(oldDocker, namedHash{file.Path, file.Hash})
+	}
+	for _, file := range current.Docker {
+		newDocker = append(newDocker, namedHash{file.Path, file.Hash})
+	}
+	changes = append(changes, diffHashes("docker", oldDocker, newDocker)...)
+
+	var oldTools, newTools []namedHash
+	for _, tool := range previous.Tools {
+		oldTools = append(oldTools, namedHash{tool.Name, tool.Version})
+	}
+	for _, tool := range current.Tools {
+		newTools = append(newTools, namedHash{tool.Name, tool.Version})
+	}
+	changes = append(changes, diffHashes("tool", oldTools, newTools)...)
+
+	var oldDeps, newDeps []namedHash
+	for _, dep := range previous.Dependencies {
+		oldDeps = append(oldDeps, namedHash{dep.Task, dep.Key})
+	}
+	for _, dep := range current.Dependencies {
+		newDeps = append(newDeps, namedHash{dep.Task, dep.Key})
+	}
+	changes = append(changes, diffHashes("dependency", oldDeps, newDeps)...)
+
+	return changes
+}
+
+type namedHash struct {
+	name string
+	hash string
+}
+
+func diffHashes(kind string, previous, current []namedHash) []ManifestChange {
+	oldHashes := make(map[string]string, len(previous))
+	for _, entry := range previous {
+		oldHashes[entry.name] = entry.hash
+	}
+	newHashes := make(map[string]bool, len(current))
+
+	var changes []ManifestChange
+	for _, entry := range current {
+		newHashes[entry.name] = true
+		oldHash, existed := oldHashes[entry.name]
+		switch {
+		case !existed:
+			changes = append(changes, ManifestChange{Kind: kind, Name: entry.name, Change: ChangeAdded, New: entry.hash})
+		case oldHash != entry.hash:
+			changes = append(changes, ManifestChange{Kind: kind, Name: entry.name, Change: ChangeModified, Old: oldHash, New: entry.hash})
+		}
+	}
+	for _, entry := range previous {
+		if !newHashes[entry.name] {
+			changes = append(changes, ManifestChange{Kind: kind, Name: entry.name, Change: ChangeRemoved, Old: entry.hash})
+		}
+	}
+	return changes
+}
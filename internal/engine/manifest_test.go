@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffManifests(t *testing.T) {
+	previous := &KeyManifest{
+		Command:      "tsc",
+		CommandHash:  hashString("tsc"),
+		Env:          []EnvHash{{Name: "NODE_ENV", ValueHash: "a"}, {Name: "CI", ValueHash: "c"}},
+		Files:        []FileHash{{Path: "src/a.ts", Hash: "1"}, {Path: "src/old.ts", Hash: "2"}},
+		Dependencies: []DependencyKey{{Task: "lib#build", Key: "k1"}},
+	}
+	current := &KeyManifest{
+		Command:      "tsc -b",
+		CommandHash:  hashString("tsc -b"),
+		Env:          []EnvHash{{Name: "NODE_ENV", ValueHash: "b"}},
+		Files:        []FileHash{{Path: "src/a.ts", Hash: "1"}, {Path: "src/new.ts", Hash: "3"}},
+		Dependencies: []DependencyKey{{Task: "lib#build", Key: "k2"}},
+	}
+
+	assert.Equal(t, []ManifestChange{
+		{Kind: "command", Change: ChangeModified, Old: "tsc", New: "tsc -b"},
+		{Kind: "env", Name: "NODE_ENV", Change: ChangeModified, Old: "a", New: "b"},
+		{Kind: "env", Name: "CI", Change: ChangeRemoved, Old: "c"},
+		{Kind: "file", Name: "src/new.ts", Change: ChangeAdded, New: "3"},
+		{Kind: "file", Name: "src/old.ts", Change: ChangeRemoved, Old: "2"},
+		{Kind: "dependency", Name: "lib#build", Change: ChangeModified, Old: "k1", New: "k2"},
+	}, DiffManifests(previous, current))
+
+	assert.Empty(t, DiffManifests(current, current))
+}
+
+func TestLatestLocalManifestPicksNewestForTask(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		older := &KeyManifest{Key: "older", Task: "app#build"}
+		newer := &KeyManifest{Key: "newer", Task: "app#build"}
+		other := &KeyManifest{Key: "other", Task: "lib#build"}
+		for _, m := range []*KeyManifest{older, newer, other} {
+			require.NoError(t, SaveLocalManifest(m))
+		}
+		past := time.Now().Add(-time.Hour)
+		require.NoError(t, os.Chtimes(filepath.Join(root, ".velocity", "cache", "older"+cacheMetaExt), past, past))
+
+		latest, err := LatestLocalManifest("app#build")
+		require.NoError(t, err)
+		require.NotNil(t, latest)
+		assert.Equal(t, "newer", latest.Key)
+
+		loaded, err := LoadLocalManifest("other")
+		require.NoError(t, err)
+		assert.Equal(t, other, loaded)
+
+		missing, err := LatestLocalManifest("docs#build")
+		require.NoError(t, err)
+		assert.Nil(t, missing)
+	})
+}
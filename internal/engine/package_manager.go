@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var lockfilePackageManagers = []struct {
+	lockfile string
+	manager  string
+}{
+	{"pnpm-lock.yaml", "pnpm"},
+	{"yarn.lock", "yarn"},
+	{"bun.lockb", "bun"},
+	{"bun.lock", "bun"},
+	{"package-lock.json", "npm"},
+}
+
+// PackageManagerPlaceholder is replaced in task commands with the package
+// manager detected for the package the task runs in.
+const PackageManagerPlaceholder = "{pm}"
+
+// DetectPackageManager returns the JavaScript package manager used in dir,
+// preferring the "packageManager" field of package.json over lockfiles and
+// defaulting to npm.
+func DetectPackageManager(dir string) string {
+	if manager, ok := detectPackageManager(dir); ok {
+		return manager
+	}
+	return "npm"
+}
+
+func detectPackageManager(dir string) (string, bool) {
+	if data, err := os.ReadFile(filepath.Join(dir, "package.json")); err == nil {
+		var manifest struct {
+			PackageManager string `json:"packageManager"`
+		}
+		if json.Unmarshal(data, &manifest) == nil && manifest.PackageManager != "" {
+			name, _, _ := strings.Cut(manifest.PackageManager, "@")
+			return name, true
+		}
+	}
+
+	for _, candidate := range lockfilePackageManagers {
+		if _, err := os.Stat(filepath.Join(dir, candidate.lockfile)); err == nil {
+			return candidate.manager, true
+		}
+	}
+	return "", false
+}
+
+// assignPackageManagers detects each package's manager from its own
+// directory, falling back to the one used at the workspace root.
+func assignPackageManagers(packages map[string]*Package, root string) {
+	rootManager := DetectPackageManager(root)
+	for _, pkg := range packages {
+		if manager, ok := detectPackageManager(pkg.Path); ok {
+			pkg.PackageManager = manager
+		} else {
+			pkg.PackageManager = rootManager
+		}
+	}
+}
+
+// ExpandCommand substitutes the package manager placeholder in command.
+func ExpandCommand(command string, pkg *Package) string {
+	if pkg == nil || !strings.Contains(command, PackageManagerPlaceholder) {
+		return command
+	}
+	manager := pkg.PackageManager
+	if manager == "" {
+		manager = DetectPackageManager(pkg.Path)
+	}
+	return strings.ReplaceAll(command, PackageManagerPlaceholder, manager)
+}
+
+// ReadScripts returns the "scripts" of the package.json at path, or nil if it
+// cannot be read.
+func ReadScripts(path string) map[string]string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var manifest struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil
+	}
+	return manifest.Scripts
+}
@@ -0,0 +1,38 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectPackageManager(t *testing.T) {
+	assert.Equal(t, "npm", DetectPackageManager(t.TempDir()))
+
+	pnpm := t.TempDir()
+	writeWorkspaceFile(t, pnpm, "pnpm-lock.yaml", "lockfileVersion: '9.0'\n")
+	assert.Equal(t, "pnpm", DetectPackageManager(pnpm))
+
+	pinned := t.TempDir()
+	writeWorkspaceFile(t, pinned, "package-lock.json", "{}")
+	writeWorkspaceFile(t, pinned, "package.json", `{"packageManager": "yarn@4.1.0"}`)
+	assert.Equal(t, "yarn", DetectPackageManager(pinned), "packageManager wins over lockfiles")
+}
+
+func TestDiscoverPackagesAssignsPackageManagers(t *testing.T) {
+	root := t.TempDir()
+	writeWorkspaceFile(t, root, "pnpm-lock.yaml", "")
+	writeWorkspaceFile(t, root, "packages/web/package.json", `{"name": "web"}`)
+	writeWorkspaceFile(t, root, "packages/legacy/package.json", `{"name": "legacy"}`)
+	writeWorkspaceFile(t, root, "packages/legacy/yarn.lock", "")
+	t.Chdir(root)
+
+	packages, err := DiscoverPackages([]string{"packages/*"})
+	require.NoError(t, err)
+	assert.Equal(t, "pnpm", packages["web"].PackageManager)
+	assert.Equal(t, "yarn", packages["legacy"].PackageManager)
+
+	assert.Equal(t, "yarn run build && yarn test", ExpandCommand("{pm} run build && {pm} test", packages["legacy"]))
+	assert.Equal(t, "npm run build", ExpandCommand("npm run build", packages["web"]))
+}
@@ -17,14 +17,30 @@ type Package struct {
 	PackageJsonPath  string
 	InternalDepNames []string
 	InternalDeps     []*Package
+	Scripts          map[string]string
+	PackageManager   string
+	Tags             []string
+
+	depNames []string
 }
 
+// DiscoverPackages finds every package.json matched by patterns. Patterns
+// starting with "!" exclude matching package directories, and anything
+// under node_modules is ignored.
 func DiscoverPackages(patterns []string) (map[string]*Package, error) {
 	discovered := make(map[string]*Package)
 
+	var excludes []string
 	for _, pattern := range patterns {
 		trimmed := strings.TrimSpace(pattern)
-		if trimmed == "" {
+		if strings.HasPrefix(trimmed, "!") {
+			excludes = append(excludes, filepath.ToSlash(filepath.Clean(strings.TrimPrefix(trimmed, "!"))))
+		}
+	}
+
+	for _, pattern := range patterns {
+		trimmed := strings.TrimSpace(pattern)
+		if trimmed == "" || strings.HasPrefix(trimmed, "!") {
 			continue
 		}
 
@@ -39,6 +55,9 @@ func DiscoverPackages(patterns []string) (map[string]*Package, error) {
 		}
 
 		for _, pkgJSONPath := range matches {
+			if isExcludedPackage(filepath.Dir(pkgJSONPath), excludes) {
+				continue
+			}
 			pkg, err := readPackageJson(pkgJSONPath)
 			if err != nil {
 				return nil, fmt.Errorf("parse package.json %q: %w", pkgJSONPath, err)
@@ -56,15 +75,54 @@ func DiscoverPackages(patterns []string) (map[string]*Package, error) {
 		}
 	}
 
+	linkWorkspaceDeps(discovered)
+	assignPackageManagers(discovered, ".")
 	return discovered, nil
 }
 
+func isExcludedPackage(dir string, excludes []string) bool {
+	dir = filepath.ToSlash(filepath.Clean(dir))
+	for _, segment := range strings.Split(dir, "/") {
+		if segment == "node_modules" {
+			return true
+		}
+	}
+	for _, exclude := range excludes {
+		if ok, _ := doublestar.Match(exclude, dir); ok {
+			return true
+		}
+		if ok, _ := doublestar.Match(exclude, dir+"/package.json"); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// linkWorkspaceDeps records dependencies on other discovered packages as
+// internal, so npm and yarn workspaces that use plain version ranges
+// instead of the workspace: protocol still get a package graph.
+func linkWorkspaceDeps(packages map[string]*Package) {
+	for _, pkg := range packages {
+		for _, dep := range pkg.depNames {
+			if _, ok := packages[dep]; !ok || dep == pkg.Name || slices.Contains(pkg.InternalDepNames, dep) {
+				continue
+			}
+			pkg.InternalDepNames = append(pkg.InternalDepNames, dep)
+		}
+		slices.Sort(pkg.InternalDepNames)
+	}
+}
+
 type packageJson struct {
 	Name                 string            `json:"name"`
 	Dependencies         map[string]string `json:"dependencies"`
 	DevDependencies      map[string]string `json:"devDependencies"`
 	OptionalDependencies map[string]string `json:"optionalDependencies"`
 	PeerDependencies     map[string]string `json:"peerDependencies"`
+	Scripts              map[string]string `json:"scripts"`
+	Velocity             struct {
+		Tags []string `json:"tags"`
+	} `json:"velocity"`
 }
 
 func readPackageJson(path string) (*Package, error) {
@@ -89,6 +147,9 @@ func readPackageJson(path string) (*Package, error) {
 		Path:             filepath.Dir(path),
 		PackageJsonPath:  filepath.Clean(path),
 		InternalDepNames: deps,
+		Scripts:          parsed.Scripts,
+		Tags:             normalizeTags(parsed.Velocity.Tags),
+		depNames:         dependencyNames(parsed.Dependencies, parsed.DevDependencies, parsed.OptionalDependencies, parsed.PeerDependencies),
 	}
 
 	return pkg, nil
@@ -118,6 +179,17 @@ func collectWorkspaceDeps(depGroups ...map[string]string) []string {
 	return deps
 }
 
+func dependencyNames(depGroups ...map[string]string) []string {
+	var names []string
+	for _, group := range depGroups {
+		for name := range group {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
 func BuildPackageGraph(packages map[string]*Package) error {
 	for _, pkg := range packages {
 		if len(pkg.InternalDepNames) == 0 {
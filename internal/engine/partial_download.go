@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A remote artifact is downloaded into <key>.partial in the local cache
+// directory, next to <key>.partial.validator holding the ETag or
+// modification time of the artifact being fetched. Both outlive a failed
+// download so that the next attempt, in this run or a later one, resumes
+// where it stopped.
+const (
+	cachePartialExt   = ".partial"
+	cacheValidatorExt = ".partial.validator"
+)
+
+var partialLocks sync.Map
+
+// DownloadArtifact fetches the remote artifact for cacheKey from targetURL
+// and copies it to output once it has been verified. Bytes are kept on
+// disk as they arrive, so a download cut short by a dropped connection or
+// an interrupted run continues from where it stopped next time, as long as
+// the server still holds the same artifact. progress, if set, is called
+// with the number of bytes fetched as they arrive.
+func DownloadArtifact(ctx context.Context, cacheKey, targetURL, serverURL, authToken string, output io.Writer, progress func(int)) error {
+	if err := validateCacheKey(cacheKey); err != nil {
+		return err
+	}
+	dir, err := localCacheDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create cache dir %s: %w", dir, err)
+	}
+	path := filepath.Join(dir, cacheKey+cachePartialExt)
+	validatorPath := filepath.Join(dir, cacheKey+cacheValidatorExt)
+
+	lock, _ := partialLocks.LoadOrStore(path, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	part, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("open partial download: %w", err)
+	}
+	defer part.Close()
+
+	validator := ""
+	if data, err := os.ReadFile(validatorPath); err == nil {
+		validator = strings.TrimSpace(string(data))
+	}
+	sum := sha256.New()
+	offset, err := resumePartial(part, validator, sum)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		debugf("transfer GET %s: resuming %s at byte %d", redactURL(targetURL), cacheKey, offset)
+	}
+
+	var dst io.Writer = part
+	if progress != nil {
+		dst = progressFunc{w: part, add: progress}
+	}
+	validator, err = downloadFrom(ctx, targetURL, serverURL, dst, authToken, offset, sum, validator)
+	if errors.Is(err, errStaleRange) {
+		debugf("transfer GET %s: %v; starting over", redactURL(targetURL), err)
+		if err := part.Truncate(0); err != nil {
+			return fmt.Errorf("truncate partial download: %w", err)
+		}
+		if _, err := part.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("seek partial download: %w", err)
+		}
+		sum.Reset()
+		validator, err = downloadFrom(ctx, targetURL, serverURL, dst, authToken, 0, sum, "")
+	}
+	if err != nil {
+		if errors.Is(err, ErrChecksumMismatch) || validator == "" {
+			removePartial(path, validatorPath)
+		} else if writeErr := os.WriteFile(validatorPath, []byte(validator+"\n"), 0o644); writeErr != nil {
+			debugf("save partial download validator: %v", writeErr)
+		}
+		return err
+	}
+
+	if _, err := part.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek partial download: %w", err)
+	}
+	if _, err := io.Copy(output, part); err != nil {
+		return fmt.Errorf("copy download: %w", err)
+	}
+	removePartial(path, validatorPath)
+	return nil
+}
+
+// resumePartial hashes what an earlier download left in part and leaves
+// part positioned after it, returning its size. Bytes whose artifact is not
+// known cannot be resumed and are dropped.
+func resumePartial(part *os.File, validator string, sum io.Writer) (int64, error) {
+	if validator == "" {
+		if err := part.Truncate(0); err != nil {
+			return 0, fmt.Errorf("truncate partial download: %w", err)
+		}
+		return 0, nil
+	}
+	n, err := io.Copy(sum, part)
+	if err != nil {
+		return 0, fmt.Errorf("read partial download: %w", err)
+	}
+	return n, nil
+}
+
+func removePartial(path, validatorPath string) {
+	for _, name := range []string{path, validatorPath} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			debugf("remove partial download: %v", err)
+		}
+	}
+}
+
+type progressFunc struct {
+	w   io.Writer
+	add func(int)
+}
+
+func (p progressFunc) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.add(n)
+	return n, err
+}
+
+// prunePartials removes partial downloads last written before cutoff.
+func prunePartials(cutoff time.Time) error {
+	dir, err := localCacheDir()
+	if err != nil {
+		return err
+	}
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read cache dir %s: %w", dir, err)
+	}
+	for _, dirEntry := range dirEntries {
+		key, ok := strings.CutSuffix(dirEntry.Name(), cachePartialExt)
+		if !ok || dirEntry.IsDir() {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		removePartial(filepath.Join(dir, dirEntry.Name()), filepath.Join(dir, key+cacheValidatorExt))
+	}
+	return nil
+}
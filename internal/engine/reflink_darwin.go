@@ -0,0 +1,15 @@
+//go:build darwin
+
+package engine
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflinkFile creates dst as a copy-on-write clone of src, which APFS
+// supports.
+func reflinkFile(src, dst string, mode os.FileMode) error {
+	return unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW)
+}
@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Artifact exchange flows a server may offer. FlowNegotiate hands out
+// storage URLs that clients use directly; FlowProxy means those URLs point
+// back at the server, which streams the artifacts itself.
+const (
+	FlowNegotiate = "negotiate"
+	FlowProxy     = "proxy"
+)
+
+// Capabilities describes what the remote server supports.
+type Capabilities struct {
+	Version   string   `json:"version"`
+	Flows     []string `json:"flows"`
+	Multipart bool     `json:"multipart"`
+	Checksums bool     `json:"checksums"`
+	Prune     bool     `json:"prune"`
+}
+
+// legacyCapabilities is assumed of servers predating /v1/capabilities:
+// everything is tried, and requests they reject are fallen back from.
+var legacyCapabilities = Capabilities{Flows: []string{FlowNegotiate}, Multipart: true, Checksums: true, Prune: true}
+
+// Capabilities asks the server what it supports, once per client. Servers
+// predating the capabilities endpoint are reported as offering the
+// negotiate flow with every optional feature.
+func (c *RemoteClient) Capabilities(ctx context.Context) (Capabilities, error) {
+	c.capsMu.Lock()
+	defer c.capsMu.Unlock()
+	if c.caps != nil {
+		return *c.caps, nil
+	}
+
+	if c.store != nil {
+		caps := c.storeCapabilities()
+		c.caps = &caps
+		return caps, nil
+	}
+
+	var caps Capabilities
+	err := c.getJSON(ctx, "/v1/capabilities", &caps)
+	switch {
+	case errors.Is(err, errNotImplemented):
+		caps = legacyCapabilities
+	case err != nil:
+		return Capabilities{}, err
+	case !slices.Contains(caps.Flows, FlowNegotiate):
+		return Capabilities{}, fmt.Errorf("remote server offers no supported flow (it offers %v)", caps.Flows)
+	}
+	debugf("remote capabilities: %+v", caps)
+	c.caps = &caps
+	return caps, nil
+}
+
+// capabilities is Capabilities for deciding which requests to make. When
+// the server cannot be asked, everything is tried as with older servers.
+func (c *RemoteClient) capabilities(ctx context.Context) Capabilities {
+	caps, err := c.Capabilities(ctx)
+	if err != nil {
+		debugf("remote capabilities: %v", err)
+		return legacyCapabilities
+	}
+	return caps
+}
@@ -4,45 +4,118 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bit2swaz/velocity-cache/internal/version"
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
 )
 
+// ErrUnauthorized is returned when the remote server rejects the token.
+var ErrUnauthorized = errors.New("remote server rejected the auth token")
+
 type RemoteClient struct {
 	baseURL    string
 	token      string
 	httpClient *http.Client
+	// store, when set, holds the artifacts and is used directly instead of
+	// a server; see NewStoreClient.
+	store storage.Driver
+
+	// encryptionKey, when set, encrypts artifacts before upload and
+	// decrypts them after download.
+	encryptionKey []byte
+	// signatureKey, when set, signs uploaded artifacts and verifies
+	// downloaded ones.
+	signatureKey     []byte
+	requireSignature bool
+
+	// misses remembers keys the remote reported missing for downloads.
+	misses missCache
+
+	capsMu sync.Mutex
+	caps   *Capabilities
 }
 
 type NegotiateResponse struct {
 	Status string `json:"status"`
 	URL    string `json:"url,omitempty"`
+	// Headers must be sent with the upload, e.g. the checksum a presigned
+	// URL was signed for.
+	Headers map[string]string `json:"headers,omitempty"`
 }
 
 type negotiateRequest struct {
-	Hash   string `json:"hash"`
-	Action string `json:"action"`
+	Hash     string `json:"hash"`
+	Action   string `json:"action"`
+	Checksum string `json:"checksum,omitempty"`
 }
 
 func NewRemoteClient(baseURL, token string) *RemoteClient {
 	return &RemoteClient{
 		baseURL:    baseURL,
 		token:      token,
-		httpClient: &http.Client{},
+		httpClient: &http.Client{Transport: configuredTransport{}},
 	}
 }
 
+// SetEncryptionKey enables AES-256-GCM encryption of artifacts with key,
+// which must be 32 bytes. A nil key disables it.
+func (c *RemoteClient) SetEncryptionKey(key []byte) {
+	c.encryptionKey = key
+}
+
+// Negotiate asks the remote for a URL to perform action on hash. Download
+// lookups of keys the remote recently reported missing are answered
+// without asking again.
 func (c *RemoteClient) Negotiate(ctx context.Context, hash, action string) (*NegotiateResponse, error) {
-	reqBody := negotiateRequest{
-		Hash:   hash,
-		Action: action,
+	if action == "download" && c.misses.has(hash) {
+		debugf("negotiate download %.12s: remembered as missing", hash)
+		return &NegotiateResponse{Status: "missing"}, nil
 	}
+	resp, err := c.negotiate(ctx, negotiateRequest{Hash: hash, Action: action})
+	if err == nil && action == "download" && resp.Status == "missing" {
+		c.misses.add(hash)
+	}
+	return resp, err
+}
 
+// NegotiateUpload asks for an upload URL for an artifact with the given hex
+// SHA-256, which the server records so downloads can be verified.
+func (c *RemoteClient) NegotiateUpload(ctx context.Context, hash, checksum string) (*NegotiateResponse, error) {
+	resp, err := c.negotiate(ctx, negotiateRequest{Hash: hash, Action: "upload", Checksum: checksum})
+	if err == nil && resp.Status == "skipped" {
+		c.misses.forget(hash)
+	}
+	return resp, err
+}
+
+func (c *RemoteClient) negotiate(ctx context.Context, reqBody negotiateRequest) (*NegotiateResponse, error) {
+	if c.store != nil {
+		return c.negotiateStore(ctx, reqBody)
+	}
 	bodyBytes, err := json.Marshal(reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("marshal request: %w", err)
 	}
 
+	var negResp *NegotiateResponse
+	err = withRetries(ctx, fmt.Sprintf("negotiate %s %.12s", reqBody.Action, reqBody.Hash), func() error {
+		var err error
+		negResp, err = c.negotiateOnce(ctx, reqBody, bodyBytes)
+		return err
+	})
+	return negResp, err
+}
+
+func (c *RemoteClient) negotiateOnce(ctx context.Context, reqBody negotiateRequest, bodyBytes []byte) (*NegotiateResponse, error) {
+	hash, action := reqBody.Hash, reqBody.Action
+
 	url := fmt.Sprintf("%s/v1/negotiate", c.baseURL)
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(bodyBytes))
 	if err != nil {
@@ -50,24 +123,318 @@ func (c *RemoteClient) Negotiate(ctx context.Context, hash, action string) (*Neg
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", version.UserAgent())
 	if c.token != "" {
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
 	}
 
+	start := time.Now()
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("do request: %w", err)
+		debugf("negotiate %s %.12s: %v", action, hash, err)
+		return nil, requestError(err)
 	}
 	defer resp.Body.Close()
+	debugf("negotiate %s %.12s: HTTP %d in %s", action, hash, resp.StatusCode, time.Since(start).Round(time.Millisecond))
+
+	if resp.StatusCode == http.StatusNotFound {
+		return &NegotiateResponse{Status: "missing"}, nil
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, fmt.Errorf("remote server returned status %d: %w", resp.StatusCode, ErrUnauthorized)
+	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("remote server returned status %d", resp.StatusCode)
+		return nil, transientStatus(resp, fmt.Errorf("remote server returned status %d", resp.StatusCode))
 	}
 
 	var negResp NegotiateResponse
 	if err := json.NewDecoder(resp.Body).Decode(&negResp); err != nil {
 		return nil, fmt.Errorf("decode response: %w", err)
 	}
+	debugf("negotiate %s %.12s: status %q", action, hash, negResp.Status)
 
 	return &negResp, nil
 }
+
+// Artifacts of at least multipartThreshold bytes are uploaded in parts of
+// uploadPartSize, grown as needed to stay within maxUploadParts.
+var (
+	multipartThreshold int64 = 512 << 20
+	uploadPartSize     int64 = 128 << 20
+)
+
+const maxUploadParts = 10000
+
+// errNotImplemented is returned for endpoints an older server or a storage
+// driver does not provide.
+var errNotImplemented = errors.New("not supported by the remote server")
+
+type multipartStartRequest struct {
+	Hash     string `json:"hash"`
+	Size     int64  `json:"size"`
+	PartSize int64  `json:"part_size"`
+	Checksum string `json:"checksum,omitempty"`
+}
+
+type multipartStartResponse struct {
+	UploadID string   `json:"upload_id"`
+	URLs     []string `json:"urls"`
+}
+
+type multipartCompleteRequest struct {
+	Hash     string   `json:"hash"`
+	UploadID string   `json:"upload_id"`
+	ETags    []string `json:"etags"`
+}
+
+// Upload sends the artifact at path for hash, as negotiated by resp. Large
+// artifacts go up in parts when the server supports it; everything else is
+// PUT to the negotiated URL. checksum is the artifact's hex SHA-256. wrap,
+// if set, wraps each request body, e.g. to report progress.
+func (c *RemoteClient) Upload(ctx context.Context, hash string, resp *NegotiateResponse, path, checksum string, wrap func(io.Reader) io.Reader) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open artifact: %w", err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat artifact: %w", err)
+	}
+
+	if stat.Size() >= multipartThreshold && c.capabilities(ctx).Multipart {
+		err := c.uploadParts(ctx, hash, f, stat.Size(), checksum, wrap)
+		if err == nil {
+			c.misses.forget(hash)
+		}
+		if !errors.Is(err, errNotImplemented) {
+			return err
+		}
+		debugf("multipart upload %.12s: %v; uploading in one request", hash, err)
+	}
+
+	if c.store != nil {
+		err = c.putStore(ctx, hash, f, stat.Size(), checksum, wrap)
+		if err == nil {
+			c.misses.forget(hash)
+		}
+		return err
+	}
+	err = withRetries(ctx, fmt.Sprintf("upload %.12s", hash), func() error {
+		_, err := send(ctx, http.MethodPut, resp.URL, c.baseURL, sectionOpener(f, 0, stat.Size(), wrap), nil, stat.Size(), c.token, resp.Headers)
+		return err
+	})
+	if err == nil {
+		c.misses.forget(hash)
+	}
+	return err
+}
+
+func (c *RemoteClient) uploadParts(ctx context.Context, hash string, f *os.File, size int64, checksum string, wrap func(io.Reader) io.Reader) error {
+	partSize := max(uploadPartSize, (size+maxUploadParts-1)/maxUploadParts)
+	upload, err := c.startMultipart(ctx, multipartStartRequest{Hash: hash, Size: size, PartSize: partSize, Checksum: checksum})
+	if err != nil {
+		return fmt.Errorf("start multipart upload: %w", err)
+	}
+	if want := (size + partSize - 1) / partSize; int64(len(upload.URLs)) != want {
+		return fmt.Errorf("start multipart upload: server returned %d part URLs, expected %d", len(upload.URLs), want)
+	}
+
+	etags := make([]string, len(upload.URLs))
+	for i, partURL := range upload.URLs {
+		offset := int64(i) * partSize
+		length := min(partSize, size-offset)
+		err := withRetries(ctx, fmt.Sprintf("multipart upload %.12s part %d", hash, i+1), func() error {
+			header, err := send(ctx, http.MethodPut, partURL, c.baseURL, sectionOpener(f, offset, length, wrap), nil, length, c.token, nil)
+			if err == nil {
+				etags[i] = header.Get("ETag")
+			}
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("upload part %d of %d: %w", i+1, len(upload.URLs), err)
+		}
+		if etags[i] == "" {
+			return fmt.Errorf("upload part %d of %d: no ETag in response", i+1, len(upload.URLs))
+		}
+	}
+
+	if err := c.completeMultipart(ctx, multipartCompleteRequest{Hash: hash, UploadID: upload.UploadID, ETags: etags}); err != nil {
+		return fmt.Errorf("complete multipart upload: %w", err)
+	}
+	debugf("multipart upload %.12s: %d bytes in %d parts", hash, size, len(etags))
+	return nil
+}
+
+func (c *RemoteClient) startMultipart(ctx context.Context, req multipartStartRequest) (multipartStartResponse, error) {
+	if c.store != nil {
+		return c.startStoreMultipart(ctx, req)
+	}
+	var upload multipartStartResponse
+	err := c.postJSON(ctx, "/v1/multipart/start", req, &upload)
+	return upload, err
+}
+
+func (c *RemoteClient) completeMultipart(ctx context.Context, req multipartCompleteRequest) error {
+	if c.store != nil {
+		return c.completeStoreMultipart(ctx, req)
+	}
+	return c.postJSON(ctx, "/v1/multipart/complete", req, nil)
+}
+
+// Download fetches the artifact for hash, as negotiated by resp, into
+// output, resuming an earlier partial download of it. progress is as for
+// DownloadArtifact.
+func (c *RemoteClient) Download(ctx context.Context, hash string, resp *NegotiateResponse, output io.Writer, progress func(int)) error {
+	if c.store != nil {
+		return c.getStore(ctx, hash, output, progress)
+	}
+	return DownloadArtifact(ctx, hash, resp.URL, c.baseURL, c.token, output, progress)
+}
+
+// sectionOpener opens length bytes of f from offset as a request body,
+// wrapped by wrap if set.
+func sectionOpener(f *os.File, offset, length int64, wrap func(io.Reader) io.Reader) func() (io.Reader, error) {
+	return func() (io.Reader, error) {
+		var body io.Reader = io.NewSectionReader(f, offset, length)
+		if wrap != nil {
+			body = wrap(body)
+		}
+		return body, nil
+	}
+}
+
+// postJSON posts in to the server and decodes the response into out, if
+// set.
+func (c *RemoteClient) postJSON(ctx context.Context, path string, in, out any) error {
+	bodyBytes, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("marshal request: %w", err)
+	}
+	return withRetries(ctx, "POST "+path, func() error {
+		return c.doJSON(ctx, http.MethodPost, path, bodyBytes, out)
+	})
+}
+
+// getJSON fetches path from the server and decodes the response into out.
+func (c *RemoteClient) getJSON(ctx context.Context, path string, out any) error {
+	return withRetries(ctx, "GET "+path, func() error {
+		return c.doJSON(ctx, http.MethodGet, path, nil, out)
+	})
+}
+
+// doJSON makes one request to the server, sending bodyBytes as JSON if set.
+func (c *RemoteClient) doJSON(ctx context.Context, method, path string, bodyBytes []byte, out any) error {
+	var body io.Reader
+	if bodyBytes != nil {
+		body = bytes.NewReader(bodyBytes)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("User-Agent", version.UserAgent())
+	if c.token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return requestError(err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("remote server returned status %d: %w", resp.StatusCode, ErrUnauthorized)
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return fmt.Errorf("remote server returned status %d: %w", resp.StatusCode, errNotImplemented)
+	default:
+		return transientStatus(resp, fmt.Errorf("remote server returned status %d", resp.StatusCode))
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// Health checks the server's unauthenticated /health endpoint.
+func (c *RemoteClient) Health(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/health", nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("User-Agent", version.UserAgent())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("remote server returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type PruneResponse struct {
+	Removed int   `json:"removed"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// Prune asks the server to delete artifacts not used within olderThan.
+func (c *RemoteClient) Prune(ctx context.Context, olderThan time.Duration) (*PruneResponse, error) {
+	if !c.capabilities(ctx).Prune {
+		return nil, fmt.Errorf("remote storage driver does not support pruning")
+	}
+	if c.store != nil {
+		return c.pruneStore(ctx, olderThan)
+	}
+	bodyBytes, err := json.Marshal(map[string]int64{"older_than_seconds": int64(olderThan / time.Second)})
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/v1/prune", bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", version.UserAgent())
+	if c.token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, fmt.Errorf("remote server returned status %d: %w", resp.StatusCode, ErrUnauthorized)
+	case http.StatusNotImplemented:
+		return nil, fmt.Errorf("remote storage driver does not support pruning")
+	default:
+		return nil, fmt.Errorf("remote server returned status %d", resp.StatusCode)
+	}
+
+	var pruneResp PruneResponse
+	if err := json.NewDecoder(resp.Body).Decode(&pruneResp); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &pruneResp, nil
+}
@@ -0,0 +1,411 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/internal/version"
+)
+
+func TestRemoteRequestsSendUserAgent(t *testing.T) {
+	var agents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents = append(agents, r.Header.Get("User-Agent"))
+		if r.URL.Path == "/v1/negotiate" {
+			json.NewEncoder(w).Encode(NegotiateResponse{Status: "found"})
+		}
+	}))
+	defer server.Close()
+
+	client := NewRemoteClient(server.URL, "")
+	_, err := client.Negotiate(context.Background(), "abc", "download")
+	require.NoError(t, err)
+	require.NoError(t, client.Health(context.Background()))
+	require.NoError(t, Transfer(context.Background(), http.MethodGet, server.URL+"/blob", server.URL, nil, &bytes.Buffer{}, 0, ""))
+
+	want := "velocity-cache/" + version.Get().Version
+	assert.Equal(t, []string{want, want, want}, agents)
+}
+
+func TestDownloadResumesInChunks(t *testing.T) {
+	defer func(size int64) { downloadChunkSize = size }(downloadChunkSize)
+	downloadChunkSize = 100
+
+	data := bytes.Repeat([]byte("0123456789"), 45)
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if len(ranges) == 3 {
+			// Drop the connection halfway through the third chunk.
+			w.Header().Set("Content-Range", "bytes 200-299/450")
+			w.Header().Set("Content-Length", "100")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[200:250])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	require.NoError(t, Transfer(context.Background(), http.MethodGet, server.URL, server.URL, nil, &out, 0, ""))
+	assert.Equal(t, data, out.Bytes())
+	assert.Equal(t, []string{"bytes=0-99", "bytes=100-199", "bytes=200-299", "bytes=250-349", "bytes=350-449"}, ranges)
+}
+
+func TestDownloadResumesFromServerIgnoringRange(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 45)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+		if requests == 1 {
+			// Drop the connection after 200 of the 450 bytes.
+			w.Write(data[:200])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	require.NoError(t, Transfer(context.Background(), http.MethodGet, server.URL, server.URL, nil, &out, 0, ""))
+	assert.Equal(t, data, out.Bytes())
+	assert.Equal(t, 2, requests)
+}
+
+func TestUploadSendsLargeArtifactsInParts(t *testing.T) {
+	defer func(threshold, size int64) { multipartThreshold, uploadPartSize = threshold, size }(multipartThreshold, uploadPartSize)
+	multipartThreshold, uploadPartSize = 10, 4
+
+	data := []byte("velocity-cache!")
+	parts := make(map[string][]byte)
+	var complete multipartCompleteRequest
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/capabilities":
+			json.NewEncoder(w).Encode(Capabilities{Flows: []string{FlowNegotiate}, Multipart: true})
+		case r.URL.Path == "/v1/multipart/start":
+			var req multipartStartRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.Equal(t, multipartStartRequest{Hash: "abc", Size: 15, PartSize: 4, Checksum: "c1"}, req)
+			json.NewEncoder(w).Encode(multipartStartResponse{UploadID: "u1", URLs: []string{
+				server.URL + "/part/1", server.URL + "/part/2", server.URL + "/part/3", server.URL + "/part/4",
+			}})
+		case strings.HasPrefix(r.URL.Path, "/part/"):
+			body, _ := io.ReadAll(r.Body)
+			parts[r.URL.Path] = body
+			w.Header().Set("ETag", `"`+strings.TrimPrefix(r.URL.Path, "/part/")+`"`)
+		case r.URL.Path == "/v1/multipart/complete":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&complete))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "artifact")
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	client := NewRemoteClient(server.URL, "")
+	require.NoError(t, client.Upload(context.Background(), "abc", &NegotiateResponse{URL: server.URL + "/blob"}, path, "c1", nil))
+
+	assembled := append(append(append(parts["/part/1"], parts["/part/2"]...), parts["/part/3"]...), parts["/part/4"]...)
+	assert.Equal(t, data, assembled)
+	assert.Equal(t, multipartCompleteRequest{Hash: "abc", UploadID: "u1", ETags: []string{`"1"`, `"2"`, `"3"`, `"4"`}}, complete)
+}
+
+func TestUploadFallsBackWithoutMultipartSupport(t *testing.T) {
+	defer func(threshold int64) { multipartThreshold = threshold }(multipartThreshold)
+	multipartThreshold = 1
+
+	var uploaded []byte
+	var checksum string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/blob" {
+			http.NotFound(w, r)
+			return
+		}
+		uploaded, _ = io.ReadAll(r.Body)
+		checksum = r.Header.Get(checksumHeader)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "artifact")
+	require.NoError(t, os.WriteFile(path, []byte("artifact"), 0o644))
+
+	client := NewRemoteClient(server.URL, "")
+	resp := &NegotiateResponse{URL: server.URL + "/blob", Headers: map[string]string{checksumHeader: "c1"}}
+	require.NoError(t, client.Upload(context.Background(), "abc", resp, path, "c1", nil))
+	assert.Equal(t, "artifact", string(uploaded))
+	assert.Equal(t, "c1", checksum)
+}
+
+func TestDownloadVerifiesChecksum(t *testing.T) {
+	data := []byte("artifact")
+	sum := sha256.Sum256(data)
+	advertised := hex.EncodeToString(sum[:])
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(checksumHeader, advertised)
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	require.NoError(t, Transfer(context.Background(), http.MethodGet, server.URL, server.URL, nil, &out, 0, ""))
+	assert.Equal(t, data, out.Bytes())
+
+	advertised = strings.Repeat("0", 64)
+	err := Transfer(context.Background(), http.MethodGet, server.URL, server.URL, nil, &bytes.Buffer{}, 0, "")
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+}
+
+func TestNegotiateRemembersMisses(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		lookups := map[string]int{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req negotiateRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			lookups[req.Action+" "+req.Hash]++
+			switch req.Action {
+			case "download":
+				http.Error(w, "Not found", http.StatusNotFound)
+			case "upload":
+				json.NewEncoder(w).Encode(NegotiateResponse{Status: "skipped"})
+			}
+		}))
+		defer server.Close()
+
+		client := NewRemoteClient(server.URL, "")
+		client.SetMissTTL(time.Minute)
+		for range 3 {
+			resp, err := client.Negotiate(context.Background(), "abc", "download")
+			require.NoError(t, err)
+			assert.Equal(t, "missing", resp.Status)
+		}
+		assert.Equal(t, 1, lookups["download abc"])
+		require.NoError(t, client.SaveMisses())
+
+		next := NewRemoteClient(server.URL, "")
+		next.SetMissTTL(time.Minute)
+		_, err := next.Negotiate(context.Background(), "abc", "download")
+		require.NoError(t, err)
+		assert.Equal(t, 1, lookups["download abc"], "misses are reused across runs within the TTL")
+
+		_, err = next.NegotiateUpload(context.Background(), "abc", "")
+		require.NoError(t, err)
+		_, err = next.Negotiate(context.Background(), "abc", "download")
+		require.NoError(t, err)
+		assert.Equal(t, 2, lookups["download abc"], "a key the remote has is looked up again")
+
+		other := NewRemoteClient(server.URL+"/other", "")
+		other.SetMissTTL(time.Minute)
+		_, err = other.Negotiate(context.Background(), "abc", "download")
+		require.NoError(t, err)
+		assert.Equal(t, 3, lookups["download abc"], "misses are scoped to their remote")
+	})
+}
+
+func TestNegotiateRetriesTransientFailures(t *testing.T) {
+	defer SetRetryPolicy(DefaultRetryPolicy)
+	SetRetryPolicy(RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Path {
+		case "/v1/negotiate":
+			if calls < 3 {
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+			json.NewEncoder(w).Encode(NegotiateResponse{Status: "found"})
+		default:
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	client := NewRemoteClient(server.URL, "")
+	resp, err := client.Negotiate(context.Background(), "abc", "download")
+	require.NoError(t, err)
+	assert.Equal(t, "found", resp.Status)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	err = Transfer(context.Background(), http.MethodPut, server.URL+"/blob", server.URL, strings.NewReader("data"), nil, 4, "")
+	require.Error(t, err)
+	assert.Equal(t, 1, calls, "client errors are not retried")
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.Equal(t, 7*time.Second, parseRetryAfter("7", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now))
+	assert.Zero(t, parseRetryAfter("soon", now))
+
+	policy := RetryPolicy{Attempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Second}
+	delay, ok := policy.nextRetry(1, &transientError{err: io.ErrUnexpectedEOF, retryAfter: 2 * time.Second})
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Second, delay)
+
+	_, ok = policy.nextRetry(1, &transientError{err: io.ErrUnexpectedEOF, retryAfter: time.Minute})
+	assert.False(t, ok, "waits longer than MaxDelay are not retried")
+	_, ok = policy.nextRetry(3, transient(io.ErrUnexpectedEOF))
+	assert.False(t, ok, "attempts are bounded")
+	_, ok = policy.nextRetry(1, io.ErrUnexpectedEOF)
+	assert.False(t, ok, "only transient errors are retried")
+}
+
+func TestTransferFollowsRedirectsWithoutLeakingToken(t *testing.T) {
+	var storedAuth, stored string
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		storedAuth = r.Header.Get("Authorization")
+		data, _ := io.ReadAll(r.Body)
+		stored = string(data)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer storage.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		http.Redirect(w, r, storage.URL+"/blob", http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	require.NoError(t, Transfer(context.Background(), http.MethodPut, server.URL+"/blob", server.URL, strings.NewReader("artifact"), nil, 8, "secret"))
+	assert.Equal(t, "artifact", stored)
+	assert.Empty(t, storedAuth, "the token is not sent to another host")
+}
+
+func TestTransferReportsResponseBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	err := Transfer(context.Background(), http.MethodPut, server.URL, server.URL, strings.NewReader("x"), nil, 1, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "status 403: <Error><Code>AccessDenied</Code></Error>")
+}
+
+func TestDownloadArtifactResumesAcrossAttempts(t *testing.T) {
+	withTempWorkdir(t, func(root string) {
+		defer SetRetryPolicy(DefaultRetryPolicy)
+		SetRetryPolicy(RetryPolicy{Attempts: 1})
+
+		data := bytes.Repeat([]byte("0123456789"), 30)
+		etag := `"v1"`
+		var ranges, ifRanges []string
+		drop := true
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ranges = append(ranges, r.Header.Get("Range"))
+			ifRanges = append(ifRanges, r.Header.Get("If-Range"))
+			w.Header().Set("ETag", etag)
+			if drop {
+				drop = false
+				w.Header().Set("Content-Length", "300")
+				w.WriteHeader(http.StatusOK)
+				w.Write(data[:120])
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			}
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		}))
+		defer server.Close()
+
+		var out bytes.Buffer
+		require.Error(t, DownloadArtifact(context.Background(), "key", server.URL, server.URL, "", &out, nil))
+		assert.Empty(t, out.Bytes(), "nothing is written until the download completes")
+
+		require.NoError(t, DownloadArtifact(context.Background(), "key", server.URL, server.URL, "", &out, nil))
+		assert.Equal(t, data, out.Bytes())
+		assert.Equal(t, "bytes=120-", strings.TrimSuffix(ranges[1], strconv.FormatInt(120+downloadChunkSize-1, 10)))
+		assert.Equal(t, etag, ifRanges[1])
+		_, err := os.Stat(filepath.Join(root, ".velocity", "cache", "key"+cachePartialExt))
+		assert.True(t, os.IsNotExist(err), "the partial download is removed once complete")
+
+		// An artifact that changed since is downloaded from the start.
+		drop, etag = true, `"v2"`
+		out.Reset()
+		require.Error(t, DownloadArtifact(context.Background(), "key", server.URL, server.URL, "", &out, nil))
+		etag = `"v3"`
+		require.NoError(t, DownloadArtifact(context.Background(), "key", server.URL, server.URL, "", &out, nil))
+		assert.Equal(t, data, out.Bytes())
+	})
+}
+
+func TestRemoteClientTrustsConfiguredCA(t *testing.T) {
+	defer SetTLSOptions(TLSOptions{})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(NegotiateResponse{Status: "found"})
+	}))
+	defer server.Close()
+	client := NewRemoteClient(server.URL, "")
+
+	require.NoError(t, SetTLSOptions(TLSOptions{}))
+	_, err := client.Negotiate(context.Background(), "abc", "download")
+	require.Error(t, err, "the test server's certificate is not trusted by default")
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o644))
+	require.NoError(t, SetTLSOptions(TLSOptions{CAFile: caFile}))
+	resp, err := client.Negotiate(context.Background(), "abc", "download")
+	require.NoError(t, err)
+	assert.Equal(t, "found", resp.Status)
+
+	require.Error(t, SetTLSOptions(TLSOptions{CAFile: filepath.Join(t.TempDir(), "missing.pem")}))
+}
+
+func TestCapabilitiesDecideRequests(t *testing.T) {
+	defer func(threshold int64) { multipartThreshold = threshold }(multipartThreshold)
+	multipartThreshold = 4
+
+	var paths []string
+	caps := Capabilities{Version: "v9", Flows: []string{FlowNegotiate, FlowProxy}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		if r.URL.Path == "/v1/capabilities" {
+			json.NewEncoder(w).Encode(caps)
+		}
+	}))
+	defer server.Close()
+
+	artifact := filepath.Join(t.TempDir(), "artifact.zip")
+	require.NoError(t, os.WriteFile(artifact, []byte("0123456789"), 0o644))
+
+	client := NewRemoteClient(server.URL, "")
+	require.NoError(t, client.Upload(context.Background(), "abc", &NegotiateResponse{URL: server.URL + "/blob"}, artifact, "", nil))
+	_, err := client.Prune(context.Background(), time.Hour)
+	require.ErrorContains(t, err, "does not support pruning")
+	got, err := client.Capabilities(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, caps, got)
+	assert.Equal(t, []string{"GET /v1/capabilities", "PUT /blob"}, paths, "capabilities are asked once and unsupported requests skipped")
+
+	legacy := httptest.NewServer(http.NotFoundHandler())
+	defer legacy.Close()
+	got, err = NewRemoteClient(legacy.URL, "").Capabilities(context.Background())
+	require.NoError(t, err)
+	assert.True(t, got.Multipart, "older servers are assumed to support everything")
+}
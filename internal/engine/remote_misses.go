@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const remoteMissesFileName = "remote-misses.json"
+
+// missCache remembers keys the remote reported missing, so tasks sharing a
+// dependency do not negotiate the same missing key repeatedly. Entries last
+// for the client's lifetime, which is one run; with a TTL they are also
+// saved to .velocity/remote-misses.json for later runs to reuse.
+type missCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	dirty   bool
+	entries map[string]time.Time
+}
+
+type remoteMissesFile struct {
+	URL    string               `json:"url"`
+	Misses map[string]time.Time `json:"misses"`
+}
+
+func remoteMissesPath() string {
+	return filepath.Join(velocityDirName, remoteMissesFileName)
+}
+
+// has reports whether hash was reported missing during this run or, within
+// the TTL, before it.
+func (m *missCache) has(hash string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.entries[hash]
+	return ok
+}
+
+func (m *missCache) add(hash string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entries == nil {
+		m.entries = make(map[string]time.Time)
+	}
+	m.entries[hash] = time.Now()
+	m.dirty = m.dirty || m.ttl > 0
+}
+
+func (m *missCache) forget(hash string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[hash]; ok {
+		delete(m.entries, hash)
+		m.dirty = m.dirty || m.ttl > 0
+	}
+}
+
+// SetMissTTL keeps remote "not found" answers for ttl across runs, loading
+// the ones saved by earlier runs against the same remote. Zero keeps them
+// for this client only.
+func (c *RemoteClient) SetMissTTL(ttl time.Duration) {
+	c.misses.mu.Lock()
+	defer c.misses.mu.Unlock()
+	c.misses.ttl = ttl
+	if ttl <= 0 {
+		return
+	}
+
+	data, err := os.ReadFile(remoteMissesPath())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			debugf("read remote misses: %v", err)
+		}
+		return
+	}
+	var saved remoteMissesFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		debugf("ignoring corrupt remote misses: %v", err)
+		return
+	}
+	if saved.URL != c.baseURL {
+		return
+	}
+	if c.misses.entries == nil {
+		c.misses.entries = make(map[string]time.Time)
+	}
+	for hash, seen := range saved.Misses {
+		if _, ok := c.misses.entries[hash]; !ok && time.Since(seen) < ttl {
+			c.misses.entries[hash] = seen
+		}
+	}
+}
+
+// SaveMisses writes the unexpired remote misses to
+// .velocity/remote-misses.json when a miss TTL is set and they changed.
+func (c *RemoteClient) SaveMisses() error {
+	c.misses.mu.Lock()
+	defer c.misses.mu.Unlock()
+	if c.misses.ttl <= 0 || !c.misses.dirty {
+		return nil
+	}
+
+	saved := remoteMissesFile{URL: c.baseURL, Misses: make(map[string]time.Time, len(c.misses.entries))}
+	for hash, seen := range c.misses.entries {
+		if time.Since(seen) < c.misses.ttl {
+			saved.Misses[hash] = seen
+		}
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return fmt.Errorf("encode remote misses: %w", err)
+	}
+	if err := os.MkdirAll(velocityDirName, 0o755); err != nil {
+		return fmt.Errorf("create %s: %w", velocityDirName, err)
+	}
+	if err := writeAtomically(remoteMissesPath(), bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("save remote misses: %w", err)
+	}
+	c.misses.dirty = false
+	return nil
+}
@@ -0,0 +1,125 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
+)
+
+// NewStoreClient returns a client that keeps artifacts in store directly,
+// e.g. a bucket the machine has credentials for, so that no server needs to
+// run. baseURL identifies the store, such as in the misses remembered
+// across runs, and is not dialed.
+func NewStoreClient(baseURL string, store storage.Driver) *RemoteClient {
+	client := NewRemoteClient(strings.TrimRight(baseURL, "/"), "")
+	client.store = store
+	return client
+}
+
+// storeCapabilities reports what the client's store supports.
+func (c *RemoteClient) storeCapabilities() Capabilities {
+	_, multipart := c.store.(storage.MultipartUploader)
+	_, prune := c.store.(storage.Pruner)
+	return Capabilities{Flows: []string{FlowNegotiate}, Multipart: multipart, Checksums: true, Prune: prune}
+}
+
+// negotiateStore answers a negotiate request from whether the store holds
+// the artifact. No URL is handed out: uploads and downloads go through the
+// store.
+func (c *RemoteClient) negotiateStore(ctx context.Context, req negotiateRequest) (*NegotiateResponse, error) {
+	exists, err := c.store.Exists(ctx, req.Hash)
+	if err != nil {
+		debugf("negotiate %s %.12s: %v", req.Action, req.Hash, err)
+		return nil, err
+	}
+	status := "missing"
+	switch {
+	case req.Action == "upload" && exists:
+		status = "skipped"
+	case req.Action == "upload":
+		status = "upload_needed"
+	case exists:
+		status = "found"
+	}
+	debugf("negotiate %s %.12s: status %q", req.Action, req.Hash, status)
+	return &NegotiateResponse{Status: status}, nil
+}
+
+// putStore stores the size bytes of f for hash in one request.
+func (c *RemoteClient) putStore(ctx context.Context, hash string, f *os.File, size int64, checksum string, wrap func(io.Reader) io.Reader) error {
+	body, _ := sectionOpener(f, 0, size, wrap)()
+	start := time.Now()
+	if err := c.store.Put(ctx, hash, body, size, checksum); err != nil {
+		return fmt.Errorf("store artifact: %w", err)
+	}
+	debugf("store put %.12s: %d bytes in %s", hash, size, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// getStore copies the artifact for hash from the store to output, verifying
+// it against the checksum the store recorded for it, if any. progress is as
+// for DownloadArtifact.
+func (c *RemoteClient) getStore(ctx context.Context, hash string, output io.Writer, progress func(int)) error {
+	body, err := c.store.Get(ctx, hash)
+	if err != nil {
+		return fmt.Errorf("fetch artifact: %w", err)
+	}
+	defer body.Close()
+
+	start := time.Now()
+	sum := sha256.New()
+	output = io.MultiWriter(output, sum)
+	if progress != nil {
+		output = progressFunc{w: output, add: progress}
+	}
+	n, err := io.Copy(output, body)
+	if err != nil {
+		return fmt.Errorf("fetch artifact: %w", err)
+	}
+	debugf("store get %.12s: %d bytes in %s", hash, n, time.Since(start).Round(time.Millisecond))
+	if checksummed, ok := body.(storage.Checksummed); ok && checksummed.Checksum() != "" {
+		return verifyChecksum(hex.EncodeToString(sum.Sum(nil)), strings.ToLower(checksummed.Checksum()))
+	}
+	return nil
+}
+
+// startStoreMultipart starts a multipart upload with the store, whose part
+// URLs are then uploaded to like a server's.
+func (c *RemoteClient) startStoreMultipart(ctx context.Context, req multipartStartRequest) (multipartStartResponse, error) {
+	uploader, ok := c.store.(storage.MultipartUploader)
+	if !ok {
+		return multipartStartResponse{}, errNotImplemented
+	}
+	parts := int((req.Size + req.PartSize - 1) / req.PartSize)
+	uploadID, urls, err := uploader.StartMultipartUpload(ctx, req.Hash, parts, req.Checksum)
+	return multipartStartResponse{UploadID: uploadID, URLs: urls}, err
+}
+
+func (c *RemoteClient) completeStoreMultipart(ctx context.Context, req multipartCompleteRequest) error {
+	uploader, ok := c.store.(storage.MultipartUploader)
+	if !ok {
+		return errNotImplemented
+	}
+	return uploader.CompleteMultipartUpload(ctx, req.Hash, req.UploadID, req.ETags)
+}
+
+// pruneStore deletes artifacts in the store not used within olderThan.
+func (c *RemoteClient) pruneStore(ctx context.Context, olderThan time.Duration) (*PruneResponse, error) {
+	pruner, ok := c.store.(storage.Pruner)
+	if !ok {
+		return nil, errors.New("remote storage driver does not support pruning")
+	}
+	result, err := pruner.Prune(ctx, time.Now().Add(-olderThan))
+	if err != nil {
+		return nil, err
+	}
+	return &PruneResponse{Removed: result.Removed, Bytes: result.Bytes}, nil
+}
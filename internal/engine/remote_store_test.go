@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/pkg/storage/local"
+)
+
+func newStoreClient(t *testing.T) (*RemoteClient, *local.LocalDriver) {
+	t.Helper()
+	t.Setenv("VC_LOCAL_ROOT", t.TempDir())
+	t.Setenv("VC_STORAGE_PREFIX", "")
+	store, err := local.New()
+	require.NoError(t, err)
+	return NewStoreClient("s3://builds/", store), store
+}
+
+func writeArtifact(t *testing.T, data string) (string, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "artifact")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+	sum := sha256.Sum256([]byte(data))
+	return path, hex.EncodeToString(sum[:])
+}
+
+func TestStoreClientRoundTrip(t *testing.T) {
+	client, _ := newStoreClient(t)
+	ctx := context.Background()
+
+	cases := []struct {
+		action string
+		want   string
+	}{
+		{action: "download", want: "missing"},
+		{action: "upload", want: "upload_needed"},
+	}
+	for _, tc := range cases {
+		resp, err := client.Negotiate(ctx, "abc", tc.action)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, resp.Status, tc.action)
+	}
+
+	path, checksum := writeArtifact(t, "artifact")
+	resp, err := client.NegotiateUpload(ctx, "abc", checksum)
+	require.NoError(t, err)
+	require.NoError(t, client.Upload(ctx, "abc", resp, path, checksum, nil))
+
+	// The upload forgets the miss remembered above.
+	resp, err = client.Negotiate(ctx, "abc", "download")
+	require.NoError(t, err)
+	assert.Equal(t, "found", resp.Status)
+	resp, err = client.Negotiate(ctx, "abc", "upload")
+	require.NoError(t, err)
+	assert.Equal(t, "skipped", resp.Status)
+
+	var out bytes.Buffer
+	fetched := 0
+	require.NoError(t, client.Download(ctx, "abc", resp, &out, func(n int) { fetched += n }))
+	assert.Equal(t, "artifact", out.String())
+	assert.Equal(t, 8, fetched)
+}
+
+func TestStoreClientVerifiesChecksum(t *testing.T) {
+	client, store := newStoreClient(t)
+	ctx := context.Background()
+	path, checksum := writeArtifact(t, "artifact")
+	require.NoError(t, client.Upload(ctx, "abc", &NegotiateResponse{}, path, checksum, nil))
+
+	require.NoError(t, os.WriteFile(filepath.Join(store.Root(), "abc"), []byte("tampered"), 0o644))
+	err := client.Download(ctx, "abc", &NegotiateResponse{}, io.Discard, nil)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+}
+
+func TestStoreClientPrunes(t *testing.T) {
+	client, store := newStoreClient(t)
+	ctx := context.Background()
+	caps, err := client.Capabilities(ctx)
+	require.NoError(t, err)
+	assert.True(t, caps.Prune)
+
+	path, checksum := writeArtifact(t, "artifact")
+	require.NoError(t, client.Upload(ctx, "abc", &NegotiateResponse{}, path, checksum, nil))
+	old := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(store.Root(), "abc"), old, old))
+
+	resp, err := client.Prune(ctx, 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, &PruneResponse{Removed: 1, Bytes: 8}, resp)
+}
+
+// partStore is a store taking multipart uploads at part URLs of server.
+type partStore struct {
+	*local.LocalDriver
+	server   *httptest.Server
+	parts    map[string][]byte
+	complete []string
+}
+
+func (s *partStore) StartMultipartUpload(ctx context.Context, key string, parts int, checksum string) (string, []string, error) {
+	urls := make([]string, parts)
+	for i := range urls {
+		urls[i] = s.server.URL + "/part/" + string(rune('1'+i))
+	}
+	return "u1", urls, nil
+}
+
+func (s *partStore) CompleteMultipartUpload(ctx context.Context, key, uploadID string, etags []string) error {
+	s.complete = etags
+	return nil
+}
+
+func TestStoreClientUploadsLargeArtifactsInParts(t *testing.T) {
+	defer func(threshold, size int64) { multipartThreshold, uploadPartSize = threshold, size }(multipartThreshold, uploadPartSize)
+	multipartThreshold, uploadPartSize = 10, 4
+
+	_, driver := newStoreClient(t)
+	store := &partStore{LocalDriver: driver, parts: map[string][]byte{}}
+	store.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		store.parts[r.URL.Path] = body
+		w.Header().Set("ETag", `"`+strings.TrimPrefix(r.URL.Path, "/part/")+`"`)
+	}))
+	defer store.server.Close()
+	client := NewStoreClient("s3://builds/", store)
+
+	path, checksum := writeArtifact(t, "velocity-cache!")
+	require.NoError(t, client.Upload(context.Background(), "abc", &NegotiateResponse{}, path, checksum, nil))
+
+	assembled := append(append(append(store.parts["/part/1"], store.parts["/part/2"]...), store.parts["/part/3"]...), store.parts["/part/4"]...)
+	assert.Equal(t, "velocity-cache!", string(assembled))
+	assert.Equal(t, []string{`"1"`, `"2"`, `"3"`, `"4"`}, store.complete)
+}
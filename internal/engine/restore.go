@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+)
+
+// cacheTreeExt names the unpacked copy of an artifact that reflink and
+// hardlink restores link files from.
+const cacheTreeExt = ".tree"
+
+var (
+	restoreMode atomic.Value
+	// linkFallbackLogged limits the "falling back to copying" message to
+	// one per process.
+	linkFallbackLogged atomic.Bool
+)
+
+// SetRestoreMode selects how RestoreLocal writes outputs, one of
+// config.RestoreCopy (the default), config.RestoreReflink or
+// config.RestoreHardlink.
+func SetRestoreMode(mode string) {
+	restoreMode.Store(mode)
+}
+
+func currentRestoreMode() string {
+	if mode, _ := restoreMode.Load().(string); mode != "" {
+		return mode
+	}
+	return config.RestoreCopy
+}
+
+func localCacheTree(cacheKey string) (string, error) {
+	dir, err := localCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, cacheKey+cacheTreeExt), nil
+}
+
+// RestoreLocal restores a task's outputs from the artifact stored in the
+// local cache for cacheKey. With reflink or hardlink restores, the artifact
+// is unpacked next to it on first use and its files are linked from there.
+func RestoreLocal(cacheKey string, outputs []string, packagePath string) error {
+	archive, found, err := checkLocal(cacheKey)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("restore %s: not in the local cache", cacheKey)
+	}
+	if currentRestoreMode() == config.RestoreCopy {
+		return extract(archive, outputs, packagePath)
+	}
+
+	tree, err := unpackLocal(cacheKey, archive)
+	if err != nil {
+		debugf("local cache %s: unpack: %v; extracting the archive", cacheKey, err)
+		return extract(archive, outputs, packagePath)
+	}
+	return extract(tree, outputs, packagePath)
+}
+
+// unpackLocal returns the unpacked copy of the artifact for cacheKey,
+// creating it from archive if needed.
+func unpackLocal(cacheKey, archive string) (string, error) {
+	tree, err := localCacheTree(cacheKey)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(tree); err == nil && info.IsDir() {
+		return tree, nil
+	}
+
+	tmp, err := os.MkdirTemp(filepath.Dir(tree), "."+cacheKey+"-*"+cacheTmpExt)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	var dirTimes []dirTime
+	err = readArchive(archive, func(entry archiveEntry) error {
+		name := path.Clean(strings.ReplaceAll(entry.name, "\\", "/"))
+		if name == "." {
+			return nil
+		}
+		if name == ".." || strings.HasPrefix(name, "../") || strings.HasPrefix(name, "/") {
+			return fmt.Errorf("invalid path %s", entry.name)
+		}
+		target := filepath.Join(tmp, filepath.FromSlash(name))
+
+		switch {
+		case entry.mode&os.ModeSymlink != 0:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			return os.Symlink(entry.linkTarget, target)
+		case entry.mode.IsDir() || strings.HasSuffix(entry.name, "/"):
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+			if err := os.Chmod(target, entry.mode&archiveModeBits|0o700); err != nil {
+				return err
+			}
+			if restoredModTime(entry) {
+				dirTimes = append(dirTimes, dirTime{path: target, modTime: entry.modTime})
+			}
+			return nil
+		case entry.open == nil:
+			return nil
+		}
+		return extractArchiveFile(entry, target)
+	})
+	if err != nil {
+		return "", err
+	}
+	for i := len(dirTimes) - 1; i >= 0; i-- {
+		if err := os.Chtimes(dirTimes[i].path, dirTimes[i].modTime, dirTimes[i].modTime); err != nil {
+			return "", err
+		}
+	}
+
+	if err := os.Rename(tmp, tree); err != nil {
+		// Another process may have unpacked the same artifact meanwhile.
+		if info, statErr := os.Stat(tree); statErr == nil && info.IsDir() {
+			return tree, nil
+		}
+		return "", err
+	}
+	return tree, nil
+}
+
+// readTree calls fn for each entry of an unpacked artifact, as readArchive
+// does for archives. File entries carry their path for linking.
+func readTree(root string, fn func(archiveEntry) error) error {
+	return filepath.WalkDir(root, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if filePath == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		entry := archiveEntry{name: filepath.ToSlash(rel), mode: info.Mode(), modTime: info.ModTime()}
+		switch {
+		case info.IsDir():
+			entry.name += "/"
+		case info.Mode()&fs.ModeSymlink != 0:
+			if entry.linkTarget, err = os.Readlink(filePath); err != nil {
+				return err
+			}
+		default:
+			entry.source = filePath
+			entry.open = func() (io.ReadCloser, error) { return os.Open(filePath) }
+		}
+		return fn(entry)
+	})
+}
+
+// linkTreeFile links dst to src as the restore mode asks. It reports
+// whether it did, and whether dst now shares src's inode and so its mode
+// and modification time.
+func linkTreeFile(src, dst string, mode fs.FileMode) (linked, shared bool) {
+	var err error
+	switch currentRestoreMode() {
+	case config.RestoreHardlink:
+		if err = os.Link(src, dst); err == nil {
+			return true, true
+		}
+	case config.RestoreReflink:
+		if err = reflinkFile(src, dst, mode); err == nil {
+			return true, false
+		}
+	default:
+		return false, false
+	}
+	if !errors.Is(err, os.ErrExist) && linkFallbackLogged.CompareAndSwap(false, true) {
+		debugf("restore: %s %s: %v; falling back to copying", currentRestoreMode(), dst, err)
+	}
+	return false, false
+}
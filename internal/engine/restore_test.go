@@ -0,0 +1,81 @@
+//go:build !windows
+
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+)
+
+func TestRestoreLocalModes(t *testing.T) {
+	for _, mode := range []string{config.RestoreCopy, config.RestoreReflink, config.RestoreHardlink} {
+		t.Run(mode, func(t *testing.T) {
+			withTempWorkdir(t, func(root string) {
+				SetRestoreMode(mode)
+				defer SetRestoreMode("")
+
+				pkg := filepath.Join(root, "pkg")
+				mustWriteFile(t, filepath.Join(pkg, "dist", "app.js"), "app")
+				mustWriteFile(t, filepath.Join(pkg, "dist", "lib", "util.js"), "util")
+				if err := os.Symlink("app.js", filepath.Join(pkg, "dist", "index.js")); err != nil {
+					t.Fatalf("symlink: %v", err)
+				}
+				archivePath := filepath.Join(root, "artifact.zip")
+				if err := compress(context.Background(), []string{"dist"}, archivePath, pkg); err != nil {
+					t.Fatalf("compress returned error: %v", err)
+				}
+				if _, err := saveLocal("key", archivePath); err != nil {
+					t.Fatalf("saveLocal error: %v", err)
+				}
+
+				// Restore twice: the second restore reuses the unpacked tree.
+				for i := 0; i < 2; i++ {
+					if err := os.RemoveAll(filepath.Join(pkg, "dist")); err != nil {
+						t.Fatalf("remove dist: %v", err)
+					}
+					if err := RestoreLocal("key", []string{"dist"}, pkg); err != nil {
+						t.Fatalf("RestoreLocal returned error: %v", err)
+					}
+					assertFileContent(t, filepath.Join(pkg, "dist", "app.js"), "app")
+					assertFileContent(t, filepath.Join(pkg, "dist", "lib", "util.js"), "util")
+					if target, err := os.Readlink(filepath.Join(pkg, "dist", "index.js")); err != nil || target != "app.js" {
+						t.Fatalf("expected dist/index.js -> app.js, got %q (%v)", target, err)
+					}
+				}
+
+				tree, _ := localCacheTree("key")
+				_, treeErr := os.Stat(tree)
+				if mode == config.RestoreCopy && treeErr == nil {
+					t.Fatalf("expected copy restores not to unpack the artifact")
+				}
+				if mode != config.RestoreCopy && treeErr != nil {
+					t.Fatalf("expected an unpacked tree, got %v", treeErr)
+				}
+
+				info, err := os.Stat(filepath.Join(pkg, "dist", "app.js"))
+				if err != nil {
+					t.Fatalf("stat app.js: %v", err)
+				}
+				links := info.Sys().(*syscall.Stat_t).Nlink
+				if mode == config.RestoreHardlink && links != 2 {
+					t.Fatalf("expected app.js to be hardlinked to the cache, got %d links", links)
+				}
+				if mode != config.RestoreHardlink && links != 1 {
+					t.Fatalf("expected app.js to be a separate file, got %d links", links)
+				}
+
+				if _, err := RemoveLocal("key"); err != nil {
+					t.Fatalf("RemoveLocal error: %v", err)
+				}
+				if _, err := os.Stat(tree); !os.IsNotExist(err) {
+					t.Fatalf("expected the unpacked tree to be removed, got %v", err)
+				}
+			})
+		})
+	}
+}
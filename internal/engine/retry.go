@@ -0,0 +1,159 @@
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy controls how remote requests that fail transiently, with a
+// network error or a status such as 429 or 503, are retried.
+type RetryPolicy struct {
+	// Attempts is the total number of tries per request, at least 1.
+	Attempts int
+	// BaseDelay is the wait before the first retry. It doubles after every
+	// further attempt, up to MaxDelay, and is jittered by up to half.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy is used unless SetRetryPolicy chose another.
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, BaseDelay: 250 * time.Millisecond, MaxDelay: 10 * time.Second}
+
+var retryPolicy atomic.Value
+
+// SetRetryPolicy sets how negotiation, artifact transfers and other remote
+// requests are retried. Zero fields keep their defaults.
+func SetRetryPolicy(policy RetryPolicy) {
+	if policy.Attempts < 1 {
+		policy.Attempts = DefaultRetryPolicy.Attempts
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = max(DefaultRetryPolicy.MaxDelay, policy.BaseDelay)
+	}
+	retryPolicy.Store(policy)
+}
+
+func currentRetryPolicy() RetryPolicy {
+	if policy, ok := retryPolicy.Load().(RetryPolicy); ok {
+		return policy
+	}
+	return DefaultRetryPolicy
+}
+
+// transientError marks a failure worth retrying. retryAfter is the wait the
+// server asked for, if any.
+type transientError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+func transient(err error) error {
+	return &transientError{err: err}
+}
+
+// requestError wraps the error of a request that got no response. It is
+// transient unless the server's certificate was rejected, which retrying
+// does not change.
+func requestError(err error) error {
+	err = fmt.Errorf("do request: %w", err)
+	var verifyErr *tls.CertificateVerificationError
+	if errors.As(err, &verifyErr) {
+		return err
+	}
+	return transient(err)
+}
+
+// transientStatus returns err marked as transient when the response's
+// status is worth retrying, carrying its Retry-After.
+func transientStatus(resp *http.Response, err error) error {
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return &transientError{err: err, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	}
+	return err
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an
+// HTTP date, returning zero when there is none.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
+
+// nextRetry reports whether a request whose attempt failed with err should
+// be tried again, and after how long. Requests are not retried when the
+// server asks for a longer wait than MaxDelay.
+func (p RetryPolicy) nextRetry(attempt int, err error) (time.Duration, bool) {
+	var transientErr *transientError
+	if attempt >= p.Attempts || !errors.As(err, &transientErr) {
+		return 0, false
+	}
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxDelay)
+	if delay > 1 {
+		delay = delay/2 + rand.N(delay/2)
+	}
+	if transientErr.retryAfter > p.MaxDelay {
+		return 0, false
+	}
+	return max(delay, transientErr.retryAfter), true
+}
+
+// withRetries calls fn until it succeeds, fails with an error that is not
+// transient or runs out of attempts, waiting between attempts as the
+// current policy says. what names the request in debug logs.
+func withRetries(ctx context.Context, what string, fn func() error) error {
+	policy := currentRetryPolicy()
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		delay, ok := policy.nextRetry(attempt, err)
+		if !ok {
+			return err
+		}
+		debugf("%s: attempt %d of %d failed: %v; retrying in %s", what, attempt, policy.Attempts, err, delay.Round(time.Millisecond))
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
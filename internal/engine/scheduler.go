@@ -0,0 +1,256 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+const (
+	defaultRetryDelay = time.Second
+	maxRetryDelay     = 30 * time.Second
+)
+
+type TaskFunc func(ctx context.Context, node *TaskNode) error
+
+type Scheduler struct {
+	concurrency int
+
+	// ContinueOnError keeps scheduling tasks whose dependencies all
+	// succeeded after another task fails. Dependents of a failed task are
+	// marked TaskSkipped and never run.
+	ContinueOnError bool
+
+	// RetryDelay is the wait before the first retry of a task configured
+	// with `retries`; it doubles after every further attempt.
+	RetryDelay time.Duration
+
+	// OnRetry, when set, is called before a failed task is retried.
+	OnRetry func(node *TaskNode, attempt int, delay time.Duration, err error)
+}
+
+func NewScheduler(concurrency int) *Scheduler {
+	if concurrency < 1 {
+		concurrency = runtime.NumCPU()
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Scheduler{concurrency: concurrency, RetryDelay: defaultRetryDelay}
+}
+
+func (s *Scheduler) Concurrency() int {
+	return s.concurrency
+}
+
+// Plan flattens the graphs reachable from roots into a dependency-first
+// order. Nodes sharing an ID are collapsed into one so diamond-shaped
+// graphs only execute each task once.
+func Plan(roots ...*TaskNode) ([]*TaskNode, error) {
+	canonical := make(map[string]*TaskNode)
+	visiting := make(map[string]bool)
+	done := make(map[string]bool)
+	order := make([]*TaskNode, 0)
+
+	var visit func(node *TaskNode) (*TaskNode, error)
+	visit = func(node *TaskNode) (*TaskNode, error) {
+		if existing, ok := canonical[node.ID]; ok {
+			if visiting[node.ID] {
+				return nil, fmt.Errorf("cycle detected while planning %s", node.ID)
+			}
+			if done[node.ID] {
+				return existing, nil
+			}
+		}
+		canonical[node.ID] = node
+		visiting[node.ID] = true
+
+		deps := make([]*TaskNode, 0, len(node.Dependencies))
+		seen := make(map[string]struct{}, len(node.Dependencies))
+		for _, dep := range node.Dependencies {
+			if dep == nil {
+				continue
+			}
+			resolved, err := visit(dep)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := seen[resolved.ID]; ok {
+				continue
+			}
+			seen[resolved.ID] = struct{}{}
+			deps = append(deps, resolved)
+		}
+		node.Dependencies = deps
+
+		visiting[node.ID] = false
+		done[node.ID] = true
+		order = append(order, node)
+		return node, nil
+	}
+
+	for _, root := range roots {
+		if root == nil {
+			continue
+		}
+		if _, err := visit(root); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+type schedulerSlotsKey struct{}
+
+// Yield runs fn without holding the calling task's scheduler slot, letting
+// other tasks start while fn waits, for example on the network. The slot is
+// taken back before Yield returns. Outside Scheduler.Run it just calls fn.
+func Yield(ctx context.Context, fn func() error) error {
+	slots, ok := ctx.Value(schedulerSlotsKey{}).(chan struct{})
+	if !ok {
+		return fn()
+	}
+	<-slots
+	defer func() { slots <- struct{}{} }()
+	return fn()
+}
+
+type taskResult struct {
+	node *TaskNode
+	err  error
+}
+
+// Run executes every task reachable from roots, starting a task only after
+// all of its dependencies have completed and never running more than the
+// configured number of tasks at once, not counting those waiting in Yield.
+// Unless ContinueOnError is set, the
+// first failure stops new tasks from being scheduled; tasks already running
+// are allowed to finish. Every task failure is reported in the returned error.
+func (s *Scheduler) Run(ctx context.Context, roots []*TaskNode, fn TaskFunc) error {
+	nodes, err := Plan(roots...)
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	pending := make(map[*TaskNode]int, len(nodes))
+	dependents := make(map[*TaskNode][]*TaskNode, len(nodes))
+	for _, node := range nodes {
+		node.State = TaskPending
+		pending[node] = len(node.Dependencies)
+		for _, dep := range node.Dependencies {
+			dependents[dep] = append(dependents[dep], node)
+		}
+	}
+
+	ready := make(chan *TaskNode, len(nodes))
+	results := make(chan taskResult, len(nodes))
+
+	// Each running task holds a slot, which it gives up while it waits in
+	// Yield.
+	slots := make(chan struct{}, s.concurrency)
+	taskCtx := context.WithValue(ctx, schedulerSlotsKey{}, slots)
+	go func() {
+		for node := range ready {
+			slots <- struct{}{}
+			go func(node *TaskNode) {
+				err := s.execute(taskCtx, node, fn)
+				<-slots
+				results <- taskResult{node: node, err: err}
+			}(node)
+		}
+	}()
+
+	inFlight := 0
+	schedule := func(node *TaskNode) {
+		node.State = TaskRunning
+		inFlight++
+		ready <- node
+	}
+
+	for _, node := range nodes {
+		if pending[node] == 0 {
+			schedule(node)
+		}
+	}
+
+	var skip func(node *TaskNode)
+	skip = func(node *TaskNode) {
+		for _, dependent := range dependents[node] {
+			if dependent.State != TaskPending {
+				continue
+			}
+			dependent.State = TaskSkipped
+			skip(dependent)
+		}
+	}
+
+	var errs []error
+	for inFlight > 0 {
+		res := <-results
+		inFlight--
+
+		if res.err != nil {
+			res.node.State = TaskFailed
+			res.node.LastError = res.err
+			errs = append(errs, res.err)
+			skip(res.node)
+			continue
+		}
+		res.node.State = TaskDone
+
+		if (len(errs) > 0 && !s.ContinueOnError) || ctx.Err() != nil {
+			continue
+		}
+		for _, dependent := range dependents[res.node] {
+			pending[dependent]--
+			if pending[dependent] == 0 && dependent.State == TaskPending {
+				schedule(dependent)
+			}
+		}
+	}
+	close(ready)
+
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	if len(errs) > 1 {
+		return errors.Join(errs...)
+	}
+	return ctx.Err()
+}
+
+// execute runs fn for node, retrying up to node.TaskConfig.Retries times
+// with exponential backoff between attempts. Replayed failures are not
+// retried.
+func (s *Scheduler) execute(ctx context.Context, node *TaskNode, fn TaskFunc) error {
+	delay := s.RetryDelay
+	for attempt := 1; ; attempt++ {
+		node.Attempts = attempt
+		err := fn(ctx, node)
+		if err == nil || attempt > node.TaskConfig.Retries || ctx.Err() != nil || errors.Is(err, ErrCachedFailure) {
+			return err
+		}
+
+		if s.OnRetry != nil {
+			s.OnRetry(node, attempt, delay, err)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+)
+
+func TestSchedulerRunsDependenciesFirst(t *testing.T) {
+	lib := &TaskNode{ID: "packages/lib#build"}
+	util := &TaskNode{ID: "packages/util#build", Dependencies: []*TaskNode{lib}}
+	app := &TaskNode{ID: "packages/app#build", Dependencies: []*TaskNode{lib, util}}
+
+	var mu sync.Mutex
+	var order []string
+
+	err := NewScheduler(4).Run(context.Background(), []*TaskNode{app}, func(ctx context.Context, node *TaskNode) error {
+		mu.Lock()
+		order = append(order, node.ID)
+		mu.Unlock()
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"packages/lib#build", "packages/util#build", "packages/app#build"}, order)
+	for _, node := range []*TaskNode{lib, util, app} {
+		assert.Equal(t, TaskDone, node.State)
+	}
+}
+
+func TestSchedulerBoundsConcurrency(t *testing.T) {
+	root := &TaskNode{ID: "root#build"}
+	for i := 0; i < 20; i++ {
+		root.Dependencies = append(root.Dependencies, &TaskNode{ID: fmt.Sprintf("packages/p%d#build", i)})
+	}
+
+	var running, peak int32
+	err := NewScheduler(3).Run(context.Background(), []*TaskNode{root}, func(ctx context.Context, node *TaskNode) error {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if current <= old || atomic.CompareAndSwapInt32(&peak, old, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.LessOrEqual(t, peak, int32(3), "scheduler should never exceed its concurrency limit")
+}
+
+func TestTransferPoolRunsTransfersBeyondTaskConcurrency(t *testing.T) {
+	root := &TaskNode{ID: "root#build"}
+	for i := 0; i < 8; i++ {
+		root.Dependencies = append(root.Dependencies, &TaskNode{ID: fmt.Sprintf("packages/p%d#build", i)})
+	}
+
+	pool := NewTransferPool(4)
+	var transferring, peak int32
+	err := NewScheduler(1).Run(context.Background(), []*TaskNode{root}, func(ctx context.Context, node *TaskNode) error {
+		return pool.Download(ctx, func() (int64, error) {
+			current := atomic.AddInt32(&transferring, 1)
+			for {
+				old := atomic.LoadInt32(&peak)
+				if current <= old || atomic.CompareAndSwapInt32(&peak, old, current) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&transferring, -1)
+			return 100, nil
+		})
+	})
+	require.NoError(t, err)
+	assert.Greater(t, peak, int32(1), "transfers should not be limited to one per task slot")
+	assert.LessOrEqual(t, peak, int32(4), "pool should never exceed its parallelism")
+
+	stats := pool.Stats()
+	assert.Equal(t, 9, stats.Downloads)
+	assert.Equal(t, int64(900), stats.BytesDownloaded)
+	assert.Positive(t, stats.BytesPerSecond())
+}
+
+func TestSchedulerDeduplicatesSharedDependencies(t *testing.T) {
+	libA := &TaskNode{ID: "packages/lib#build"}
+	libB := &TaskNode{ID: "packages/lib#build"}
+	web := &TaskNode{ID: "packages/web#build", Dependencies: []*TaskNode{libA}}
+	api := &TaskNode{ID: "packages/api#build", Dependencies: []*TaskNode{libB}}
+
+	var mu sync.Mutex
+	runs := make(map[string]int)
+
+	err := NewScheduler(2).Run(context.Background(), []*TaskNode{web, api}, func(ctx context.Context, node *TaskNode) error {
+		mu.Lock()
+		runs[node.ID]++
+		mu.Unlock()
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, runs["packages/lib#build"], "shared dependency should run once")
+	assert.Same(t, web.Dependencies[0], api.Dependencies[0])
+}
+
+func TestSchedulerStopsAfterFailure(t *testing.T) {
+	lib := &TaskNode{ID: "packages/lib#build"}
+	app := &TaskNode{ID: "packages/app#build", Dependencies: []*TaskNode{lib}}
+
+	boom := errors.New("boom")
+	err := NewScheduler(2).Run(context.Background(), []*TaskNode{app}, func(ctx context.Context, node *TaskNode) error {
+		if node == lib {
+			return boom
+		}
+		t.Fatalf("dependent %s should not run after failure", node.ID)
+		return nil
+	})
+	require.ErrorIs(t, err, boom)
+	assert.Equal(t, TaskFailed, lib.State)
+	assert.NotEqual(t, TaskDone, app.State)
+}
+
+func TestSchedulerContinueOnErrorRunsIndependentTasks(t *testing.T) {
+	lib := &TaskNode{ID: "packages/lib#build"}
+	app := &TaskNode{ID: "packages/app#build", Dependencies: []*TaskNode{lib}}
+	util := &TaskNode{ID: "packages/util#build"}
+	docs := &TaskNode{ID: "packages/docs#build", Dependencies: []*TaskNode{util}}
+	broken := &TaskNode{ID: "packages/broken#build"}
+
+	libErr := errors.New("lib failed")
+	brokenErr := errors.New("broken failed")
+
+	scheduler := NewScheduler(1)
+	scheduler.ContinueOnError = true
+	err := scheduler.Run(context.Background(), []*TaskNode{app, docs, broken}, func(ctx context.Context, node *TaskNode) error {
+		switch node {
+		case lib:
+			return libErr
+		case broken:
+			return brokenErr
+		case app:
+			t.Fatalf("dependent %s should not run after failure", node.ID)
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, libErr)
+	require.ErrorIs(t, err, brokenErr)
+
+	assert.Equal(t, TaskFailed, lib.State)
+	assert.Equal(t, TaskSkipped, app.State)
+	assert.Equal(t, TaskDone, util.State)
+	assert.Equal(t, TaskDone, docs.State)
+}
+
+func TestSchedulerRetriesWithBackoff(t *testing.T) {
+	flaky := &TaskNode{ID: "packages/e2e#test", TaskConfig: config.TaskConfig{Retries: 3}}
+
+	var delays []time.Duration
+	calls := 0
+	scheduler := NewScheduler(1)
+	scheduler.RetryDelay = time.Millisecond
+	scheduler.OnRetry = func(node *TaskNode, attempt int, delay time.Duration, err error) {
+		delays = append(delays, delay)
+	}
+	err := scheduler.Run(context.Background(), []*TaskNode{flaky}, func(ctx context.Context, node *TaskNode) error {
+		calls++
+		if calls < 3 {
+			return errors.New("flaky")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, 3, flaky.Attempts)
+	assert.Equal(t, []time.Duration{time.Millisecond, 2 * time.Millisecond}, delays)
+
+	calls = 0
+	broken := &TaskNode{ID: "packages/broken#test", TaskConfig: config.TaskConfig{Retries: 1}}
+	err = scheduler.Run(context.Background(), []*TaskNode{broken}, func(ctx context.Context, node *TaskNode) error {
+		calls++
+		return errors.New("broken")
+	})
+	require.Error(t, err)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, TaskFailed, broken.State)
+}
+
+func TestPlanDetectsCycles(t *testing.T) {
+	a := &TaskNode{ID: "a#build"}
+	b := &TaskNode{ID: "b#build", Dependencies: []*TaskNode{a}}
+	a.Dependencies = []*TaskNode{b}
+
+	_, err := Plan(a)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cycle")
+}
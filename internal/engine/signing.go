@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// Signed artifacts start with signatureMagic and an HMAC-SHA256 tag over
+// the cache key and the rest of the artifact, so an artifact cannot be
+// forged or moved to another key without the team's signing key.
+var signatureMagic = []byte("VELOSIG1")
+
+var (
+	// ErrUnsignedArtifact is returned when remote.require_signature is set
+	// and a downloaded artifact carries no signature.
+	ErrUnsignedArtifact = errors.New("artifact is not signed")
+	// ErrInvalidSignature is returned when a downloaded artifact's signature
+	// does not match its contents and cache key.
+	ErrInvalidSignature = errors.New("artifact signature is invalid")
+)
+
+func newArtifactMAC(key []byte, cacheKey string) hash.Hash {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(cacheKey))
+	mac.Write([]byte{0})
+	return mac
+}
+
+// SetSignatureKey enables HMAC-SHA256 signing of uploaded artifacts and
+// verification of downloaded ones. When required, unsigned downloads are
+// rejected.
+func (c *RemoteClient) SetSignatureKey(key []byte, required bool) {
+	c.signatureKey = key
+	c.requireSignature = required
+}
+
+// signArtifact returns a signed copy of the artifact at path, or path
+// itself when no signature key is configured.
+func (c *RemoteClient) signArtifact(cacheKey, path string) (string, error) {
+	if len(c.signatureKey) == 0 {
+		return path, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open artifact: %w", err)
+	}
+	mac := newArtifactMAC(c.signatureKey, cacheKey)
+	_, err = io.Copy(mac, bufio.NewReader(file))
+	file.Close()
+	if err != nil {
+		return "", fmt.Errorf("sign artifact: %w", err)
+	}
+	tag := mac.Sum(nil)
+
+	return rewriteArtifact(path, "velo-sig-*", func(r io.Reader, w io.Writer) error {
+		if _, err := w.Write(append(append([]byte(nil), signatureMagic...), tag...)); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, r)
+		return err
+	})
+}
+
+// verifyArtifact checks and strips the signature of a downloaded artifact
+// in place. Signed artifacts are only unwrapped without verification when
+// no signature key is configured.
+func (c *RemoteClient) verifyArtifact(cacheKey, path string) error {
+	signed, err := hasArtifactHeader(path, signatureMagic)
+	if err != nil {
+		return fmt.Errorf("open artifact: %w", err)
+	}
+	if !signed {
+		if c.requireSignature {
+			return ErrUnsignedArtifact
+		}
+		return nil
+	}
+	if len(c.signatureKey) == 0 {
+		debugf("artifact %.12s is signed but no signature key is configured; not verifying", cacheKey)
+	}
+
+	var mac hash.Hash
+	if len(c.signatureKey) > 0 {
+		mac = newArtifactMAC(c.signatureKey, cacheKey)
+	}
+	payload, err := rewriteArtifact(path, "velo-ver-*", func(r io.Reader, w io.Writer) error {
+		header := make([]byte, len(signatureMagic)+sha256.Size)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return fmt.Errorf("%w: truncated header", ErrInvalidSignature)
+		}
+		if mac == nil {
+			_, err := io.Copy(w, r)
+			return err
+		}
+		if _, err := io.Copy(io.MultiWriter(w, mac), r); err != nil {
+			return err
+		}
+		if !hmac.Equal(mac.Sum(nil), header[len(signatureMagic):]) {
+			return ErrInvalidSignature
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(payload, path); err != nil {
+		os.Remove(payload)
+		return fmt.Errorf("replace artifact: %w", err)
+	}
+	return nil
+}
+
+// hasArtifactHeader reports whether the file at path starts with magic.
+func hasArtifactHeader(path string, magic []byte) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	header := make([]byte, len(magic))
+	n, _ := io.ReadFull(file, header)
+	return bytes.Equal(header[:n], magic), nil
+}
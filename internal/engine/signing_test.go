@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedArtifactsVerifyOnlyForTheirKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "artifact.zip")
+	require.NoError(t, os.WriteFile(path, []byte("build output"), 0o644))
+
+	signer := NewRemoteClient("http://cache.example", "")
+	signer.SetSignatureKey([]byte("team secret"), false)
+	upload, err := signer.SealArtifact("abc", path)
+	require.NoError(t, err)
+	defer os.Remove(upload)
+	signed, err := os.ReadFile(upload)
+	require.NoError(t, err)
+
+	restore := func(client *RemoteClient, cacheKey string) (string, error) {
+		dl := filepath.Join(t.TempDir(), "download.zip")
+		require.NoError(t, os.WriteFile(dl, signed, 0o644))
+		err := client.OpenArtifact(cacheKey, dl)
+		data, _ := os.ReadFile(dl)
+		return string(data), err
+	}
+
+	data, err := restore(signer, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "build output", data)
+
+	_, err = restore(signer, "other-key")
+	assert.ErrorIs(t, err, ErrInvalidSignature, "an artifact moved to another key is rejected")
+
+	forger := NewRemoteClient("http://cache.example", "")
+	forger.SetSignatureKey([]byte("wrong secret"), false)
+	_, err = restore(forger, "abc")
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	data, err = restore(NewRemoteClient("http://cache.example", ""), "abc")
+	require.NoError(t, err, "clients without a key unwrap signed artifacts")
+	assert.Equal(t, "build output", data)
+}
+
+func TestRequiredSignatureRejectsUnsignedArtifacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "artifact.zip")
+	require.NoError(t, os.WriteFile(path, []byte("build output"), 0o644))
+
+	client := NewRemoteClient("http://cache.example", "")
+	client.SetSignatureKey([]byte("team secret"), false)
+	require.NoError(t, client.OpenArtifact("abc", path), "unsigned artifacts pass unless signatures are required")
+
+	client.SetSignatureKey([]byte("team secret"), true)
+	assert.ErrorIs(t, client.OpenArtifact("abc", path), ErrUnsignedArtifact)
+}
+
+func TestSignedAndEncryptedArtifactRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "artifact.zip")
+	require.NoError(t, os.WriteFile(path, []byte("build output"), 0o644))
+
+	client := NewRemoteClient("http://cache.example", "")
+	client.SetEncryptionKey(make([]byte, 32))
+	client.SetSignatureKey([]byte("team secret"), true)
+	upload, err := client.SealArtifact("abc", path)
+	require.NoError(t, err)
+	defer os.Remove(upload)
+
+	require.NoError(t, client.OpenArtifact("abc", upload))
+	data, err := os.ReadFile(upload)
+	require.NoError(t, err)
+	assert.Equal(t, "build output", string(data))
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "intermediate encrypted copy is removed")
+}
@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ApplyTags adds the tags declared in velocity.yml to packages. Each tag maps
+// to package selectors using the same name and path globs as --filter.
+func ApplyTags(packages map[string]*Package, tags map[string][]string) error {
+	for tag, selectors := range tags {
+		for _, selector := range selectors {
+			filter, err := ParsePackageFilter(selector)
+			if err != nil {
+				return fmt.Errorf("tag %q: %w", tag, err)
+			}
+			if filter.Exclude || filter.IncludeDependents || filter.IncludeDeps {
+				return fmt.Errorf("tag %q: selector %q must be a package name or path glob", tag, selector)
+			}
+			for _, pkg := range packages {
+				if filter.matches(pkg) {
+					pkg.Tags = append(pkg.Tags, tag)
+				}
+			}
+		}
+	}
+	for _, pkg := range packages {
+		pkg.Tags = normalizeTags(pkg.Tags)
+	}
+	return nil
+}
+
+// HasAnyTag reports whether pkg carries at least one of tags.
+func (p *Package) HasAnyTag(tags []string) bool {
+	for _, tag := range tags {
+		if slices.Contains(p.Tags, strings.TrimSpace(tag)) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeTags(tags []string) []string {
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			out = append(out, tag)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
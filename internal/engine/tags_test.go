@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyTagsMergesConfigAndPackageJSONTags(t *testing.T) {
+	packages := filterFixture()
+	packages["@repo/web"].Tags = []string{"app"}
+
+	err := ApplyTags(packages, map[string][]string{
+		"frontend": {"apps/*", "@repo/ui"},
+		"app":      {"@repo/web"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"app", "frontend"}, packages["@repo/web"].Tags)
+	assert.Equal(t, []string{"frontend"}, packages["@repo/docs"].Tags)
+	assert.Equal(t, []string{"frontend"}, packages["@repo/ui"].Tags)
+	assert.Nil(t, packages["@repo/lib"].Tags)
+	assert.True(t, packages["@repo/ui"].HasAnyTag([]string{"backend", "frontend"}))
+	assert.False(t, packages["@repo/lib"].HasAnyTag([]string{"frontend"}))
+}
+
+func TestApplyTagsRejectsGraphSelectors(t *testing.T) {
+	err := ApplyTags(filterFixture(), map[string][]string{"frontend": {"...@repo/ui"}})
+	assert.Error(t, err)
+}
+
+func TestReadPackageJsonTags(t *testing.T) {
+	root := t.TempDir()
+	writeWorkspaceFile(t, root, "package.json", `{"name": "web", "velocity": {"tags": ["frontend", " app ", "frontend"]}}`)
+
+	pkg, err := readPackageJson(root + "/package.json")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app", "frontend"}, pkg.Tags)
+}
+
+func TestBuildTaskGraphAppliesTagOverrides(t *testing.T) {
+	web := &Package{Name: "web", Path: "apps/web", Tags: []string{"frontend"}}
+	api := &Package{Name: "api", Path: "apps/api"}
+	packages := map[string]*Package{web.Name: web, api.Name: api}
+	cfg := &config.Config{Pipeline: map[string]config.TaskConfig{
+		"build": {
+			Command: "go build ./...",
+			Outputs: []string{"bin/**"},
+			Overrides: map[string]config.TaskConfig{
+				"frontend": {Command: "vite build", Outputs: []string{"dist/**"}},
+			},
+		},
+	}}
+
+	webNode, err := BuildTaskGraph("build", web, packages, cfg, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "vite build", webNode.TaskConfig.Command)
+	assert.Equal(t, []string{"dist/**"}, webNode.TaskConfig.Outputs)
+	assert.Nil(t, webNode.TaskConfig.Overrides)
+
+	apiNode, err := BuildTaskGraph("build", api, packages, cfg, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "go build ./...", apiNode.TaskConfig.Command)
+	assert.Equal(t, []string{"bin/**"}, apiNode.TaskConfig.Outputs)
+}
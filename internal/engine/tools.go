@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// toolVersionArgs lists how to ask well-known tools for their version.
+// Anything else is run with --version.
+var toolVersionArgs = map[string][]string{
+	"go":      {"env", "GOVERSION"},
+	"java":    {"-version"},
+	"python":  {"--version"},
+	"python3": {"--version"},
+}
+
+var toolVersions sync.Map
+
+// toolVersion runs the tool's version command in dir and returns the first
+// line it prints. Results are cached for the life of the process, since
+// every task in a run usually shares the same toolchain.
+func toolVersion(ctx context.Context, name, dir string) (string, error) {
+	cacheKey := name + "\x00" + dir
+	if version, ok := toolVersions.Load(cacheKey); ok {
+		return version.(string), nil
+	}
+
+	args, ok := toolVersionArgs[name]
+	if !ok {
+		args = []string{"--version"}
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	// Some tools (java, older pythons) print their version on stderr.
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", fmt.Errorf("tool_dependencies: %s not found on PATH", name)
+		}
+		return "", fmt.Errorf("tool_dependencies: %s %s: %w", name, strings.Join(args, " "), err)
+	}
+
+	version := ""
+	for _, line := range strings.Split(out.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			version = line
+			break
+		}
+	}
+	if version == "" {
+		return "", fmt.Errorf("tool_dependencies: %s printed no version", name)
+	}
+	debugf("tool %s: %s", name, version)
+	toolVersions.Store(cacheKey, version)
+	return version, nil
+}
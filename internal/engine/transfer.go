@@ -1,50 +1,343 @@
 package engine
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/bit2swaz/velocity-cache/internal/version"
 )
 
+// Downloads are fetched in ranges of downloadChunkSize so that a dropped
+// connection only repeats the unfinished chunk. Each chunk and upload part is
+// retried as the RetryPolicy says.
+var downloadChunkSize int64 = 64 << 20
+
+// Transfer copies an artifact to or from targetURL. Requests without a body
+// and those whose body can seek back to where it started are retried on
+// transient failures and may be redirected with their body.
 func Transfer(ctx context.Context, method, targetURL, serverURL string, body io.Reader, output io.Writer, contentLength int64, authToken string) error {
-	req, err := http.NewRequestWithContext(ctx, method, targetURL, body)
+	if method == http.MethodGet && body == nil && output != nil {
+		return download(ctx, targetURL, serverURL, output, authToken)
+	}
+	what := "transfer " + method + " " + redactURL(targetURL)
+	if body == nil {
+		return withRetries(ctx, what, func() error {
+			_, err := send(ctx, method, targetURL, serverURL, nil, output, contentLength, authToken, nil)
+			return err
+		})
+	}
+
+	seeker, rewindable := body.(io.Seeker)
+	if !rewindable {
+		opened := false
+		_, err := send(ctx, method, targetURL, serverURL, func() (io.Reader, error) {
+			if opened {
+				return nil, errors.New("request body cannot be sent again")
+			}
+			opened = true
+			return body, nil
+		}, output, contentLength, authToken, nil)
+		return err
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
 	if err != nil {
-		return fmt.Errorf("create request: %w", err)
+		return fmt.Errorf("seek request body: %w", err)
+	}
+	openBody := func() (io.Reader, error) {
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("seek request body: %w", err)
+		}
+		return body, nil
 	}
+	return withRetries(ctx, what, func() error {
+		_, err := send(ctx, method, targetURL, serverURL, openBody, output, contentLength, authToken, nil)
+		return err
+	})
+}
+
+// transferClient follows redirects like http.DefaultClient, but sends the
+// Authorization header only to the host it was meant for.
+var transferClient = &http.Client{Transport: configuredTransport{}, CheckRedirect: checkTransferRedirect}
 
+func checkTransferRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+		req.Header.Del("Authorization")
+	}
+	return nil
+}
+
+// maxErrorBody bounds how much of a failed response's body is quoted in
+// errors.
+const maxErrorBody = 512
+
+// responseDetail returns the start of a failed response's body, such as
+// the XML error document of S3, to append to an error message.
+func responseDetail(resp *http.Response) string {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	detail := strings.Join(strings.Fields(string(data)), " ")
+	if detail == "" {
+		return ""
+	}
+	return ": " + detail
+}
+
+func successStatus(code int) bool {
+	return code >= 200 && code < 300
+}
+
+// send makes one transfer request with the given extra headers, copying the
+// response body to output, and returns the response headers. openBody, if
+// set, opens the request body; it is called again when a redirect needs the
+// body sent anew.
+func send(ctx context.Context, method, targetURL, serverURL string, openBody func() (io.Reader, error), output io.Writer, contentLength int64, authToken string, headers map[string]string) (http.Header, error) {
+	var body io.Reader
+	contentType := ""
+	if openBody != nil {
+		opened, err := openBody()
+		if err != nil {
+			return nil, err
+		}
+		// Label the artifact's format so clients configured differently can
+		// tell what they download.
+		buffered := bufio.NewReader(opened)
+		header, _ := buffered.Peek(len(zstdMagic))
+		contentType = ArtifactContentType(header)
+		body = buffered
+	}
+
+	req, err := newTransferRequest(ctx, method, targetURL, serverURL, body, authToken)
+	if err != nil {
+		return nil, err
+	}
 	if body != nil {
 		req.ContentLength = contentLength
+		req.Header.Set("Content-Type", contentType)
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := openBody()
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(body), nil
+		}
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
 	}
 
-	shouldAddAuth, err := hostsMatch(targetURL, serverURL)
+	start := time.Now()
+	resp, err := transferClient.Do(req)
+	if err != nil {
+		debugf("transfer %s %s: %v", method, redactURL(targetURL), err)
+		return nil, requestError(err)
+	}
+	defer resp.Body.Close()
+
+	if !successStatus(resp.StatusCode) {
+		debugf("transfer %s %s: HTTP %d in %s", method, redactURL(targetURL), resp.StatusCode, time.Since(start).Round(time.Millisecond))
+		return nil, transientStatus(resp, fmt.Errorf("transfer failed with status %d%s", resp.StatusCode, responseDetail(resp)))
+	}
+
+	size := contentLength
+	if output != nil {
+		n, err := io.Copy(output, resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("copy response body: %w", err)
+		}
+		size = n
+	}
+	debugf("transfer %s %s: %d bytes in %s (auth header: %t)", method, redactURL(targetURL), size, time.Since(start).Round(time.Millisecond), req.Header.Get("Authorization") != "")
+
+	return resp.Header, nil
+}
+
+func newTransferRequest(ctx context.Context, method, targetURL, serverURL string, body io.Reader, authToken string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, targetURL, body)
 	if err != nil {
-		return fmt.Errorf("check host match: %w", err)
+		return nil, fmt.Errorf("create request: %w", err)
 	}
+	req.Header.Set("User-Agent", version.UserAgent())
 
+	shouldAddAuth, err := hostsMatch(targetURL, serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("check host match: %w", err)
+	}
 	if shouldAddAuth && authToken != "" {
 		req.Header.Set("Authorization", "Bearer "+authToken)
 	}
+	return req, nil
+}
 
-	resp, err := http.DefaultClient.Do(req)
+// download fetches targetURL in ranged chunks, resuming from the last byte
+// written when a chunk fails. Servers that ignore Range are read in one go.
+// When the server advertises the artifact's SHA-256, the download is
+// verified against it.
+func download(ctx context.Context, targetURL, serverURL string, output io.Writer, authToken string) error {
+	_, err := downloadFrom(ctx, targetURL, serverURL, output, authToken, 0, sha256.New(), "")
+	return err
+}
+
+// downloadFrom is download for an artifact whose first offset bytes, which
+// sum has hashed, were already fetched. ifRange is the validator of the
+// artifact those bytes came from; if the server's artifact has changed
+// since, errStaleRange is returned. downloadFrom returns the validator of
+// the artifact it fetched, if the server gave one.
+func downloadFrom(ctx context.Context, targetURL, serverURL string, output io.Writer, authToken string, offset int64, sum hash.Hash, ifRange string) (string, error) {
+	policy := currentRetryPolicy()
+	start, resumedAt := time.Now(), offset
+	output = io.MultiWriter(output, sum)
+	want, validator := "", ifRange
+	total := int64(-1)
+	for attempt := 1; total < 0 || offset < total; {
+		n, size, header, err := fetchRange(ctx, targetURL, serverURL, output, offset, authToken, ifRange)
+		offset += n
+		if size >= 0 {
+			total = size
+		}
+		if header != nil {
+			if want == "" {
+				want = responseChecksum(header)
+			}
+			if validator == "" {
+				validator = rangeValidator(header)
+			}
+		}
+		if err == nil && n == 0 && offset < total {
+			err = fmt.Errorf("%w: empty range at byte %d of %d", errTransferStatus, offset, total)
+		}
+		if err == nil {
+			attempt = 1
+			continue
+		}
+		if ctx.Err() != nil {
+			return validator, err
+		}
+		delay, ok := policy.nextRetry(attempt, err)
+		if !ok {
+			return validator, err
+		}
+		debugf("transfer GET %s: retrying at byte %d in %s: %v", redactURL(targetURL), offset, delay.Round(time.Millisecond), err)
+		if err := sleepContext(ctx, delay); err != nil {
+			return validator, err
+		}
+		attempt++
+	}
+	debugf("transfer GET %s: %d bytes in %s", redactURL(targetURL), offset-resumedAt, time.Since(start).Round(time.Millisecond))
+	if want != "" {
+		return validator, verifyChecksum(hex.EncodeToString(sum.Sum(nil)), want)
+	}
+	return validator, nil
+}
+
+// rangeValidator returns what identifies the version of an artifact in a
+// download response for If-Range: its ETag, unless that is weak, or else
+// its modification time.
+func rangeValidator(header http.Header) string {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return header.Get("Last-Modified")
+}
+
+var errTransferStatus = errors.New("transfer failed")
+
+// errStaleRange is returned when a download is resumed but the server's
+// artifact has changed since its first bytes were fetched.
+var errStaleRange = errors.New("artifact changed since the download started")
+
+// fetchRange copies up to downloadChunkSize bytes from offset to output. It
+// returns the number of bytes written, the artifact's total size (or -1 if
+// that is not yet known) and the response headers. ifRange, if set, is sent
+// as If-Range.
+func fetchRange(ctx context.Context, targetURL, serverURL string, output io.Writer, offset int64, authToken, ifRange string) (int64, int64, http.Header, error) {
+	req, err := newTransferRequest(ctx, http.MethodGet, targetURL, serverURL, nil, authToken)
 	if err != nil {
-		return fmt.Errorf("do request: %w", err)
+		return 0, -1, nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+downloadChunkSize-1))
+	if ifRange != "" && offset > 0 {
+		req.Header.Set("If-Range", ifRange)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("transfer failed with status %d", resp.StatusCode)
+	resp, err := transferClient.Do(req)
+	if err != nil {
+		debugf("transfer GET %s: %v", redactURL(targetURL), err)
+		return 0, -1, nil, requestError(err)
 	}
+	defer resp.Body.Close()
 
-	if output != nil {
-		if _, err := io.Copy(output, resp.Body); err != nil {
-			return fmt.Errorf("copy response body: %w", err)
+	body := io.Reader(resp.Body)
+	total := int64(-1)
+	status := resp.StatusCode
+	if status != http.StatusPartialContent && successStatus(status) {
+		status = http.StatusOK
+	}
+	switch status {
+	case http.StatusPartialContent:
+		_, size, ok := strings.Cut(resp.Header.Get("Content-Range"), "/")
+		if total, err = strconv.ParseInt(size, 10, 64); !ok || err != nil {
+			return 0, -1, nil, fmt.Errorf("%w: invalid Content-Range %q", errTransferStatus, resp.Header.Get("Content-Range"))
+		}
+	case http.StatusOK:
+		if ifRange != "" && offset > 0 {
+			// If-Range did not match: the bytes fetched before are of
+			// another artifact.
+			return 0, -1, nil, errStaleRange
 		}
+		// The whole artifact; skip what was already written.
+		if _, err := io.CopyN(io.Discard, body, offset); err != nil {
+			return 0, -1, nil, transient(fmt.Errorf("copy response body: %w", err))
+		}
+		total = resp.ContentLength
+		if total < 0 {
+			n, err := io.Copy(output, body)
+			if err != nil {
+				return n, -1, resp.Header, transient(fmt.Errorf("copy response body: %w", err))
+			}
+			return n, offset + n, resp.Header, nil
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		_, size, _ := strings.Cut(resp.Header.Get("Content-Range"), "/")
+		if offset == 0 || size == strconv.FormatInt(offset, 10) {
+			// An empty artifact, or one already fetched in full.
+			return 0, offset, resp.Header, nil
+		}
+		fallthrough
+	default:
+		debugf("transfer GET %s: HTTP %d", redactURL(targetURL), resp.StatusCode)
+		return 0, -1, nil, transientStatus(resp, fmt.Errorf("%w with status %d%s", errTransferStatus, resp.StatusCode, responseDetail(resp)))
 	}
 
-	return nil
+	n, err := io.Copy(output, body)
+	if err != nil {
+		return n, total, resp.Header, transient(fmt.Errorf("copy response body: %w", err))
+	}
+	return n, total, resp.Header, nil
+}
+
+// redactURL drops the query string, which holds the signature of presigned
+// URLs, so URLs can be logged.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<invalid url>"
+	}
+	u.RawQuery = ""
+	u.User = nil
+	return u.String()
 }
 
 func hostsMatch(url1, url2 string) (bool, error) {
@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultTransferParallelism is the number of artifact transfers a
+// TransferPool runs at once unless configured otherwise.
+const DefaultTransferParallelism = 8
+
+// TransferStats totals the transfers made through a TransferPool.
+type TransferStats struct {
+	Downloads       int
+	Uploads         int
+	BytesDownloaded int64
+	BytesUploaded   int64
+	// Busy is the wall time during which at least one transfer was running.
+	Busy time.Duration
+}
+
+// BytesPerSecond is the aggregate throughput over the time transfers ran.
+func (s TransferStats) BytesPerSecond() float64 {
+	if s.Busy <= 0 {
+		return 0
+	}
+	return float64(s.BytesDownloaded+s.BytesUploaded) / s.Busy.Seconds()
+}
+
+// TransferPool schedules the artifact transfers of every task in a run. Up
+// to its parallelism transfers run at once, independently of how many tasks
+// the scheduler runs: a task waiting for or making a transfer yields its
+// scheduler slot. A nil pool runs transfers directly.
+type TransferPool struct {
+	slots chan struct{}
+
+	mu        sync.Mutex
+	active    int
+	busySince time.Time
+	stats     TransferStats
+}
+
+// NewTransferPool returns a pool running up to parallelism transfers at
+// once, DefaultTransferParallelism when it is below one.
+func NewTransferPool(parallelism int) *TransferPool {
+	if parallelism < 1 {
+		parallelism = DefaultTransferParallelism
+	}
+	return &TransferPool{slots: make(chan struct{}, parallelism)}
+}
+
+// Download runs fn, which returns the number of bytes it fetched, as a
+// download.
+func (p *TransferPool) Download(ctx context.Context, fn func() (int64, error)) error {
+	return p.do(ctx, false, fn)
+}
+
+// Upload runs fn, which returns the number of bytes it sent, as an upload.
+func (p *TransferPool) Upload(ctx context.Context, fn func() (int64, error)) error {
+	return p.do(ctx, true, fn)
+}
+
+// Stats returns the totals of the transfers completed so far.
+func (p *TransferPool) Stats() TransferStats {
+	if p == nil {
+		return TransferStats{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats
+	if p.active > 0 {
+		stats.Busy += time.Since(p.busySince)
+	}
+	return stats
+}
+
+func (p *TransferPool) do(ctx context.Context, upload bool, fn func() (int64, error)) error {
+	if p == nil {
+		_, err := fn()
+		return err
+	}
+	return Yield(ctx, func() error {
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		defer func() { <-p.slots }()
+
+		p.begin()
+		n, err := fn()
+		p.end(upload, n, err)
+		return err
+	})
+}
+
+func (p *TransferPool) begin() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.active == 0 {
+		p.busySince = time.Now()
+	}
+	p.active++
+}
+
+func (p *TransferPool) end(upload bool, n int64, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active--
+	if p.active == 0 {
+		p.stats.Busy += time.Since(p.busySince)
+	}
+	if err != nil {
+		return
+	}
+	if upload {
+		p.stats.Uploads++
+		p.stats.BytesUploaded += n
+	} else {
+		p.stats.Downloads++
+		p.stats.BytesDownloaded += n
+	}
+}
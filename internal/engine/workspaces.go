@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// WorkspaceGlobs returns the package globs declared by the workspace at
+// root, checking pnpm-workspace.yaml, the "workspaces" field of package.json
+// and lerna.json in that order. It returns nil when none of them declare
+// any packages.
+func WorkspaceGlobs(root string) ([]string, error) {
+	readers := []struct {
+		file  string
+		parse func([]byte) ([]string, error)
+	}{
+		{"pnpm-workspace.yaml", parsePnpmWorkspace},
+		{"package.json", parsePackageJSONWorkspaces},
+		{"lerna.json", parseLernaPackages},
+	}
+
+	for _, reader := range readers {
+		data, err := os.ReadFile(filepath.Join(root, reader.file))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("read %s: %w", reader.file, err)
+		}
+		globs, err := reader.parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", reader.file, err)
+		}
+		if len(globs) > 0 {
+			return globs, nil
+		}
+	}
+	return nil, nil
+}
+
+func parsePnpmWorkspace(data []byte) ([]string, error) {
+	var workspace struct {
+		Packages []string `yaml:"packages"`
+	}
+	if err := yaml.Unmarshal(data, &workspace); err != nil {
+		return nil, err
+	}
+	return workspace.Packages, nil
+}
+
+// parsePackageJSONWorkspaces accepts both the array form used by npm and
+// yarn and the {"packages": [...]} object form.
+func parsePackageJSONWorkspaces(data []byte) ([]string, error) {
+	var manifest struct {
+		Workspaces json.RawMessage `json:"workspaces"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	if len(manifest.Workspaces) == 0 {
+		return nil, nil
+	}
+
+	var globs []string
+	if err := json.Unmarshal(manifest.Workspaces, &globs); err == nil {
+		return globs, nil
+	}
+	var object struct {
+		Packages []string `json:"packages"`
+	}
+	if err := json.Unmarshal(manifest.Workspaces, &object); err != nil {
+		return nil, fmt.Errorf("workspaces: %w", err)
+	}
+	return object.Packages, nil
+}
+
+func parseLernaPackages(data []byte) ([]string, error) {
+	var lerna struct {
+		Packages []string `json:"packages"`
+	}
+	if err := json.Unmarshal(data, &lerna); err != nil {
+		return nil, err
+	}
+	return lerna.Packages, nil
+}
@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeWorkspaceFile(t *testing.T, root, rel, contents string) {
+	t.Helper()
+	path := filepath.Join(root, rel)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+}
+
+func TestWorkspaceGlobsSources(t *testing.T) {
+	pnpm := t.TempDir()
+	writeWorkspaceFile(t, pnpm, "pnpm-workspace.yaml", "packages:\n  - 'apps/*'\n  - '!**/test/**'\n")
+	writeWorkspaceFile(t, pnpm, "package.json", `{"workspaces": ["ignored/*"]}`)
+	globs, err := WorkspaceGlobs(pnpm)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"apps/*", "!**/test/**"}, globs, "pnpm-workspace.yaml takes precedence")
+
+	yarn := t.TempDir()
+	writeWorkspaceFile(t, yarn, "package.json", `{"workspaces": {"packages": ["packages/*"], "nohoist": ["**/react"]}}`)
+	globs, err = WorkspaceGlobs(yarn)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"packages/*"}, globs)
+
+	lerna := t.TempDir()
+	writeWorkspaceFile(t, lerna, "package.json", `{"name": "root"}`)
+	writeWorkspaceFile(t, lerna, "lerna.json", `{"packages": ["modules/*"]}`)
+	globs, err = WorkspaceGlobs(lerna)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"modules/*"}, globs)
+
+	globs, err = WorkspaceGlobs(t.TempDir())
+	require.NoError(t, err)
+	assert.Nil(t, globs)
+}
+
+func TestDiscoverPackagesHonorsExclusionsAndPlainVersions(t *testing.T) {
+	root := t.TempDir()
+	writeWorkspaceFile(t, root, "packages/lib/package.json", `{"name": "lib"}`)
+	writeWorkspaceFile(t, root, "packages/app/package.json", `{"name": "app", "dependencies": {"lib": "^1.0.0", "react": "^18.0.0"}}`)
+	writeWorkspaceFile(t, root, "packages/app/node_modules/dep/package.json", `{"name": "dep"}`)
+	writeWorkspaceFile(t, root, "packages/fixtures/package.json", `{"name": "fixtures"}`)
+	t.Chdir(root)
+
+	packages, err := DiscoverPackages([]string{"packages/**", "!packages/fixtures"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app", "lib"}, sortedPackageNames(packages))
+	assert.Equal(t, []string{"lib"}, packages["app"].InternalDepNames)
+}
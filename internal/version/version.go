@@ -0,0 +1,79 @@
+// Package version holds the build metadata of the velocity binaries. Release
+// builds set it with -ldflags, for example:
+//
+//	go build -ldflags "-X github.com/bit2swaz/velocity-cache/internal/version.Version=v3.1.0"
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Info is the build metadata reported by `velocity version`.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// Get returns the build metadata, falling back to the module version and VCS
+// stamp recorded by the Go toolchain for builds without ldflags.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" && build.Main.Version != "" && build.Main.Version != "(devel)" {
+		info.Version = build.Main.Version
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+func (i Info) String() string {
+	s := i.Version
+	if i.Commit != "" {
+		commit := i.Commit
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		s += " (" + commit
+		if i.Date != "" {
+			s += ", " + i.Date
+		}
+		s += ")"
+	}
+	return fmt.Sprintf("%s %s %s", s, i.GoVersion, i.Platform)
+}
+
+// UserAgent is sent with every request to the remote cache.
+func UserAgent() string {
+	return "velocity-cache/" + Get().Version
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bit2swaz/velocity-cache/pkg/observability"
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
+	"github.com/bit2swaz/velocity-cache/pkg/storage/local"
+)
+
+// serveArtifact streams the artifact stored under key, or answers 404 when
+// there is none.
+func (h *Handler) serveArtifact(w http.ResponseWriter, r *http.Request, key string) {
+	body, err := h.store.Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		observability.CacheOperations.WithLabelValues("download", "miss").Inc()
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	defer body.Close()
+	observability.CacheOperations.WithLabelValues("download", "hit").Inc()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	sendArtifact(w, r, body)
+}
+
+// sendArtifact writes body as the response, along with its checksum when
+// the driver recorded one, and returns the number of bytes written. Bodies
+// that can seek are served with http.ServeContent, which answers Range
+// requests; clients use those to download large artifacts in resumable
+// chunks.
+func sendArtifact(w http.ResponseWriter, r *http.Request, body io.Reader) int64 {
+	if checksummed, ok := body.(storage.Checksummed); ok && checksummed.Checksum() != "" {
+		w.Header().Set(local.ChecksumHeader, checksummed.Checksum())
+	}
+	counter := &countingWriter{ResponseWriter: w}
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		var modTime time.Time
+		if file, ok := body.(interface{ Stat() (os.FileInfo, error) }); ok {
+			if info, err := file.Stat(); err == nil {
+				modTime = info.ModTime()
+			}
+		}
+		http.ServeContent(counter, r, "", modTime, seeker)
+		return counter.n
+	}
+	counter.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(counter, body); err != nil {
+		// Abort the response so the client sees the artifact is
+		// incomplete rather than a short body.
+		log.Printf("Failed to send artifact: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+	return counter.n
+}
+
+// answerExists answers a HEAD request for the artifact stored under key.
+func (h *Handler) answerExists(w http.ResponseWriter, r *http.Request, key string) {
+	exists, err := h.store.Exists(r.Context(), key)
+	switch {
+	case err != nil:
+		storeError(w, err)
+	case !exists:
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
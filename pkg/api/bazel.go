@@ -0,0 +1,81 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/bit2swaz/velocity-cache/pkg/observability"
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
+)
+
+// The plain HTTP cache protocol spoken by Bazel (--remote_cache), BuildKit,
+// sccache and others stores action results under /ac/<hash> and content
+// under /cas/<hash>, where the hash of content is its SHA-256. Entries are
+// kept as ac-<hash> and cas-<hash> in the same store as other artifacts.
+
+var httpCacheHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// MountHTTPCache registers the plain HTTP cache protocol on r.
+func (h *Handler) MountHTTPCache(r chi.Router) {
+	r.Head("/{kind:ac|cas}/{hash}", h.HandleHTTPCacheHead)
+	r.Get("/{kind:ac|cas}/{hash}", h.HandleHTTPCacheDownload)
+	r.Put("/{kind:ac|cas}/{hash}", h.HandleHTTPCacheUpload)
+}
+
+func (h *Handler) HandleHTTPCacheUpload(w http.ResponseWriter, r *http.Request) {
+	key, cas, err := httpCacheKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Content is addressed by its hash, which is checked as it is stored.
+	checksum := ""
+	if cas {
+		checksum = chi.URLParam(r, "hash")
+	}
+
+	err = h.store.Put(r.Context(), key, r.Body, r.ContentLength, checksum)
+	if errors.Is(err, storage.ErrChecksumMismatch) {
+		http.Error(w, "Content does not match its hash", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	observability.CacheOperations.WithLabelValues("upload", "http_cache").Inc()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) HandleHTTPCacheDownload(w http.ResponseWriter, r *http.Request) {
+	key, _, err := httpCacheKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.serveArtifact(w, r, key)
+}
+
+func (h *Handler) HandleHTTPCacheHead(w http.ResponseWriter, r *http.Request) {
+	key, _, err := httpCacheKey(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	h.answerExists(w, r, key)
+}
+
+// httpCacheKey returns the storage key of the entry a request addresses and
+// whether it is in the content-addressed store.
+func httpCacheKey(r *http.Request) (string, bool, error) {
+	hash := chi.URLParam(r, "hash")
+	if !httpCacheHashPattern.MatchString(hash) {
+		return "", false, fmt.Errorf("invalid hash %q (expected a lowercase hex SHA-256)", hash)
+	}
+	kind := chi.URLParam(r, "kind")
+	return kind + "-" + hash, kind == "cas", nil
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func newHTTPCacheRouter(t *testing.T) chi.Router {
+	t.Helper()
+	h, _ := newLocalHandler(t)
+	r := chi.NewRouter()
+	h.MountHTTPCache(r)
+	return r
+}
+
+func TestHTTPCacheActionResults(t *testing.T) {
+	r := newHTTPCacheRouter(t)
+	hash := strings.Repeat("ab", 32)
+
+	assert.Equal(t, http.StatusNotFound, serve(r, http.MethodHead, "/ac/"+hash, "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(r, http.MethodGet, "/ac/"+hash, "").Code)
+
+	// Action results are not addressed by their content.
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodPut, "/ac/"+hash, "result").Code)
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodHead, "/ac/"+hash, "").Code)
+	rec := serve(r, http.MethodGet, "/ac/"+hash, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "result", rec.Body.String())
+
+	// The stores are separate.
+	assert.Equal(t, http.StatusNotFound, serve(r, http.MethodHead, "/cas/"+hash, "").Code)
+}
+
+func TestHTTPCacheContent(t *testing.T) {
+	r := newHTTPCacheRouter(t)
+	sum := sha256.Sum256([]byte("content"))
+	hash := hex.EncodeToString(sum[:])
+
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodPut, "/cas/"+hash, "content").Code)
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodHead, "/cas/"+hash, "").Code)
+	rec := serve(r, http.MethodGet, "/cas/"+hash, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "content", rec.Body.String())
+}
+
+func TestHTTPCacheRejectsContentNotMatchingItsHash(t *testing.T) {
+	r := newHTTPCacheRouter(t)
+	sum := sha256.Sum256([]byte("content"))
+	hash := hex.EncodeToString(sum[:])
+
+	rec := serve(r, http.MethodPut, "/cas/"+hash, "tampered")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "does not match its hash")
+	assert.Equal(t, http.StatusNotFound, serve(r, http.MethodHead, "/cas/"+hash, "").Code)
+}
+
+func TestHTTPCacheRejectsInvalidHashes(t *testing.T) {
+	r := newHTTPCacheRouter(t)
+	for _, hash := range []string{"abc", strings.Repeat("AB", 32), strings.Repeat("zz", 32)} {
+		assert.Equal(t, http.StatusBadRequest, serve(r, http.MethodPut, "/ac/"+hash, "result").Code, hash)
+		assert.Equal(t, http.StatusBadRequest, serve(r, http.MethodGet, "/cas/"+hash, "").Code, hash)
+		assert.Equal(t, http.StatusBadRequest, serve(r, http.MethodHead, "/cas/"+hash, "").Code, hash)
+	}
+	assert.Equal(t, http.StatusNotFound, serve(r, http.MethodGet, "/other/"+strings.Repeat("ab", 32), "").Code)
+}
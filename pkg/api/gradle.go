@@ -0,0 +1,72 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/bit2swaz/velocity-cache/pkg/observability"
+)
+
+// Gradle's HTTP build cache reads and writes entries at <url>/<key>, and the
+// Maven build cache extension at nested paths below its url. Both are
+// served under /cache/, with the path's segments joined by "~" into the
+// storage key. Neither tool can send a bearer token, so they authenticate
+// with HTTP basic auth, using the token as password.
+
+var buildCacheSegmentPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// MountBuildCache registers the Gradle and Maven build cache routes on r.
+func (h *Handler) MountBuildCache(r chi.Router) {
+	r.Head("/cache/*", h.HandleBuildCacheHead)
+	r.Get("/cache/*", h.HandleBuildCacheDownload)
+	r.Put("/cache/*", h.HandleBuildCacheUpload)
+}
+
+func (h *Handler) HandleBuildCacheUpload(w http.ResponseWriter, r *http.Request) {
+	key, err := buildCacheKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.store.Put(r.Context(), key, r.Body, r.ContentLength, ""); err != nil {
+		storeError(w, err)
+		return
+	}
+	observability.CacheOperations.WithLabelValues("upload", "build_cache").Inc()
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) HandleBuildCacheDownload(w http.ResponseWriter, r *http.Request) {
+	key, err := buildCacheKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.serveArtifact(w, r, key)
+}
+
+func (h *Handler) HandleBuildCacheHead(w http.ResponseWriter, r *http.Request) {
+	key, err := buildCacheKey(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	h.answerExists(w, r, key)
+}
+
+// buildCacheKey returns the storage key of the entry at the request's path
+// below /cache/.
+func buildCacheKey(r *http.Request) (string, error) {
+	path := chi.URLParam(r, "*")
+	segments := strings.Split(path, "/")
+	for _, segment := range segments {
+		if !buildCacheSegmentPattern.MatchString(segment) || segment == "." || segment == ".." {
+			return "", fmt.Errorf("invalid cache entry path %q", path)
+		}
+	}
+	return "cache-" + strings.Join(segments, "~"), nil
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newBuildCacheRouter(t *testing.T) (chi.Router, *Handler) {
+	t.Helper()
+	h, _ := newLocalHandler(t)
+	r := chi.NewRouter()
+	h.MountBuildCache(r)
+	return r, h
+}
+
+func TestBuildCacheGradleEntries(t *testing.T) {
+	r, _ := newBuildCacheRouter(t)
+	key := "/cache/8b5b3c5e0e5e4c7a9f1d2e3f4a5b6c7d"
+
+	assert.Equal(t, http.StatusNotFound, serve(r, http.MethodHead, key, "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(r, http.MethodGet, key, "").Code)
+
+	assert.Equal(t, http.StatusCreated, serve(r, http.MethodPut, key, "entry").Code)
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodHead, key, "").Code)
+	rec := serve(r, http.MethodGet, key, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "entry", rec.Body.String())
+}
+
+func TestBuildCacheMavenNestedEntries(t *testing.T) {
+	r, h := newBuildCacheRouter(t)
+	path := "/cache/v1.1/com.acme/web/abc123/buildinfo.xml"
+
+	assert.Equal(t, http.StatusCreated, serve(r, http.MethodPut, path, "<build/>").Code)
+	assert.Equal(t, "<build/>", serve(r, http.MethodGet, path, "").Body.String())
+	assert.Equal(t, "<build/>", readStored(t, h, "cache-v1.1~com.acme~web~abc123~buildinfo.xml"))
+
+	// Entries are told apart by their whole path.
+	assert.Equal(t, http.StatusNotFound, serve(r, http.MethodGet, "/cache/v1.1/com.acme/api/abc123/buildinfo.xml", "").Code)
+}
+
+func TestBuildCacheRejectsInvalidPaths(t *testing.T) {
+	r, _ := newBuildCacheRouter(t)
+	for _, path := range []string{"/cache/a/../b", "/cache/a//b", "/cache/a/", "/cache/a%20b", "/cache/."} {
+		assert.Equal(t, http.StatusBadRequest, serve(r, http.MethodPut, path, "entry").Code, path)
+		assert.Equal(t, http.StatusBadRequest, serve(r, http.MethodHead, path, "").Code, path)
+	}
+}
+
+func TestBuildCacheKey(t *testing.T) {
+	r := chi.NewRouter()
+	var key string
+	var err error
+	r.Get("/cache/*", func(w http.ResponseWriter, req *http.Request) {
+		key, err = buildCacheKey(req)
+	})
+	serve(r, http.MethodGet, "/cache/a/b.c/d-e_f", "")
+	require.NoError(t, err)
+	assert.Equal(t, "cache-a~b.c~d-e_f", key)
+}
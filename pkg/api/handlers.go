@@ -1,9 +1,17 @@
 package api
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5"
+
+	"github.com/bit2swaz/velocity-cache/internal/version"
 	"github.com/bit2swaz/velocity-cache/pkg/observability"
 	"github.com/bit2swaz/velocity-cache/pkg/storage"
 )
@@ -11,21 +19,38 @@ import (
 type NegotiateRequest struct {
 	Hash   string `json:"hash"`
 	Action string `json:"action"`
+	// Checksum is the artifact's hex SHA-256, sent with uploads.
+	Checksum string `json:"checksum,omitempty"`
 }
 
 type NegotiateResponse struct {
-	Status string `json:"status"`
-	URL    string `json:"url,omitempty"`
+	Status  string            `json:"status"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
 }
 
 type Handler struct {
-	store storage.Driver
+	store    storage.Driver
+	upstream *Upstream
 }
 
 func NewHandler(store storage.Driver) *Handler {
 	return &Handler{store: store}
 }
 
+// Mount registers the cache API on r, including the proxy routes clients
+// transfer artifacts through when the driver hands out their URLs.
+func (h *Handler) Mount(r chi.Router) {
+	r.Get("/v1/capabilities", h.HandleCapabilities)
+	r.Post("/v1/negotiate", h.HandleNegotiate)
+	r.Post("/v1/prune", h.HandlePrune)
+	r.Post("/v1/multipart/start", h.HandleMultipartStart)
+	r.Post("/v1/multipart/complete", h.HandleMultipartComplete)
+	r.Put("/v1/proxy/blob/{key}", h.HandleProxyUpload)
+	r.Get("/v1/proxy/blob/{key}", h.HandleProxyDownload)
+	r.Put("/v1/proxy/blob/{key}/parts/{uploadID}/{part}", h.HandleProxyPartUpload)
+}
+
 func (h *Handler) HandleNegotiate(w http.ResponseWriter, r *http.Request) {
 	var req NegotiateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -39,7 +64,7 @@ func (h *Handler) HandleNegotiate(w http.ResponseWriter, r *http.Request) {
 	case "upload":
 		exists, err := h.store.Exists(ctx, req.Hash)
 		if err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			storeError(w, err)
 			return
 		}
 
@@ -50,21 +75,34 @@ func (h *Handler) HandleNegotiate(w http.ResponseWriter, r *http.Request) {
 		}
 
 		observability.CacheOperations.WithLabelValues("upload", "needed").Inc()
-		url, err := h.store.GetUploadURL(ctx, req.Hash)
+		var url string
+		var headers map[string]string
+		if uploader, ok := h.store.(storage.ChecksumUploader); ok && validChecksum(req.Checksum) {
+			url, headers, err = uploader.GetChecksumUploadURL(ctx, req.Hash, req.Checksum)
+		} else {
+			url, err = h.store.GetUploadURL(ctx, req.Hash)
+		}
 		if err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			storeError(w, err)
 			return
 		}
 
-		respondJSON(w, http.StatusOK, NegotiateResponse{Status: "upload_needed", URL: url})
+		respondJSON(w, http.StatusOK, NegotiateResponse{Status: "upload_needed", URL: url, Headers: headers})
 
 	case "download":
 		exists, err := h.store.Exists(ctx, req.Hash)
 		if err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			storeError(w, err)
 			return
 		}
 
+		if !exists && h.upstream != nil {
+			exists, err = h.pullThrough(ctx, req.Hash)
+			if err != nil {
+				log.Printf("Failed to pull %s from upstream: %v", req.Hash, err)
+				exists = false
+			}
+		}
 		if !exists {
 			observability.CacheOperations.WithLabelValues("download", "miss").Inc()
 			http.Error(w, "Not found", http.StatusNotFound)
@@ -73,7 +111,7 @@ func (h *Handler) HandleNegotiate(w http.ResponseWriter, r *http.Request) {
 		observability.CacheOperations.WithLabelValues("download", "hit").Inc()
 		url, err := h.store.GetDownloadURL(ctx, req.Hash)
 		if err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			storeError(w, err)
 			return
 		}
 
@@ -84,6 +122,161 @@ func (h *Handler) HandleNegotiate(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// maxParts and minPartSize mirror S3's multipart limits.
+const (
+	maxParts    = 10000
+	minPartSize = 5 << 20
+)
+
+type MultipartStartRequest struct {
+	Hash     string `json:"hash"`
+	Size     int64  `json:"size"`
+	PartSize int64  `json:"part_size"`
+	Checksum string `json:"checksum,omitempty"`
+}
+
+type MultipartStartResponse struct {
+	UploadID string   `json:"upload_id"`
+	URLs     []string `json:"urls"`
+}
+
+type MultipartCompleteRequest struct {
+	Hash     string   `json:"hash"`
+	UploadID string   `json:"upload_id"`
+	ETags    []string `json:"etags"`
+}
+
+func (h *Handler) HandleMultipartStart(w http.ResponseWriter, r *http.Request) {
+	uploader, ok := h.store.(storage.MultipartUploader)
+	if !ok {
+		http.Error(w, "Storage driver does not support multipart uploads", http.StatusNotImplemented)
+		return
+	}
+
+	var req MultipartStartRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Hash == "" || req.Size <= 0 || req.PartSize < minPartSize {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Checksum != "" && !validChecksum(req.Checksum) {
+		http.Error(w, "Invalid checksum", http.StatusBadRequest)
+		return
+	}
+	parts := (req.Size + req.PartSize - 1) / req.PartSize
+	if parts > maxParts {
+		http.Error(w, "Too many parts", http.StatusBadRequest)
+		return
+	}
+
+	uploadID, urls, err := uploader.StartMultipartUpload(r.Context(), req.Hash, int(parts), req.Checksum)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	observability.CacheOperations.WithLabelValues("upload", "multipart").Inc()
+	respondJSON(w, http.StatusOK, MultipartStartResponse{UploadID: uploadID, URLs: urls})
+}
+
+func (h *Handler) HandleMultipartComplete(w http.ResponseWriter, r *http.Request) {
+	uploader, ok := h.store.(storage.MultipartUploader)
+	if !ok {
+		http.Error(w, "Storage driver does not support multipart uploads", http.StatusNotImplemented)
+		return
+	}
+
+	var req MultipartCompleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Hash == "" || req.UploadID == "" || len(req.ETags) == 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := uploader.CompleteMultipartUpload(r.Context(), req.Hash, req.UploadID, req.ETags); err != nil {
+		storeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Capabilities lists what the server supports, so clients pick the
+// requests to make up front instead of trying them.
+type Capabilities struct {
+	Version string `json:"version"`
+	// Flows are the ways artifacts are exchanged: "negotiate" hands out
+	// storage URLs for clients to use directly, "proxy" means those URLs
+	// point back at this server, which streams the artifacts.
+	Flows     []string `json:"flows"`
+	Multipart bool     `json:"multipart"`
+	Checksums bool     `json:"checksums"`
+	Prune     bool     `json:"prune"`
+}
+
+func (h *Handler) HandleCapabilities(w http.ResponseWriter, r *http.Request) {
+	caps := Capabilities{Version: version.Get().Version, Flows: []string{"negotiate"}}
+	if _, ok := h.store.(storage.Proxied); ok {
+		caps.Flows = append(caps.Flows, "proxy")
+	}
+	_, caps.Multipart = h.store.(storage.MultipartUploader)
+	_, caps.Checksums = h.store.(storage.ChecksumUploader)
+	_, caps.Prune = h.store.(storage.Pruner)
+	respondJSON(w, http.StatusOK, caps)
+}
+
+type PruneRequest struct {
+	OlderThanSeconds int64 `json:"older_than_seconds"`
+}
+
+func (h *Handler) HandlePrune(w http.ResponseWriter, r *http.Request) {
+	pruner, ok := h.store.(storage.Pruner)
+	if !ok {
+		http.Error(w, "Storage driver does not support pruning", http.StatusNotImplemented)
+		return
+	}
+
+	var req PruneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.OlderThanSeconds <= 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	cutoff := time.Now().Add(-time.Duration(req.OlderThanSeconds) * time.Second)
+	result, err := pruner.Prune(r.Context(), cutoff)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, result)
+}
+
+// storeError answers a request whose storage call failed with err: 503
+// while the store is unavailable, so that clients carry on without the
+// cache, 500 otherwise. The error itself is only logged, as it may name
+// buckets or credentials.
+func storeError(w http.ResponseWriter, err error) {
+	log.Printf("Storage error: %v", err)
+	status := storeErrorStatus(err)
+	if status == http.StatusServiceUnavailable {
+		http.Error(w, "Storage unavailable", status)
+		return
+	}
+	http.Error(w, "Internal server error", status)
+}
+
+func storeErrorStatus(err error) int {
+	if errors.Is(err, storage.ErrUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// validChecksum reports whether s is a lowercase hex SHA-256.
+func validChecksum(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
+
 func respondJSON(w http.ResponseWriter, status int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -1,85 +1,181 @@
 package api
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 
 	"github.com/bit2swaz/velocity-cache/pkg/observability"
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
+	"github.com/bit2swaz/velocity-cache/pkg/storage/local"
 )
 
+// HandleProxyUpload stores an artifact uploaded to the URL the driver
+// handed out, verifying it against the checksum header when sent.
 func (h *Handler) HandleProxyUpload(w http.ResponseWriter, r *http.Request) {
+	if !verifyProxyURL(w, r) {
+		return
+	}
 	key := chi.URLParam(r, "key")
 	if key == "" {
 		http.Error(w, "Key is required", http.StatusBadRequest)
 		return
 	}
-
-	root := os.Getenv("VC_LOCAL_ROOT")
-	if root == "" {
-		http.Error(w, "Server configuration error: VC_LOCAL_ROOT not set", http.StatusInternalServerError)
+	body := &countingReader{r: r.Body}
+	err := h.store.Put(r.Context(), key, body, r.ContentLength, strings.ToLower(r.Header.Get(local.ChecksumHeader)))
+	observability.ProxyTraffic.WithLabelValues("in").Add(float64(body.n))
+	if errors.Is(err, storage.ErrChecksumMismatch) {
+		http.Error(w, "Artifact does not match its checksum", http.StatusBadRequest)
 		return
 	}
-
-	path := filepath.Join(root, key)
-
-	out, err := os.Create(path)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create file: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to store file: %v", err), storeErrorStatus(err))
 		return
 	}
-	defer out.Close()
+	w.WriteHeader(http.StatusOK)
+}
 
-	n, err := io.Copy(out, r.Body)
+// HandleProxyDownload serves an artifact from the URL the driver handed
+// out, labelled as a zip or zstd-compressed tar when its format shows.
+func (h *Handler) HandleProxyDownload(w http.ResponseWriter, r *http.Request) {
+	if !verifyProxyURL(w, r) {
+		return
+	}
+	key := chi.URLParam(r, "key")
+	if key == "" {
+		http.Error(w, "Key is required", http.StatusBadRequest)
+		return
+	}
+	body, err := h.store.Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to write file: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to open file: %v", err), storeErrorStatus(err))
 		return
 	}
+	defer body.Close()
+
+	contentType := "application/octet-stream"
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		header := make([]byte, 4)
+		read, _ := io.ReadFull(seeker, header)
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to read file: %v", err), http.StatusInternalServerError)
+			return
+		}
+		contentType = artifactContentType(header[:read])
+	}
+	w.Header().Set("Content-Type", contentType)
+	if n := sendArtifact(w, r, body); n > 0 {
+		observability.ProxyTraffic.WithLabelValues("out").Add(float64(n))
+	}
+}
+
+// verifyProxyURL checks that the request was made with a proxy URL the
+// driver signed and that it has not expired, answering 403 as S3 does for
+// presigned URLs when not.
+func verifyProxyURL(w http.ResponseWriter, r *http.Request) bool {
+	signer, err := storage.EnvURLSigner()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to verify URL: %v", err), http.StatusInternalServerError)
+		return false
+	}
+	err = signer.Verify(r.Method, r.URL.Path, r.URL.Query())
+	if errors.Is(err, storage.ErrURLExpired) {
+		http.Error(w, "URL has expired", http.StatusForbidden)
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Invalid URL signature", http.StatusForbidden)
+		return false
+	}
+	return true
+}
 
-	observability.ProxyTraffic.WithLabelValues("in").Add(float64(n))
+type countingReader struct {
+	r io.Reader
+	n int64
+}
 
-	w.WriteHeader(http.StatusOK)
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
 
-func (h *Handler) HandleProxyDownload(w http.ResponseWriter, r *http.Request) {
-	key := chi.URLParam(r, "key")
-	if key == "" {
-		http.Error(w, "Key is required", http.StatusBadRequest)
+// HandleProxyPartUpload stores one part of a multipart upload and returns
+// its ETag.
+func (h *Handler) HandleProxyPartUpload(w http.ResponseWriter, r *http.Request) {
+	if !verifyProxyURL(w, r) {
+		return
+	}
+	store, ok := h.store.(*local.LocalDriver)
+	if !ok {
+		http.Error(w, "Storage driver does not support multipart uploads", http.StatusNotImplemented)
 		return
 	}
+	root := store.Root()
 
-	root := os.Getenv("VC_LOCAL_ROOT")
-	if root == "" {
-		http.Error(w, "Server configuration error: VC_LOCAL_ROOT not set", http.StatusInternalServerError)
+	n, err := strconv.Atoi(chi.URLParam(r, "part"))
+	if err != nil || n < 1 {
+		http.Error(w, "Invalid part number", http.StatusBadRequest)
+		return
+	}
+	path, err := local.PartPath(root, chi.URLParam(r, "uploadID"), n)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	path := filepath.Join(root, key)
+	out, err := os.Create(path)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer out.Close()
 
-	file, err := os.Open(path)
+	sum := sha256.New()
+	written, err := io.Copy(io.MultiWriter(out, sum), r.Body)
 	if err != nil {
-		if os.IsNotExist(err) {
-			http.Error(w, "File not found", http.StatusNotFound)
-			return
-		}
-		http.Error(w, fmt.Sprintf("Failed to open file: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to write file: %v", err), http.StatusInternalServerError)
 		return
 	}
-	defer file.Close()
 
-	w.Header().Set("Content-Type", "application/octet-stream")
+	observability.ProxyTraffic.WithLabelValues("in").Add(float64(written))
 
-	n, err := io.Copy(w, file)
+	w.Header().Set("ETag", local.PartETag(sum.Sum(nil)))
+	w.WriteHeader(http.StatusOK)
+}
 
-	if n > 0 {
-		observability.ProxyTraffic.WithLabelValues("out").Add(float64(n))
-	}
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
 
-	if err != nil {
-		fmt.Printf("Error streaming file %s: %v\n", key, err)
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// artifactContentType reports whether a stored artifact is a zip or a
+// zstd-compressed tar, so clients can tell the formats apart.
+func artifactContentType(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return "application/zstd"
+	case bytes.HasPrefix(header, []byte("PK")):
+		return "application/zip"
 	}
+	return "application/octet-stream"
 }
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
+	"github.com/bit2swaz/velocity-cache/pkg/storage/local"
+)
+
+// newLocalHandler returns a handler over a local driver in a temporary
+// directory.
+func newLocalHandler(t *testing.T) (*Handler, *local.LocalDriver) {
+	t.Helper()
+	t.Setenv("VC_LOCAL_ROOT", t.TempDir())
+	t.Setenv("VC_STORAGE_PREFIX", "")
+	store, err := local.New()
+	require.NoError(t, err)
+	return NewHandler(store), store
+}
+
+// newLocalRouter returns a router serving the cache API over a local
+// driver in a temporary directory.
+func newLocalRouter(t *testing.T) (chi.Router, *local.LocalDriver) {
+	t.Helper()
+	h, store := newLocalHandler(t)
+	r := chi.NewRouter()
+	h.Mount(r)
+	return r, store
+}
+
+// serve sends a request for rawURL, which may be absolute, to r.
+func serve(r http.Handler, method, rawURL, body string) *httptest.ResponseRecorder {
+	u, _ := url.Parse(rawURL)
+	req := httptest.NewRequest(method, u.RequestURI(), strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestProxyRoundTripThroughSignedURLs(t *testing.T) {
+	r, store := newLocalRouter(t)
+	ctx := context.Background()
+
+	upload, err := store.GetUploadURL(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodPut, upload, "artifact").Code)
+
+	download, err := store.GetDownloadURL(ctx, "abc")
+	require.NoError(t, err)
+	rec := serve(r, http.MethodGet, download, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "artifact", rec.Body.String())
+
+	// An upload URL does not allow downloads.
+	assert.Equal(t, http.StatusForbidden, serve(r, http.MethodGet, upload, "").Code)
+}
+
+func TestProxyUploadVerifiesChecksum(t *testing.T) {
+	r, store := newLocalRouter(t)
+	upload, err := store.GetUploadURL(context.Background(), "abc")
+	require.NoError(t, err)
+	sum := sha256.Sum256([]byte("artifact"))
+	checksum := hex.EncodeToString(sum[:])
+
+	put := func(body, checksum string) int {
+		u, _ := url.Parse(upload)
+		req := httptest.NewRequest(http.MethodPut, u.RequestURI(), strings.NewReader(body))
+		req.Header.Set(local.ChecksumHeader, checksum)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusBadRequest, put("corrupt", checksum))
+	assert.Equal(t, http.StatusOK, put("artifact", strings.ToUpper(checksum)))
+
+	download, err := store.GetDownloadURL(context.Background(), "abc")
+	require.NoError(t, err)
+	rec := serve(r, http.MethodGet, download, "")
+	assert.Equal(t, "artifact", rec.Body.String())
+	assert.Equal(t, checksum, rec.Header().Get(local.ChecksumHeader))
+}
+
+func TestProxyRejectsUnsignedAndExpiredURLs(t *testing.T) {
+	r, store := newLocalRouter(t)
+	uploadID, parts, err := store.StartMultipartUpload(context.Background(), "big", 1, "")
+	require.NoError(t, err)
+	partPath := "/v1/proxy/blob/big/parts/" + uploadID + "/1"
+	require.True(t, strings.Contains(parts[0], partPath+"?"))
+
+	signer, err := storage.EnvURLSigner()
+	require.NoError(t, err)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+	}{
+		{name: "upload", method: http.MethodPut, path: "/v1/proxy/blob/abc"},
+		{name: "download", method: http.MethodGet, path: "/v1/proxy/blob/abc"},
+		{name: "part upload", method: http.MethodPut, path: partPath},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(r, tc.method, tc.path, "data")
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+			assert.Contains(t, rec.Body.String(), "Invalid URL signature")
+
+			expired := signer.SignFor(tc.method, "http://localhost:8080", tc.path, -time.Minute)
+			rec = serve(r, tc.method, expired, "data")
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+			assert.Contains(t, rec.Body.String(), "URL has expired")
+		})
+	}
+
+	// Nothing was stored through the rejected requests.
+	exists, err := store.Exists(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodPut, parts[0], "data").Code)
+}
+
+// brokenBody fails after its first bytes, like a storage connection that
+// drops.
+type brokenBody struct{ sent bool }
+
+func (b *brokenBody) Read(p []byte) (int, error) {
+	if b.sent {
+		return 0, errors.New("connection reset")
+	}
+	b.sent = true
+	return copy(p, "arti"), nil
+}
+
+func TestSendArtifactAbortsOnReadError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		sendArtifact(rec, req, &brokenBody{})
+	})
+	assert.Equal(t, "arti", rec.Body.String())
+}
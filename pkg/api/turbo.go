@@ -0,0 +1,231 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/bit2swaz/velocity-cache/pkg/observability"
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
+)
+
+// The Turborepo remote cache API lets repos using turbo point TURBO_API at
+// this server. Artifacts are stored under their turbo hash in a namespace
+// per team, given as the teamId or slug query parameter; requests without
+// one share a default namespace. The task duration and signature turbo
+// sends with an artifact are kept next to it.
+
+var (
+	turboHashPattern = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+	turboTeamPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// turboMeta is what turbo sends alongside an artifact and expects back.
+type turboMeta struct {
+	DurationMs int64  `json:"duration_ms"`
+	Tag        string `json:"tag,omitempty"`
+}
+
+// MountTurbo registers the Turborepo remote cache API on r.
+func (h *Handler) MountTurbo(r chi.Router) {
+	r.Get("/v8/artifacts/status", h.HandleTurboStatus)
+	r.Post("/v8/artifacts", h.HandleTurboQuery)
+	r.Post("/v8/artifacts/events", h.HandleTurboEvents)
+	r.Head("/v8/artifacts/{hash}", h.HandleTurboHead)
+	r.Get("/v8/artifacts/{hash}", h.HandleTurboDownload)
+	r.Put("/v8/artifacts/{hash}", h.HandleTurboUpload)
+}
+
+func (h *Handler) HandleTurboStatus(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, map[string]string{"status": "enabled"})
+}
+
+func (h *Handler) HandleTurboUpload(w http.ResponseWriter, r *http.Request) {
+	key, err := turboKey(r, chi.URLParam(r, "hash"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	meta := turboMeta{Tag: r.Header.Get("x-artifact-tag")}
+	if duration := r.Header.Get("x-artifact-duration"); duration != "" {
+		if meta.DurationMs, err = strconv.ParseInt(duration, 10, 64); err != nil || meta.DurationMs < 0 {
+			http.Error(w, "Invalid x-artifact-duration", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The metadata is stored first, so that an artifact is never found
+	// without it.
+	ctx := r.Context()
+	metaBytes, err := json.Marshal(meta)
+	if err == nil {
+		err = h.store.Put(ctx, turboMetaKey(key), bytes.NewReader(metaBytes), int64(len(metaBytes)), "")
+	}
+	if err == nil {
+		err = h.store.Put(ctx, key, r.Body, r.ContentLength, "")
+	}
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	observability.CacheOperations.WithLabelValues("upload", "turbo").Inc()
+	respondJSON(w, http.StatusAccepted, map[string][]string{"urls": {r.URL.Path}})
+}
+
+func (h *Handler) HandleTurboDownload(w http.ResponseWriter, r *http.Request) {
+	key, err := turboKey(r, chi.URLParam(r, "hash"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	body, err := h.store.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		observability.CacheOperations.WithLabelValues("download", "miss").Inc()
+		http.Error(w, "Artifact not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	defer body.Close()
+	observability.CacheOperations.WithLabelValues("download", "hit").Inc()
+
+	setTurboHeaders(w, h.turboMeta(r, key))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, body); err != nil {
+		// Abort the response so turbo sees the artifact is incomplete.
+		log.Printf("Failed to send turbo artifact %s: %v", key, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (h *Handler) HandleTurboHead(w http.ResponseWriter, r *http.Request) {
+	key, err := turboKey(r, chi.URLParam(r, "hash"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	exists, err := h.store.Exists(r.Context(), key)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	setTurboHeaders(w, h.turboMeta(r, key))
+	w.WriteHeader(http.StatusOK)
+}
+
+// turboArtifactInfo describes an artifact in answers to artifact queries.
+type turboArtifactInfo struct {
+	TaskDurationMs int64  `json:"taskDurationMs"`
+	Tag            string `json:"tag,omitempty"`
+}
+
+// HandleTurboQuery reports which of the given hashes have artifacts.
+func (h *Handler) HandleTurboQuery(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Hashes []string `json:"hashes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	result := make(map[string]*turboArtifactInfo, len(req.Hashes))
+	for _, hash := range req.Hashes {
+		key, err := turboKey(r, hash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		exists, err := h.store.Exists(r.Context(), key)
+		if err != nil {
+			storeError(w, err)
+			return
+		}
+		if !exists {
+			result[hash] = nil
+			continue
+		}
+		meta := h.turboMeta(r, key)
+		result[hash] = &turboArtifactInfo{TaskDurationMs: meta.DurationMs, Tag: meta.Tag}
+	}
+	respondJSON(w, http.StatusOK, result)
+}
+
+// HandleTurboEvents accepts the cache usage events turbo reports. They are
+// counted rather than stored.
+func (h *Handler) HandleTurboEvents(w http.ResponseWriter, r *http.Request) {
+	var events []struct {
+		Source string `json:"source"`
+		Event  string `json:"event"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	for _, event := range events {
+		if event.Source == "REMOTE" && (event.Event == "HIT" || event.Event == "MISS") {
+			observability.CacheOperations.WithLabelValues("turbo_event", event.Event).Inc()
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// turboKey returns the storage key for hash in the team namespace the
+// request names.
+func turboKey(r *http.Request, hash string) (string, error) {
+	if !turboHashPattern.MatchString(hash) {
+		return "", fmt.Errorf("invalid artifact hash %q", hash)
+	}
+	team := r.URL.Query().Get("teamId")
+	if team == "" {
+		team = r.URL.Query().Get("slug")
+	}
+	if team == "" {
+		return "turbo-" + hash, nil
+	}
+	if !turboTeamPattern.MatchString(team) {
+		return "", fmt.Errorf("invalid team %q", team)
+	}
+	return "turbo-" + team + "-" + hash, nil
+}
+
+func turboMetaKey(key string) string {
+	return key + ".meta"
+}
+
+// turboMeta reads what turbo sent with the artifact under key, if the
+// store has it.
+func (h *Handler) turboMeta(r *http.Request, key string) turboMeta {
+	var meta turboMeta
+	body, err := h.store.Get(r.Context(), turboMetaKey(key))
+	if err != nil {
+		return meta
+	}
+	defer body.Close()
+	json.NewDecoder(body).Decode(&meta)
+	return meta
+}
+
+func setTurboHeaders(w http.ResponseWriter, meta turboMeta) {
+	w.Header().Set("x-artifact-duration", strconv.FormatInt(meta.DurationMs, 10))
+	if meta.Tag != "" {
+		w.Header().Set("x-artifact-tag", meta.Tag)
+	}
+}
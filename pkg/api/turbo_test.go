@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
+)
+
+// failingPuts is a driver whose Put fails for the keys in fail.
+type failingPuts struct {
+	storage.Driver
+	fail map[string]bool
+}
+
+func (d *failingPuts) Put(ctx context.Context, key string, body io.Reader, size int64, checksum string) error {
+	if d.fail[key] {
+		io.Copy(io.Discard, body)
+		return errors.New("disk full")
+	}
+	return d.Driver.Put(ctx, key, body, size, checksum)
+}
+
+func newTurboRouter(t *testing.T) (chi.Router, *Handler) {
+	t.Helper()
+	h, _ := newLocalHandler(t)
+	r := chi.NewRouter()
+	h.MountTurbo(r)
+	return r, h
+}
+
+func turboRequest(r http.Handler, method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTurboStatus(t *testing.T) {
+	r, _ := newTurboRouter(t)
+	rec := turboRequest(r, http.MethodGet, "/v8/artifacts/status", "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"enabled"}`, rec.Body.String())
+}
+
+func TestTurboUploadAndDownload(t *testing.T) {
+	r, _ := newTurboRouter(t)
+
+	rec := turboRequest(r, http.MethodHead, "/v8/artifacts/abc123?teamId=team_1", "", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = turboRequest(r, http.MethodGet, "/v8/artifacts/abc123?teamId=team_1", "", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = turboRequest(r, http.MethodPut, "/v8/artifacts/abc123?teamId=team_1", "artifact",
+		map[string]string{"x-artifact-duration": "1500", "x-artifact-tag": "sig"})
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.JSONEq(t, `{"urls":["/v8/artifacts/abc123"]}`, rec.Body.String())
+
+	rec = turboRequest(r, http.MethodGet, "/v8/artifacts/abc123?teamId=team_1", "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "artifact", rec.Body.String())
+	assert.Equal(t, "1500", rec.Header().Get("x-artifact-duration"))
+	assert.Equal(t, "sig", rec.Header().Get("x-artifact-tag"))
+
+	rec = turboRequest(r, http.MethodHead, "/v8/artifacts/abc123?teamId=team_1", "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "1500", rec.Header().Get("x-artifact-duration"))
+}
+
+func TestTurboDownloadKeepsArtifactPastPrune(t *testing.T) {
+	h, driver := newLocalHandler(t)
+	r := chi.NewRouter()
+	h.MountTurbo(r)
+
+	rec := turboRequest(r, http.MethodPut, "/v8/artifacts/abc123", "artifact", map[string]string{"x-artifact-duration": "10"})
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	old := time.Now().Add(-48 * time.Hour)
+	for _, key := range []string{"turbo-abc123", "turbo-abc123.meta"} {
+		require.NoError(t, os.Chtimes(filepath.Join(driver.Root(), key), old, old))
+	}
+
+	require.Equal(t, http.StatusOK, turboRequest(r, http.MethodGet, "/v8/artifacts/abc123", "", nil).Code)
+	result, err := driver.Prune(context.Background(), time.Now().Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, result.Removed)
+
+	rec = turboRequest(r, http.MethodGet, "/v8/artifacts/abc123", "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "10", rec.Header().Get("x-artifact-duration"))
+}
+
+func TestTurboUploadRejectsInvalidDuration(t *testing.T) {
+	r, _ := newTurboRouter(t)
+	rec := turboRequest(r, http.MethodPut, "/v8/artifacts/abc123", "artifact", map[string]string{"x-artifact-duration": "-1"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestTurboFailedUploadLeavesNoArtifact(t *testing.T) {
+	h, _ := newLocalHandler(t)
+	failing := &failingPuts{Driver: h.store, fail: map[string]bool{"turbo-abc123": true, "turbo-def456.meta": true}}
+	h.store = failing
+	r := chi.NewRouter()
+	h.MountTurbo(r)
+
+	for _, hash := range []string{"abc123", "def456"} {
+		rec := turboRequest(r, http.MethodPut, "/v8/artifacts/"+hash, "artifact", map[string]string{"x-artifact-duration": "10"})
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, http.StatusNotFound, turboRequest(r, http.MethodHead, "/v8/artifacts/"+hash, "", nil).Code)
+	}
+
+	// Once stored, the artifact is found with its metadata.
+	delete(failing.fail, "turbo-abc123")
+	rec := turboRequest(r, http.MethodPut, "/v8/artifacts/abc123", "artifact", map[string]string{"x-artifact-duration": "10"})
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	rec = turboRequest(r, http.MethodHead, "/v8/artifacts/abc123", "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "10", rec.Header().Get("x-artifact-duration"))
+}
+
+func TestTurboQuery(t *testing.T) {
+	r, _ := newTurboRouter(t)
+	turboRequest(r, http.MethodPut, "/v8/artifacts/abc123?slug=acme", "artifact",
+		map[string]string{"x-artifact-duration": "42", "x-artifact-tag": "sig"})
+
+	rec := turboRequest(r, http.MethodPost, "/v8/artifacts?slug=acme", `{"hashes":["abc123","def456"]}`, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"abc123":{"taskDurationMs":42,"tag":"sig"},"def456":null}`, rec.Body.String())
+
+	rec = turboRequest(r, http.MethodPost, "/v8/artifacts?slug=acme", `{"hashes":["not-a-hash!"]}`, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = turboRequest(r, http.MethodPost, "/v8/artifacts", `{`, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestTurboEvents(t *testing.T) {
+	r, _ := newTurboRouter(t)
+	rec := turboRequest(r, http.MethodPost, "/v8/artifacts/events",
+		`[{"source":"REMOTE","event":"HIT","hash":"abc123"},{"source":"LOCAL","event":"MISS","hash":"def456"}]`, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = turboRequest(r, http.MethodPost, "/v8/artifacts/events", `{}`, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestTurboTeamsHaveSeparateNamespaces(t *testing.T) {
+	r, _ := newTurboRouter(t)
+	turboRequest(r, http.MethodPut, "/v8/artifacts/abc123?teamId=team_1", "one", nil)
+
+	assert.Equal(t, http.StatusNotFound, turboRequest(r, http.MethodGet, "/v8/artifacts/abc123?teamId=team_2", "", nil).Code)
+	assert.Equal(t, http.StatusNotFound, turboRequest(r, http.MethodGet, "/v8/artifacts/abc123", "", nil).Code)
+	assert.Equal(t, "one", turboRequest(r, http.MethodGet, "/v8/artifacts/abc123?slug=team_1", "", nil).Body.String())
+}
+
+func TestTurboKey(t *testing.T) {
+	cases := []struct {
+		target  string
+		hash    string
+		want    string
+		wantErr bool
+	}{
+		{target: "/", hash: "abc123", want: "turbo-abc123"},
+		{target: "/?teamId=team_1", hash: "abc123", want: "turbo-team_1-abc123"},
+		{target: "/?slug=acme-web", hash: "abc123", want: "turbo-acme-web-abc123"},
+		{target: "/?teamId=team_1&slug=acme", hash: "abc123", want: "turbo-team_1-abc123"},
+		{target: "/?teamId=../etc", hash: "abc123", wantErr: true},
+		{target: "/", hash: "abc/123", wantErr: true},
+		{target: "/", hash: "", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.target+" "+tc.hash, func(t *testing.T) {
+			key, err := turboKey(httptest.NewRequest(http.MethodGet, tc.target, nil), tc.hash)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, key)
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bit2swaz/velocity-cache/pkg/observability"
+	"github.com/bit2swaz/velocity-cache/pkg/storage/local"
+)
+
+// defaultUpstreamTimeout bounds each pull from upstream unless
+// VC_UPSTREAM_TIMEOUT says otherwise.
+const defaultUpstreamTimeout = 10 * time.Minute
+
+// errUpstreamMiss reports that the upstream cache does not have an artifact.
+var errUpstreamMiss = errors.New("artifact not found upstream")
+
+// Upstream is another cache server consulted when an artifact is missing
+// here, such as a company-wide cache behind a branch office or CI cluster.
+// Artifacts it has are stored here on the way through, so later downloads
+// stay local.
+type Upstream struct {
+	url     string
+	token   string
+	client  *http.Client
+	timeout time.Duration
+
+	// pulls holds the fetches in progress by key, so that clients missing
+	// an artifact at once download it from upstream only once. Entries are
+	// removed when their fetch finishes.
+	mu    sync.Mutex
+	pulls map[string]*pull
+}
+
+// pull is a fetch from upstream that other requests for the same artifact
+// wait on.
+type pull struct {
+	done  chan struct{}
+	found bool
+	err   error
+}
+
+// NewUpstream returns an upstream cache served at baseURL, authenticated
+// with token when it is set. Each pull from it is given up after timeout,
+// or after 10 minutes when timeout is zero.
+func NewUpstream(baseURL, token string, timeout time.Duration) *Upstream {
+	if timeout <= 0 {
+		timeout = defaultUpstreamTimeout
+	}
+	return &Upstream{
+		url:     strings.TrimRight(baseURL, "/"),
+		token:   token,
+		client:  &http.Client{Timeout: timeout},
+		timeout: timeout,
+		pulls:   make(map[string]*pull),
+	}
+}
+
+// SetUpstream makes the handler fetch artifacts it does not have from
+// upstream.
+func (h *Handler) SetUpstream(upstream *Upstream) {
+	h.upstream = upstream
+}
+
+// pullThrough copies the artifact for key from the upstream cache into the
+// store, reporting whether upstream had it. Requests for an artifact being
+// pulled already wait for that pull instead of starting another.
+func (h *Handler) pullThrough(ctx context.Context, key string) (bool, error) {
+	u := h.upstream
+	u.mu.Lock()
+	p, ok := u.pulls[key]
+	if !ok {
+		p = &pull{done: make(chan struct{})}
+		u.pulls[key] = p
+		go h.runPull(ctx, key, p)
+	}
+	u.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return p.found, p.err
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// runPull performs p for key. The pull is shared by every request waiting
+// for key, so it carries on when the request that started it goes away,
+// until the upstream timeout.
+func (h *Handler) runPull(ctx context.Context, key string, p *pull) {
+	u := h.upstream
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), u.timeout)
+	defer cancel()
+	p.found, p.err = h.pull(ctx, key)
+
+	u.mu.Lock()
+	delete(u.pulls, key)
+	u.mu.Unlock()
+	close(p.done)
+}
+
+// pull copies the artifact for key from the upstream cache into the store.
+func (h *Handler) pull(ctx context.Context, key string) (bool, error) {
+	// Another request may have pulled it since this one missed it.
+	if exists, err := h.store.Exists(ctx, key); err != nil || exists {
+		return exists, err
+	}
+
+	tmp, err := os.CreateTemp("", "velocity-upstream-*")
+	if err != nil {
+		return false, fmt.Errorf("create temp file: %w", err)
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	checksum, err := h.upstream.fetch(ctx, key, tmp)
+	if errors.Is(err, errUpstreamMiss) {
+		observability.CacheOperations.WithLabelValues("download", "upstream_miss").Inc()
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return false, fmt.Errorf("seek temp file: %w", err)
+	}
+	if err := h.store.Put(ctx, key, tmp, -1, checksum); err != nil {
+		return false, err
+	}
+	observability.CacheOperations.WithLabelValues("download", "upstream_hit").Inc()
+	return true, nil
+}
+
+// fetch downloads the artifact for key from upstream into dst, returning
+// its checksum when upstream recorded one. The download is verified
+// against that checksum.
+func (u *Upstream) fetch(ctx context.Context, key string, dst io.Writer) (string, error) {
+	body, err := json.Marshal(NegotiateRequest{Hash: key, Action: "download"})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.url+"/v1/negotiate", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("create upstream request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	u.authorize(req)
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("negotiate with upstream: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", errUpstreamMiss
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("negotiate with upstream: unexpected status %s", resp.Status)
+	}
+	var negotiated NegotiateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&negotiated); err != nil {
+		return "", fmt.Errorf("decode upstream response: %w", err)
+	}
+	if negotiated.Status != "found" {
+		return "", errUpstreamMiss
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, negotiated.URL, nil)
+	if err != nil {
+		return "", fmt.Errorf("create upstream request: %w", err)
+	}
+	// Presigned storage URLs carry their own credentials; the token is only
+	// for URLs served by the upstream server itself.
+	if sameHost(negotiated.URL, u.url) {
+		u.authorize(req)
+	}
+	resp, err = u.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("download from upstream: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download from upstream: unexpected status %s", resp.Status)
+	}
+
+	sum := sha256.New()
+	n, err := io.Copy(io.MultiWriter(dst, sum), resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("download from upstream: %w", err)
+	}
+	checksum := strings.ToLower(resp.Header.Get(local.ChecksumHeader))
+	if !validChecksum(checksum) {
+		checksum = ""
+	}
+	if checksum != "" && hex.EncodeToString(sum.Sum(nil)) != checksum {
+		return "", fmt.Errorf("download from upstream: artifact %s does not match its checksum", key)
+	}
+	log.Printf("Pulled %s (%d bytes) from upstream", key, n)
+	return checksum, nil
+}
+
+func (u *Upstream) authorize(req *http.Request) {
+	if u.token != "" {
+		req.Header.Set("Authorization", "Bearer "+u.token)
+	}
+}
+
+func sameHost(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	return errA == nil && errB == nil && strings.EqualFold(ua.Host, ub.Host)
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/pkg/storage/local"
+)
+
+// fakeUpstream is an upstream cache server holding artifacts by key.
+type fakeUpstream struct {
+	*httptest.Server
+	artifacts map[string]string
+	// checksums overrides the checksum advertised for a key.
+	checksums map[string]string
+	// blobURL, if set, is where downloads are sent instead of the server.
+	blobURL   string
+	downloads atomic.Int32
+	// auth records the Authorization header of each download.
+	auth []string
+	// release, if set, holds downloads until it is closed.
+	release chan struct{}
+}
+
+func newFakeUpstream(t *testing.T, artifacts map[string]string) *fakeUpstream {
+	u := &fakeUpstream{artifacts: artifacts, checksums: map[string]string{}}
+	u.Server = httptest.NewServer(u)
+	t.Cleanup(u.Close)
+	return u
+}
+
+func (u *fakeUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/v1/negotiate":
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		var req NegotiateRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if _, ok := u.artifacts[req.Hash]; !ok {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		base := u.URL
+		if u.blobURL != "" {
+			base = u.blobURL
+		}
+		json.NewEncoder(w).Encode(NegotiateResponse{Status: "found", URL: base + "/blob/" + req.Hash})
+	default:
+		u.serveBlob(w, r)
+	}
+}
+
+func (u *fakeUpstream) serveBlob(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Path[len("/blob/"):]
+	u.downloads.Add(1)
+	u.auth = append(u.auth, r.Header.Get("Authorization"))
+	if u.release != nil {
+		<-u.release
+	}
+	data := u.artifacts[key]
+	checksum, ok := u.checksums[key]
+	if !ok {
+		sum := sha256.Sum256([]byte(data))
+		checksum = hex.EncodeToString(sum[:])
+	}
+	w.Header().Set(local.ChecksumHeader, checksum)
+	io.WriteString(w, data)
+}
+
+func readStored(t *testing.T, h *Handler, key string) string {
+	t.Helper()
+	body, err := h.store.Get(context.Background(), key)
+	require.NoError(t, err)
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestPullThroughStoresHit(t *testing.T) {
+	upstream := newFakeUpstream(t, map[string]string{"abc": "artifact"})
+	h, _ := newLocalHandler(t)
+	h.SetUpstream(NewUpstream(upstream.URL+"/", "secret", 0))
+
+	found, err := h.pullThrough(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "artifact", readStored(t, h, "abc"))
+	assert.Equal(t, []string{"Bearer secret"}, upstream.auth)
+
+	// Later requests are served from the store.
+	found, err = h.pullThrough(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, int32(1), upstream.downloads.Load())
+}
+
+func TestPullThroughMiss(t *testing.T) {
+	upstream := newFakeUpstream(t, map[string]string{})
+	h, _ := newLocalHandler(t)
+	h.SetUpstream(NewUpstream(upstream.URL, "secret", 0))
+
+	found, err := h.pullThrough(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.False(t, found)
+	exists, err := h.store.Exists(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestPullThroughRejectsChecksumMismatch(t *testing.T) {
+	upstream := newFakeUpstream(t, map[string]string{"abc": "artifact"})
+	sum := sha256.Sum256([]byte("other"))
+	upstream.checksums["abc"] = hex.EncodeToString(sum[:])
+	h, _ := newLocalHandler(t)
+	h.SetUpstream(NewUpstream(upstream.URL, "secret", 0))
+
+	_, err := h.pullThrough(context.Background(), "abc")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does not match its checksum")
+	exists, err := h.store.Exists(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestPullThroughKeepsTokenFromOtherHosts(t *testing.T) {
+	upstream := newFakeUpstream(t, map[string]string{"abc": "artifact"})
+	storage := newFakeUpstream(t, upstream.artifacts)
+	upstream.blobURL = storage.URL
+	h, _ := newLocalHandler(t)
+	h.SetUpstream(NewUpstream(upstream.URL, "secret", 0))
+
+	found, err := h.pullThrough(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []string{""}, storage.auth)
+}
+
+func TestPullThroughFetchesConcurrentMissesOnce(t *testing.T) {
+	upstream := newFakeUpstream(t, map[string]string{"abc": "artifact"})
+	upstream.release = make(chan struct{})
+	h, _ := newLocalHandler(t)
+	h.SetUpstream(NewUpstream(upstream.URL, "secret", 0))
+
+	var wg sync.WaitGroup
+	results := make([]bool, 5)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			found, err := h.pullThrough(context.Background(), "abc")
+			assert.NoError(t, err)
+			results[i] = found
+		}()
+	}
+	require.Eventually(t, func() bool { return upstream.downloads.Load() == 1 }, time.Second, time.Millisecond)
+	close(upstream.release)
+	wg.Wait()
+
+	assert.Equal(t, []bool{true, true, true, true, true}, results)
+	assert.Equal(t, int32(1), upstream.downloads.Load())
+	assert.Empty(t, h.upstream.pulls, "finished pulls should be forgotten")
+}
+
+func TestPullThroughOutlivesTheRequestStartingIt(t *testing.T) {
+	upstream := newFakeUpstream(t, map[string]string{"abc": "artifact"})
+	upstream.release = make(chan struct{})
+	h, _ := newLocalHandler(t)
+	h.SetUpstream(NewUpstream(upstream.URL, "secret", 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := h.pullThrough(ctx, "abc")
+		first <- err
+	}()
+	require.Eventually(t, func() bool { return upstream.downloads.Load() == 1 }, time.Second, time.Millisecond)
+
+	waiter := make(chan bool, 1)
+	go func() {
+		found, err := h.pullThrough(context.Background(), "abc")
+		assert.NoError(t, err)
+		waiter <- found
+	}()
+	cancel()
+	assert.ErrorIs(t, <-first, context.Canceled)
+
+	close(upstream.release)
+	assert.True(t, <-waiter)
+	assert.Equal(t, "artifact", readStored(t, h, "abc"))
+	assert.Equal(t, int32(1), upstream.downloads.Load())
+}
+
+func TestPullThroughGivesUpOnStalledUpstream(t *testing.T) {
+	upstream := newFakeUpstream(t, map[string]string{"abc": "artifact"})
+	upstream.release = make(chan struct{})
+	t.Cleanup(func() { close(upstream.release) })
+	h, _ := newLocalHandler(t)
+	h.SetUpstream(NewUpstream(upstream.URL, "secret", 50*time.Millisecond))
+
+	found, err := h.pullThrough(context.Background(), "abc")
+	require.Error(t, err)
+	assert.False(t, found)
+	require.Eventually(t, func() bool {
+		h.upstream.mu.Lock()
+		defer h.upstream.mu.Unlock()
+		return len(h.upstream.pulls) == 0
+	}, time.Second, time.Millisecond)
+}
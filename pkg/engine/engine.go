@@ -0,0 +1,105 @@
+// Package engine exposes velocity-cache's task hashing, graph building,
+// artifact storage and execution to other Go programs, so they can cache
+// task outputs the way `velocity run` does without shelling out to it.
+//
+// A typical embedding loads the workspace, builds the graph of a task and
+// runs it:
+//
+//	ws, err := engine.Open()
+//	...
+//	nodes, err := ws.Graph().Tasks("build")
+//	...
+//	runner := engine.NewRunner(engine.NewHasher(), ws.LocalStore(), engine.RunnerOptions{})
+//	err = runner.Run(ctx, nodes)
+//
+// Paths are resolved against the current working directory, which must be
+// the workspace root, and the workspace settings Open applies (cache
+// version, global inputs, input discovery and hash algorithm) are shared by
+// the whole process.
+package engine
+
+import (
+	"fmt"
+
+	"github.com/bit2swaz/velocity-cache/internal/config"
+	internal "github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+type (
+	// Config is the contents of velocity.yml.
+	Config = config.Config
+	// TaskConfig is a pipeline entry of velocity.yml.
+	TaskConfig = config.TaskConfig
+	// Package is a workspace package.
+	Package = internal.Package
+	// TaskNode is a task of a package in a task graph. Its CacheKey and
+	// Manifest are set once it has been hashed.
+	TaskNode = internal.TaskNode
+	// KeyManifest records every component of a task's cache key.
+	KeyManifest = internal.KeyManifest
+)
+
+// Workspace is a loaded velocity.yml together with the packages it covers.
+type Workspace struct {
+	Config   *Config
+	Packages map[string]*Package
+}
+
+// Open loads velocity.yml from the current directory and discovers the
+// workspace's packages.
+func Open() (*Workspace, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	return OpenConfig(cfg)
+}
+
+// OpenConfig discovers the packages of the workspace described by cfg,
+// from cfg.Packages or else the package manager's workspace globs.
+func OpenConfig(cfg *Config) (*Workspace, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("workspace requires configuration")
+	}
+	internal.SetCacheVersion(cfg.CacheVersion())
+	internal.SetGlobalInputs(cfg.GlobalDependencies, cfg.GlobalEnv)
+	internal.SetInputDiscovery(cfg.InputDiscovery)
+	internal.SetFileHashAlgorithm(cfg.HashAlgorithm)
+	internal.SetRestoreMode(cfg.Cache.Restore)
+
+	globs := cfg.Packages
+	if len(globs) == 0 {
+		found, err := internal.WorkspaceGlobs(".")
+		if err != nil {
+			return nil, fmt.Errorf("read workspace globs: %w", err)
+		}
+		globs = found
+	}
+	if len(globs) == 0 {
+		globs = []string{"apps/*", "libs/*", "packages/*"}
+	}
+
+	packages, err := internal.DiscoverPackages(globs)
+	if err != nil {
+		return nil, fmt.Errorf("discover packages: %w", err)
+	}
+	if len(packages) > 0 {
+		if err := internal.BuildPackageGraph(packages); err != nil {
+			return nil, fmt.Errorf("build package graph: %w", err)
+		}
+		if err := internal.ApplyTags(packages, cfg.Tags); err != nil {
+			return nil, fmt.Errorf("apply tags: %w", err)
+		}
+	}
+	return &Workspace{Config: cfg, Packages: packages}, nil
+}
+
+// Graph returns a Graph over the workspace's packages.
+func (w *Workspace) Graph() Graph {
+	return &workspaceGraph{cfg: w.Config, packages: w.Packages}
+}
+
+// LocalStore returns the workspace's local artifact cache.
+func (w *Workspace) LocalStore() Store {
+	return NewLocalStore(w.Config)
+}
@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunnerRestoresUnchangedTasks(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	require.NoError(t, os.WriteFile("velocity.yml", []byte(`version: 1
+pipeline:
+  build:
+    command: "mkdir -p dist && cat src.txt > dist/out.txt && echo built"
+    inputs: ["src.txt"]
+    outputs: ["dist/**"]
+`), 0o644))
+	require.NoError(t, os.WriteFile("src.txt", []byte("hello"), 0o644))
+
+	ws, err := Open()
+	require.NoError(t, err)
+
+	run := func() (string, bool) {
+		nodes, err := ws.Graph().Tasks("build")
+		require.NoError(t, err)
+		require.Len(t, nodes, 1)
+
+		var stdout bytes.Buffer
+		var restored bool
+		runner := NewRunner(NewHasher(), ws.LocalStore(), RunnerOptions{
+			Stdout:     &stdout,
+			OnTaskDone: func(_ *TaskNode, cached bool) { restored = cached },
+		})
+		require.NoError(t, runner.Run(context.Background(), nodes))
+		assert.NotEmpty(t, nodes[0].CacheKey)
+		return stdout.String(), restored
+	}
+
+	out, cached := run()
+	assert.Equal(t, "built\n", out)
+	assert.False(t, cached)
+
+	require.NoError(t, os.RemoveAll("dist"))
+	out, cached = run()
+	assert.Empty(t, out)
+	assert.True(t, cached)
+	data, err := os.ReadFile(filepath.Join("dist", "out.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+}
@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+
+	internal "github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+// Graph resolves tasks into the dependency graphs that run them.
+type Graph interface {
+	// Tasks returns every task needed to run task in the packages matching
+	// filters, dependencies first. Filters use the syntax of `velocity run
+	// --filter`; without any, every package defining a script named task
+	// is selected, or every package when none does.
+	Tasks(task string, filters ...string) ([]*TaskNode, error)
+}
+
+// workspaceRootName names the package standing in for the workspace root
+// when it has no packages.
+const workspaceRootName = "__workspace__"
+
+type workspaceGraph struct {
+	cfg      *Config
+	packages map[string]*Package
+}
+
+func (g *workspaceGraph) Tasks(task string, filters ...string) ([]*TaskNode, error) {
+	if _, ok := g.cfg.Pipeline[task]; !ok {
+		return nil, fmt.Errorf("task %q is not defined in pipeline", task)
+	}
+
+	targets, err := g.targets(task, filters)
+	if err != nil {
+		return nil, err
+	}
+	roots := make([]*TaskNode, 0, len(targets))
+	for _, target := range targets {
+		root, err := internal.BuildTaskGraph(task, target, g.packages, g.cfg, nil)
+		if err != nil {
+			return nil, fmt.Errorf("build task graph: %w", err)
+		}
+		roots = append(roots, root)
+	}
+
+	nodes, err := internal.Plan(roots...)
+	if err != nil {
+		return nil, fmt.Errorf("build task graph: %w", err)
+	}
+	if _, err := internal.TaskConflicts(nodes); err != nil {
+		return nil, fmt.Errorf("build task graph: %w", err)
+	}
+	return nodes, nil
+}
+
+func (g *workspaceGraph) targets(task string, filters []string) ([]*Package, error) {
+	if len(g.packages) == 0 || g.packages[workspaceRootName] != nil {
+		if len(filters) > 0 {
+			return nil, fmt.Errorf("no packages found to filter")
+		}
+		root := &Package{
+			Name:           workspaceRootName,
+			Path:           ".",
+			Scripts:        internal.ReadScripts("package.json"),
+			PackageManager: internal.DetectPackageManager("."),
+		}
+		g.packages[root.Name] = root
+		return []*Package{root}, nil
+	}
+
+	candidates := g.packages
+	if len(filters) > 0 {
+		filtered, err := internal.FilterPackages(g.packages, filters)
+		if err != nil {
+			return nil, err
+		}
+		candidates = filtered
+	}
+
+	var selected []*Package
+	for _, pkg := range candidates {
+		if _, ok := pkg.Scripts[task]; ok || len(filters) > 0 {
+			selected = append(selected, pkg)
+		}
+	}
+	if len(selected) == 0 {
+		for _, pkg := range candidates {
+			selected = append(selected, pkg)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no packages match %v", filters)
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Name < selected[j].Name })
+	return selected, nil
+}
@@ -0,0 +1,26 @@
+package engine
+
+import (
+	"context"
+
+	internal "github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+// Hasher computes cache keys.
+type Hasher interface {
+	// Hash computes node's cache key from its command, environment, input
+	// files and the keys already assigned to its dependencies, returning
+	// the manifest of everything the key was derived from.
+	Hash(ctx context.Context, node *TaskNode) (*KeyManifest, error)
+}
+
+type contentHasher struct{}
+
+// NewHasher returns the Hasher `velocity run` uses.
+func NewHasher() Hasher {
+	return contentHasher{}
+}
+
+func (contentHasher) Hash(ctx context.Context, node *TaskNode) (*KeyManifest, error) {
+	return internal.GenerateTaskNodeManifest(ctx, node)
+}
@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	internal "github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+// Runner executes tasks, restoring them from a Store when their inputs are
+// unchanged.
+type Runner interface {
+	// Run executes nodes and everything they depend on, dependencies first.
+	Run(ctx context.Context, nodes []*TaskNode) error
+}
+
+// RunnerOptions configures the Runner returned by NewRunner.
+type RunnerOptions struct {
+	// Concurrency bounds how many tasks run at once; zero means one per
+	// CPU.
+	Concurrency int
+	// ContinueOnError keeps running tasks that do not depend on a failed
+	// one.
+	ContinueOnError bool
+	// Stdout and Stderr receive the tasks' output, os.Stdout and os.Stderr
+	// when nil.
+	Stdout, Stderr io.Writer
+	// OnTaskDone, when set, is called after each task that ran or was
+	// restored, with cached reporting whether it was restored.
+	OnTaskDone func(node *TaskNode, cached bool)
+}
+
+type taskRunner struct {
+	hasher Hasher
+	store  Store
+	opts   RunnerOptions
+}
+
+// NewRunner returns a Runner that hashes tasks with hasher and restores and
+// saves their outputs with store, which may be nil to run without caching.
+func NewRunner(hasher Hasher, store Store, opts RunnerOptions) Runner {
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stdout
+	}
+	if opts.Stderr == nil {
+		opts.Stderr = os.Stderr
+	}
+	return &taskRunner{hasher: hasher, store: store, opts: opts}
+}
+
+func (r *taskRunner) Run(ctx context.Context, nodes []*TaskNode) error {
+	scheduler := internal.NewScheduler(r.opts.Concurrency)
+	scheduler.ContinueOnError = r.opts.ContinueOnError
+	// Tasks print through shared writers, so their writes are serialized.
+	var mu sync.Mutex
+	stdout := &lockedWriter{mu: &mu, w: r.opts.Stdout}
+	stderr := &lockedWriter{mu: &mu, w: r.opts.Stderr}
+
+	err := scheduler.Run(ctx, nodes, func(ctx context.Context, node *TaskNode) error {
+		cached, err := r.runTask(ctx, node, stdout, stderr)
+		if err == nil && r.opts.OnTaskDone != nil {
+			r.opts.OnTaskDone(node, cached)
+		}
+		return err
+	})
+	if saveErr := internal.SaveHashCache(); err == nil && saveErr != nil {
+		err = saveErr
+	}
+	return err
+}
+
+func (r *taskRunner) runTask(ctx context.Context, node *TaskNode, stdout, stderr io.Writer) (bool, error) {
+	if strings.TrimSpace(node.TaskConfig.Command) == "" {
+		return false, fmt.Errorf("task %s has no command", node.ID)
+	}
+
+	manifest, err := r.hasher.Hash(ctx, node)
+	if err != nil {
+		return false, fmt.Errorf("hash %s: %w", node.ID, err)
+	}
+	node.Manifest = manifest
+	node.CacheKey = manifest.Key
+
+	cacheable := r.store != nil && node.TaskConfig.CacheEnabled()
+	if cacheable {
+		found, err := r.store.Restore(ctx, node.CacheKey, node)
+		if err != nil {
+			return false, fmt.Errorf("restore %s: %w", node.ID, err)
+		}
+		if found {
+			return true, nil
+		}
+	}
+
+	if _, err := internal.ExecuteWithWriters(ctx, node.TaskConfig, packagePath(node), stdout, stderr); err != nil {
+		return false, err
+	}
+
+	if cacheable && len(node.TaskConfig.Outputs) > 0 {
+		if err := r.store.Save(ctx, node.CacheKey, node); err != nil {
+			return false, fmt.Errorf("save %s: %w", node.ID, err)
+		}
+	}
+	return false, nil
+}
+
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	internal "github.com/bit2swaz/velocity-cache/internal/engine"
+)
+
+// Store keeps task outputs by cache key.
+type Store interface {
+	// Restore restores node's outputs from the artifact stored under key,
+	// reporting false when there is none.
+	Restore(ctx context.Context, key string, node *TaskNode) (bool, error)
+	// Save archives node's outputs and stores them under key.
+	Save(ctx context.Context, key string, node *TaskNode) error
+}
+
+type localStore struct {
+	cfg *Config
+}
+
+// NewLocalStore returns the Store backed by the local cache in
+// .velocity/cache, honoring cfg's archive format and restore mode.
+func NewLocalStore(cfg *Config) Store {
+	return &localStore{cfg: cfg}
+}
+
+func (s *localStore) Restore(_ context.Context, key string, node *TaskNode) (bool, error) {
+	if _, found, err := internal.CheckLocal(key); err != nil || !found {
+		return false, err
+	}
+	if err := internal.RestoreLocal(key, node.TaskConfig.Outputs, packagePath(node)); err != nil {
+		return false, err
+	}
+	_ = internal.TouchLocal(key)
+	return true, nil
+}
+
+func (s *localStore) Save(ctx context.Context, key string, node *TaskNode) error {
+	tmp, err := os.CreateTemp("", "velo-out-*.zip")
+	if err != nil {
+		return fmt.Errorf("create archive: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	opts := internal.ArchiveOptions{PreserveMetadata: s.cfg != nil && s.cfg.PreserveMetadata}
+	if s.cfg != nil {
+		opts.Format = s.cfg.ArchiveFormatFor(node.TaskConfig)
+	}
+	if err := internal.Compress(ctx, node.TaskConfig.Outputs, tmp.Name(), packagePath(node), opts); err != nil {
+		return fmt.Errorf("archive outputs: %w", err)
+	}
+	if _, err := internal.SaveLocal(key, tmp.Name()); err != nil {
+		return err
+	}
+	if node.Manifest != nil && node.Manifest.Key == key {
+		return internal.SaveLocalManifest(node.Manifest)
+	}
+	return nil
+}
+
+func packagePath(node *TaskNode) string {
+	if node.Package == nil {
+		return ""
+	}
+	return node.Package.Path
+}
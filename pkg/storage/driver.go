@@ -1,9 +1,68 @@
 package storage
 
-import "context"
+import (
+	"context"
+	"errors"
+	"io"
+)
 
+// ErrNotFound is returned by Driver.Get for keys with no artifact.
+var ErrNotFound = errors.New("artifact not found")
+
+// ErrChecksumMismatch is returned by Driver.Put for bodies that do not match
+// the checksum they were stored with.
+var ErrChecksumMismatch = errors.New("artifact does not match its checksum")
+
+// ErrUnavailable is returned by drivers that stopped sending requests to a
+// store after it kept failing, until it is given another try.
+var ErrUnavailable = errors.New("storage unavailable")
+
+// Driver stores artifacts. Clients usually transfer them with the storage
+// itself through the URLs a driver hands out; Put and Get let the server
+// transfer them on clients' behalf, for its proxy routes and for protocols
+// whose clients cannot follow a URL.
 type Driver interface {
 	GetUploadURL(ctx context.Context, key string) (string, error)
 	GetDownloadURL(ctx context.Context, key string) (string, error)
 	Exists(ctx context.Context, key string) (bool, error)
+	// Put stores body under key. size is the length of body, or -1 when it
+	// is not known in advance. checksum, if set, is the artifact's hex
+	// SHA-256: body is checked against it, failing with
+	// ErrChecksumMismatch before replacing any stored artifact, and it is
+	// recorded as GetChecksumUploadURL would.
+	Put(ctx context.Context, key string, body io.Reader, size int64, checksum string) error
+	// Get opens the artifact stored under key, returning ErrNotFound when
+	// there is none.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// MultipartUploader is implemented by drivers that accept artifacts in
+// separately uploaded parts, for artifacts too large for a single PUT.
+type MultipartUploader interface {
+	// StartMultipartUpload returns an upload ID and one upload URL per part.
+	// checksum, if set, is the hex SHA-256 of the whole artifact.
+	StartMultipartUpload(ctx context.Context, key string, parts int, checksum string) (string, []string, error)
+	// CompleteMultipartUpload assembles the parts, given the ETag returned
+	// for each part in order.
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, etags []string) error
+}
+
+// ChecksumUploader is implemented by drivers that record an artifact's hex
+// SHA-256 at upload time and return it on download. The returned headers
+// must be sent with the upload.
+type ChecksumUploader interface {
+	GetChecksumUploadURL(ctx context.Context, key, checksum string) (string, map[string]string, error)
+}
+
+// Checksummed is implemented by artifacts opened by Driver.Get whose
+// checksum the driver recorded.
+type Checksummed interface {
+	// Checksum returns the artifact's hex SHA-256.
+	Checksum() string
+}
+
+// Proxied is implemented by drivers whose upload and download URLs point
+// back at the server's proxy routes instead of at the storage itself.
+type Proxied interface {
+	ProxiedByServer()
 }
@@ -2,25 +2,65 @@ package local
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
 )
 
+// ChecksumHeader carries an artifact's hex SHA-256 on uploads and
+// downloads, matching the header S3 returns for the same user metadata.
+const ChecksumHeader = "X-Amz-Meta-Sha256"
+
+// checksumsDir holds the recorded SHA-256 of each artifact.
+const checksumsDir = ".checksums"
+
+// ChecksumPath returns where the checksum of the artifact stored for key is
+// recorded.
+func ChecksumPath(root, key string) string {
+	return filepath.Join(root, checksumsDir, key)
+}
+
+// WriteChecksum records the hex SHA-256 of the artifact stored for key.
+func WriteChecksum(root, key, checksum string) error {
+	path := ChecksumPath(root, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(checksum), 0644)
+}
+
 // LocalDriver implements storage.Driver for local filesystem storage.
 type LocalDriver struct {
 	root    string
 	baseURL string
+	signer  *storage.URLSigner
 }
 
-// New creates a new LocalDriver.
+// New creates a LocalDriver storing artifacts in VC_LOCAL_ROOT, or in the
+// directory VC_STORAGE_PREFIX names below it. Its proxy URLs are signed as
+// VC_PROXY_SIGNING_KEY and VC_PROXY_URL_EXPIRY configure.
 func New() (*LocalDriver, error) {
 	root := os.Getenv("VC_LOCAL_ROOT")
 	if root == "" {
 		return nil, fmt.Errorf("VC_LOCAL_ROOT is not set")
 	}
+	prefix, err := storage.EnvPrefix()
+	if err != nil {
+		return nil, err
+	}
+	root = filepath.Join(root, filepath.FromSlash(prefix))
+	signer, err := storage.EnvURLSigner()
+	if err != nil {
+		return nil, err
+	}
 
 	// Default to localhost:8080 if not set, but allow override
 	baseURL := os.Getenv("VC_BASE_URL")
@@ -33,28 +73,101 @@ func New() (*LocalDriver, error) {
 		return nil, fmt.Errorf("failed to create local root directory: %w", err)
 	}
 
-	return &LocalDriver{root: root, baseURL: baseURL}, nil
+	return &LocalDriver{root: root, baseURL: baseURL, signer: signer}, nil
 }
 
-// GetUploadURL returns the URL for uploading a file.
+// Root returns the directory artifacts are stored in.
+func (d *LocalDriver) Root() string {
+	return d.root
+}
+
+// ProxiedByServer marks the driver's URLs as pointing back at the server.
+func (d *LocalDriver) ProxiedByServer() {}
+
+// GetUploadURL returns a signed proxy URL for uploading a file.
 func (d *LocalDriver) GetUploadURL(ctx context.Context, key string) (string, error) {
-	return fmt.Sprintf("%s/v1/proxy/blob/%s", d.baseURL, key), nil
+	return d.signer.Sign(http.MethodPut, d.baseURL, "/v1/proxy/blob/"+key), nil
 }
 
-// GetDownloadURL returns the URL for downloading a file.
+// GetChecksumUploadURL returns the proxy upload URL. The proxy verifies the
+// upload against checksum and stores it for downloads.
+func (d *LocalDriver) GetChecksumUploadURL(ctx context.Context, key, checksum string) (string, map[string]string, error) {
+	url, err := d.GetUploadURL(ctx, key)
+	if err != nil {
+		return "", nil, err
+	}
+	return url, map[string]string{ChecksumHeader: checksum}, nil
+}
+
+// GetDownloadURL returns a signed proxy URL for downloading a file.
 func (d *LocalDriver) GetDownloadURL(ctx context.Context, key string) (string, error) {
-	return fmt.Sprintf("%s/v1/proxy/blob/%s", d.baseURL, key), nil
+	return d.signer.Sign(http.MethodGet, d.baseURL, "/v1/proxy/blob/"+key), nil
+}
+
+// Put writes body to the file for key through a temporary file, so that a
+// failed or corrupt upload never replaces the artifact.
+func (d *LocalDriver) Put(ctx context.Context, key string, body io.Reader, size int64, checksum string) error {
+	out, err := os.CreateTemp(d.root, ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(out.Name())
+
+	sum := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, sum), body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if checksum != "" && !strings.EqualFold(hex.EncodeToString(sum.Sum(nil)), checksum) {
+		return storage.ErrChecksumMismatch
+	}
+	if err := os.Rename(out.Name(), filepath.Join(d.root, key)); err != nil {
+		return fmt.Errorf("failed to store file: %w", err)
+	}
+	if checksum == "" {
+		os.Remove(ChecksumPath(d.root, key))
+		return nil
+	}
+	return WriteChecksum(d.root, key, strings.ToLower(checksum))
+}
+
+// Get opens the file for key, along with its recorded checksum. Reading an
+// artifact resets its eviction timer, like Exists does.
+func (d *LocalDriver) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(d.root, key))
+	if os.IsNotExist(err) {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	d.touch(key)
+	artifact := &artifact{File: file}
+	if checksum, err := os.ReadFile(ChecksumPath(d.root, key)); err == nil {
+		artifact.checksum = string(checksum)
+	}
+	return artifact, nil
+}
+
+// artifact is a stored file opened by Get. It is an io.ReadSeeker, so the
+// server can answer range requests for it.
+type artifact struct {
+	*os.File
+	checksum string
+}
+
+func (a *artifact) Checksum() string {
+	return a.checksum
 }
 
 // Exists checks if the file exists in the local filesystem.
 func (d *LocalDriver) Exists(ctx context.Context, key string) (bool, error) {
-	path := filepath.Join(d.root, key)
-	_, err := os.Stat(path)
+	_, err := os.Stat(filepath.Join(d.root, key))
 	if err == nil {
-		// UPDATE: Touch the file to reset its eviction timer
-		// We ignore errors here because it's an optimization, not critical
-		now := time.Now()
-		os.Chtimes(path, now, now)
+		d.touch(key)
 		return true, nil
 	}
 	if os.IsNotExist(err) {
@@ -62,3 +175,11 @@ func (d *LocalDriver) Exists(ctx context.Context, key string) (bool, error) {
 	}
 	return false, err
 }
+
+// touch resets the eviction timer of the artifact stored for key and of its
+// checksum. Errors are ignored because it's an optimization, not critical.
+func (d *LocalDriver) touch(key string) {
+	now := time.Now()
+	os.Chtimes(filepath.Join(d.root, key), now, now)
+	os.Chtimes(ChecksumPath(d.root, key), now, now)
+}
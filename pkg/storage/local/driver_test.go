@@ -0,0 +1,90 @@
+package local
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
+)
+
+func newTestDriver(t *testing.T) *LocalDriver {
+	t.Helper()
+	t.Setenv("VC_LOCAL_ROOT", t.TempDir())
+	t.Setenv("VC_STORAGE_PREFIX", "")
+	d, err := New()
+	require.NoError(t, err)
+	return d
+}
+
+func checksumOf(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestPutAndGet(t *testing.T) {
+	d := newTestDriver(t)
+	ctx := context.Background()
+	checksum := checksumOf("artifact")
+
+	require.NoError(t, d.Put(ctx, "abc", strings.NewReader("artifact"), 8, strings.ToUpper(checksum)))
+
+	body, err := d.Get(ctx, "abc")
+	require.NoError(t, err)
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "artifact", string(data))
+	require.Implements(t, (*storage.Checksummed)(nil), body)
+	assert.Equal(t, checksum, body.(storage.Checksummed).Checksum())
+	_, seekable := body.(io.Seeker)
+	assert.True(t, seekable, "local artifacts should support range requests")
+
+	exists, err := d.Exists(ctx, "abc")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestPutRejectsChecksumMismatch(t *testing.T) {
+	d := newTestDriver(t)
+	ctx := context.Background()
+	require.NoError(t, d.Put(ctx, "abc", strings.NewReader("artifact"), 8, checksumOf("artifact")))
+
+	err := d.Put(ctx, "abc", strings.NewReader("corrupt"), 7, checksumOf("artifact"))
+	assert.ErrorIs(t, err, storage.ErrChecksumMismatch)
+
+	// The stored artifact is untouched and no upload is left behind.
+	body, err := d.Get(ctx, "abc")
+	require.NoError(t, err)
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "artifact", string(data))
+	leftovers, err := filepath.Glob(filepath.Join(d.Root(), ".upload-*"))
+	require.NoError(t, err)
+	assert.Empty(t, leftovers)
+}
+
+func TestGetMissing(t *testing.T) {
+	d := newTestDriver(t)
+	_, err := d.Get(context.Background(), "missing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestPutWithoutChecksumDropsStaleChecksum(t *testing.T) {
+	d := newTestDriver(t)
+	ctx := context.Background()
+	require.NoError(t, d.Put(ctx, "abc", strings.NewReader("artifact"), 8, checksumOf("artifact")))
+	require.NoError(t, d.Put(ctx, "abc", strings.NewReader("replaced"), 8, ""))
+
+	_, err := os.Stat(ChecksumPath(d.Root(), "abc"))
+	assert.True(t, os.IsNotExist(err))
+}
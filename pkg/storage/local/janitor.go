@@ -1,10 +1,13 @@
 package local
 
 import (
+	"context"
 	"log"
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
 )
 
 func (d *LocalDriver) StartJanitor(retentionPeriod time.Duration, interval time.Duration) {
@@ -22,22 +25,40 @@ func (d *LocalDriver) StartJanitor(retentionPeriod time.Duration, interval time.
 }
 
 func (d *LocalDriver) cleanup(retention time.Duration) error {
-	cutoff := time.Now().Add(-retention)
+	_, err := d.Prune(context.Background(), time.Now().Add(-retention))
+	return err
+}
 
-	return filepath.Walk(d.root, func(path string, info os.FileInfo, err error) error {
+// Prune deletes every artifact not written or read since cutoff, together
+// with its recorded checksum.
+func (d *LocalDriver) Prune(ctx context.Context, cutoff time.Time) (storage.PruneResult, error) {
+	var result storage.PruneResult
+	err := filepath.Walk(d.root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if info.IsDir() {
+			if info.Name() == checksumsDir {
+				return filepath.SkipDir
+			}
 			return nil
 		}
-		
+
 		if info.ModTime().Before(cutoff) {
 			if err := os.Remove(path); err != nil {
 				return err
 			}
+			if key, err := filepath.Rel(d.root, path); err == nil {
+				os.Remove(ChecksumPath(d.root, key))
+			}
+			result.Removed++
+			result.Bytes += info.Size()
 			log.Printf("Janitor: Deleted expired cache %s", info.Name())
 		}
 		return nil
 	})
+	return result, err
 }
@@ -0,0 +1,106 @@
+package local
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixedDriverStoresBelowPrefix(t *testing.T) {
+	base := t.TempDir()
+	t.Setenv("VC_LOCAL_ROOT", base)
+	t.Setenv("VC_STORAGE_PREFIX", "acme/web")
+	d, err := New()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(base, "acme", "web"), d.Root())
+
+	require.NoError(t, d.Put(context.Background(), "abc", strings.NewReader("artifact"), 8, checksumOf("artifact")))
+	data, err := os.ReadFile(filepath.Join(base, "acme", "web", "abc"))
+	require.NoError(t, err)
+	assert.Equal(t, "artifact", string(data))
+	_, err = os.Stat(filepath.Join(base, "abc"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestNewRejectsInvalidPrefix(t *testing.T) {
+	t.Setenv("VC_LOCAL_ROOT", t.TempDir())
+	t.Setenv("VC_STORAGE_PREFIX", "../escape")
+	_, err := New()
+	assert.Error(t, err)
+}
+
+func TestGetKeepsArtifactPastPrune(t *testing.T) {
+	d := newTestDriver(t)
+	ctx := context.Background()
+	require.NoError(t, d.Put(ctx, "abc", strings.NewReader("artifact"), 8, checksumOf("artifact")))
+	old := time.Now().Add(-48 * time.Hour)
+	for _, path := range []string{filepath.Join(d.Root(), "abc"), ChecksumPath(d.Root(), "abc")} {
+		require.NoError(t, os.Chtimes(path, old, old))
+	}
+
+	body, err := d.Get(ctx, "abc")
+	require.NoError(t, err)
+	body.Close()
+
+	result, err := d.Prune(ctx, time.Now().Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, result.Removed)
+	for _, path := range []string{filepath.Join(d.Root(), "abc"), ChecksumPath(d.Root(), "abc")} {
+		_, err := os.Stat(path)
+		assert.NoError(t, err, path)
+	}
+}
+
+func TestPruneRemovesChecksumWithArtifact(t *testing.T) {
+	d := newTestDriver(t)
+	ctx := context.Background()
+	require.NoError(t, d.Put(ctx, "abc", strings.NewReader("artifact"), 8, checksumOf("artifact")))
+	old := time.Now().Add(-48 * time.Hour)
+	for _, path := range []string{filepath.Join(d.Root(), "abc"), ChecksumPath(d.Root(), "abc")} {
+		require.NoError(t, os.Chtimes(path, old, old))
+	}
+
+	result, err := d.Prune(ctx, time.Now().Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Removed)
+	assert.Equal(t, int64(8), result.Bytes)
+	_, err = os.Stat(ChecksumPath(d.Root(), "abc"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPruneOnlyTouchesOwnPrefix(t *testing.T) {
+	base := t.TempDir()
+	t.Setenv("VC_LOCAL_ROOT", base)
+	old := time.Now().Add(-48 * time.Hour)
+	write := func(path string) {
+		t.Helper()
+		path = filepath.Join(base, filepath.FromSlash(path))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte("artifact"), 0o644))
+		require.NoError(t, os.Chtimes(path, old, old))
+	}
+	write("team-a/stale")
+	write("team-b/stale")
+	write("stale")
+
+	t.Setenv("VC_STORAGE_PREFIX", "team-a")
+	d, err := New()
+	require.NoError(t, err)
+	require.NoError(t, d.Put(context.Background(), "fresh", strings.NewReader("artifact"), 8, ""))
+
+	result, err := d.Prune(context.Background(), time.Now().Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Removed)
+	assert.Equal(t, int64(8), result.Bytes)
+
+	for path, kept := range map[string]bool{"team-a/stale": false, "team-a/fresh": true, "team-b/stale": true, "stale": true} {
+		_, err := os.Stat(filepath.Join(base, filepath.FromSlash(path)))
+		assert.Equal(t, kept, err == nil, path)
+	}
+}
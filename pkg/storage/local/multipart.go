@@ -0,0 +1,128 @@
+package local
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// uploadsDir holds the parts of unfinished multipart uploads. Abandoned
+// parts are removed by the janitor like any other stale file.
+const uploadsDir = ".uploads"
+
+// uploadChecksumFile records, inside an upload's directory, the checksum
+// the assembled artifact must match.
+const uploadChecksumFile = "sha256"
+
+// PartPath returns where the proxy stores part n (counting from 1) of an
+// upload. It rejects upload IDs that are not ones this driver issued.
+func PartPath(root, uploadID string, n int) (string, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return "", fmt.Errorf("invalid upload id %q", uploadID)
+	}
+	return filepath.Join(root, uploadsDir, uploadID, strconv.Itoa(n)), nil
+}
+
+// PartETag is the ETag the proxy returns for an uploaded part.
+func PartETag(sum []byte) string {
+	return `"` + hex.EncodeToString(sum) + `"`
+}
+
+// minPartExpiry is the shortest time part upload URLs stay valid, as large
+// uploads take a while.
+const minPartExpiry = time.Hour
+
+// StartMultipartUpload returns signed proxy URLs for each part of a new upload.
+func (d *LocalDriver) StartMultipartUpload(ctx context.Context, key string, parts int, checksum string) (string, []string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, fmt.Errorf("failed to generate upload id: %w", err)
+	}
+	uploadID := hex.EncodeToString(id)
+	dir := filepath.Join(d.root, uploadsDir, uploadID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	if checksum != "" {
+		if err := os.WriteFile(filepath.Join(dir, uploadChecksumFile), []byte(checksum), 0644); err != nil {
+			return "", nil, fmt.Errorf("failed to record checksum: %w", err)
+		}
+	}
+
+	expiry := max(d.signer.Expiry(), minPartExpiry)
+	urls := make([]string, parts)
+	for i := range urls {
+		path := fmt.Sprintf("/v1/proxy/blob/%s/parts/%s/%d", key, uploadID, i+1)
+		urls[i] = d.signer.SignFor(http.MethodPut, d.baseURL, path, expiry)
+	}
+	return uploadID, urls, nil
+}
+
+// CompleteMultipartUpload concatenates the parts into the artifact,
+// checking each against its ETag and the whole against the checksum given
+// when the upload started.
+func (d *LocalDriver) CompleteMultipartUpload(ctx context.Context, key, uploadID string, etags []string) error {
+	if _, err := PartPath(d.root, uploadID, 1); err != nil {
+		return err
+	}
+	dir := filepath.Join(d.root, uploadsDir, uploadID)
+
+	tmp, err := os.CreateTemp(dir, "assemble-*")
+	if err != nil {
+		return fmt.Errorf("failed to create artifact: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	whole := sha256.New()
+	for i, etag := range etags {
+		if err := ctx.Err(); err != nil {
+			tmp.Close()
+			return err
+		}
+		path, _ := PartPath(d.root, uploadID, i+1)
+		part, err := os.Open(path)
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to open part %d: %w", i+1, err)
+		}
+		sum := sha256.New()
+		_, err = io.Copy(io.MultiWriter(tmp, sum, whole), part)
+		part.Close()
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to copy part %d: %w", i+1, err)
+		}
+		if PartETag(sum.Sum(nil)) != etag {
+			tmp.Close()
+			return fmt.Errorf("part %d does not match its etag", i+1)
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write artifact: %w", err)
+	}
+
+	checksum, err := os.ReadFile(filepath.Join(dir, uploadChecksumFile))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read checksum: %w", err)
+	}
+	if len(checksum) > 0 {
+		if got := hex.EncodeToString(whole.Sum(nil)); got != string(checksum) {
+			return fmt.Errorf("artifact does not match its checksum")
+		}
+		if err := WriteChecksum(d.root, key, string(checksum)); err != nil {
+			return fmt.Errorf("failed to record checksum: %w", err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(d.root, key)); err != nil {
+		return fmt.Errorf("failed to store artifact: %w", err)
+	}
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,126 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// authenticator answers registry auth challenges: basic auth with the
+// configured credentials, or a bearer token fetched from the registry's
+// token service with them, as container tools do.
+type authenticator struct {
+	username string
+	password string
+	scope    string
+	client   *http.Client
+
+	mu    sync.Mutex
+	basic bool
+	token string
+}
+
+func (a *authenticator) authorize(req *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case a.token != "":
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	case a.basic:
+		req.SetBasicAuth(a.username, a.password)
+	}
+}
+
+// answer prepares the credentials challenge, the WWW-Authenticate header
+// of a 401 response, asks for.
+func (a *authenticator) answer(ctx context.Context, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if a.username == "" && a.password == "" {
+			return fmt.Errorf("registry requires credentials; set VC_OCI_USERNAME and VC_OCI_PASSWORD")
+		}
+		a.mu.Lock()
+		a.basic = true
+		a.mu.Unlock()
+		return nil
+	case "bearer":
+		token, err := a.fetchToken(ctx, params)
+		if err != nil {
+			return err
+		}
+		a.mu.Lock()
+		a.token = token
+		a.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("registry rejected the request (challenge %q)", challenge)
+}
+
+func (a *authenticator) fetchToken(ctx context.Context, params map[string]string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("registry sent an invalid token realm %q", params["realm"])
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", a.scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	if a.username != "" || a.password != "" {
+		req.SetBasicAuth(a.username, a.password)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch registry token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch registry token: unexpected status %s", resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode registry token: %w", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", fmt.Errorf("registry token service returned no token")
+}
+
+// parseChallenge splits a WWW-Authenticate header such as
+// `Bearer realm="https://auth.example.com/token",service="registry"` into
+// its scheme and parameters.
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := make(map[string]string)
+	for rest != "" {
+		var name, value string
+		name, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if name = strings.TrimSpace(name); name != "" {
+			params[strings.ToLower(name)] = value
+		}
+	}
+	return scheme, params
+}
@@ -0,0 +1,336 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
+)
+
+// Artifacts are pushed as OCI artifacts, one per cache key: a manifest
+// tagged with the key whose single layer is the artifact, as ORAS does.
+// Registries then handle their storage, replication and retention like
+// that of any image.
+const (
+	artifactType   = "application/vnd.velocity.cache.artifact.v1"
+	layerMediaType = "application/vnd.velocity.cache.artifact.layer.v1"
+	manifestType   = "application/vnd.oci.image.manifest.v1+json"
+	emptyMediaType = "application/vnd.oci.empty.v1+json"
+	// checksumAnnotation records an artifact's hex SHA-256 on its manifest.
+	checksumAnnotation = "dev.velocity.sha256"
+	titleAnnotation    = "org.opencontainers.image.title"
+)
+
+// emptyConfig is the config blob of artifacts, which have none.
+var emptyConfig = []byte("{}")
+
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]{0,127}$`)
+
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        descriptor        `json:"config"`
+	Layers        []descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Driver implements storage.Driver on a repository of an OCI registry. The
+// registry credentials stay on the server, so clients transfer artifacts
+// through its proxy routes.
+type Driver struct {
+	registry  string
+	name      string
+	serverURL string
+	signer    *storage.URLSigner
+	auth      *authenticator
+	client    *http.Client
+}
+
+// New creates a driver for the repository VC_OCI_REPOSITORY, such as
+// ghcr.io/acme/build-cache, authenticating with VC_OCI_USERNAME and
+// VC_OCI_PASSWORD when set. VC_STORAGE_PREFIX, such as "team-a/", selects
+// the repository below it, ghcr.io/acme/build-cache/team-a. VC_OCI_PLAIN_HTTP=true talks to the registry
+// without TLS. VC_BASE_URL is the public URL of this server, and proxy URLs
+// are signed as EnvURLSigner describes.
+func New() (*Driver, error) {
+	repository := os.Getenv("VC_OCI_REPOSITORY")
+	if repository == "" {
+		return nil, fmt.Errorf("VC_OCI_REPOSITORY is not set")
+	}
+	host, name, ok := strings.Cut(repository, "/")
+	if !ok || name == "" {
+		return nil, fmt.Errorf("invalid VC_OCI_REPOSITORY %q (expected <registry>/<repository>)", repository)
+	}
+	prefix, err := storage.EnvPrefix()
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" {
+		name += "/" + strings.ToLower(strings.TrimSuffix(prefix, "/"))
+	}
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	scheme := "https"
+	if os.Getenv("VC_OCI_PLAIN_HTTP") == "true" {
+		scheme = "http"
+	}
+	signer, err := storage.EnvURLSigner()
+	if err != nil {
+		return nil, err
+	}
+	serverURL := os.Getenv("VC_BASE_URL")
+	if serverURL == "" {
+		serverURL = "http://localhost:8080"
+	}
+	client := &http.Client{}
+	return &Driver{
+		registry:  scheme + "://" + host,
+		name:      name,
+		serverURL: strings.TrimSuffix(serverURL, "/"),
+		signer:    signer,
+		auth: &authenticator{
+			username: os.Getenv("VC_OCI_USERNAME"),
+			password: os.Getenv("VC_OCI_PASSWORD"),
+			scope:    "repository:" + name + ":pull,push",
+			client:   client,
+		},
+		client: client,
+	}, nil
+}
+
+// ProxiedByServer marks the driver's URLs as pointing back at the server.
+func (d *Driver) ProxiedByServer() {}
+
+// GetUploadURL returns the server's signed proxy URL for key.
+func (d *Driver) GetUploadURL(ctx context.Context, key string) (string, error) {
+	return d.signer.Sign(http.MethodPut, d.serverURL, "/v1/proxy/blob/"+key), nil
+}
+
+// GetDownloadURL returns the server's signed proxy URL for key.
+func (d *Driver) GetDownloadURL(ctx context.Context, key string) (string, error) {
+	return d.signer.Sign(http.MethodGet, d.serverURL, "/v1/proxy/blob/"+key), nil
+}
+
+// Exists reports whether the repository has a manifest tagged for key.
+func (d *Driver) Exists(ctx context.Context, key string) (bool, error) {
+	resp, err := d.do(ctx, http.MethodHead, d.manifestURL(key), nil, http.Header{"Accept": {manifestType}})
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode == http.StatusOK:
+		return true, nil
+	}
+	return false, fmt.Errorf("failed to check manifest: unexpected status %s", resp.Status)
+}
+
+// Put pushes body as the layer of an artifact tagged for key. Layers are
+// addressed by their digest, so body is spooled to a temporary file to be
+// hashed before it is sent. Layers the registry already holds are not
+// uploaded again.
+func (d *Driver) Put(ctx context.Context, key string, body io.Reader, size int64, checksum string) error {
+	file, err := storage.Spool(body, checksum)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	sum := sha256.New()
+	_, err = io.Copy(sum, file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to hash artifact: %w", err)
+	}
+	layer := descriptor{
+		MediaType:   layerMediaType,
+		Digest:      "sha256:" + hex.EncodeToString(sum.Sum(nil)),
+		Size:        file.Size,
+		Annotations: map[string]string{titleAnnotation: key},
+	}
+	configSum := sha256.Sum256(emptyConfig)
+	config := descriptor{MediaType: emptyMediaType, Digest: "sha256:" + hex.EncodeToString(configSum[:]), Size: int64(len(emptyConfig))}
+
+	if err := d.pushBlob(ctx, config, bytes.NewReader(emptyConfig)); err != nil {
+		return err
+	}
+	if err := d.pushBlob(ctx, layer, file); err != nil {
+		return err
+	}
+
+	m := manifest{SchemaVersion: 2, MediaType: manifestType, ArtifactType: artifactType, Config: config, Layers: []descriptor{layer}}
+	if checksum != "" {
+		m.Annotations = map[string]string{checksumAnnotation: checksum}
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	resp, err := d.do(ctx, http.MethodPut, d.manifestURL(key), bytes.NewReader(data), http.Header{"Content-Type": {manifestType}})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to push manifest: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Get pulls the layer of the artifact tagged for key.
+func (d *Driver) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := d.do(ctx, http.MethodGet, d.manifestURL(key), nil, http.Header{"Accept": {manifestType}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, storage.ErrNotFound
+	default:
+		return nil, fmt.Errorf("failed to pull manifest: unexpected status %s", resp.Status)
+	}
+	var m manifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	if len(m.Layers) != 1 || m.Layers[0].MediaType != layerMediaType {
+		return nil, fmt.Errorf("manifest for %s is not a velocity cache artifact", key)
+	}
+
+	blob, err := d.do(ctx, http.MethodGet, d.blobURL(m.Layers[0].Digest), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if blob.StatusCode != http.StatusOK {
+		blob.Body.Close()
+		return nil, fmt.Errorf("failed to pull layer: unexpected status %s", blob.Status)
+	}
+	return blob.Body, nil
+}
+
+// pushBlob uploads body as the blob desc describes unless the registry has
+// it already.
+func (d *Driver) pushBlob(ctx context.Context, desc descriptor, body io.ReadSeeker) error {
+	resp, err := d.do(ctx, http.MethodHead, d.blobURL(desc.Digest), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	resp, err = d.do(ctx, http.MethodPost, d.registry+"/v2/"+d.name+"/blobs/uploads/", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("failed to start blob upload: unexpected status %s", resp.Status)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		return fmt.Errorf("failed to start blob upload: %w", err)
+	}
+	query := location.Query()
+	query.Set("digest", desc.Digest)
+	location.RawQuery = query.Encode()
+
+	resp, err = d.do(ctx, http.MethodPut, location.String(), body, http.Header{"Content-Type": {"application/octet-stream"}})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("failed to upload blob: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (d *Driver) manifestURL(key string) string {
+	return d.registry + "/v2/" + d.name + "/manifests/" + tagFor(key)
+}
+
+func (d *Driver) blobURL(digest string) string {
+	return d.registry + "/v2/" + d.name + "/blobs/" + url.PathEscape(digest)
+}
+
+// tagFor returns the tag of the artifact for key: the key itself when it
+// is a valid tag, its SHA-256 otherwise.
+func tagFor(key string) string {
+	if tagPattern.MatchString(key) {
+		return key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "sha256-" + hex.EncodeToString(sum[:])
+}
+
+// do sends a request to the registry, authenticating and retrying once
+// when challenged. body, if set, is rewound for the retry.
+func (d *Driver) do(ctx context.Context, method, target string, body io.ReadSeeker, header http.Header) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		var reqBody io.Reader
+		var size int64
+		if body != nil {
+			var err error
+			if size, err = body.Seek(0, io.SeekEnd); err == nil {
+				_, err = body.Seek(0, io.SeekStart)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body: %w", err)
+			}
+			// The client closes request bodies, which would remove a
+			// spooled layer before it could be sent again.
+			reqBody = io.NopCloser(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, target, reqBody)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		if body != nil {
+			req.ContentLength = size
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		d.auth.authorize(req)
+
+		resp, err := d.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to %s %s: %w", strings.ToLower(method), target, err)
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := d.auth.answer(ctx, challenge); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
+)
+
+// fakeRegistry is an in-memory registry serving the parts of the
+// distribution API the driver uses, behind token auth.
+type fakeRegistry struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   int
+	scopes    []string
+	// challengeUploads makes the registry challenge the first upload of
+	// each blob, as when a token expires mid-push.
+	challengeUploads bool
+	challenged       map[string]bool
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	reg := &fakeRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}, challenged: map[string]bool{}}
+	reg.Server = httptest.NewServer(reg)
+	t.Cleanup(reg.Close)
+	return reg
+}
+
+func (reg *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if r.URL.Path == "/token" {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "ci" || pass != "hunter2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		reg.scopes = append(reg.scopes, r.URL.Query().Get("scope"))
+		json.NewEncoder(w).Encode(map[string]string{"token": "tok"})
+		return
+	}
+	challenge := reg.challengeUploads && r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/blobs/uploads/") &&
+		!reg.challenged[r.URL.Query().Get("digest")]
+	if challenge {
+		reg.challenged[r.URL.Query().Get("digest")] = true
+	}
+	if challenge || r.Header.Get("Authorization") != "Bearer tok" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, reg.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case strings.Contains(path, "/blobs/uploads/") && r.Method == http.MethodPost:
+		w.Header().Set("Location", "/v2/"+path+"session")
+		w.WriteHeader(http.StatusAccepted)
+	case strings.Contains(path, "/blobs/uploads/") && r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(data)
+		digest := r.URL.Query().Get("digest")
+		if digest != "sha256:"+hex.EncodeToString(sum[:]) {
+			http.Error(w, "digest mismatch", http.StatusBadRequest)
+			return
+		}
+		reg.blobs[digest] = data
+		reg.uploads++
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(path, "/blobs/"):
+		_, digest, _ := strings.Cut(path, "/blobs/")
+		data, ok := reg.blobs[digest]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case strings.Contains(path, "/manifests/"):
+		if r.Method == http.MethodPut {
+			data, _ := io.ReadAll(r.Body)
+			reg.manifests[path] = data
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		data, ok := reg.manifests[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", manifestType)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// manifest returns the manifest pushed for tag in the repository name.
+func (reg *fakeRegistry) manifest(t *testing.T, name, tag string) manifest {
+	t.Helper()
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	data, ok := reg.manifests[name+"/manifests/"+tag]
+	require.True(t, ok, "no manifest tagged %s in %s", tag, name)
+	var m manifest
+	require.NoError(t, json.Unmarshal(data, &m))
+	return m
+}
+
+func newTestDriver(t *testing.T, reg *fakeRegistry, prefix string) *Driver {
+	t.Helper()
+	t.Setenv("VC_OCI_REPOSITORY", strings.TrimPrefix(reg.URL, "http://")+"/acme/cache")
+	t.Setenv("VC_OCI_PLAIN_HTTP", "true")
+	t.Setenv("VC_OCI_USERNAME", "ci")
+	t.Setenv("VC_OCI_PASSWORD", "hunter2")
+	t.Setenv("VC_STORAGE_PREFIX", prefix)
+	d, err := New()
+	require.NoError(t, err)
+	return d
+}
+
+func checksumOf(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestPutGetAndExists(t *testing.T) {
+	reg := newFakeRegistry(t)
+	d := newTestDriver(t, reg, "")
+	ctx := context.Background()
+
+	exists, err := d.Exists(ctx, "abc")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, d.Put(ctx, "abc", strings.NewReader("artifact"), 8, checksumOf("artifact")))
+
+	exists, err = d.Exists(ctx, "abc")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	body, err := d.Get(ctx, "abc")
+	require.NoError(t, err)
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "artifact", string(data))
+
+	m := reg.manifest(t, "acme/cache", "abc")
+	assert.Equal(t, artifactType, m.ArtifactType)
+	require.Len(t, m.Layers, 1)
+	assert.Equal(t, "sha256:"+checksumOf("artifact"), m.Layers[0].Digest)
+	assert.Equal(t, int64(8), m.Layers[0].Size)
+	assert.Equal(t, checksumOf("artifact"), m.Annotations[checksumAnnotation])
+	assert.Equal(t, []string{"repository:acme/cache:pull,push"}, reg.scopes)
+}
+
+func TestGetMissing(t *testing.T) {
+	reg := newFakeRegistry(t)
+	d := newTestDriver(t, reg, "")
+	_, err := d.Get(context.Background(), "missing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestPutRejectsChecksumMismatch(t *testing.T) {
+	reg := newFakeRegistry(t)
+	d := newTestDriver(t, reg, "")
+	err := d.Put(context.Background(), "abc", strings.NewReader("corrupt"), 7, checksumOf("artifact"))
+	assert.ErrorIs(t, err, storage.ErrChecksumMismatch)
+	assert.Empty(t, reg.manifests)
+}
+
+func TestPutSkipsBlobsTheRegistryHas(t *testing.T) {
+	reg := newFakeRegistry(t)
+	d := newTestDriver(t, reg, "")
+	ctx := context.Background()
+
+	require.NoError(t, d.Put(ctx, "abc", strings.NewReader("artifact"), 8, ""))
+	require.NoError(t, d.Put(ctx, "abd", strings.NewReader("artifact"), 8, ""))
+	// The config and the layer, each uploaded once.
+	assert.Equal(t, 2, reg.uploads)
+}
+
+func TestKeysThatAreNotTagsAreHashed(t *testing.T) {
+	reg := newFakeRegistry(t)
+	d := newTestDriver(t, reg, "")
+	ctx := context.Background()
+	key := "turbo-team/" + strings.Repeat("a", 200)
+
+	require.NoError(t, d.Put(ctx, key, strings.NewReader("artifact"), 8, ""))
+	reg.manifest(t, "acme/cache", tagFor(key))
+	assert.True(t, strings.HasPrefix(tagFor(key), "sha256-"))
+
+	exists, err := d.Exists(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestPrefixSelectsNestedRepository(t *testing.T) {
+	reg := newFakeRegistry(t)
+	d := newTestDriver(t, reg, "Team-A/")
+	require.NoError(t, d.Put(context.Background(), "abc", strings.NewReader("artifact"), 8, ""))
+	reg.manifest(t, "acme/cache/team-a", "abc")
+}
+
+func TestPutRetriesChallengedUploads(t *testing.T) {
+	reg := newFakeRegistry(t)
+	reg.challengeUploads = true
+	d := newTestDriver(t, reg, "")
+	ctx := context.Background()
+
+	require.NoError(t, d.Put(ctx, "abc", strings.NewReader("artifact"), 8, ""))
+	assert.Equal(t, []byte("artifact"), reg.blobs["sha256:"+checksumOf("artifact")])
+}
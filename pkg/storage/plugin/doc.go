@@ -0,0 +1,42 @@
+// Package plugin loads storage drivers shipped as separate executables, so
+// that stores the server does not support out of the box can be added
+// without forking it. The server is started with
+// VC_STORAGE_DRIVER=plugin:<path> and runs <path> once, for as long as it
+// runs itself, restarting it should it exit.
+//
+// The two talk over the plugin's stdin and stdout, one JSON object per
+// line. Requests carry an id, which the response to them repeats; a plugin
+// may answer requests in any order and should handle several at once.
+// Anything the plugin writes to stderr ends up in the server's log.
+// Clients transfer artifacts through the server's proxy routes, so plugins
+// never hand out URLs of their own.
+//
+//	-> {"id":1,"method":"handshake","protocol":1}
+//	<- {"id":1,"protocol":1,"capabilities":["prune"]}
+//	-> {"id":2,"method":"exists","key":"abc"}
+//	<- {"id":2,"exists":false}
+//	-> {"id":3,"method":"put","key":"abc","path":"/tmp/velocity-plugin-1","checksum":"9f86..."}
+//	<- {"id":3}
+//	-> {"id":4,"method":"get","key":"abc","path":"/tmp/velocity-plugin-2"}
+//	<- {"id":4,"found":true}
+//
+// The methods are:
+//
+//   - handshake, sent first, answered with the protocol version the plugin
+//     speaks and its optional capabilities.
+//   - exists reports whether key has an artifact.
+//   - put stores the file at path under key. checksum, if set, is its hex
+//     SHA-256, already verified by the server.
+//   - get writes the artifact for key to the file at path, answering found
+//     false when there is none.
+//   - prune, with the "prune" capability, removes artifacts last used
+//     before cutoff, in Unix seconds, answering removed and bytes. Only
+//     keys starting with prefix, if set, are to be removed.
+//
+// With VC_STORAGE_PREFIX set, keys start with that prefix, such as
+// "team-a/".
+//
+// A failed request is answered with an error message instead:
+//
+//	<- {"id":5,"error":"bucket is read-only"}
+package plugin
@@ -0,0 +1,313 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
+)
+
+// protocolVersion is the version of the plugin protocol spoken here.
+const protocolVersion = 1
+
+type request struct {
+	ID       uint64 `json:"id"`
+	Method   string `json:"method"`
+	Protocol int    `json:"protocol,omitempty"`
+	Key      string `json:"key,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+	Cutoff   int64  `json:"cutoff,omitempty"`
+	Prefix   string `json:"prefix,omitempty"`
+}
+
+type response struct {
+	ID           uint64   `json:"id"`
+	Error        string   `json:"error,omitempty"`
+	Protocol     int      `json:"protocol,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	Exists       bool     `json:"exists,omitempty"`
+	Found        bool     `json:"found,omitempty"`
+	Removed      int      `json:"removed,omitempty"`
+	Bytes        int64    `json:"bytes,omitempty"`
+}
+
+// Driver implements storage.Driver by forwarding to a plugin executable.
+type Driver struct {
+	path      string
+	prefix    string
+	serverURL string
+	signer    *storage.URLSigner
+
+	mu      sync.Mutex
+	proc    *process
+	nextID  uint64
+	started bool
+}
+
+// process is one run of the plugin.
+type process struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	pending map[uint64]chan response
+	err     error
+}
+
+// New starts the plugin at path and checks that it speaks this protocol.
+// Keys are sent to it below VC_STORAGE_PREFIX. VC_BASE_URL is the public
+// URL of this server, and proxy URLs are signed as EnvURLSigner describes.
+func New(path string) (storage.Driver, error) {
+	prefix, err := storage.EnvPrefix()
+	if err != nil {
+		return nil, err
+	}
+	signer, err := storage.EnvURLSigner()
+	if err != nil {
+		return nil, err
+	}
+	serverURL := os.Getenv("VC_BASE_URL")
+	if serverURL == "" {
+		serverURL = "http://localhost:8080"
+	}
+	d := &Driver{path: path, prefix: prefix, serverURL: strings.TrimSuffix(serverURL, "/"), signer: signer}
+	resp, err := d.call(context.Background(), request{Method: "handshake", Protocol: protocolVersion})
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", path, err)
+	}
+	if resp.Protocol != protocolVersion {
+		return nil, fmt.Errorf("plugin %s speaks protocol %d, expected %d", path, resp.Protocol, protocolVersion)
+	}
+	if slices.Contains(resp.Capabilities, "prune") {
+		return &pruningDriver{d}, nil
+	}
+	return d, nil
+}
+
+// ProxiedByServer marks the driver's URLs as pointing back at the server.
+// Plugins hold their own credentials, so clients transfer artifacts through
+// the server's proxy routes.
+func (d *Driver) ProxiedByServer() {}
+
+// GetUploadURL returns the server's signed proxy URL for key.
+func (d *Driver) GetUploadURL(ctx context.Context, key string) (string, error) {
+	return d.signer.Sign(http.MethodPut, d.serverURL, "/v1/proxy/blob/"+key), nil
+}
+
+// GetDownloadURL returns the server's signed proxy URL for key.
+func (d *Driver) GetDownloadURL(ctx context.Context, key string) (string, error) {
+	return d.signer.Sign(http.MethodGet, d.serverURL, "/v1/proxy/blob/"+key), nil
+}
+
+func (d *Driver) Exists(ctx context.Context, key string) (bool, error) {
+	resp, err := d.call(ctx, request{Method: "exists", Key: d.prefix + key})
+	if err != nil {
+		return false, err
+	}
+	return resp.Exists, nil
+}
+
+// Put hands the plugin body as a file, spooling it to one first.
+func (d *Driver) Put(ctx context.Context, key string, body io.Reader, size int64, checksum string) error {
+	file, err := storage.Spool(body, checksum)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = d.call(ctx, request{Method: "put", Key: d.prefix + key, Path: file.Name(), Checksum: checksum})
+	return err
+}
+
+// Get has the plugin write the artifact for key to a temporary file, which
+// is removed once the returned reader is closed.
+func (d *Driver) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	tmp, err := os.CreateTemp("", "velocity-plugin-*")
+	if err != nil {
+		return nil, fmt.Errorf("create temp file: %w", err)
+	}
+	tmp.Close()
+	resp, err := d.call(ctx, request{Method: "get", Key: d.prefix + key, Path: tmp.Name()})
+	if err == nil && !resp.Found {
+		err = storage.ErrNotFound
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	file, err := os.Open(tmp.Name())
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("open plugin download: %w", err)
+	}
+	return &tempFile{File: file}, nil
+}
+
+// pruningDriver is a Driver whose plugin can prune.
+type pruningDriver struct {
+	*Driver
+}
+
+func (d *pruningDriver) Prune(ctx context.Context, cutoff time.Time) (storage.PruneResult, error) {
+	resp, err := d.call(ctx, request{Method: "prune", Cutoff: cutoff.Unix(), Prefix: d.prefix})
+	if err != nil {
+		return storage.PruneResult{}, err
+	}
+	return storage.PruneResult{Removed: resp.Removed, Bytes: resp.Bytes}, nil
+}
+
+// tempFile removes the file once closed.
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// call sends req to the plugin, starting it if it is not running, and waits
+// for its response.
+func (d *Driver) call(ctx context.Context, req request) (response, error) {
+	d.mu.Lock()
+	proc := d.proc
+	if proc == nil || proc.exited() != nil {
+		if d.started {
+			log.Printf("Restarting storage plugin %s: %v", d.path, proc.exited())
+		}
+		var err error
+		if proc, err = startProcess(d.path); err != nil {
+			d.mu.Unlock()
+			return response{}, err
+		}
+		d.proc, d.started = proc, true
+	}
+	d.nextID++
+	req.ID = d.nextID
+	d.mu.Unlock()
+
+	wait, err := proc.send(req)
+	if err != nil {
+		return response{}, err
+	}
+	select {
+	case resp, ok := <-wait:
+		if !ok {
+			return response{}, fmt.Errorf("storage plugin exited: %w", proc.exited())
+		}
+		if resp.Error != "" {
+			return response{}, fmt.Errorf("storage plugin: %s", resp.Error)
+		}
+		return resp, nil
+	case <-ctx.Done():
+		proc.forget(req.ID)
+		return response{}, ctx.Err()
+	}
+}
+
+func startProcess(path string) (*process, error) {
+	cmd := exec.Command(path)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("start storage plugin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("start storage plugin: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start storage plugin: %w", err)
+	}
+	p := &process{cmd: cmd, stdin: stdin, pending: make(map[uint64]chan response)}
+	go p.read(stdout)
+	return p, nil
+}
+
+func (p *process) send(req request) (<-chan response, error) {
+	line, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	wait := make(chan response, 1)
+	p.mu.Lock()
+	if p.err != nil {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("storage plugin exited: %w", p.err)
+	}
+	p.pending[req.ID] = wait
+	p.mu.Unlock()
+
+	p.writeMu.Lock()
+	_, err = p.stdin.Write(append(line, '\n'))
+	p.writeMu.Unlock()
+	if err != nil {
+		p.forget(req.ID)
+		return nil, fmt.Errorf("write to storage plugin: %w", err)
+	}
+	return wait, nil
+}
+
+func (p *process) forget(id uint64) {
+	p.mu.Lock()
+	delete(p.pending, id)
+	p.mu.Unlock()
+}
+
+// read delivers the plugin's responses until it exits, then fails the
+// requests still waiting.
+func (p *process) read(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var resp response
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			log.Printf("Storage plugin sent an invalid response: %v", err)
+			continue
+		}
+		p.mu.Lock()
+		wait, ok := p.pending[resp.ID]
+		delete(p.pending, resp.ID)
+		p.mu.Unlock()
+		if ok {
+			wait <- resp
+		}
+	}
+
+	err := p.cmd.Wait()
+	if err == nil {
+		err = errors.New("plugin closed its output")
+	}
+	p.mu.Lock()
+	p.err = err
+	for id, wait := range p.pending {
+		close(wait)
+		delete(p.pending, id)
+	}
+	p.mu.Unlock()
+	p.stdin.Close()
+}
+
+// exited returns why the plugin exited, or nil while it runs.
+func (p *process) exited() error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
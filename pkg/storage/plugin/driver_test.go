@@ -0,0 +1,285 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
+)
+
+// The test binary doubles as a plugin: run with VC_TEST_PLUGIN_DIR set, it
+// serves the protocol over stdin and stdout, storing artifacts in that
+// directory. Keys steer it: "crash" makes it exit, "fail" makes it answer
+// with an error and "slow" delays its answer past later requests'.
+func TestMain(m *testing.M) {
+	if dir := os.Getenv("VC_TEST_PLUGIN_DIR"); dir != "" {
+		servePlugin(dir)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func servePlugin(dir string) {
+	var mu sync.Mutex
+	out := json.NewEncoder(os.Stdout)
+	answer := func(resp response) {
+		mu.Lock()
+		defer mu.Unlock()
+		out.Encode(resp)
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	var wg sync.WaitGroup
+	for scanner.Scan() {
+		var req request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			os.Exit(2)
+		}
+		switch strings.TrimPrefix(req.Key, os.Getenv("VC_STORAGE_PREFIX")) {
+		case "crash":
+			os.Exit(3)
+		case "fail":
+			answer(response{ID: req.ID, Error: "bucket is read-only"})
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if strings.HasSuffix(req.Key, "slow") {
+				time.Sleep(200 * time.Millisecond)
+			}
+			answer(handlePlugin(dir, req))
+		}()
+	}
+	wg.Wait()
+}
+
+func handlePlugin(dir string, req request) response {
+	resp := response{ID: req.ID}
+	path := filepath.Join(dir, url.PathEscape(req.Key))
+	fail := func(err error) response {
+		return response{ID: req.ID, Error: err.Error()}
+	}
+	switch req.Method {
+	case "handshake":
+		resp.Protocol = protocolVersion
+		if v := os.Getenv("VC_TEST_PLUGIN_PROTOCOL"); v != "" {
+			resp.Protocol, _ = strconv.Atoi(v)
+		}
+		resp.Capabilities = []string{"prune"}
+	case "exists":
+		_, err := os.Stat(path)
+		resp.Exists = err == nil
+	case "put":
+		data, err := os.ReadFile(req.Path)
+		if err == nil {
+			err = os.WriteFile(path, data, 0o644)
+		}
+		if err == nil {
+			err = os.WriteFile(path+".sha256", []byte(req.Checksum), 0o644)
+		}
+		if err != nil {
+			return fail(err)
+		}
+	case "get":
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			return resp
+		}
+		if err == nil {
+			err = os.WriteFile(req.Path, data, 0o644)
+		}
+		if err != nil {
+			return fail(err)
+		}
+		resp.Found = true
+	case "prune":
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			key, _ := url.PathUnescape(entry.Name())
+			info, err := entry.Info()
+			if err != nil || !strings.HasPrefix(key, req.Prefix) || strings.HasSuffix(key, ".sha256") || info.ModTime().Unix() >= req.Cutoff {
+				continue
+			}
+			os.Remove(filepath.Join(dir, entry.Name()))
+			resp.Removed++
+			resp.Bytes += info.Size()
+		}
+	default:
+		return fail(fmt.Errorf("unknown method %q", req.Method))
+	}
+	return resp
+}
+
+// newTestDriver starts the test binary as a plugin storing artifacts in a
+// temporary directory, which it returns.
+func newTestDriver(t *testing.T, prefix string) (storage.Driver, string) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("VC_TEST_PLUGIN_DIR", dir)
+	t.Setenv("VC_STORAGE_PREFIX", prefix)
+	d, err := New(os.Args[0])
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if proc := driverOf(d).proc; proc != nil {
+			proc.stdin.Close()
+		}
+	})
+	return d, dir
+}
+
+func driverOf(d storage.Driver) *Driver {
+	if p, ok := d.(*pruningDriver); ok {
+		return p.Driver
+	}
+	return d.(*Driver)
+}
+
+func TestPutGetAndExists(t *testing.T) {
+	d, dir := newTestDriver(t, "")
+	ctx := context.Background()
+
+	exists, err := d.Exists(ctx, "abc")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, d.Put(ctx, "abc", strings.NewReader("artifact"), 8, ""))
+	exists, err = d.Exists(ctx, "abc")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	body, err := d.Get(ctx, "abc")
+	require.NoError(t, err)
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "artifact", string(data))
+	download := body.(*tempFile).Name()
+	require.NoError(t, body.Close())
+	_, err = os.Stat(download)
+	assert.True(t, os.IsNotExist(err), "the download should be removed once closed")
+
+	_, err = d.Get(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	_, err = os.Stat(filepath.Join(dir, "abc"))
+	assert.NoError(t, err)
+}
+
+func TestPutSendsChecksum(t *testing.T) {
+	d, dir := newTestDriver(t, "")
+	checksum := "5d5f5f0a4b7c3f5e0f3c2f2c0c3d1d1e7b8f1a8b1c9f7d5e3b1a9c7e5d3b1a9c"
+
+	err := d.Put(context.Background(), "abc", strings.NewReader("artifact"), 8, checksum)
+	assert.ErrorIs(t, err, storage.ErrChecksumMismatch, "the artifact is checked before the plugin sees it")
+
+	require.NoError(t, d.Put(context.Background(), "abc", strings.NewReader(""), 0,
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"))
+	sent, err := os.ReadFile(filepath.Join(dir, "abc.sha256"))
+	require.NoError(t, err)
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", string(sent))
+}
+
+func TestPluginErrorsArePropagated(t *testing.T) {
+	d, _ := newTestDriver(t, "")
+	_, err := d.Exists(context.Background(), "fail")
+	require.Error(t, err)
+	assert.Equal(t, "storage plugin: bucket is read-only", err.Error())
+
+	// The plugin keeps serving other requests.
+	_, err = d.Exists(context.Background(), "abc")
+	assert.NoError(t, err)
+}
+
+func TestResponsesMayArriveOutOfOrder(t *testing.T) {
+	d, _ := newTestDriver(t, "")
+	ctx := context.Background()
+	require.NoError(t, d.Put(ctx, "abc", strings.NewReader("artifact"), 8, ""))
+
+	slow := make(chan error, 1)
+	go func() {
+		_, err := d.Exists(ctx, "slow")
+		slow <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	exists, err := d.Exists(ctx, "abc")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	select {
+	case <-slow:
+		t.Fatal("the slow request should still be waiting")
+	default:
+	}
+	assert.NoError(t, <-slow)
+}
+
+func TestPluginIsRestartedAfterExiting(t *testing.T) {
+	d, _ := newTestDriver(t, "")
+	ctx := context.Background()
+	require.NoError(t, d.Put(ctx, "abc", strings.NewReader("artifact"), 8, ""))
+
+	_, err := d.Exists(ctx, "crash")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "storage plugin exited")
+
+	exists, err := d.Exists(ctx, "abc")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestCanceledRequestsStopWaiting(t *testing.T) {
+	d, _ := newTestDriver(t, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := d.Exists(ctx, "slow")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The late answer is dropped and later requests still work.
+	time.Sleep(250 * time.Millisecond)
+	_, err = d.Exists(context.Background(), "abc")
+	assert.NoError(t, err)
+}
+
+func TestHandshakeRejectsOtherProtocols(t *testing.T) {
+	t.Setenv("VC_TEST_PLUGIN_DIR", t.TempDir())
+	t.Setenv("VC_TEST_PLUGIN_PROTOCOL", "2")
+	_, err := New(os.Args[0])
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "speaks protocol 2, expected 1")
+}
+
+func TestNewFailsForMissingPlugin(t *testing.T) {
+	_, err := New(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestPrefixAndPrune(t *testing.T) {
+	d, dir := newTestDriver(t, "team-a/")
+	ctx := context.Background()
+	require.NoError(t, d.Put(ctx, "abc", strings.NewReader("artifact"), 8, ""))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, url.PathEscape("team-b/abc")), []byte("other"), 0o644))
+	_, err := os.Stat(filepath.Join(dir, url.PathEscape("team-a/abc")))
+	require.NoError(t, err, "keys should be sent below the prefix")
+
+	pruner, ok := d.(storage.Pruner)
+	require.True(t, ok)
+	result, err := pruner.Prune(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, storage.PruneResult{Removed: 1, Bytes: 8}, result)
+
+	_, err = os.Stat(filepath.Join(dir, url.PathEscape("team-b/abc")))
+	assert.NoError(t, err, "artifacts of other prefixes should be kept")
+}
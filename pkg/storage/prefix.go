@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var prefixSegmentPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// EnvPrefix returns the namespace VC_STORAGE_PREFIX sets, such as
+// "team-a/" or "acme/web/", for drivers to store keys under. Servers
+// sharing one bucket or directory keep apart by using different prefixes,
+// and prune only artifacts under their own.
+func EnvPrefix() (string, error) {
+	prefix, err := CleanPrefix(os.Getenv("VC_STORAGE_PREFIX"))
+	if err != nil {
+		return "", fmt.Errorf("invalid VC_STORAGE_PREFIX: %w", err)
+	}
+	return prefix, nil
+}
+
+// CleanPrefix checks that prefix is a relative path of plain names and
+// returns it ending in "/", or empty for no prefix.
+func CleanPrefix(prefix string) (string, error) {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return "", nil
+	}
+	for _, segment := range strings.Split(prefix, "/") {
+		if !prefixSegmentPattern.MatchString(segment) || segment == "." || segment == ".." {
+			return "", fmt.Errorf("%q is not a path of names made of letters, digits, '.', '_' and '-'", prefix)
+		}
+	}
+	return prefix + "/", nil
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanPrefix(t *testing.T) {
+	cases := []struct {
+		prefix  string
+		want    string
+		wantErr bool
+	}{
+		{prefix: "", want: ""},
+		{prefix: "/", want: ""},
+		{prefix: "team-a", want: "team-a/"},
+		{prefix: "/team-a/", want: "team-a/"},
+		{prefix: "acme/web_1.2", want: "acme/web_1.2/"},
+		{prefix: "acme//web", wantErr: true},
+		{prefix: "acme/../web", wantErr: true},
+		{prefix: "./acme", wantErr: true},
+		{prefix: "team a", wantErr: true},
+		{prefix: `acme\web`, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.prefix, func(t *testing.T) {
+			got, err := CleanPrefix(tc.prefix)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestEnvPrefix(t *testing.T) {
+	t.Setenv("VC_STORAGE_PREFIX", "team-a")
+	prefix, err := EnvPrefix()
+	require.NoError(t, err)
+	assert.Equal(t, "team-a/", prefix)
+
+	t.Setenv("VC_STORAGE_PREFIX", "../team-a")
+	_, err = EnvPrefix()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid VC_STORAGE_PREFIX")
+}
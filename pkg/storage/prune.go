@@ -0,0 +1,18 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// PruneResult reports what a Pruner removed.
+type PruneResult struct {
+	Removed int   `json:"removed"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// Pruner is implemented by drivers that can delete artifacts last written or
+// used before a cutoff.
+type Pruner interface {
+	Prune(ctx context.Context, cutoff time.Time) (PruneResult, error)
+}
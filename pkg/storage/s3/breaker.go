@@ -0,0 +1,64 @@
+package s3
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
+)
+
+// breaker stops sending requests to a bucket that keeps failing, so that a
+// wedged object store fails each request at once instead of holding it for
+// its timeout. After threshold consecutive failures it opens for cooldown,
+// then lets a single request through to probe whether the store recovered.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// allow returns storage.ErrUnavailable while the breaker is open.
+func (b *breaker) allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if now.Before(b.openUntil) {
+		return storage.ErrUnavailable
+	}
+	if b.failures >= b.threshold {
+		// Keep other requests out while this one probes.
+		b.openUntil = now.Add(b.cooldown)
+	}
+	return nil
+}
+
+// record counts the outcome of a request allow let through.
+func (b *breaker) record(failed bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		if b.failures >= b.threshold {
+			log.Printf("S3 bucket is reachable again")
+		}
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		if b.failures == b.threshold {
+			log.Printf("S3 bucket failed %d requests in a row; failing requests for %s", b.failures, b.cooldown)
+		}
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
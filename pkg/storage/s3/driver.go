@@ -2,21 +2,105 @@ package s3
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
+)
+
+// checksumMetadataKey names the user metadata holding an artifact's hex
+// SHA-256; S3 returns it as the X-Amz-Meta-Sha256 header.
+const checksumMetadataKey = "sha256"
+
+// defaultPresignExpiry is how long presigned URLs stay valid unless
+// configured otherwise. Multipart part URLs stay valid for at least
+// minPartExpiry, as large uploads take a while.
+const (
+	defaultPresignExpiry = 15 * time.Minute
+	minPartExpiry        = time.Hour
+)
+
+// Defaults for requests the server makes itself. Transfers of artifacts get
+// longer than requests about them.
+const (
+	defaultTimeout          = 30 * time.Second
+	defaultTransferTimeout  = 10 * time.Minute
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
 )
 
 type S3Driver struct {
 	client        *s3.Client
 	presignClient *s3.PresignClient
 	bucket        string
+	prefix        string
+	expiry        time.Duration
+
+	timeout         time.Duration
+	transferTimeout time.Duration
+	breaker         *breaker
 }
 
+// Options selects the bucket a driver stores artifacts in. Credentials come
+// from the standard AWS sources: environment, shared config or instance
+// role.
+type Options struct {
+	Bucket string
+	// Region may be left empty to take it from the AWS configuration.
+	Region string
+	// Endpoint is the URL of an S3-compatible service such as R2, MinIO or
+	// Google Cloud Storage.
+	Endpoint string
+	// Prefix is prepended to every object key.
+	Prefix string
+	// VirtualHosted addresses the bucket as a subdomain of the endpoint
+	// instead of as the first segment of the path. AWS prefers it; MinIO and
+	// most self-hosted services only understand the path style.
+	VirtualHosted bool
+	// PresignExpiry is how long presigned URLs stay valid. Zero uses 15
+	// minutes.
+	PresignExpiry time.Duration
+
+	// RetryMode is the AWS SDK retry mode, "standard" or "adaptive". Empty
+	// keeps the SDK's configuration.
+	RetryMode string
+	// MaxAttempts is the number of tries per request, including the first.
+	// Zero keeps the SDK's configuration.
+	MaxAttempts int
+	// Timeout bounds each request that does not transfer an artifact, such
+	// as checking for one. Zero uses 30 seconds.
+	Timeout time.Duration
+	// TransferTimeout bounds each upload or download of an artifact through
+	// the driver. Zero uses 10 minutes.
+	TransferTimeout time.Duration
+	// BreakerThreshold is the number of requests failing in a row after
+	// which the driver fails requests with storage.ErrUnavailable for
+	// BreakerCooldown, instead of waiting on a store that is down. Zero
+	// uses 5 failures and 30 seconds; a negative threshold never stops
+	// sending requests.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// New creates a driver configured by VC_S3_BUCKET, VC_S3_REGION,
+// VC_S3_ENDPOINT, VC_S3_VIRTUAL_HOSTED=true and VC_STORAGE_PREFIX, along with the durations
+// VC_S3_PRESIGN_EXPIRY, VC_S3_TIMEOUT, VC_S3_TRANSFER_TIMEOUT and
+// VC_S3_BREAKER_COOLDOWN, and VC_S3_RETRY_MODE, VC_S3_MAX_ATTEMPTS and
+// VC_S3_BREAKER_THRESHOLD, where a threshold of 0 disables the breaker.
 func New(ctx context.Context) (*S3Driver, error) {
 	bucket := os.Getenv("VC_S3_BUCKET")
 	if bucket == "" {
@@ -26,59 +110,411 @@ func New(ctx context.Context) (*S3Driver, error) {
 	if region == "" {
 		return nil, fmt.Errorf("VC_S3_REGION is not set")
 	}
+	opts := Options{
+		Bucket:        bucket,
+		Region:        region,
+		Endpoint:      os.Getenv("VC_S3_ENDPOINT"),
+		VirtualHosted: os.Getenv("VC_S3_VIRTUAL_HOSTED") == "true",
+	}
+	var err error
+	if opts.Prefix, err = storage.EnvPrefix(); err != nil {
+		return nil, err
+	}
+	if opts.PresignExpiry, err = envDuration("VC_S3_PRESIGN_EXPIRY"); err != nil {
+		return nil, err
+	}
+	if opts.Timeout, err = envDuration("VC_S3_TIMEOUT"); err != nil {
+		return nil, err
+	}
+	if opts.TransferTimeout, err = envDuration("VC_S3_TRANSFER_TIMEOUT"); err != nil {
+		return nil, err
+	}
+	if opts.BreakerCooldown, err = envDuration("VC_S3_BREAKER_COOLDOWN"); err != nil {
+		return nil, err
+	}
+	opts.RetryMode = os.Getenv("VC_S3_RETRY_MODE")
+	if opts.MaxAttempts, err = envCount("VC_S3_MAX_ATTEMPTS"); err != nil {
+		return nil, err
+	}
+	if v := os.Getenv("VC_S3_BREAKER_THRESHOLD"); v != "" {
+		if opts.BreakerThreshold, err = envCount("VC_S3_BREAKER_THRESHOLD"); err != nil {
+			return nil, err
+		}
+		if opts.BreakerThreshold == 0 {
+			opts.BreakerThreshold = -1
+		}
+	}
+	return NewWithOptions(ctx, opts)
+}
 
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+// NewWithOptions creates a driver for the bucket described by opts.
+func NewWithOptions(ctx context.Context, opts Options) (*S3Driver, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("no bucket configured")
+	}
+	var loadOpts []func(*config.LoadOptions) error
+	if opts.Region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(opts.Region))
+	}
+	if opts.RetryMode != "" {
+		mode, err := aws.ParseRetryMode(opts.RetryMode)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry mode %q (expected standard or adaptive)", opts.RetryMode)
+		}
+		loadOpts = append(loadOpts, config.WithRetryMode(mode))
+	}
+	if opts.MaxAttempts > 0 {
+		loadOpts = append(loadOpts, config.WithRetryMaxAttempts(opts.MaxAttempts))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to load SDK config: %w", err)
 	}
-
-	endpoint := os.Getenv("VC_S3_ENDPOINT")
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("no region configured for bucket %s", opts.Bucket)
+	}
 
 	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.UsePathStyle = true
-		if endpoint != "" {
-			o.BaseEndpoint = aws.String(endpoint)
+		o.UsePathStyle = !opts.VirtualHosted
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opts.Endpoint)
 		}
 	})
 	presignClient := s3.NewPresignClient(client)
+	breaker := &breaker{threshold: opts.BreakerThreshold, cooldown: orDefault(opts.BreakerCooldown, defaultBreakerCooldown)}
+	if breaker.threshold == 0 {
+		breaker.threshold = defaultBreakerThreshold
+	}
 
 	return &S3Driver{
 		client:        client,
 		presignClient: presignClient,
-		bucket:        bucket,
+		bucket:        opts.Bucket,
+		prefix:        opts.Prefix,
+		expiry:        orDefault(opts.PresignExpiry, defaultPresignExpiry),
+
+		timeout:         orDefault(opts.Timeout, defaultTimeout),
+		transferTimeout: orDefault(opts.TransferTimeout, defaultTransferTimeout),
+		breaker:         breaker,
 	}, nil
 }
 
 func (d *S3Driver) GetUploadURL(ctx context.Context, key string) (string, error) {
 	req, err := d.presignClient.PresignPutObject(ctx, &s3.PutObjectInput{
 		Bucket: aws.String(d.bucket),
-		Key:    aws.String(key),
-	}, s3.WithPresignExpires(15*time.Minute))
+		Key:    aws.String(d.prefix + key),
+	}, s3.WithPresignExpires(d.expiry))
 	if err != nil {
 		return "", fmt.Errorf("failed to presign put object: %w", err)
 	}
 	return req.URL, nil
 }
 
+// GetChecksumUploadURL presigns an upload that S3 verifies against checksum
+// and stores it as user metadata, which is returned on every download.
+func (d *S3Driver) GetChecksumUploadURL(ctx context.Context, key, checksum string) (string, map[string]string, error) {
+	sum, err := hex.DecodeString(checksum)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid checksum: %w", err)
+	}
+	req, err := d.presignClient.PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:         aws.String(d.bucket),
+		Key:            aws.String(d.prefix + key),
+		ChecksumSHA256: aws.String(base64.StdEncoding.EncodeToString(sum)),
+		Metadata:       map[string]string{checksumMetadataKey: checksum},
+	}, s3.WithPresignExpires(d.expiry))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to presign put object: %w", err)
+	}
+	headers := make(map[string]string)
+	for name, values := range req.SignedHeader {
+		if !strings.EqualFold(name, "Host") && len(values) > 0 {
+			headers[name] = values[0]
+		}
+	}
+	return req.URL, headers, nil
+}
+
+// Put uploads body to the object for key. Uploads are signed, which takes
+// a body that can be read twice, so it is spooled to a temporary file
+// first. With a checksum, S3 verifies the upload against it as well and
+// stores it as user metadata.
+func (d *S3Driver) Put(ctx context.Context, key string, body io.Reader, size int64, checksum string) error {
+	var sum []byte
+	if checksum != "" {
+		var err error
+		if sum, err = hex.DecodeString(checksum); err != nil {
+			return fmt.Errorf("invalid checksum: %w", err)
+		}
+	}
+	file, err := storage.Spool(body, checksum)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(d.bucket),
+		Key:           aws.String(d.prefix + key),
+		Body:          file,
+		ContentLength: aws.Int64(file.Size),
+	}
+	if checksum != "" {
+		input.ChecksumSHA256 = aws.String(base64.StdEncoding.EncodeToString(sum))
+		input.Metadata = map[string]string{checksumMetadataKey: checksum}
+	}
+	err = d.call(ctx, d.transferTimeout, func(ctx context.Context) error {
+		_, err := d.client.PutObject(ctx, input)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put object: %w", err)
+	}
+	return nil
+}
+
+// Get streams the object for key. The transfer timeout covers reading the
+// object as well.
+func (d *S3Driver) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := d.breaker.allow(); err != nil {
+		return nil, err
+	}
+	getCtx, cancel := context.WithTimeout(ctx, d.transferTimeout)
+	out, err := d.client.GetObject(getCtx, &s3.GetObjectInput{
+		Bucket: aws.String(d.bucket),
+		Key:    aws.String(d.prefix + key),
+	})
+	notFound := isNotFound(err)
+	if ctx.Err() == nil {
+		d.breaker.record(err != nil && !notFound)
+	}
+	if err != nil {
+		cancel()
+		if notFound {
+			return nil, storage.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+	return &cancelingBody{ReadCloser: out.Body, cancel: cancel, checksum: out.Metadata[checksumMetadataKey]}, nil
+}
+
 func (d *S3Driver) GetDownloadURL(ctx context.Context, key string) (string, error) {
 	req, err := d.presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(d.bucket),
-		Key:    aws.String(key),
-	}, s3.WithPresignExpires(15*time.Minute))
+		Key:    aws.String(d.prefix + key),
+	}, s3.WithPresignExpires(d.expiry))
 	if err != nil {
 		return "", fmt.Errorf("failed to presign get object: %w", err)
 	}
 	return req.URL, nil
 }
 
-func (d *S3Driver) Exists(ctx context.Context, key string) (bool, error) {
-	_, err := d.client.HeadObject(ctx, &s3.HeadObjectInput{
+func (d *S3Driver) StartMultipartUpload(ctx context.Context, key string, parts int, checksum string) (string, []string, error) {
+	input := &s3.CreateMultipartUploadInput{
 		Bucket: aws.String(d.bucket),
-		Key:    aws.String(key),
+		Key:    aws.String(d.prefix + key),
+	}
+	if checksum != "" {
+		input.Metadata = map[string]string{checksumMetadataKey: checksum}
+	}
+	var out *s3.CreateMultipartUploadOutput
+	err := d.call(ctx, d.timeout, func(ctx context.Context) error {
+		var err error
+		out, err = d.client.CreateMultipartUpload(ctx, input)
+		return err
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+
+	expiry := max(d.expiry, minPartExpiry)
+	urls := make([]string, parts)
+	for i := range urls {
+		req, err := d.presignClient.PresignUploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(d.bucket),
+			Key:        aws.String(d.prefix + key),
+			UploadId:   out.UploadId,
+			PartNumber: aws.Int32(int32(i + 1)),
+		}, s3.WithPresignExpires(expiry))
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to presign upload part: %w", err)
+		}
+		urls[i] = req.URL
+	}
+	return aws.ToString(out.UploadId), urls, nil
+}
+
+func (d *S3Driver) CompleteMultipartUpload(ctx context.Context, key, uploadID string, etags []string) error {
+	parts := make([]types.CompletedPart, len(etags))
+	for i, etag := range etags {
+		parts[i] = types.CompletedPart{ETag: aws.String(etag), PartNumber: aws.Int32(int32(i + 1))}
+	}
+	// S3 may take minutes to assemble large uploads.
+	err := d.call(ctx, d.transferTimeout, func(ctx context.Context) error {
+		_, err := d.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(d.bucket),
+			Key:             aws.String(d.prefix + key),
+			UploadId:        aws.String(uploadID),
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		})
+		return err
 	})
 	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	return nil
+}
 
+func (d *S3Driver) Exists(ctx context.Context, key string) (bool, error) {
+	err := d.call(ctx, d.timeout, func(ctx context.Context) error {
+		_, err := d.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(d.bucket),
+			Key:    aws.String(d.prefix + key),
+		})
+		return err
+	})
+	switch {
+	case err == nil:
+		return true, nil
+	case isNotFound(err):
 		return false, nil
+	case errors.Is(err, storage.ErrUnavailable):
+		return false, err
+	case statusCode(err) == http.StatusForbidden:
+		// Without s3:ListBucket, S3 answers 403 for missing objects too.
+		return false, fmt.Errorf("failed to check object: access denied (the credentials need s3:GetObject and s3:ListBucket): %w", err)
+	}
+	return false, fmt.Errorf("failed to check object: %w", err)
+}
+
+// Prune deletes every object last modified before cutoff, in batches of up
+// to 1000 keys per DeleteObjects call.
+func (d *S3Driver) Prune(ctx context.Context, cutoff time.Time) (storage.PruneResult, error) {
+	var result storage.PruneResult
+	paginator := s3.NewListObjectsV2Paginator(d.client, &s3.ListObjectsV2Input{Bucket: aws.String(d.bucket), Prefix: aws.String(d.prefix)})
+	for paginator.HasMorePages() {
+		var page *s3.ListObjectsV2Output
+		err := d.call(ctx, d.timeout, func(ctx context.Context) error {
+			var err error
+			page, err = paginator.NextPage(ctx)
+			return err
+		})
+		if err != nil {
+			return result, fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		var expired []types.ObjectIdentifier
+		var bytes int64
+		for _, object := range page.Contents {
+			if object.LastModified == nil || !object.LastModified.Before(cutoff) {
+				continue
+			}
+			expired = append(expired, types.ObjectIdentifier{Key: object.Key})
+			bytes += aws.ToInt64(object.Size)
+		}
+		if len(expired) == 0 {
+			continue
+		}
+
+		var out *s3.DeleteObjectsOutput
+		err = d.call(ctx, d.timeout, func(ctx context.Context) error {
+			var err error
+			out, err = d.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+				Bucket: aws.String(d.bucket),
+				Delete: &types.Delete{Objects: expired, Quiet: aws.Bool(true)},
+			})
+			return err
+		})
+		if err != nil {
+			return result, fmt.Errorf("failed to delete objects: %w", err)
+		}
+		if len(out.Errors) > 0 {
+			return result, fmt.Errorf("failed to delete %d object(s): %s", len(out.Errors), aws.ToString(out.Errors[0].Message))
+		}
+		result.Removed += len(expired)
+		result.Bytes += bytes
+	}
+	return result, nil
+}
+
+// call runs a request to the bucket within timeout, unless the breaker is
+// open. Requests that fail because ctx ended are not counted against the
+// bucket, nor are lookups of missing objects.
+func (d *S3Driver) call(ctx context.Context, timeout time.Duration, request func(context.Context) error) error {
+	if err := d.breaker.allow(); err != nil {
+		return err
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := request(reqCtx)
+	if ctx.Err() == nil {
+		d.breaker.record(err != nil && !isNotFound(err))
+	}
+	return err
+}
+
+// isNotFound reports whether err is S3's answer for a missing object.
+func isNotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	return errors.As(err, &noSuchKey) || errors.As(err, &notFound) || statusCode(err) == http.StatusNotFound
+}
+
+// statusCode returns the HTTP status S3 failed a request with, or 0 when it
+// failed without a response.
+func statusCode(err error) int {
+	var resp *awshttp.ResponseError
+	if errors.As(err, &resp) {
+		return resp.HTTPStatusCode()
+	}
+	return 0
+}
+
+// cancelingBody ends the context of the request it is the body of once
+// closed.
+type cancelingBody struct {
+	io.ReadCloser
+	cancel   context.CancelFunc
+	checksum string
+}
+
+func (b *cancelingBody) Checksum() string {
+	return b.checksum
+}
+
+func (b *cancelingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func orDefault(d, fallback time.Duration) time.Duration {
+	if d <= 0 {
+		return fallback
+	}
+	return d
+}
+
+func envDuration(name string) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q (expected a duration such as \"30s\")", name, v)
+	}
+	return d, nil
+}
+
+func envCount(name string) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q (expected a non-negative number)", name, v)
 	}
-	return true, nil
+	return n, nil
 }
@@ -0,0 +1,253 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
+)
+
+// setS3Env configures the environment for New with static credentials and
+// no shared AWS configuration, then applies env.
+func setS3Env(t *testing.T, env map[string]string) {
+	t.Helper()
+	for name, value := range map[string]string{
+		"AWS_ACCESS_KEY_ID":           "AKIDEXAMPLE",
+		"AWS_SECRET_ACCESS_KEY":       "secret",
+		"AWS_CONFIG_FILE":             t.TempDir() + "/config",
+		"AWS_SHARED_CREDENTIALS_FILE": t.TempDir() + "/credentials",
+		"AWS_EC2_METADATA_DISABLED":   "true",
+		"VC_S3_BUCKET":                "build-cache",
+		"VC_S3_REGION":                "us-east-1",
+		"VC_S3_ENDPOINT":              "",
+		"VC_S3_VIRTUAL_HOSTED":        "",
+		"VC_S3_PRESIGN_EXPIRY":        "",
+		"VC_S3_RETRY_MODE":            "",
+		"VC_S3_MAX_ATTEMPTS":          "",
+		"VC_S3_TIMEOUT":               "",
+		"VC_S3_TRANSFER_TIMEOUT":      "",
+		"VC_S3_BREAKER_THRESHOLD":     "",
+		"VC_S3_BREAKER_COOLDOWN":      "",
+		"VC_STORAGE_PREFIX":           "",
+	} {
+		t.Setenv(name, value)
+	}
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+}
+
+func TestNewReadsEnvironment(t *testing.T) {
+	setS3Env(t, map[string]string{
+		"VC_S3_PRESIGN_EXPIRY":    "30m",
+		"VC_S3_TIMEOUT":           "5s",
+		"VC_S3_TRANSFER_TIMEOUT":  "1h",
+		"VC_S3_BREAKER_THRESHOLD": "3",
+		"VC_S3_BREAKER_COOLDOWN":  "1m",
+		"VC_S3_MAX_ATTEMPTS":      "7",
+		"VC_S3_RETRY_MODE":        "adaptive",
+		"VC_STORAGE_PREFIX":       "team-a",
+	})
+	d, err := New(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, 30*time.Minute, d.expiry)
+	assert.Equal(t, 5*time.Second, d.timeout)
+	assert.Equal(t, time.Hour, d.transferTimeout)
+	assert.Equal(t, 3, d.breaker.threshold)
+	assert.Equal(t, time.Minute, d.breaker.cooldown)
+	assert.Equal(t, "team-a/", d.prefix)
+	assert.True(t, d.client.Options().UsePathStyle)
+	assert.Equal(t, 7, d.client.Options().RetryMaxAttempts)
+	assert.Equal(t, aws.RetryModeAdaptive, d.client.Options().RetryMode)
+}
+
+func TestNewDefaults(t *testing.T) {
+	setS3Env(t, nil)
+	d, err := New(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, defaultPresignExpiry, d.expiry)
+	assert.Equal(t, defaultTimeout, d.timeout)
+	assert.Equal(t, defaultTransferTimeout, d.transferTimeout)
+	assert.Equal(t, defaultBreakerThreshold, d.breaker.threshold)
+	assert.Equal(t, defaultBreakerCooldown, d.breaker.cooldown)
+	assert.Empty(t, d.prefix)
+}
+
+func TestNewBreakerThresholdZeroDisables(t *testing.T) {
+	setS3Env(t, map[string]string{"VC_S3_BREAKER_THRESHOLD": "0"})
+	d, err := New(context.Background())
+	require.NoError(t, err)
+	assert.Negative(t, d.breaker.threshold)
+}
+
+func TestNewRejectsInvalidEnvironment(t *testing.T) {
+	cases := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "no bucket", env: map[string]string{"VC_S3_BUCKET": ""}, wantErr: "VC_S3_BUCKET is not set"},
+		{name: "no region", env: map[string]string{"VC_S3_REGION": ""}, wantErr: "VC_S3_REGION is not set"},
+		{name: "presign expiry", env: map[string]string{"VC_S3_PRESIGN_EXPIRY": "15"}, wantErr: "invalid VC_S3_PRESIGN_EXPIRY"},
+		{name: "negative timeout", env: map[string]string{"VC_S3_TIMEOUT": "-1s"}, wantErr: "invalid VC_S3_TIMEOUT"},
+		{name: "transfer timeout", env: map[string]string{"VC_S3_TRANSFER_TIMEOUT": "soon"}, wantErr: "invalid VC_S3_TRANSFER_TIMEOUT"},
+		{name: "breaker cooldown", env: map[string]string{"VC_S3_BREAKER_COOLDOWN": "0s"}, wantErr: "invalid VC_S3_BREAKER_COOLDOWN"},
+		{name: "max attempts", env: map[string]string{"VC_S3_MAX_ATTEMPTS": "many"}, wantErr: "invalid VC_S3_MAX_ATTEMPTS"},
+		{name: "breaker threshold", env: map[string]string{"VC_S3_BREAKER_THRESHOLD": "-2"}, wantErr: "invalid VC_S3_BREAKER_THRESHOLD"},
+		{name: "retry mode", env: map[string]string{"VC_S3_RETRY_MODE": "eager"}, wantErr: "invalid retry mode"},
+		{name: "prefix", env: map[string]string{"VC_STORAGE_PREFIX": "a/../b"}, wantErr: "invalid VC_STORAGE_PREFIX"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			setS3Env(t, tc.env)
+			_, err := New(context.Background())
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.wantErr)
+		})
+	}
+}
+
+func TestPresignedURLAddressing(t *testing.T) {
+	cases := []struct {
+		name     string
+		env      map[string]string
+		wantHost string
+		wantPath string
+	}{
+		{
+			name:     "path style",
+			env:      map[string]string{"VC_S3_ENDPOINT": "https://minio.internal:9000"},
+			wantHost: "minio.internal:9000",
+			wantPath: "/build-cache/team-a/abc",
+		},
+		{
+			name:     "virtual hosted",
+			env:      map[string]string{"VC_S3_ENDPOINT": "https://s3.example.com", "VC_S3_VIRTUAL_HOSTED": "true"},
+			wantHost: "build-cache.s3.example.com",
+			wantPath: "/team-a/abc",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.env["VC_STORAGE_PREFIX"] = "team-a"
+			tc.env["VC_S3_PRESIGN_EXPIRY"] = "20m"
+			setS3Env(t, tc.env)
+			d, err := New(context.Background())
+			require.NoError(t, err)
+
+			for _, presign := range []func(context.Context, string) (string, error){d.GetUploadURL, d.GetDownloadURL} {
+				raw, err := presign(context.Background(), "abc")
+				require.NoError(t, err)
+				u, err := url.Parse(raw)
+				require.NoError(t, err)
+				assert.Equal(t, tc.wantHost, u.Host)
+				assert.Equal(t, tc.wantPath, u.Path)
+				assert.Equal(t, "1200", u.Query().Get("X-Amz-Expires"))
+			}
+		})
+	}
+}
+
+func TestMultipartPartURLsLastAtLeastAnHour(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<InitiateMultipartUploadResult><Bucket>build-cache</Bucket><Key>abc</Key><UploadId>u1</UploadId></InitiateMultipartUploadResult>`)
+	}))
+	defer server.Close()
+	setS3Env(t, map[string]string{"VC_S3_ENDPOINT": server.URL, "VC_S3_PRESIGN_EXPIRY": "5m"})
+	d, err := New(context.Background())
+	require.NoError(t, err)
+
+	uploadID, urls, err := d.StartMultipartUpload(context.Background(), "abc", 2, "")
+	require.NoError(t, err)
+	assert.Equal(t, "u1", uploadID)
+	require.Len(t, urls, 2)
+	u, err := url.Parse(urls[0])
+	require.NoError(t, err)
+	assert.Equal(t, "3600", u.Query().Get("X-Amz-Expires"))
+}
+
+// newStatusDriver returns a driver for a fake S3 endpoint answering every
+// request with status, trying each request once.
+func newStatusDriver(t *testing.T, status int) *S3Driver {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	setS3Env(t, map[string]string{"VC_S3_ENDPOINT": server.URL, "VC_S3_MAX_ATTEMPTS": "1"})
+	d, err := New(context.Background())
+	require.NoError(t, err)
+	return d
+}
+
+func TestExistsReportsErrors(t *testing.T) {
+	cases := []struct {
+		status  int
+		want    bool
+		wantErr string
+	}{
+		{status: http.StatusOK, want: true},
+		{status: http.StatusNotFound, want: false},
+		{status: http.StatusForbidden, wantErr: "access denied (the credentials need s3:GetObject and s3:ListBucket)"},
+		{status: http.StatusInternalServerError, wantErr: "failed to check object"},
+	}
+	for _, tc := range cases {
+		t.Run(http.StatusText(tc.status), func(t *testing.T) {
+			d := newStatusDriver(t, tc.status)
+			exists, err := d.Exists(context.Background(), "abc")
+			if tc.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+				assert.Equal(t, tc.status, statusCode(err), "the S3 error should stay wrapped")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, exists)
+		})
+	}
+}
+
+func TestExistsPassesOnUnavailable(t *testing.T) {
+	d := newStatusDriver(t, http.StatusServiceUnavailable)
+	d.breaker.threshold = 1
+	_, err := d.Exists(context.Background(), "abc")
+	require.Error(t, err)
+
+	_, err = d.Exists(context.Background(), "abc")
+	assert.ErrorIs(t, err, storage.ErrUnavailable)
+}
+
+func TestIsNotFoundAndStatusCode(t *testing.T) {
+	headError := func(status int) error {
+		d := newStatusDriver(t, status)
+		_, err := d.client.HeadObject(context.Background(), &s3.HeadObjectInput{Bucket: aws.String("build-cache"), Key: aws.String("abc")})
+		require.Error(t, err)
+		return err
+	}
+
+	notFound := headError(http.StatusNotFound)
+	assert.True(t, isNotFound(notFound))
+	assert.True(t, isNotFound(fmt.Errorf("wrapped: %w", notFound)))
+	assert.Equal(t, http.StatusNotFound, statusCode(fmt.Errorf("wrapped: %w", notFound)))
+
+	forbidden := headError(http.StatusForbidden)
+	assert.False(t, isNotFound(forbidden))
+	assert.Equal(t, http.StatusForbidden, statusCode(forbidden))
+
+	assert.False(t, isNotFound(errors.New("connection refused")))
+	assert.Equal(t, 0, statusCode(errors.New("connection refused")))
+	assert.Equal(t, 0, statusCode(context.DeadlineExceeded))
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultURLExpiry is how long signed proxy URLs stay valid unless
+// VC_PROXY_URL_EXPIRY says otherwise, matching presigned S3 URLs.
+const defaultURLExpiry = 15 * time.Minute
+
+// minSigningKeyLen is the shortest VC_PROXY_SIGNING_KEY accepted.
+const minSigningKeyLen = 16
+
+var (
+	// ErrURLExpired is returned by URLSigner.Verify for a URL whose
+	// expiry has passed.
+	ErrURLExpired = errors.New("url has expired")
+	// ErrInvalidSignature is returned by URLSigner.Verify for a URL that
+	// is unsigned or was not signed for the request made with it.
+	ErrInvalidSignature = errors.New("url signature is invalid")
+)
+
+// URLSigner signs the proxy URLs drivers hand out, so that like presigned
+// S3 URLs they allow one method on one path until they expire.
+type URLSigner struct {
+	key    []byte
+	expiry time.Duration
+	now    func() time.Time
+}
+
+// NewURLSigner returns a URLSigner signing with key URLs that stay valid
+// for expiry.
+func NewURLSigner(key []byte, expiry time.Duration) *URLSigner {
+	return &URLSigner{key: key, expiry: expiry, now: time.Now}
+}
+
+var envSigner struct {
+	once   sync.Once
+	signer *URLSigner
+	err    error
+}
+
+// EnvURLSigner returns the URLSigner VC_PROXY_SIGNING_KEY and
+// VC_PROXY_URL_EXPIRY configure, shared by the drivers signing URLs and the
+// server checking them. Without a key, URLs are signed with one generated
+// for the process, so they stop working when the server restarts and are
+// not accepted by other replicas.
+func EnvURLSigner() (*URLSigner, error) {
+	envSigner.once.Do(func() {
+		envSigner.signer, envSigner.err = newEnvURLSigner()
+	})
+	return envSigner.signer, envSigner.err
+}
+
+func newEnvURLSigner() (*URLSigner, error) {
+	expiry := defaultURLExpiry
+	if v := os.Getenv("VC_PROXY_URL_EXPIRY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid VC_PROXY_URL_EXPIRY %q: expected a positive duration such as 30m", v)
+		}
+		expiry = d
+	}
+
+	key := []byte(os.Getenv("VC_PROXY_SIGNING_KEY"))
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate url signing key: %w", err)
+		}
+	} else if len(key) < minSigningKeyLen {
+		return nil, fmt.Errorf("VC_PROXY_SIGNING_KEY must be at least %d characters", minSigningKeyLen)
+	}
+	return NewURLSigner(key, expiry), nil
+}
+
+// Expiry returns how long URLs signed by Sign stay valid.
+func (s *URLSigner) Expiry() time.Duration {
+	return s.expiry
+}
+
+// Sign returns baseURL+path with a signature allowing method on path until
+// the signer's expiry passes.
+func (s *URLSigner) Sign(method, baseURL, path string) string {
+	return s.SignFor(method, baseURL, path, s.expiry)
+}
+
+// SignFor is like Sign, but the URL stays valid for expiry.
+func (s *URLSigner) SignFor(method, baseURL, path string, expiry time.Duration) string {
+	expires := strconv.FormatInt(s.now().Add(expiry).Unix(), 10)
+	query := url.Values{
+		"expires":   {expires},
+		"signature": {s.signature(method, path, expires)},
+	}
+	return baseURL + path + "?" + query.Encode()
+}
+
+// Verify checks that query signs a request with method for path and has
+// not expired.
+func (s *URLSigner) Verify(method, path string, query url.Values) error {
+	expires := query.Get("expires")
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if expires == "" || err != nil {
+		return ErrInvalidSignature
+	}
+	want, _ := hex.DecodeString(s.signature(method, path, expires))
+	if !hmac.Equal(signature, want) {
+		return ErrInvalidSignature
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if s.now().After(time.Unix(unix, 0)) {
+		return ErrURLExpired
+	}
+	return nil
+}
+
+func (s *URLSigner) signature(method, path, expires string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(method + "\n" + path + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLSignerVerify(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	signer := NewURLSigner([]byte("0123456789abcdef"), 15*time.Minute)
+	signer.now = func() time.Time { return now }
+
+	signed, err := url.Parse(signer.Sign(http.MethodPut, "http://cache", "/v1/proxy/blob/abc"))
+	require.NoError(t, err)
+	assert.Equal(t, "http://cache/v1/proxy/blob/abc", signed.Scheme+"://"+signed.Host+signed.Path)
+	query := signed.Query()
+	signature := query.Get("signature")
+
+	with := func(key, value string) url.Values {
+		changed := url.Values{}
+		for k, v := range query {
+			changed[k] = v
+		}
+		if value == "" {
+			changed.Del(key)
+		} else {
+			changed.Set(key, value)
+		}
+		return changed
+	}
+	flipped := []byte(signature)
+	flipped[0] ^= 1
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		query  url.Values
+		at     time.Time
+		want   error
+	}{
+		{name: "valid", method: http.MethodPut, path: "/v1/proxy/blob/abc", query: query, at: now},
+		{name: "valid until expiry", method: http.MethodPut, path: "/v1/proxy/blob/abc", query: query, at: now.Add(15 * time.Minute)},
+		{name: "tampered signature", method: http.MethodPut, path: "/v1/proxy/blob/abc", query: with("signature", string(flipped)), at: now, want: ErrInvalidSignature},
+		{name: "other method", method: http.MethodGet, path: "/v1/proxy/blob/abc", query: query, at: now, want: ErrInvalidSignature},
+		{name: "other path", method: http.MethodPut, path: "/v1/proxy/blob/abd", query: query, at: now, want: ErrInvalidSignature},
+		{name: "extended expiry", method: http.MethodPut, path: "/v1/proxy/blob/abc", query: with("expires", strconv.FormatInt(now.Add(time.Hour).Unix(), 10)), at: now, want: ErrInvalidSignature},
+		{name: "expired", method: http.MethodPut, path: "/v1/proxy/blob/abc", query: query, at: now.Add(16 * time.Minute), want: ErrURLExpired},
+		{name: "missing signature", method: http.MethodPut, path: "/v1/proxy/blob/abc", query: with("signature", ""), at: now, want: ErrInvalidSignature},
+		{name: "non-hex signature", method: http.MethodPut, path: "/v1/proxy/blob/abc", query: with("signature", strings.Repeat("z", len(signature))), at: now, want: ErrInvalidSignature},
+		{name: "missing expiry", method: http.MethodPut, path: "/v1/proxy/blob/abc", query: with("expires", ""), at: now, want: ErrInvalidSignature},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			signer.now = func() time.Time { return tc.at }
+			err := signer.Verify(tc.method, tc.path, tc.query)
+			if tc.want == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestURLSignerRejectsOtherKeys(t *testing.T) {
+	signed, err := url.Parse(NewURLSigner([]byte("0123456789abcdef"), time.Minute).Sign(http.MethodGet, "http://cache", "/v1/proxy/blob/abc"))
+	require.NoError(t, err)
+
+	other := NewURLSigner([]byte("fedcba9876543210"), time.Minute)
+	assert.ErrorIs(t, other.Verify(http.MethodGet, signed.Path, signed.Query()), ErrInvalidSignature)
+}
+
+func TestURLSignerSignFor(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	signer := NewURLSigner([]byte("0123456789abcdef"), time.Minute)
+	signer.now = func() time.Time { return now }
+
+	signed, err := url.Parse(signer.SignFor(http.MethodPut, "http://cache", "/v1/proxy/blob/abc/parts/01/1", time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, strconv.FormatInt(now.Add(time.Hour).Unix(), 10), signed.Query().Get("expires"))
+
+	signer.now = func() time.Time { return now.Add(30 * time.Minute) }
+	assert.NoError(t, signer.Verify(http.MethodPut, signed.Path, signed.Query()))
+}
+
+func TestEnvURLSignerConfig(t *testing.T) {
+	cases := []struct {
+		name       string
+		key        string
+		expiry     string
+		wantErr    string
+		wantExpiry time.Duration
+	}{
+		{name: "defaults", wantExpiry: 15 * time.Minute},
+		{name: "configured", key: "0123456789abcdef", expiry: "30m", wantExpiry: 30 * time.Minute},
+		{name: "short key", key: "too-short", wantErr: "at least 16 characters"},
+		{name: "invalid expiry", expiry: "soon", wantErr: "invalid VC_PROXY_URL_EXPIRY"},
+		{name: "negative expiry", expiry: "-5m", wantErr: "invalid VC_PROXY_URL_EXPIRY"},
+		{name: "zero expiry", expiry: "0s", wantErr: "invalid VC_PROXY_URL_EXPIRY"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("VC_PROXY_SIGNING_KEY", tc.key)
+			t.Setenv("VC_PROXY_URL_EXPIRY", tc.expiry)
+			signer, err := newEnvURLSigner()
+			if tc.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantExpiry, signer.Expiry())
+		})
+	}
+}
+
+func TestEnvURLSignerGeneratesDistinctKeys(t *testing.T) {
+	t.Setenv("VC_PROXY_SIGNING_KEY", "")
+	t.Setenv("VC_PROXY_URL_EXPIRY", "")
+	a, err := newEnvURLSigner()
+	require.NoError(t, err)
+	b, err := newEnvURLSigner()
+	require.NoError(t, err)
+	assert.Len(t, a.key, 32)
+	assert.NotEqual(t, a.key, b.key)
+}
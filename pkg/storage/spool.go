@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// SpooledFile is a temporary copy of an artifact, removed once closed.
+type SpooledFile struct {
+	*os.File
+	// Size is the length of the artifact.
+	Size int64
+}
+
+// Spool copies body into a temporary file, for drivers that need to know
+// an artifact's length or read it more than once. checksum, if set, is the
+// hex SHA-256 body must match, failing with ErrChecksumMismatch otherwise.
+// The returned file is positioned at its start.
+func Spool(body io.Reader, checksum string) (*SpooledFile, error) {
+	tmp, err := os.CreateTemp("", "velocity-spool-*")
+	if err != nil {
+		return nil, fmt.Errorf("create temp file: %w", err)
+	}
+	file := &SpooledFile{File: tmp}
+
+	sum := sha256.New()
+	file.Size, err = io.Copy(io.MultiWriter(tmp, sum), body)
+	if err == nil && checksum != "" && !strings.EqualFold(hex.EncodeToString(sum.Sum(nil)), checksum) {
+		err = ErrChecksumMismatch
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		if err != ErrChecksumMismatch {
+			err = fmt.Errorf("spool artifact: %w", err)
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+// Close closes and removes the file.
+func (f *SpooledFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func checksumOf(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestSpoolCopiesBody(t *testing.T) {
+	for name, checksum := range map[string]string{
+		"without checksum":   "",
+		"checksum":           checksumOf("artifact"),
+		"uppercase checksum": strings.ToUpper(checksumOf("artifact")),
+	} {
+		t.Run(name, func(t *testing.T) {
+			file, err := Spool(strings.NewReader("artifact"), checksum)
+			require.NoError(t, err)
+			defer file.Close()
+
+			assert.Equal(t, int64(8), file.Size)
+			data, err := io.ReadAll(file)
+			require.NoError(t, err)
+			assert.Equal(t, "artifact", string(data))
+		})
+	}
+}
+
+func TestSpoolRejectsChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+
+	_, err := Spool(strings.NewReader("artifact"), checksumOf("other"))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the spooled copy should be removed")
+}
+
+func TestSpooledFileCloseRemovesFile(t *testing.T) {
+	file, err := Spool(strings.NewReader("artifact"), "")
+	require.NoError(t, err)
+	_, err = os.Stat(file.Name())
+	require.NoError(t, err)
+
+	require.NoError(t, file.Close())
+	_, err = os.Stat(file.Name())
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,191 @@
+package webdav
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
+)
+
+// Driver implements storage.Driver on a WebDAV share or any HTTP file
+// server accepting PUT, GET and HEAD, such as a raw repository in Nexus or
+// Artifactory. The share's credentials stay on the server, so clients
+// transfer artifacts through its proxy routes.
+type Driver struct {
+	root      string
+	prefix    string
+	username  string
+	password  string
+	serverURL string
+	signer    *storage.URLSigner
+	client    *http.Client
+}
+
+// New creates a driver for the collection at VC_WEBDAV_URL, which must
+// exist, authenticating with VC_WEBDAV_USERNAME and VC_WEBDAV_PASSWORD when
+// set. Files are stored in the collection VC_STORAGE_PREFIX names below
+// it, which is created when missing. VC_BASE_URL is the public URL of this
+// server, and proxy URLs are signed as EnvURLSigner describes.
+func New() (*Driver, error) {
+	root := os.Getenv("VC_WEBDAV_URL")
+	if root == "" {
+		return nil, fmt.Errorf("VC_WEBDAV_URL is not set")
+	}
+	if _, err := url.Parse(root); err != nil {
+		return nil, fmt.Errorf("invalid VC_WEBDAV_URL: %w", err)
+	}
+	prefix, err := storage.EnvPrefix()
+	if err != nil {
+		return nil, err
+	}
+	signer, err := storage.EnvURLSigner()
+	if err != nil {
+		return nil, err
+	}
+	serverURL := os.Getenv("VC_BASE_URL")
+	if serverURL == "" {
+		serverURL = "http://localhost:8080"
+	}
+	return &Driver{
+		root:      strings.TrimSuffix(root, "/"),
+		prefix:    prefix,
+		username:  os.Getenv("VC_WEBDAV_USERNAME"),
+		password:  os.Getenv("VC_WEBDAV_PASSWORD"),
+		serverURL: strings.TrimSuffix(serverURL, "/"),
+		signer:    signer,
+		client:    &http.Client{},
+	}, nil
+}
+
+// ProxiedByServer marks the driver's URLs as pointing back at the server.
+func (d *Driver) ProxiedByServer() {}
+
+// GetUploadURL returns the server's signed proxy URL for key.
+func (d *Driver) GetUploadURL(ctx context.Context, key string) (string, error) {
+	return d.signer.Sign(http.MethodPut, d.serverURL, "/v1/proxy/blob/"+key), nil
+}
+
+// GetDownloadURL returns the server's signed proxy URL for key.
+func (d *Driver) GetDownloadURL(ctx context.Context, key string) (string, error) {
+	return d.signer.Sign(http.MethodGet, d.serverURL, "/v1/proxy/blob/"+key), nil
+}
+
+// Exists reports whether the share has a file for key.
+func (d *Driver) Exists(ctx context.Context, key string) (bool, error) {
+	resp, err := d.do(ctx, http.MethodHead, key, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return true, nil
+	}
+	return false, fmt.Errorf("failed to check file: unexpected status %s", resp.Status)
+}
+
+// Put uploads body as the file for key. The share keeps no checksum, so it
+// is only verified against body, which is spooled to a temporary file first
+// to be sent with its length.
+func (d *Driver) Put(ctx context.Context, key string, body io.Reader, size int64, checksum string) error {
+	file, err := storage.Spool(body, checksum)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	resp, err := d.do(ctx, http.MethodPut, key, file, file.Size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusConflict && d.prefix != "" {
+		// WebDAV answers 409 for files in missing collections.
+		if err := d.makePrefix(ctx); err != nil {
+			return err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to rewind upload: %w", err)
+		}
+		if resp, err = d.do(ctx, http.MethodPut, key, file, file.Size); err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to upload file: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Get downloads the file for key.
+func (d *Driver) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := d.do(ctx, http.MethodGet, key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, storage.ErrNotFound
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download file: unexpected status %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// makePrefix creates the collections of the prefix, one level at a time.
+// Those that exist already answer 405.
+func (d *Driver) makePrefix(ctx context.Context) error {
+	path := d.root
+	for _, segment := range strings.Split(strings.TrimSuffix(d.prefix, "/"), "/") {
+		path += "/" + url.PathEscape(segment)
+		req, err := http.NewRequestWithContext(ctx, "MKCOL", path+"/", nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		if d.username != "" || d.password != "" {
+			req.SetBasicAuth(d.username, d.password)
+		}
+		resp, err := d.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to create collection %s: %w", segment, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("failed to create collection %s: unexpected status %s", segment, resp.Status)
+		}
+	}
+	return nil
+}
+
+func (d *Driver) do(ctx context.Context, method, key string, body io.Reader, size int64) (*http.Response, error) {
+	if body != nil {
+		// The client closes request bodies, which would remove a spooled
+		// upload before it could be sent again.
+		body = io.NopCloser(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, d.root+"/"+d.prefix+url.PathEscape(key), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if d.username != "" || d.password != "" {
+		req.SetBasicAuth(d.username, d.password)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s %s: %w", strings.ToLower(method), key, err)
+	}
+	return resp, nil
+}
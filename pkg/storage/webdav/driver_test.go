@@ -0,0 +1,169 @@
+package webdav
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bit2swaz/velocity-cache/pkg/storage"
+)
+
+// fakeShare is an in-memory WebDAV share below /dav that, like real
+// servers, only creates files in collections that exist.
+type fakeShare struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	files       map[string][]byte
+	collections map[string]bool
+	mkcols      []string
+}
+
+func newFakeShare(t *testing.T) *fakeShare {
+	share := &fakeShare{files: map[string][]byte{}, collections: map[string]bool{"/dav": true}}
+	share.Server = httptest.NewServer(share)
+	t.Cleanup(share.Close)
+	return share
+}
+
+func (s *fakeShare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, pass, ok := r.BasicAuth(); !ok || user != "ci" || pass != "hunter2" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	name := strings.TrimSuffix(r.URL.Path, "/")
+	switch r.Method {
+	case "MKCOL":
+		s.mkcols = append(s.mkcols, name)
+		switch {
+		case s.collections[name]:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case !s.collections[path.Dir(name)]:
+			w.WriteHeader(http.StatusConflict)
+		default:
+			s.collections[name] = true
+			w.WriteHeader(http.StatusCreated)
+		}
+	case http.MethodPut:
+		if !s.collections[path.Dir(name)] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		s.files[name] = data
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet, http.MethodHead:
+		data, ok := s.files[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestDriver(t *testing.T, share *fakeShare, prefix string) *Driver {
+	t.Helper()
+	t.Setenv("VC_WEBDAV_URL", share.URL+"/dav/")
+	t.Setenv("VC_WEBDAV_USERNAME", "ci")
+	t.Setenv("VC_WEBDAV_PASSWORD", "hunter2")
+	t.Setenv("VC_STORAGE_PREFIX", prefix)
+	d, err := New()
+	require.NoError(t, err)
+	return d
+}
+
+func TestPutGetAndExists(t *testing.T) {
+	share := newFakeShare(t)
+	d := newTestDriver(t, share, "")
+	ctx := context.Background()
+
+	exists, err := d.Exists(ctx, "abc")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, d.Put(ctx, "abc", strings.NewReader("artifact"), 8, ""))
+	assert.Equal(t, "artifact", string(share.files["/dav/abc"]))
+
+	exists, err = d.Exists(ctx, "abc")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	body, err := d.Get(ctx, "abc")
+	require.NoError(t, err)
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "artifact", string(data))
+	assert.Empty(t, share.mkcols)
+}
+
+func TestGetMissing(t *testing.T) {
+	d := newTestDriver(t, newFakeShare(t), "")
+	_, err := d.Get(context.Background(), "missing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestPutRejectsChecksumMismatch(t *testing.T) {
+	share := newFakeShare(t)
+	d := newTestDriver(t, share, "")
+	err := d.Put(context.Background(), "abc", strings.NewReader("corrupt"), 7,
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+	assert.ErrorIs(t, err, storage.ErrChecksumMismatch)
+	assert.Empty(t, share.files)
+}
+
+func TestPutCreatesPrefixCollections(t *testing.T) {
+	share := newFakeShare(t)
+	d := newTestDriver(t, share, "acme/web/")
+	ctx := context.Background()
+
+	require.NoError(t, d.Put(ctx, "abc", strings.NewReader("artifact"), 8, ""))
+	assert.Equal(t, "artifact", string(share.files["/dav/acme/web/abc"]))
+	assert.Equal(t, []string{"/dav/acme", "/dav/acme/web"}, share.mkcols)
+
+	// Once the collections exist, uploads go straight in.
+	require.NoError(t, d.Put(ctx, "abd", strings.NewReader("artifact"), 8, ""))
+	assert.Len(t, share.mkcols, 2)
+
+	// Collections another server created are accepted.
+	other := newTestDriver(t, share, "acme/api/")
+	require.NoError(t, other.Put(ctx, "abc", strings.NewReader("other"), 5, ""))
+	assert.Equal(t, []string{"/dav/acme", "/dav/acme/web", "/dav/acme", "/dav/acme/api"}, share.mkcols)
+
+	exists, err := d.Exists(ctx, "abc")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	body, err := d.Get(ctx, "abc")
+	require.NoError(t, err)
+	defer body.Close()
+	data, _ := io.ReadAll(body)
+	assert.Equal(t, "artifact", string(data), "prefixes keep servers' artifacts apart")
+}
+
+func TestUnexpectedStatusIsAnError(t *testing.T) {
+	share := newFakeShare(t)
+	t.Setenv("VC_WEBDAV_URL", share.URL+"/dav")
+	t.Setenv("VC_WEBDAV_USERNAME", "")
+	t.Setenv("VC_WEBDAV_PASSWORD", "")
+	t.Setenv("VC_STORAGE_PREFIX", "")
+	d, err := New()
+	require.NoError(t, err)
+
+	_, err = d.Exists(context.Background(), "abc")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+}